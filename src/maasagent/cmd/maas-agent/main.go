@@ -91,13 +91,17 @@ func Run() int {
 
 	workerPool := worker.NewWorkerPool(cfg.SystemID, client,
 		worker.WithAllowedWorkflows(map[string]interface{}{
-			"check_ip":    wf.CheckIP,
-			"power_on":    wf.PowerOn,
-			"power_off":   wf.PowerOff,
-			"power_query": wf.PowerQuery,
-			"power_cycle": wf.PowerCycle,
+			"check_ip":           wf.CheckIP,
+			"check_ip_recurring": wf.CheckIPRecurring,
+			"check_single_ip":    wf.CheckSingleIP,
+			"monitor_ip":         wf.MonitorIP,
+			"power_on":           wf.PowerOn,
+			"power_off":          wf.PowerOff,
+			"power_query":        wf.PowerQuery,
+			"power_cycle":        wf.PowerCycle,
 		}), worker.WithAllowedActivities(map[string]interface{}{
-			"power": wf.PowerActivity,
+			"power":              wf.PowerActivity,
+			"check_ip_heartbeat": wf.CheckIPHeartbeatActivity,
 		}), worker.WithControlPlaneTaskQueueName("region_controller"))
 
 	workerPoolBackoff := backoff.NewExponentialBackOff()
@@ -0,0 +1,278 @@
+package netmon
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/exp/slog"
+	"golang.org/x/sys/unix"
+)
+
+// sender sends a single probe to a target
+type sender interface {
+	send(t *target) error
+	Close() error
+}
+
+// icmpSender sends ICMP Echo requests, hardware addresses of targets
+// are resolved by the kernel before the requests leave
+type icmpSender struct {
+	net.PacketConn
+}
+
+func (s icmpSender) send(t *target) error {
+	_, err := s.WriteTo(echoMessage(t.ip, t.id, 0), &net.IPAddr{IP: t.ip.AsSlice(), Zone: t.ip.Zone()})
+
+	return err
+}
+
+// frameSender sends ARP probes as described in RFC 5227 to IPv4 addresses,
+// with the sender protocol address set to 0.0.0.0, so that neighbor caches
+// of targets are left untouched. IPv6 addresses get Neighbor Solicitations
+// from the unspecified address, like for Duplicate Address Detection.
+// Probes are written as Ethernet frames through a packet socket, leaving
+// through the interface the target is reachable from, with an 802.1Q tag
+// if vlan is set. If sourceIP is set, IPv4 addresses get ARP requests
+// from it instead of ARP probes.
+type frameSender struct {
+	f        *os.File
+	vlan     uint16
+	sourceIP netip.Addr
+}
+
+// newFrameSender returns a frameSender. The socket is not bound to
+// a protocol, so it never queues received frames.
+func newFrameSender(vlan uint16) (*frameSender, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	return &frameSender{f: os.NewFile(uintptr(fd), "probe"), vlan: vlan}, nil
+}
+
+func (s *frameSender) send(t *target) error {
+	iface := t.source
+	if iface == nil {
+		return fmt.Errorf("%w: no route to %s", ErrInterfaceNotFound, t.ip)
+	}
+
+	var (
+		b   []byte
+		err error
+	)
+
+	switch {
+	case t.ip.Is4() && s.sourceIP.IsValid():
+		b, err = arpRequest(iface.HardwareAddr, s.sourceIP, t.ip, s.vlan)
+	case t.ip.Is4():
+		b, err = arpProbe(iface.HardwareAddr, t.ip, s.vlan)
+	default:
+		b, err = ndProbe(iface.HardwareAddr, t.ip, s.vlan)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, iface.Name)
+	}
+
+	rc, err := s.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	// the frame has its own header, the address only selects the interface
+	addr := &unix.SockaddrLinklayer{
+		Protocol: htons(binary.BigEndian.Uint16(b[12:14])),
+		Ifindex:  iface.Index,
+		Halen:    uint8(len(layers.EthernetBroadcast)),
+	}
+	copy(addr.Addr[:], b[:len(layers.EthernetBroadcast)])
+
+	var serr error
+
+	if cerr := rc.Control(func(fd uintptr) {
+		serr = unix.Sendto(int(fd), b, 0, addr)
+	}); cerr != nil {
+		return cerr
+	}
+
+	if serr != nil {
+		return os.NewSyscallError("sendto", serr)
+	}
+
+	return nil
+}
+
+func (s *frameSender) Close() error {
+	return s.f.Close()
+}
+
+// checkSourceIP returns the IPv4 subnet of iface that addr is a host address
+// of, or an error if there is none. iface is nil if no interface was set
+// with WithInterface.
+func checkSourceIP(addr netip.Addr, iface *net.Interface) (netip.Prefix, error) {
+	addr = addr.Unmap()
+
+	if !addr.Is4() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsMulticast() {
+		return netip.Prefix{}, fmt.Errorf("%w: %s", ErrInvalidSourceIP, addr)
+	}
+
+	if iface == nil {
+		return netip.Prefix{}, fmt.Errorf("%w: an interface is required", ErrInvalidSourceIP)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok || !ip.Unmap().Is4() {
+			continue
+		}
+
+		ones, _ := ipNet.Mask.Size()
+
+		prefix := netip.PrefixFrom(ip.Unmap(), ones).Masked()
+		if isHostAddr(prefix, addr) {
+			return prefix, nil
+		}
+	}
+
+	return netip.Prefix{}, fmt.Errorf("%w: %s is not on a subnet of %s", ErrInvalidSourceIP, addr, iface.Name)
+}
+
+// warnOffSubnet logs a warning to l, unless it is nil, if IPv4 addresses of
+// ips are outside of subnet, the subnet of the source address of WithSourceIP.
+// They are still probed, as hosts may answer requests from another subnet.
+func warnOffSubnet(ctx context.Context, l *slog.Logger, subnet netip.Prefix, ips []netip.Addr) {
+	if l == nil || !l.Enabled(ctx, slog.LevelWarn) {
+		return
+	}
+
+	var (
+		n     int
+		first netip.Addr
+	)
+
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if !ip.Is4() || subnet.Contains(ip) {
+			continue
+		}
+
+		if n == 0 {
+			first = ip
+		}
+
+		n++
+	}
+
+	if n == 0 {
+		return
+	}
+
+	l.LogAttrs(ctx, slog.LevelWarn, "targets outside of the source subnet",
+		slog.String("subnet", subnet.String()),
+		slog.Int("targets", n),
+		slog.String("first", first.String()))
+}
+
+// isHostAddr returns true if addr is in prefix without being its network
+// or broadcast address, which /31 and /32 prefixes don't have
+func isHostAddr(prefix netip.Prefix, addr netip.Addr) bool {
+	prefix = prefix.Masked()
+	if !prefix.Contains(addr) {
+		return false
+	}
+
+	if prefix.Bits() >= 31 {
+		return true
+	}
+
+	network := prefix.Addr().As4()
+	broadcast := binary.BigEndian.Uint32(network[:]) | (1<<(32-prefix.Bits()) - 1)
+
+	var last [4]byte
+	binary.BigEndian.PutUint32(last[:], broadcast)
+
+	return addr != prefix.Addr() && addr != netip.AddrFrom4(last)
+}
+
+// arpProbe returns an Ethernet frame with an ARP probe for ip,
+// broadcast from hwAddr and tagged with vlan if it is set
+func arpProbe(hwAddr net.HardwareAddr, ip netip.Addr, vlan uint16) ([]byte, error) {
+	return arpRequest(hwAddr, netip.IPv4Unspecified(), ip, vlan)
+}
+
+// arpRequest returns an Ethernet frame with an ARP request for ip from src,
+// broadcast from hwAddr and tagged with vlan if it is set. It is an ARP
+// probe if src is 0.0.0.0.
+func arpRequest(hwAddr net.HardwareAddr, src, ip netip.Addr, vlan uint16) ([]byte, error) {
+	if len(hwAddr) != 6 {
+		return nil, ErrNoHardwareAddr
+	}
+
+	if !ip.Is4() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddr, ip)
+	}
+
+	if !src.Is4() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSourceIP, src)
+	}
+
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   hwAddr,
+		SourceProtAddress: src.AsSlice(),
+		DstHwAddress:      []byte{0, 0, 0, 0, 0, 0},
+		DstProtAddress:    ip.AsSlice(),
+	}
+
+	return serializeFrame(hwAddr, layers.EthernetBroadcast, layers.EthernetTypeARP, vlan, arp)
+}
+
+// serializeFrame returns an Ethernet frame of etherType from src to dst with
+// payload layers ls, tagged with vlan if it is set
+func serializeFrame(src, dst net.HardwareAddr, etherType layers.EthernetType, vlan uint16,
+	ls ...gopacket.SerializableLayer) ([]byte, error) {
+	eth := &layers.Ethernet{
+		SrcMAC:       src,
+		DstMAC:       dst,
+		EthernetType: etherType,
+	}
+
+	all := []gopacket.SerializableLayer{eth}
+
+	if vlan != 0 {
+		eth.EthernetType = layers.EthernetTypeDot1Q
+		all = append(all, &layers.Dot1Q{VLANIdentifier: vlan, Type: etherType})
+	}
+
+	all = append(all, ls...)
+
+	buf := gopacket.NewSerializeBuffer()
+
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, all...)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
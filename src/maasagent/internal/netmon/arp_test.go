@@ -0,0 +1,252 @@
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func TestARPProbe(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	b, err := arpProbe(hwAddr, netip.MustParseAddr("10.0.0.1"), 0)
+	assert.NoError(t, err)
+
+	p := gopacket.NewPacket(b, layers.LinkTypeEthernet, gopacket.Default)
+
+	eth, ok := p.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	assert.True(t, ok)
+	assert.Equal(t, hwAddr, eth.SrcMAC)
+	assert.Equal(t, layers.EthernetBroadcast, eth.DstMAC)
+
+	arp, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(layers.ARPRequest), arp.Operation)
+	assert.Equal(t, []byte(hwAddr), arp.SourceHwAddress)
+	assert.Equal(t, []byte{0, 0, 0, 0}, arp.SourceProtAddress)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0}, arp.DstHwAddress)
+	assert.Equal(t, []byte{10, 0, 0, 1}, arp.DstProtAddress)
+}
+
+func TestARPProbeInvalid(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	testcases := map[string]struct {
+		hwAddr net.HardwareAddr
+		ip     netip.Addr
+		err    error
+	}{
+		"no hardware address": {
+			ip:  netip.MustParseAddr("10.0.0.1"),
+			err: ErrNoHardwareAddr,
+		},
+		"IPv6": {
+			hwAddr: hwAddr,
+			ip:     netip.MustParseAddr("fd00::1"),
+			err:    ErrInvalidAddr,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := arpProbe(tc.hwAddr, tc.ip, 0)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestARPProbeVLAN(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	b, err := arpProbe(hwAddr, netip.MustParseAddr("10.0.0.1"), 100)
+	assert.NoError(t, err)
+
+	// the tag follows the addresses of the Ethernet header
+	assert.Equal(t, []byte{0x81, 0x00, 0x00, 0x64, 0x08, 0x06}, b[12:18])
+
+	p := gopacket.NewPacket(b, layers.LinkTypeEthernet, gopacket.Default)
+
+	eth, ok := p.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	assert.True(t, ok)
+	assert.Equal(t, layers.EthernetTypeDot1Q, eth.EthernetType)
+
+	dot1q, ok := p.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(100), dot1q.VLANIdentifier)
+	assert.Equal(t, layers.EthernetTypeARP, dot1q.Type)
+
+	arp, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{10, 0, 0, 1}, arp.DstProtAddress)
+}
+
+func TestARPRequest(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	b, err := arpRequest(hwAddr, netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("10.0.0.1"), 0)
+	assert.NoError(t, err)
+
+	p := gopacket.NewPacket(b, layers.LinkTypeEthernet, gopacket.Default)
+
+	arp, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(layers.ARPRequest), arp.Operation)
+	assert.Equal(t, []byte(hwAddr), arp.SourceHwAddress)
+	assert.Equal(t, []byte{10, 0, 0, 5}, arp.SourceProtAddress)
+	assert.Equal(t, []byte{10, 0, 0, 1}, arp.DstProtAddress)
+
+	_, err = arpRequest(hwAddr, netip.MustParseAddr("fd00::5"), netip.MustParseAddr("10.0.0.1"), 0)
+	assert.ErrorIs(t, err, ErrInvalidSourceIP)
+}
+
+func TestIsHostAddr(t *testing.T) {
+	testcases := map[string]struct {
+		prefix netip.Prefix
+		addr   netip.Addr
+		out    bool
+	}{
+		"host": {
+			prefix: netip.MustParsePrefix("10.0.0.1/24"),
+			addr:   netip.MustParseAddr("10.0.0.17"),
+			out:    true,
+		},
+		"network": {
+			prefix: netip.MustParsePrefix("10.0.0.1/24"),
+			addr:   netip.MustParseAddr("10.0.0.0"),
+		},
+		"broadcast": {
+			prefix: netip.MustParsePrefix("10.0.0.1/22"),
+			addr:   netip.MustParseAddr("10.0.3.255"),
+		},
+		"outside": {
+			prefix: netip.MustParsePrefix("10.0.0.1/24"),
+			addr:   netip.MustParseAddr("10.0.1.1"),
+		},
+		"/31": {
+			prefix: netip.MustParsePrefix("10.0.0.0/31"),
+			addr:   netip.MustParseAddr("10.0.0.0"),
+			out:    true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, isHostAddr(tc.prefix, tc.addr))
+		})
+	}
+}
+
+func TestCheckSourceIP(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	testcases := map[string]struct {
+		addr  netip.Addr
+		iface *net.Interface
+	}{
+		"IPv6": {
+			addr:  netip.MustParseAddr("fd00::1"),
+			iface: lo,
+		},
+		"unspecified": {
+			addr:  netip.IPv4Unspecified(),
+			iface: lo,
+		},
+		"loopback": {
+			addr:  netip.MustParseAddr("127.0.0.2"),
+			iface: lo,
+		},
+		"no interface": {
+			addr: netip.MustParseAddr("10.0.0.1"),
+		},
+		"not on a subnet of the interface": {
+			addr:  netip.MustParseAddr("10.0.0.1"),
+			iface: lo,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := checkSourceIP(tc.addr, tc.iface)
+			assert.ErrorIs(t, err, ErrInvalidSourceIP)
+		})
+	}
+
+	// the scan fails before sending anything
+	_, err = ScanDetailed(context.Background(), []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+		WithSourceIP(netip.MustParseAddr("10.0.0.2")))
+	assert.ErrorIs(t, err, ErrInvalidSourceIP)
+}
+
+func TestWarnOffSubnet(t *testing.T) {
+	subnet := netip.MustParsePrefix("10.0.0.0/24")
+
+	testcases := map[string]struct {
+		in  []netip.Addr
+		out string
+	}{
+		"on the subnet": {
+			in: []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("::ffff:10.0.0.2")},
+		},
+		"IPv6": {
+			in: []netip.Addr{netip.MustParseAddr("fd00::1")},
+		},
+		"outside of the subnet": {
+			in: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.1.1"),
+				netip.MustParseAddr("10.0.2.1"),
+			},
+			out: `level=WARN msg="targets outside of the source subnet" subnet=10.0.0.0/24 targets=2 first=10.0.1.1`,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+
+			warnOffSubnet(context.Background(), slog.New(slog.NewTextHandler(&buf)), subnet, tc.in)
+
+			if tc.out == "" {
+				assert.Empty(t, buf.String())
+				return
+			}
+
+			assert.Contains(t, buf.String(), tc.out)
+		})
+	}
+
+	assert.NotPanics(t, func() {
+		warnOffSubnet(context.Background(), nil, subnet, []netip.Addr{netip.MustParseAddr("10.0.1.1")})
+	})
+}
+
+func TestFrameSenderNoRoute(t *testing.T) {
+	s := &frameSender{}
+
+	err := s.send(&target{ip: netip.MustParseAddr("10.0.0.1")})
+	assert.ErrorIs(t, err, ErrInterfaceNotFound)
+}
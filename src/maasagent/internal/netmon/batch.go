@@ -0,0 +1,151 @@
+package netmon
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"time"
+)
+
+// scanBatches scans ips like scan, in batches of opts.batchSize addresses
+// sharing the deadline of the scan in proportion to their sizes. A batch
+// ending early leaves its time to the next ones.
+func scanBatches(ctx context.Context, ips []netip.Addr, opts scanOptions,
+	out chan<- ScanResult) (ScanEntries, error) {
+	if opts.batchSize <= 0 || len(ips) <= opts.batchSize {
+		return scan(ctx, ips, opts, out)
+	}
+
+	if err := checkZones(ips); err != nil {
+		return nil, err
+	}
+
+	// parent is not bound by the scan deadline, like in scan
+	parent := ctx
+	bounded := false
+
+	timeout := OperationTimeout
+	if opts.timeout > 0 {
+		timeout = opts.timeout
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout + opts.spreadOver)
+
+	// like scan, the deadline of ctx is the end of the scan unless
+	// the scan has a timeout of its own
+	if d, ok := ctx.Deadline(); ok && opts.timeout == 0 {
+		deadline = d
+	} else {
+		bounded = true
+
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+
+		// shortened by the deadline of the caller
+		deadline, _ = ctx.Deadline()
+	}
+
+	if err := callerErr(parent, bounded); err != nil {
+		return nil, err
+	}
+
+	total := deadline.Sub(start)
+	spread := opts.spreadOver
+	// batches are bounded by their own deadline
+	opts.timeout = 0
+
+	result := make(ScanEntries, len(ips))
+	done := 0
+
+	var (
+		noProbes error
+		sent     bool
+	)
+
+	for _, batch := range batches(ips, opts.batchSize) {
+		done += len(batch)
+
+		// every batch spreads its probes over its share of the window
+		opts.spreadOver = time.Duration(int64(spread) * int64(len(batch)) / int64(len(ips)))
+
+		bctx, cancel := context.WithDeadline(ctx,
+			start.Add(time.Duration(int64(total)*int64(done)/int64(len(ips)))))
+		entries, err := scan(bctx, batch, opts, out)
+
+		cancel()
+
+		// a batch that could not send any probe does not fail the others
+		if errors.Is(err, ErrNoProbesSent) && entries == nil {
+			if noProbes == nil {
+				noProbes = err
+			}
+
+			for _, ip := range batch {
+				result[ip] = ScanEntry{Err: errors.Unwrap(err)}
+			}
+
+			continue
+		}
+
+		// earlier batches are kept, unless there are none
+		if entries == nil {
+			if done == len(batch) {
+				return nil, err
+			}
+
+			return result, err
+		}
+
+		for ip, e := range entries {
+			result[ip] = e
+		}
+
+		if err == nil {
+			err = callerErr(parent, bounded)
+		}
+
+		if err != nil {
+			return result, err
+		}
+
+		sent = true
+	}
+
+	if noProbes != nil && !sent {
+		return nil, noProbes
+	}
+
+	return result, nil
+}
+
+// batches splits ips into batches of size addresses. Duplicates of an
+// address, which is only probed once, are added to the batch of its first
+// occurrence without counting towards its size.
+func batches(ips []netip.Addr, size int) [][]netip.Addr {
+	var (
+		out  [][]netip.Addr
+		seen = make(map[netip.Addr]int, len(ips))
+		n    int
+	)
+
+	for _, ip := range ips {
+		if i, ok := seen[ip.WithZone("")]; ok {
+			out[i] = append(out[i], ip)
+			continue
+		}
+
+		if len(out) == 0 || n == size {
+			out = append(out, make([]netip.Addr, 0, size))
+			n = 0
+		}
+
+		seen[ip.WithZone("")] = len(out) - 1
+		out[len(out)-1] = append(out[len(out)-1], ip)
+		n++
+	}
+
+	return out
+}
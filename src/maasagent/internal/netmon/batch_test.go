@@ -0,0 +1,60 @@
+package netmon
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatches(t *testing.T) {
+	a := netip.MustParseAddr("10.0.0.1")
+	b := netip.MustParseAddr("10.0.0.2")
+	c := netip.MustParseAddr("10.0.0.3")
+	ll := netip.MustParseAddr("fe80::1")
+
+	testcases := map[string]struct {
+		in   []netip.Addr
+		size int
+		out  [][]netip.Addr
+	}{
+		"empty": {
+			size: 2,
+		},
+		"even": {
+			in:   []netip.Addr{a, b, c, ll},
+			size: 2,
+			out:  [][]netip.Addr{{a, b}, {c, ll}},
+		},
+		"remainder": {
+			in:   []netip.Addr{a, b, c},
+			size: 2,
+			out:  [][]netip.Addr{{a, b}, {c}},
+		},
+		"duplicates join their first occurrence": {
+			in:   []netip.Addr{a, b, c, a, ll.WithZone("eth0"), ll.WithZone("eth1")},
+			size: 2,
+			out:  [][]netip.Addr{{a, b, a}, {c, ll.WithZone("eth0"), ll.WithZone("eth1")}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, batches(tc.in, tc.size))
+		})
+	}
+}
+
+func TestScanBatchesMissingZone(t *testing.T) {
+	ips := []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("fe80::1")}
+
+	// the whole scan is rejected before the first batch is sent
+	res, err := ScanDetailed(context.Background(), ips, WithBatchSize(1), WithTimeout(time.Second))
+	assert.ErrorIs(t, err, ErrMissingZone)
+	assert.Nil(t, res)
+}
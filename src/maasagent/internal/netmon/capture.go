@@ -0,0 +1,187 @@
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/sys/unix"
+)
+
+// MaxCapturedFrames bounds the number of frames returned by Capture,
+// later frames are dropped
+const MaxCapturedFrames = 128
+
+// MaxCapturedFrameSize bounds the bytes kept of every frame returned by
+// Capture, which is enough for the headers of probes and replies
+const MaxCapturedFrameSize = 256
+
+// CapturedFrame is a frame sent or received while Capture scanned its target
+type CapturedFrame struct {
+	// Time is the time the frame was read from the capture
+	Time time.Time
+	// Outgoing is set for frames sent by this host
+	Outgoing bool
+	// Interface is the name of the interface the frame was captured on,
+	// it is empty if the interface is gone
+	Interface string
+	// Data holds the frame, truncated to MaxCapturedFrameSize
+	Data []byte
+	// Length is the length of the frame, which is larger than the length
+	// of Data if the frame was truncated
+	Length int
+}
+
+// Capture scans target like ScanDetailed with opts, and returns the frames
+// sent and received during the scan that carry target in their ARP, IP or
+// Neighbor Discovery headers, to tell why an address did not resolve without
+// running tcpdump on the host. Frames are captured on the interface of
+// WithInterface, or on all interfaces, and only the first MaxCapturedFrames
+// frames are kept, each truncated to MaxCapturedFrameSize. Frames of other
+// addresses are read and dropped, so the memory used does not grow with the
+// traffic of the link. The scan error is returned with the frames.
+func Capture(ctx context.Context, target netip.Addr, opts ...Option) ([]CapturedFrame, error) {
+	return defaultScanner.Capture(ctx, target, opts...)
+}
+
+// Capture is like the package-level Capture, opts are applied after
+// the options of the scanner
+func (s *Scanner) Capture(ctx context.Context, target netip.Addr, opts ...Option) ([]CapturedFrame, error) {
+	o := s.options(opts)
+
+	f, err := openCapture(o.iface, o.vlan, acceptFilter, MaxCapturedFrames*captureReplySize)
+	if err != nil {
+		return nil, socketError(err)
+	}
+
+	frames := make(chan []CapturedFrame, 1)
+
+	go func() {
+		frames <- readFrames(f, target.Unmap().WithZone(""))
+	}()
+
+	_, err = s.ScanDetailed(ctx, []netip.Addr{target}, opts...)
+
+	// replies received at the end of the scan may not be read yet
+	timer := time.NewTimer(duplicateReplyWait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	// closing the socket interrupts a pending read
+	f.Close()
+
+	return <-frames, err
+}
+
+// readFrames reads frames of target from the capture f until it is closed,
+// or MaxCapturedFrames frames were read
+func readFrames(f *os.File, target netip.Addr) []CapturedFrame {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var res []CapturedFrame
+
+	names := make(map[int]string)
+	b := make([]byte, MaxCapturedFrameSize)
+
+	for len(res) < MaxCapturedFrames {
+		var (
+			n    int
+			from unix.Sockaddr
+			rerr error
+		)
+
+		// MSG_TRUNC returns the length of the frame rather than of b
+		err := rc.Read(func(fd uintptr) bool {
+			n, from, rerr = unix.Recvfrom(int(fd), b, unix.MSG_TRUNC)
+			return rerr != unix.EAGAIN
+		})
+		if err != nil || rerr != nil {
+			return res
+		}
+
+		data := b
+		if n < len(b) {
+			data = b[:n]
+		}
+
+		if !hasFrameAddr(data, target) {
+			continue
+		}
+
+		frame := CapturedFrame{
+			Time:   time.Now(),
+			Data:   append([]byte(nil), data...),
+			Length: n,
+		}
+
+		if sll, ok := from.(*unix.SockaddrLinklayer); ok {
+			frame.Outgoing = sll.Pkttype == unix.PACKET_OUTGOING
+			frame.Interface = interfaceName(names, sll.Ifindex)
+		}
+
+		res = append(res, frame)
+	}
+
+	return res
+}
+
+// hasFrameAddr returns true if addr is a protocol address of the ARP packet,
+// a source or destination of the IP packet, or the target of the Neighbor
+// Discovery message carried by frame
+func hasFrameAddr(frame []byte, addr netip.Addr) bool {
+	packet := gopacket.NewPacket(frame, layers.LinkTypeEthernet,
+		gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+	for _, layer := range packet.Layers() {
+		var addrs [][]byte
+
+		switch l := layer.(type) {
+		case *layers.ARP:
+			addrs = [][]byte{l.SourceProtAddress, l.DstProtAddress}
+		case *layers.IPv4:
+			addrs = [][]byte{l.SrcIP, l.DstIP}
+		case *layers.IPv6:
+			addrs = [][]byte{l.SrcIP, l.DstIP}
+		case *layers.ICMPv6NeighborSolicitation:
+			addrs = [][]byte{l.TargetAddress}
+		case *layers.ICMPv6NeighborAdvertisement:
+			addrs = [][]byte{l.TargetAddress}
+		}
+
+		for _, a := range addrs {
+			if ip, ok := netip.AddrFromSlice(a); ok && ip.Unmap() == addr {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// interfaceName returns the name of the interface with index, names caches
+// names that were looked up already
+func interfaceName(names map[int]string, index int) string {
+	if name, ok := names[index]; ok {
+		return name
+	}
+
+	var name string
+	if iface, err := net.InterfaceByIndex(index); err == nil {
+		name = iface.Name
+	}
+
+	names[index] = name
+
+	return name
+}
@@ -0,0 +1,112 @@
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasFrameAddr(t *testing.T) {
+	hwAddr := net.HardwareAddr{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}
+	target := netip.MustParseAddr("10.0.0.2")
+	other := netip.MustParseAddr("10.0.0.3")
+
+	testcases := map[string]struct {
+		in   []byte
+		addr netip.Addr
+		out  bool
+	}{
+		"ARP request for the address": {
+			in:   arpFrame(t, layers.ARPRequest, hwAddr, other, target),
+			addr: target,
+			out:  true,
+		},
+		"ARP reply from the address": {
+			in:   arpFrame(t, layers.ARPReply, hwAddr, target, other),
+			addr: target,
+			out:  true,
+		},
+		"ARP of other addresses": {
+			in:   arpFrame(t, layers.ARPRequest, hwAddr, other, netip.MustParseAddr("10.0.0.4")),
+			addr: target,
+		},
+		"IPv4 packet from the address": {
+			in: []byte{
+				0xc0, 0x25, 0xa5, 0x8d, 0xd0, 0x68, 0xcc, 0x2d, 0xe0, 0xe7, 0x03, 0xf0,
+				0x08, 0x00, 0x45, 0x00, 0x00, 0x1c, 0x73, 0x72, 0x00, 0x00, 0x38, 0x01,
+				0x60, 0x52, 0x01, 0x01, 0x01, 0x01, 0xac, 0x10, 0x01, 0x0b, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			addr: netip.MustParseAddr("1.1.1.1"),
+			out:  true,
+		},
+		"neighbor advertisement of the address": {
+			in:   neighborAdvertisement(t, netip.MustParseAddr("fe80::216:3eff:febc:3446"), hwAddr),
+			addr: netip.MustParseAddr("fe80::216:3eff:febc:3446"),
+			out:  true,
+		},
+		"not a frame": {
+			in:   make([]byte, 8),
+			addr: target,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, hasFrameAddr(tc.in, tc.addr))
+		})
+	}
+}
+
+func TestInterfaceName(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+
+	names := make(map[int]string)
+
+	assert.Equal(t, "lo", interfaceName(names, lo.Index))
+	assert.Equal(t, map[int]string{lo.Index: "lo"}, names)
+	assert.Empty(t, interfaceName(names, -1))
+}
+
+// TestCapture captures frames of scanning the first address of
+// TEST_NETMON_SCAN, which is expected to reply
+func TestCapture(t *testing.T) {
+	env := os.Getenv("TEST_NETMON_SCAN")
+	if env == "" {
+		t.Skip("set TEST_NETMON_SCAN to run this test")
+	}
+
+	target := netip.MustParseAddr(env)
+
+	frames, err := Capture(context.TODO(), target, WithWaitForAll())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent, received int
+
+	for _, f := range frames {
+		assert.LessOrEqual(t, len(f.Data), MaxCapturedFrameSize)
+		assert.True(t, hasFrameAddr(f.Data, target))
+
+		if f.Outgoing {
+			sent++
+		} else {
+			received++
+		}
+	}
+
+	assert.NotZero(t, sent)
+	assert.NotZero(t, received)
+}
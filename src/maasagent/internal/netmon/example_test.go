@@ -0,0 +1,77 @@
+package netmon_test
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+// Scan can be called directly, for example by a debugging tool, without
+// a Temporal worker. It needs CAP_NET_RAW to open its sockets.
+func ExampleScan() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ips := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("192.0.2.2"),
+	}
+
+	found, err := netmon.Scan(ctx, ips, netmon.WithTimeout(2*time.Second), netmon.WithRetries(1))
+	if err != nil {
+		fmt.Println("scan failed:", err)
+		return
+	}
+
+	for _, ip := range ips {
+		if hwAddr, ok := found[ip]; ok {
+			fmt.Println(ip, "is at", hwAddr)
+		} else {
+			fmt.Println(ip, "did not reply")
+		}
+	}
+}
+
+// ScanDetailed tells addresses that did not reply apart from addresses
+// that could not be probed
+func ExampleScanDetailed() {
+	entries, err := netmon.ScanDetailed(context.Background(),
+		[]netip.Addr{netip.MustParseAddr("192.0.2.1")}, netmon.WithInterface("eth0"))
+	if err != nil {
+		fmt.Println("scan failed:", err)
+		return
+	}
+
+	for ip, e := range entries {
+		switch {
+		case e.Err != nil:
+			fmt.Println(ip, "could not be probed:", e.Err)
+		case e.Responded:
+			fmt.Println(ip, "is at", e.MAC, "after", e.Latency)
+		default:
+			fmt.Println(ip, "did not reply")
+		}
+	}
+}
+
+// Capture shows the frames of a scan of an address that does not resolve
+func ExampleCapture() {
+	frames, err := netmon.Capture(context.Background(), netip.MustParseAddr("192.0.2.1"),
+		netmon.WithInterface("eth0"))
+	if err != nil {
+		fmt.Println("scan failed:", err)
+	}
+
+	for _, f := range frames {
+		direction := "received"
+		if f.Outgoing {
+			direction = "sent"
+		}
+
+		fmt.Printf("%s %s %d bytes on %s: %x\n", f.Time.Format(time.RFC3339Nano), direction,
+			f.Length, f.Interface, f.Data)
+	}
+}
@@ -0,0 +1,200 @@
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FakeScanner has the methods of Scanner, answering with Entries instead of
+// sending probes, so that code scanning with a Scanner can be tested without
+// privileges or a network. Every scanned address gets its entry of Entries,
+// or an entry of an address that did not respond if it has none, like with
+// a real scan, and options other than WithDropCount and WithEntries are
+// ignored. It is safe for concurrent use, as long as fields are not changed
+// during scans.
+type FakeScanner struct {
+	// Entries are the entries of scanned addresses
+	Entries ScanEntries
+	// Err is returned by every call if set, together with entries of
+	// ScanDetailed and ScanStream like a scan failing once replies were
+	// received
+	Err error
+	// Dropped is added to the counter of WithDropCount by every call of
+	// ScanDetailed and ScanStream
+	Dropped uint64
+
+	mu      sync.Mutex
+	scanned [][]netip.Addr
+}
+
+// Scanned returns addresses of every call that scanned addresses,
+// in the order of calls
+func (s *FakeScanner) Scanned() [][]netip.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([][]netip.Addr(nil), s.scanned...)
+}
+
+// scan records a call scanning ips and returns their entries
+func (s *FakeScanner) scan(ips []netip.Addr) ScanEntries {
+	s.mu.Lock()
+	s.scanned = append(s.scanned, ips)
+	s.mu.Unlock()
+
+	res := make(ScanEntries, len(ips))
+
+	for _, ip := range ips {
+		res[ip] = s.Entries[ip]
+	}
+
+	return res
+}
+
+// Scan is like Scanner.Scan
+func (s *FakeScanner) Scan(_ context.Context, ips []netip.Addr,
+	_ ...Option) (map[netip.Addr]net.HardwareAddr, error) {
+	entries := s.scan(ips)
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	res := make(map[netip.Addr]net.HardwareAddr, len(entries))
+
+	for ip, e := range entries {
+		res[ip] = e.MAC
+	}
+
+	return res, nil
+}
+
+// ScanDetailed is like Scanner.ScanDetailed
+func (s *FakeScanner) ScanDetailed(_ context.Context, ips []netip.Addr,
+	opts ...Option) (ScanEntries, error) {
+	s.drop(opts)
+
+	return s.scan(ips), s.Err
+}
+
+// drop adds Dropped to the counter of WithDropCount of opts, if it is set
+func (s *FakeScanner) drop(opts []Option) {
+	if n := newScanOptions(opts).dropCount; n != nil {
+		atomic.AddUint64(n, s.Dropped)
+	}
+}
+
+// ScanStream is like Scanner.ScanStream, addresses that responded are sent
+// once for every hardware address, in the order of ips
+func (s *FakeScanner) ScanStream(ctx context.Context, ips []netip.Addr, out chan<- ScanResult,
+	opts ...Option) error {
+	defer close(out)
+
+	s.drop(opts)
+
+	entries := s.scan(ips)
+	if e := newScanOptions(opts).entries; e != nil {
+		*e = entries
+	}
+
+	for _, ip := range ips {
+		e := entries[ip]
+		if !e.Responded {
+			continue
+		}
+
+		hwAddrs := e.MACs
+		if len(hwAddrs) == 0 {
+			hwAddrs = []net.HardwareAddr{e.MAC}
+		}
+
+		for _, hwAddr := range hwAddrs {
+			select {
+			case out <- e.result(ip, hwAddr):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	return s.Err
+}
+
+// Ping is like Scanner.Ping, addresses that responded reply with the latency
+// of their entry
+func (s *FakeScanner) Ping(_ context.Context, ips []netip.Addr,
+	_ ...Option) (map[netip.Addr]time.Duration, error) {
+	entries := s.scan(ips)
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	res := make(map[netip.Addr]time.Duration)
+
+	for ip, e := range entries {
+		if e.Responded {
+			res[ip] = e.Latency
+		}
+	}
+
+	return res, nil
+}
+
+// Probe is like Scanner.Probe, addresses that responded are in use
+func (s *FakeScanner) Probe(_ context.Context, ips []netip.Addr, _ ...Option) (ProbeEntries, error) {
+	entries := s.scan(ips)
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	res := make(ProbeEntries, len(entries))
+
+	for ip, e := range entries {
+		hwAddrs := e.MACs
+		if e.Responded && len(hwAddrs) == 0 {
+			hwAddrs = []net.HardwareAddr{e.MAC}
+		}
+
+		res[ip] = ProbeEntry{
+			InUse:          e.Responded,
+			MACs:           hwAddrs,
+			Local:          e.Self,
+			Err:            e.Err,
+			Interface:      e.Interface,
+			InterfaceIndex: e.InterfaceIndex,
+			SourceMAC:      e.SourceMAC,
+			Attempts:       e.Attempts,
+			SeenAt:         e.SeenAt,
+		}
+	}
+
+	return res, nil
+}
+
+// Capture is like Scanner.Capture, no frames are captured
+func (s *FakeScanner) Capture(_ context.Context, target netip.Addr, _ ...Option) ([]CapturedFrame, error) {
+	s.scan([]netip.Addr{target})
+
+	return nil, s.Err
+}
+
+// Neighbors is like Scanner.Neighbors, the neighbor table holds every
+// address of Entries that responded. It does not scan.
+func (s *FakeScanner) Neighbors(_ context.Context, _ ...Option) (map[netip.Addr]net.HardwareAddr, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	res := make(map[netip.Addr]net.HardwareAddr)
+
+	for ip, e := range s.Entries {
+		if e.Responded {
+			res[ip] = e.MAC
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,122 @@
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeScanner(t *testing.T) {
+	first := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	second := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.0.3"),
+	}
+
+	s := &FakeScanner{Entries: ScanEntries{
+		ips[0]: {MAC: first, Responded: true, Latency: time.Millisecond},
+		ips[1]: {MAC: first, MACs: []net.HardwareAddr{first, second}, Responded: true},
+		// not scanned
+		netip.MustParseAddr("10.0.0.9"): {MAC: second, Responded: true},
+	}}
+
+	entries, err := s.ScanDetailed(context.Background(), ips)
+	assert.NoError(t, err)
+	assert.Equal(t, ScanEntries{ips[0]: s.Entries[ips[0]], ips[1]: s.Entries[ips[1]], ips[2]: {}}, entries)
+
+	res, err := s.Scan(context.Background(), ips[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{ips[0]: first}, res)
+
+	out := make(chan ScanResult, 3)
+
+	assert.NoError(t, s.ScanStream(context.Background(), ips, out))
+
+	var streamed []ScanResult
+	for r := range out {
+		streamed = append(streamed, r)
+	}
+
+	assert.Equal(t, []ScanResult{
+		{IP: ips[0], MAC: first, Latency: time.Millisecond},
+		{IP: ips[1], MAC: first},
+		{IP: ips[1], MAC: second},
+	}, streamed)
+
+	latencies, err := s.Ping(context.Background(), ips)
+	assert.NoError(t, err)
+	assert.Equal(t, map[netip.Addr]time.Duration{ips[0]: time.Millisecond, ips[1]: 0}, latencies)
+
+	probed, err := s.Probe(context.Background(), ips)
+	assert.NoError(t, err)
+	assert.Equal(t, ProbeEntry{InUse: true, MACs: []net.HardwareAddr{first}}, probed[ips[0]])
+	assert.Equal(t, ProbeEntry{}, probed[ips[2]])
+
+	neigh, err := s.Neighbors(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, neigh, 3)
+
+	frames, err := s.Capture(context.Background(), ips[0])
+	assert.NoError(t, err)
+	assert.Empty(t, frames)
+
+	assert.Equal(t, [][]netip.Addr{ips, ips[:1], ips, ips, ips, ips[:1]}, s.Scanned())
+}
+
+func TestFakeScannerError(t *testing.T) {
+	ip := netip.MustParseAddr("10.0.0.1")
+	s := &FakeScanner{
+		Entries: ScanEntries{ip: {Responded: true}},
+		Err:     ErrInterfaceDown,
+	}
+
+	// entries found before the failure are returned with the error
+	entries, err := s.ScanDetailed(context.Background(), []netip.Addr{ip})
+	assert.ErrorIs(t, err, ErrInterfaceDown)
+	assert.True(t, entries[ip].Responded)
+
+	_, err = s.Ping(context.Background(), []netip.Addr{ip})
+	assert.ErrorIs(t, err, ErrInterfaceDown)
+
+	_, err = s.Neighbors(context.Background())
+	assert.ErrorIs(t, err, ErrInterfaceDown)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = s.ScanStream(ctx, []netip.Addr{ip}, make(chan ScanResult))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFakeScannerDropped(t *testing.T) {
+	s := &FakeScanner{Dropped: 2}
+	ips := []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+
+	var dropped uint64
+
+	_, err := s.ScanDetailed(context.Background(), ips, WithDropCount(&dropped))
+	assert.NoError(t, err)
+	assert.NoError(t, s.ScanStream(context.Background(), ips, make(chan ScanResult), WithDropCount(&dropped)))
+	assert.Equal(t, uint64(4), dropped)
+
+	// a counter is only needed with the option
+	_, err = s.ScanDetailed(context.Background(), ips)
+	assert.NoError(t, err)
+}
+
+func TestFakeScannerEntries(t *testing.T) {
+	ips := []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")}
+	s := &FakeScanner{Entries: ScanEntries{ips[0]: {Self: true}, ips[1]: {Err: ErrInvalidAddr}}}
+
+	var entries ScanEntries
+
+	assert.NoError(t, s.ScanStream(context.Background(), ips, make(chan ScanResult), WithEntries(&entries)))
+	assert.Equal(t, s.Entries, entries)
+}
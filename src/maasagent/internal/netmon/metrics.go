@@ -0,0 +1,177 @@
+package netmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "maas_agent_netmon"
+
+// operations label metrics by the function that sent probes
+const (
+	opScan  = "scan"
+	opProbe = "probe"
+	opPing  = "ping"
+)
+
+// metrics are collectors updated by scans of this process
+type metrics struct {
+	probesSent     *prometheus.CounterVec
+	replies        *prometheus.CounterVec
+	repliesDropped *prometheus.CounterVec
+	errors         *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+}
+
+var (
+	metricsMu         sync.RWMutex
+	registeredMetrics *metrics
+)
+
+// errorTypes are label values of errors counted by metrics, in the order
+// they are matched. Other errors are counted as "other".
+var errorTypes = []struct {
+	err     error
+	errType string
+}{
+	{err: ErrPermissionDenied, errType: "permission_denied"},
+	{err: ErrNoRawSocket, errType: "no_raw_socket"},
+	{err: ErrMissingZone, errType: "missing_zone"},
+	{err: ErrInterfaceNotFound, errType: "interface_not_found"},
+	{err: ErrInterfaceDown, errType: "interface_down"},
+	{err: ErrNoInterfaceAddr, errType: "no_interface_addr"},
+	{err: ErrNoProbesSent, errType: "no_probes_sent"},
+	{err: ErrInvalidVLAN, errType: "invalid_vlan"},
+	{err: ErrVLANUnsupported, errType: "vlan_unsupported"},
+	{err: ErrInvalidSourceIP, errType: "invalid_source_ip"},
+	{err: context.Canceled, errType: "canceled"},
+	{err: context.DeadlineExceeded, errType: "deadline_exceeded"},
+}
+
+// RegisterMetrics registers collectors of Scan, ScanDetailed, ScanStream,
+// Probe and Ping with reg, for example a *prometheus.Registry served by
+// the metrics endpoint of the agent. Probes are counted when they are
+// written to the socket and replies when they are matched to a probed
+// address, both labeled by operation ("scan", "probe" or "ping"), like
+// replies dropped by the kernel as the capture buffer was full, failed
+// calls, also labeled by error type, and call durations. Addresses
+// resolved from the neighbor table by WithICMPFallback are not replies.
+// It is meant to be called once before scanning, a nil reg stops updating
+// collectors.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	var m *metrics
+
+	if reg != nil {
+		m = newMetrics()
+
+		collectors := []prometheus.Collector{m.probesSent, m.replies, m.repliesDropped, m.errors, m.duration}
+
+		for i, c := range collectors {
+			if err := reg.Register(c); err != nil {
+				// registration is all or nothing
+				for _, done := range collectors[:i] {
+					reg.Unregister(done)
+				}
+
+				return fmt.Errorf("failed to register netmon metrics: %w", err)
+			}
+		}
+	}
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	registeredMetrics = m
+
+	return nil
+}
+
+func getMetrics() *metrics {
+	metricsMu.RLock()
+	defer metricsMu.RUnlock()
+
+	return registeredMetrics
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		probesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "probes_sent_total",
+			Help:      "Number of probes sent.",
+		}, []string{"operation"}),
+		replies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "replies_received_total",
+			Help:      "Number of replies received from probed addresses.",
+		}, []string{"operation"}),
+		repliesDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "replies_dropped_total",
+			Help:      "Number of replies dropped as the capture buffer was full.",
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "errors_total",
+			Help:      "Number of scans that failed, by error type.",
+		}, []string{"operation", "type"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "scan_duration_seconds",
+			Help:      "Duration of scans.",
+			Buckets:   prometheus.ExponentialBuckets(0.25, 2, 10),
+		}, []string{"operation"}),
+	}
+}
+
+// sent counts a probe sent by op, nothing is counted if m is nil
+func (m *metrics) sent(op string) {
+	if m != nil {
+		m.probesSent.WithLabelValues(op).Inc()
+	}
+}
+
+// received counts a reply received by op, nothing is counted if m is nil
+func (m *metrics) received(op string) {
+	if m != nil {
+		m.replies.WithLabelValues(op).Inc()
+	}
+}
+
+// dropped counts n replies dropped by the capture of op, nothing is counted
+// if m is nil
+func (m *metrics) dropped(op string, n int) {
+	if m != nil {
+		m.repliesDropped.WithLabelValues(op).Add(float64(n))
+	}
+}
+
+// observe records a call of op that took since start and returned err,
+// nothing is recorded if m is nil
+func (m *metrics) observe(op string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	m.duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		m.errors.WithLabelValues(op, errorType(err)).Inc()
+	}
+}
+
+// errorType returns the label value of err, so that label values stay few
+func errorType(err error) string {
+	for _, t := range errorTypes {
+		if errors.Is(err, t.err) {
+			return t.errType
+		}
+	}
+
+	return "other"
+}
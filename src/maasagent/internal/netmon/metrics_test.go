@@ -0,0 +1,115 @@
+package netmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorType(t *testing.T) {
+	testcases := map[string]struct {
+		in  error
+		out string
+	}{
+		"permission denied": {
+			in:  &wrappedError{kind: ErrPermissionDenied, err: errors.New("operation not permitted")},
+			out: "permission_denied",
+		},
+		"no probes sent": {
+			in: &wrappedError{kind: ErrNoProbesSent,
+				err: &wrappedError{kind: ErrPermissionDenied, err: errors.New("operation not permitted")}},
+			out: "permission_denied",
+		},
+		"interface not found": {
+			in:  fmt.Errorf("%w: eth9", ErrInterfaceNotFound),
+			out: "interface_not_found",
+		},
+		"canceled": {
+			in:  context.Canceled,
+			out: "canceled",
+		},
+		"other": {
+			in:  errors.New("failed"),
+			out: "other",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, errorType(tc.in))
+		})
+	}
+}
+
+func TestMetricsObserve(t *testing.T) {
+	m := newMetrics()
+
+	m.sent(opScan)
+	m.sent(opScan)
+	m.received(opScan)
+	m.dropped(opScan, 3)
+	m.observe(opScan, time.Now(), nil)
+	m.observe(opPing, time.Now(), fmt.Errorf("%w: eth9", ErrInterfaceNotFound))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.probesSent.WithLabelValues(opScan)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.replies.WithLabelValues(opScan)))
+	assert.Equal(t, float64(3), testutil.ToFloat64(m.repliesDropped.WithLabelValues(opScan)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.errors.WithLabelValues(opPing, "interface_not_found")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.errors))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.duration))
+
+	var none *metrics
+
+	assert.NotPanics(t, func() {
+		none.sent(opScan)
+		none.received(opScan)
+		none.dropped(opScan, 1)
+		none.observe(opScan, time.Now(), errors.New("failed"))
+	})
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	t.Cleanup(func() { assert.NoError(t, RegisterMetrics(nil)) })
+
+	reg := prometheus.NewRegistry()
+
+	assert.NoError(t, RegisterMetrics(reg))
+	assert.NotNil(t, getMetrics())
+
+	// the same collectors can't be registered twice
+	assert.Error(t, RegisterMetrics(reg))
+
+	m := getMetrics()
+
+	_, err := Scan(context.Background(), []netip.Addr{netip.MustParseAddr("127.0.6.1")},
+		WithTimeout(time.Second))
+	if errors.Is(err, ErrPermissionDenied) {
+		t.Skip(err)
+	}
+
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.probesSent.WithLabelValues(opScan)))
+	assert.GreaterOrEqual(t, testutil.ToFloat64(m.replies.WithLabelValues(opScan)), float64(1))
+
+	count, err := testutil.GatherAndCount(reg, "maas_agent_netmon_scan_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	_, err = Scan(context.Background(), []netip.Addr{netip.MustParseAddr("127.0.6.1")},
+		WithInterface("missing0"))
+	assert.ErrorIs(t, err, ErrInterfaceNotFound)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.errors.WithLabelValues(opScan, "interface_not_found")))
+
+	assert.NoError(t, RegisterMetrics(nil))
+	assert.Nil(t, getMetrics())
+}
@@ -0,0 +1,211 @@
+package netmon
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// nativeEndian is the byte order of netlink messages
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	var x uint16 = 1
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+
+	return binary.BigEndian
+}()
+
+// neighbor is an entry of the kernel neighbor table
+type neighbor struct {
+	ip     netip.Addr
+	hwAddr net.HardwareAddr
+	// state is a combination of NUD_* flags
+	state uint16
+	// index is the index of the interface of the entry
+	index int
+}
+
+// neighbors returns hardware addresses from the kernel neighbor table
+// (ARP cache for IPv4 and NDP cache for IPv6). Only reachable and permanent
+// entries are returned, as a stale entry may belong to a host that is gone.
+func neighbors() (map[netip.Addr]net.HardwareAddr, error) {
+	entries, err := readNeighbors()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[netip.Addr]net.HardwareAddr)
+
+	for _, n := range entries {
+		if n.reachable() {
+			res[n.ip] = n.hwAddr
+		}
+	}
+
+	return res, nil
+}
+
+// Neighbors returns hardware addresses that the kernel neighbor table (ARP
+// cache for IPv4 and NDP cache for IPv6) holds for the interface named iface,
+// or for every interface if it is empty, without sending anything. Stale
+// entries are kept, as the table is what the host last learned rather than
+// a proof that hosts are up, while incomplete and failed entries are
+// omitted. Link-local IPv6 addresses are zoned with the name of their
+// interface.
+func Neighbors(ctx context.Context, iface string) (map[netip.Addr]net.HardwareAddr, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int]string, len(ifaces))
+	index := 0
+
+	for _, i := range ifaces {
+		names[i.Index] = i.Name
+
+		if i.Name == iface {
+			index = i.Index
+		}
+	}
+
+	if iface != "" && index == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInterfaceNotFound, iface)
+	}
+
+	entries, err := readNeighbors()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[netip.Addr]net.HardwareAddr)
+
+	for _, n := range entries {
+		if !n.resolved() || (index != 0 && n.index != index) {
+			continue
+		}
+
+		ip := n.ip
+		if ip.Is6() && ip.IsLinkLocalUnicast() {
+			ip = ip.WithZone(names[n.index])
+		}
+
+		res[ip] = n.hwAddr
+	}
+
+	return res, nil
+}
+
+// Neighbors is like the package-level Neighbors, for the interface set with
+// WithInterface among the options of the scanner and opts. Other options
+// don't apply, as no probe is sent.
+func (s *Scanner) Neighbors(ctx context.Context, opts ...Option) (map[netip.Addr]net.HardwareAddr, error) {
+	return Neighbors(ctx, s.options(opts).iface)
+}
+
+// readNeighbors returns entries of the kernel neighbor table
+// that hold a hardware address
+func readNeighbors() ([]neighbor, error) {
+	b, err := syscall.NetlinkRIB(unix.RTM_GETNEIGH, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []neighbor
+
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWNEIGH {
+			continue
+		}
+
+		n, ok := parseNeighborEntry(m.Data)
+		if ok {
+			res = append(res, n)
+		}
+	}
+
+	return res, nil
+}
+
+// reachable returns true if the entry was confirmed recently or is permanent
+func (n neighbor) reachable() bool {
+	return n.state&(unix.NUD_REACHABLE|unix.NUD_PERMANENT) != 0
+}
+
+// resolved returns true if the entry holds a hardware address that was
+// resolved at some point, which incomplete and failed entries don't
+func (n neighbor) resolved() bool {
+	return n.state != unix.NUD_NONE && n.state&(unix.NUD_INCOMPLETE|unix.NUD_FAILED) == 0
+}
+
+// parseNeighbor parses the payload of an RTM_NEWNEIGH message
+// of a reachable or permanent entry
+func parseNeighbor(b []byte) (netip.Addr, net.HardwareAddr, bool) {
+	n, ok := parseNeighborEntry(b)
+	if !ok || !n.reachable() {
+		return netip.Addr{}, nil, false
+	}
+
+	return n.ip, n.hwAddr, true
+}
+
+// parseNeighborEntry parses the payload of an RTM_NEWNEIGH message
+func parseNeighborEntry(b []byte) (neighbor, bool) {
+	if len(b) < unix.SizeofNdMsg {
+		return neighbor{}, false
+	}
+
+	// ndmsg is family (1), pad (1+2), ifindex (4), state (2), flags (1), type (1)
+	n := neighbor{
+		state: nativeEndian.Uint16(b[8:10]),
+		index: int(int32(nativeEndian.Uint32(b[4:8]))),
+	}
+
+	for b = b[unix.SizeofNdMsg:]; len(b) >= unix.SizeofRtAttr; {
+		l := int(nativeEndian.Uint16(b[0:2]))
+		if l < unix.SizeofRtAttr || l > len(b) {
+			break
+		}
+
+		value := b[unix.SizeofRtAttr:l]
+
+		switch nativeEndian.Uint16(b[2:4]) {
+		case unix.NDA_DST:
+			n.ip, _ = netip.AddrFromSlice(value)
+		case unix.NDA_LLADDR:
+			n.hwAddr = append(net.HardwareAddr(nil), value...)
+		}
+
+		// attributes are aligned to 4 bytes
+		l = (l + unix.RTA_ALIGNTO - 1) & ^(unix.RTA_ALIGNTO - 1)
+		if l > len(b) {
+			break
+		}
+
+		b = b[l:]
+	}
+
+	if !n.ip.IsValid() || len(n.hwAddr) == 0 {
+		return neighbor{}, false
+	}
+
+	n.ip = n.ip.Unmap()
+
+	return n, true
+}
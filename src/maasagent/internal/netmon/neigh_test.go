@@ -0,0 +1,195 @@
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// ndMsg builds the payload of an RTM_NEWNEIGH message
+func ndMsg(state uint16, ip netip.Addr, hwAddr net.HardwareAddr) []byte {
+	b := make([]byte, unix.SizeofNdMsg)
+	nativeEndian.PutUint16(b[8:10], state)
+
+	attr := func(typ uint16, value []byte) {
+		a := make([]byte, (unix.SizeofRtAttr+len(value)+unix.RTA_ALIGNTO-1) & ^(unix.RTA_ALIGNTO-1))
+		nativeEndian.PutUint16(a[0:2], uint16(unix.SizeofRtAttr+len(value)))
+		nativeEndian.PutUint16(a[2:4], typ)
+		copy(a[unix.SizeofRtAttr:], value)
+		b = append(b, a...)
+	}
+
+	if ip.IsValid() {
+		attr(unix.NDA_DST, ip.AsSlice())
+	}
+
+	if hwAddr != nil {
+		attr(unix.NDA_LLADDR, hwAddr)
+	}
+
+	return b
+}
+
+func TestParseNeighbor(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	testcases := map[string]struct {
+		in     []byte
+		ip     netip.Addr
+		hwAddr net.HardwareAddr
+		ok     bool
+	}{
+		"reachable IPv4": {
+			in:     ndMsg(unix.NUD_REACHABLE, netip.MustParseAddr("10.0.0.1"), hwAddr),
+			ip:     netip.MustParseAddr("10.0.0.1"),
+			hwAddr: hwAddr,
+			ok:     true,
+		},
+		"permanent IPv6": {
+			in:     ndMsg(unix.NUD_PERMANENT, netip.MustParseAddr("fd00::1"), hwAddr),
+			ip:     netip.MustParseAddr("fd00::1"),
+			hwAddr: hwAddr,
+			ok:     true,
+		},
+		"stale": {
+			in: ndMsg(unix.NUD_STALE, netip.MustParseAddr("10.0.0.1"), hwAddr),
+		},
+		"failed without hardware address": {
+			in: ndMsg(unix.NUD_FAILED, netip.MustParseAddr("10.0.0.1"), nil),
+		},
+		"reachable without hardware address": {
+			in: ndMsg(unix.NUD_REACHABLE, netip.MustParseAddr("10.0.0.1"), nil),
+		},
+		"truncated": {
+			in: []byte{0x02, 0x00},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ip, hwAddr, ok := parseNeighbor(tc.in)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.ip, ip)
+			assert.Equal(t, tc.hwAddr, hwAddr)
+		})
+	}
+}
+
+func TestParseNeighborEntry(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	b := ndMsg(unix.NUD_STALE, netip.MustParseAddr("10.0.0.1"), hwAddr)
+	nativeEndian.PutUint32(b[4:8], 2)
+
+	n, ok := parseNeighborEntry(b)
+	assert.True(t, ok)
+	assert.Equal(t, neighbor{
+		ip:     netip.MustParseAddr("10.0.0.1"),
+		hwAddr: hwAddr,
+		state:  unix.NUD_STALE,
+		index:  2,
+	}, n)
+}
+
+func TestNeighborResolved(t *testing.T) {
+	testcases := map[string]struct {
+		state     uint16
+		resolved  bool
+		reachable bool
+	}{
+		"reachable": {
+			state: unix.NUD_REACHABLE, resolved: true, reachable: true,
+		},
+		"permanent": {
+			state: unix.NUD_PERMANENT, resolved: true, reachable: true,
+		},
+		"stale": {
+			state: unix.NUD_STALE, resolved: true,
+		},
+		"delay": {
+			state: unix.NUD_DELAY, resolved: true,
+		},
+		"incomplete": {
+			state: unix.NUD_INCOMPLETE,
+		},
+		"failed": {
+			state: unix.NUD_FAILED,
+		},
+		"none": {
+			state: unix.NUD_NONE,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			n := neighbor{state: tc.state}
+			assert.Equal(t, tc.resolved, n.resolved())
+			assert.Equal(t, tc.reachable, n.reachable())
+		})
+	}
+}
+
+func TestNeighborsInterfaceNotFound(t *testing.T) {
+	_, err := Neighbors(context.Background(), "does-not-exist0")
+	assert.ErrorIs(t, err, ErrInterfaceNotFound)
+}
+
+func TestNeighborsCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Neighbors(ctx, "")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// TestNeighbors scans addresses of TEST_NETMON_SCAN, which are expected
+// to be in use like for TestScan, and then finds them in the neighbor table
+func TestNeighbors(t *testing.T) {
+	env := os.Getenv("TEST_NETMON_SCAN")
+	if env == "" {
+		t.Skip("set TEST_NETMON_SCAN to run this test")
+	}
+
+	var ips []netip.Addr
+
+	for _, v := range strings.Split(env, ",") {
+		ips = append(ips, netip.MustParseAddr(v))
+	}
+
+	scanned, err := Scan(context.TODO(), ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Neighbors(context.TODO(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range ips {
+		assert.Equal(t, scanned[ip], res[ip], ip)
+	}
+}
+
+func TestScannerNeighborsInterface(t *testing.T) {
+	s := NewScanner(WithInterface("does-not-exist0"))
+
+	_, err := s.Neighbors(context.Background())
+	assert.ErrorIs(t, err, ErrInterfaceNotFound)
+
+	_, err = s.Neighbors(context.Background(), WithInterface(""))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,278 @@
+package netmon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/time/rate"
+)
+
+// pingID tells Echo replies to Ping apart from replies to other processes
+// and to probes of Scan, which use the index of the address as the ID
+var pingID = os.Getpid() & 0xffff
+
+// Ping sends ICMP Echo requests to ips and returns the round-trip time of
+// every address that replied. Unlike Scan, replies are received by the ICMP
+// sockets instead of being captured on the link, so hosts that answer Echo
+// requests are found even if their hardware address can't be learned,
+// but no hardware address is returned.
+//
+// WithTimeout, WithInterface, WithRetries and WithRate apply like for Scan,
+// WithVLAN fails with ErrVLANUnsupported, other options are ignored. Retries are sent once waiting for replies
+// to the previous attempt is over.
+func Ping(ctx context.Context, ips []netip.Addr, opts ...Option) (map[netip.Addr]time.Duration, error) {
+	return defaultScanner.Ping(ctx, ips, opts...)
+}
+
+// Ping is like the package-level Ping, opts are applied after
+// the options of the scanner
+func (s *Scanner) Ping(ctx context.Context, ips []netip.Addr,
+	opts ...Option) (map[netip.Addr]time.Duration, error) {
+	start := time.Now()
+
+	result, err := ping(ctx, ips, s.options(opts))
+	getMetrics().observe(opPing, start, err)
+
+	return result, err
+}
+
+// pingTarget is an address awaiting an Echo reply
+type pingTarget struct {
+	ip     netip.Addr
+	seq    int
+	sentAt time.Time
+}
+
+// echoReply is an Echo reply received from addr
+type echoReply struct {
+	addr       netip.Addr
+	seq        int
+	receivedAt time.Time
+}
+
+func ping(ctx context.Context, ips []netip.Addr, opts scanOptions) (map[netip.Addr]time.Duration, error) {
+	result := make(map[netip.Addr]time.Duration)
+
+	if len(ips) == 0 {
+		return result, nil
+	}
+
+	// Echo requests are sent by the kernel, which can't tag them
+	if opts.vlan != 0 {
+		return nil, fmt.Errorf("%w: Ping can't be tagged", ErrVLANUnsupported)
+	}
+
+	for _, ip := range ips {
+		if ip.Is6() && ip.IsLinkLocalUnicast() && ip.Zone() == "" {
+			return nil, fmt.Errorf("%w: %s", ErrMissingZone, ip)
+		}
+	}
+
+	timeout := OperationTimeout
+	if opts.timeout > 0 {
+		timeout = opts.timeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var iface *net.Interface
+
+	if opts.iface != "" {
+		var err error
+
+		iface, err = net.InterfaceByName(opts.iface)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInterfaceNotFound, opts.iface)
+		}
+	}
+
+	replies := make(chan echoReply)
+	conns := make(map[int]net.PacketConn)
+	targets := make(map[netip.Addr]*pingTarget, len(ips))
+
+	var (
+		wg      sync.WaitGroup
+		sendErr error
+	)
+
+	// deferred calls below stop the readers before they are awaited
+	defer wg.Wait()
+
+	for _, ip := range ips {
+		if !ip.IsValid() {
+			continue
+		}
+
+		if _, ok := targets[ip.WithZone("")]; ok {
+			continue
+		}
+
+		c, ok := conns[ip.BitLen()]
+		if !ok {
+			var err error
+
+			c, err = getConn(ip, iface)
+			if err != nil {
+				if sendErr == nil {
+					sendErr = socketError(err)
+				}
+
+				continue
+			}
+
+			conns[ip.BitLen()] = c
+
+			defer c.Close()
+
+			wg.Add(1)
+
+			go func(is4 bool) {
+				defer wg.Done()
+				readEchoReplies(ctx, c, is4, replies)
+			}(ip.Is4())
+		}
+
+		targets[ip.WithZone("")] = &pingTarget{ip: ip, seq: len(targets)}
+	}
+
+	if len(targets) == 0 {
+		return nil, &wrappedError{kind: ErrNoProbesSent, err: sendErr}
+	}
+
+	// readers blocked on sending a reply stop once ctx is done,
+	// and pending reads once connections are closed
+	defer cancel()
+
+	m := getMetrics()
+
+	var limiter *rate.Limiter
+	if opts.rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.rate), 1)
+	}
+
+	attempts := opts.retries + 1
+	deadline, _ := ctx.Deadline()
+	wait := time.Until(deadline) / time.Duration(attempts)
+
+	for i := 0; i < attempts && ctx.Err() == nil; i++ {
+		sent := 0
+
+		for _, t := range targets {
+			if _, ok := result[t.ip]; ok {
+				continue
+			}
+
+			if limiter != nil {
+				if err := limiter.Wait(ctx); err != nil {
+					break
+				}
+			}
+
+			t.sentAt = time.Now()
+
+			_, err := conns[t.ip.BitLen()].WriteTo(echoMessage(t.ip, pingID, t.seq),
+				&net.IPAddr{IP: t.ip.AsSlice(), Zone: t.ip.Zone()})
+			if err != nil {
+				if sendErr == nil {
+					sendErr = permissionError(err)
+				}
+
+				continue
+			}
+
+			m.sent(opPing)
+
+			sent++
+		}
+
+		if i == 0 && sent == 0 {
+			return nil, &wrappedError{kind: ErrNoProbesSent, err: sendErr}
+		}
+
+		timer := time.NewTimer(wait)
+
+	collect:
+		for len(result) < len(targets) {
+			select {
+			case r := <-replies:
+				t, ok := targets[r.addr]
+				if !ok || t.seq != r.seq {
+					continue
+				}
+
+				m.received(opPing)
+
+				if _, ok := result[t.ip]; !ok {
+					result[t.ip] = rtt(t.sentAt, r.receivedAt)
+				}
+			case <-timer.C:
+				break collect
+			case <-ctx.Done():
+				break collect
+			}
+		}
+
+		timer.Stop()
+
+		if len(result) == len(targets) {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// readEchoReplies sends Echo replies with pingID received by c to out,
+// until c is closed or ctx is done
+func readEchoReplies(ctx context.Context, c net.PacketConn, is4 bool, out chan<- echoReply) {
+	proto := 58 // ICMPv6
+	if is4 {
+		proto = 1 // ICMP
+	}
+
+	b := make([]byte, 1500)
+
+	for {
+		n, peer, err := c.ReadFrom(b)
+		if err != nil {
+			return
+		}
+
+		receivedAt := time.Now()
+
+		msg, err := icmp.ParseMessage(proto, b[:n])
+		if err != nil || (msg.Type != ipv4.ICMPTypeEchoReply && msg.Type != ipv6.ICMPTypeEchoReply) {
+			continue
+		}
+
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || echo.ID != pingID {
+			continue
+		}
+
+		ipAddr, ok := peer.(*net.IPAddr)
+		if !ok {
+			continue
+		}
+
+		addr, ok := netip.AddrFromSlice(ipAddr.IP)
+		if !ok {
+			continue
+		}
+
+		select {
+		case out <- echoReply{addr: addr.Unmap(), seq: echo.Seq, receivedAt: receivedAt}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
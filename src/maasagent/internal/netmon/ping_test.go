@@ -0,0 +1,80 @@
+package netmon
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// TestPing can be used for testing like TestScan
+// sudo TEST_NETMON_SCAN=172.16.1.1,172.16.2.1 \
+// go test "maas.io/core/src/maasagent/internal/netmon -run TestPing -count 1 -v
+func TestPing(t *testing.T) {
+	env := os.Getenv("TEST_NETMON_SCAN")
+	if env == "" {
+		t.Skip("set TEST_NETMON_SCAN to run this test")
+	}
+
+	var ips []netip.Addr
+
+	for _, v := range strings.Split(env, ",") {
+		ips = append(ips, netip.MustParseAddr(v))
+	}
+
+	result, err := Ping(context.TODO(), ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("%v\n", result)
+}
+
+func TestPingEmpty(t *testing.T) {
+	result, err := Ping(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+func TestPingMissingZone(t *testing.T) {
+	_, err := Ping(context.Background(), []netip.Addr{netip.MustParseAddr("fe80::1")})
+	assert.ErrorIs(t, err, ErrMissingZone)
+}
+
+func TestEchoMessage(t *testing.T) {
+	testcases := map[string]struct {
+		ip    netip.Addr
+		proto int
+		typ   icmp.Type
+	}{
+		"IPv4": {
+			ip:    netip.MustParseAddr("10.0.0.1"),
+			proto: 1,
+			typ:   ipv4.ICMPTypeEcho,
+		},
+		"IPv6": {
+			ip:    netip.MustParseAddr("fd00::1"),
+			proto: 58,
+			typ:   ipv6.ICMPTypeEchoRequest,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, err := icmp.ParseMessage(tc.proto, echoMessage(tc.ip, pingID, 7))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.typ, msg.Type)
+			assert.Equal(t, &icmp.Echo{ID: pingID, Seq: 7}, msg.Body)
+		})
+	}
+}
@@ -0,0 +1,482 @@
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/sys/unix"
+	"golang.org/x/time/rate"
+)
+
+// ProbeEntry is the outcome of Probe for an address
+type ProbeEntry struct {
+	// InUse is set if a host claimed the address, and MACs are all distinct
+	// hardware addresses of hosts that did, in the order they were seen
+	InUse bool
+	MACs  []net.HardwareAddr
+	// Local is set if the address belongs to an interface of this host,
+	// which is in use without being probed
+	Local bool
+	// Err is set if the address could not be probed, in which case
+	// it is unknown whether the address is in use
+	Err error
+	// Interface, InterfaceIndex and SourceMAC are the same as in ScanEntry
+	Interface      string
+	InterfaceIndex int
+	SourceMAC      net.HardwareAddr
+	// Attempts is the number of probes sent to the address
+	Attempts int
+	// SeenAt is the time the address was first claimed
+	SeenAt time.Time
+}
+
+// ProbeEntries are outcomes of Probe keyed by probed address
+type ProbeEntries map[netip.Addr]ProbeEntry
+
+// Probe checks whether ips are in use before they are assigned, with ARP
+// probes as described in RFC 5227. Probes have their sender protocol address
+// set to 0.0.0.0, so that no neighbor cache learns about this host. Unlike
+// Scan, an address is in use as soon as any host claims it: by replying
+// to a probe, by sending any ARP packet from the address, or by probing
+// the address at the same time. Addresses of interfaces of this host are in
+// use without being probed, as the kernel does not answer its own probes.
+//
+// Probes are sent once more for every retry (see WithRetries), spread evenly
+// over the timeout, so that claims are collected after the last probe for as
+// long as between two probes, unless every address was claimed before.
+// The timeout is set like for Scan, WithInterface, WithRate and WithVLAN
+// apply like for Scan as well, other options are ignored.
+// Only IPv4 addresses on the link of an interface can be probed, other
+// addresses have an entry with ErrInvalidAddr or ErrNotOnLink.
+func Probe(ctx context.Context, ips []netip.Addr, opts ...Option) (ProbeEntries, error) {
+	return defaultScanner.Probe(ctx, ips, opts...)
+}
+
+// Probe is like the package-level Probe, opts are applied after
+// the options of the scanner
+func (s *Scanner) Probe(ctx context.Context, ips []netip.Addr, opts ...Option) (ProbeEntries, error) {
+	start := time.Now()
+
+	entries, err := probeConflicts(ctx, ips, s.options(opts))
+	getMetrics().observe(opProbe, start, err)
+
+	return entries, err
+}
+
+// conflictTarget is an address probed by Probe
+type conflictTarget struct {
+	ip     netip.Addr
+	source *net.Interface
+	entry  ProbeEntry
+	// err is the last error that prevented sending a probe
+	err error
+}
+
+// arpClaim is an ARP packet claiming ip for hwAddr
+type arpClaim struct {
+	ip     netip.Addr
+	hwAddr net.HardwareAddr
+}
+
+func probeConflicts(ctx context.Context, ips []netip.Addr, opts scanOptions) (ProbeEntries, error) {
+	result := make(ProbeEntries, len(ips))
+
+	if len(ips) == 0 {
+		return result, nil
+	}
+
+	parent := ctx
+	bounded := false
+
+	if _, ok := ctx.Deadline(); !ok || opts.timeout > 0 {
+		timeout := OperationTimeout
+		if opts.timeout > 0 {
+			timeout = opts.timeout
+		}
+
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		bounded = true
+	}
+
+	if err := callerErr(parent, bounded); err != nil {
+		return nil, err
+	}
+
+	var pinned *net.Interface
+
+	if opts.iface != "" {
+		var err error
+
+		pinned, err = net.InterfaceByName(opts.iface)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInterfaceNotFound, opts.iface)
+		}
+
+		if pinned.Flags&net.FlagUp == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInterfaceDown, opts.iface)
+		}
+	}
+
+	if opts.vlan != 0 {
+		if err := checkVLAN(opts.vlan, pinned); err != nil {
+			return nil, err
+		}
+	}
+
+	// local holds addresses and hardware addresses of every interface,
+	// even when probes are pinned to one of them
+	local, err := newSources(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	srcs := local
+	if pinned != nil {
+		if srcs, err = newSources(pinned); err != nil {
+			return nil, err
+		}
+	}
+
+	own := make(map[string]struct{}, len(local.byName))
+	for _, iface := range local.byName {
+		own[iface.HardwareAddr.String()] = struct{}{}
+	}
+
+	targets := make(map[netip.Addr]*conflictTarget, len(ips))
+
+	var queue []*conflictTarget
+
+	for _, ip := range ips {
+		if _, ok := result[ip]; ok {
+			continue
+		}
+
+		if !ip.Is4() {
+			result[ip] = ProbeEntry{Err: fmt.Errorf("%w: %s", ErrInvalidAddr, ip)}
+			continue
+		}
+
+		if iface := local.byAddr[ip]; iface != nil {
+			result[ip] = ProbeEntry{InUse: true, Local: true, MACs: []net.HardwareAddr{iface.HardwareAddr}}.
+				withSource(iface)
+			continue
+		}
+
+		// hosts behind a router can't be probed, it would answer for them
+		c := srcs.onLink(ip)
+		if c == nil {
+			result[ip] = ProbeEntry{Err: fmt.Errorf("%w: %s", ErrNotOnLink, ip)}
+			continue
+		}
+
+		t := &conflictTarget{ip: ip, source: c.iface}
+		targets[ip] = t
+		queue = append(queue, t)
+		// every address has an entry, even if the scan stops early
+		result[ip] = ProbeEntry{}
+	}
+
+	if len(queue) == 0 {
+		return result, nil
+	}
+
+	cctx, ccancel := context.WithCancel(ctx)
+	defer ccancel()
+
+	ifindex := 0
+	if pinned != nil {
+		ifindex = pinned.Index
+	}
+
+	claims, err := captureClaims(cctx, ifindex, opts.vlan, len(queue))
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newFrameSender(opts.vlan)
+	if err != nil {
+		return nil, socketError(err)
+	}
+
+	defer s.Close()
+
+	m := getMetrics()
+
+	var limiter *rate.Limiter
+	if opts.rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.rate), 1)
+	}
+
+	attempts := opts.retries + 1
+	deadline, _ := ctx.Deadline()
+	interval := time.Until(deadline) / time.Duration(attempts+1)
+
+	var mu sync.Mutex
+
+	sendDone := make(chan struct{})
+
+	go func() {
+		defer close(sendDone)
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for i := 0; i < attempts; i++ {
+			select {
+			case <-timer.C:
+			case <-cctx.Done():
+				return
+			}
+
+			timer.Reset(interval)
+
+			for _, t := range queue {
+				mu.Lock()
+				claimed := t.entry.InUse
+				mu.Unlock()
+
+				// a single claim is enough
+				if claimed {
+					continue
+				}
+
+				if limiter != nil {
+					if err := limiter.Wait(cctx); err != nil {
+						return
+					}
+				}
+
+				err := s.send(&target{ip: t.ip, source: t.source})
+
+				mu.Lock()
+				if err != nil {
+					t.err = permissionError(err)
+				} else {
+					t.entry.Attempts++
+				}
+				mu.Unlock()
+
+				if err == nil {
+					m.sent(opProbe)
+				}
+			}
+		}
+	}()
+
+	claimed := 0
+
+loop:
+	for claimed < len(queue) {
+		select {
+		case c, ok := <-claims:
+			if !ok {
+				break loop
+			}
+
+			t := targets[c.ip]
+			if t == nil {
+				continue
+			}
+
+			// probes of this host are captured as well
+			if _, ok := own[c.hwAddr.String()]; ok {
+				continue
+			}
+
+			m.received(opProbe)
+
+			mu.Lock()
+			if !t.entry.InUse {
+				claimed++
+			}
+
+			t.entry.claimedBy(c.hwAddr, time.Now())
+			mu.Unlock()
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	ccancel()
+	<-sendDone
+
+	sent := 0
+
+	var sendErr error
+
+	for _, t := range queue {
+		entry := t.entry.withSource(t.source)
+
+		if entry.Attempts > 0 {
+			sent++
+		} else if !entry.InUse && t.err != nil {
+			entry.Err = t.err
+			if sendErr == nil {
+				sendErr = t.err
+			}
+		}
+
+		result[t.ip] = entry
+	}
+
+	if sent == 0 && sendErr != nil {
+		return nil, &wrappedError{kind: ErrNoProbesSent, err: sendErr}
+	}
+
+	if err := callerErr(parent, bounded); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// claimedBy records a claim of the address for hwAddr
+func (e *ProbeEntry) claimedBy(hwAddr net.HardwareAddr, now time.Time) {
+	for _, seen := range e.MACs {
+		if bytes.Equal(seen, hwAddr) {
+			return
+		}
+	}
+
+	if !e.InUse {
+		e.InUse = true
+		e.SeenAt = now
+	}
+
+	e.MACs = append(e.MACs, hwAddr)
+}
+
+// withSource returns the entry with the interface that its probes left through
+func (e ProbeEntry) withSource(iface *net.Interface) ProbeEntry {
+	if iface != nil {
+		e.Interface = iface.Name
+		e.InterfaceIndex = iface.Index
+		e.SourceMAC = iface.HardwareAddr
+	}
+
+	return e
+}
+
+// captureClaims sends addresses claimed by ARP packets received
+// on ifindex, or on all interfaces if it is zero, until ctx is done.
+// Only packets tagged with vlan are received if it is set.
+func captureClaims(ctx context.Context, ifindex int, vlan uint16, n int) (<-chan arpClaim, error) {
+	proto := htons(unix.ETH_P_ARP)
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return nil, socketError(os.NewSyscallError("socket", err))
+	}
+
+	if vlan != 0 {
+		filter, err := vlanFilter(vlan, acceptFilter)
+		if err == nil {
+			err = attachFilter(fd, filter)
+		}
+
+		if err != nil {
+			unix.Close(fd)
+			return nil, socketError(err)
+		}
+	}
+
+	growReadBuffer(fd, captureBufferSize(n))
+
+	err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifindex})
+	if err != nil {
+		unix.Close(fd)
+		return nil, socketError(os.NewSyscallError("bind", err))
+	}
+
+	f := os.NewFile(uintptr(fd), "arp-capture")
+	out := make(chan arpClaim)
+
+	// the socket is pollable, so closing it interrupts a pending read
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	go func() {
+		defer close(out)
+
+		b := make([]byte, 128)
+
+		for {
+			n, err := f.Read(b)
+			if err != nil {
+				return
+			}
+
+			c, ok := parseClaim(b[:n])
+			if !ok {
+				continue
+			}
+
+			select {
+			case out <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// parseClaim returns the address claimed by an Ethernet frame with an ARP
+// packet. Any packet claims its sender protocol address, and an ARP probe,
+// which has none, claims the address it probes.
+func parseClaim(frame []byte) (arpClaim, bool) {
+	packet := gopacket.NewPacket(frame, layers.LinkTypeEthernet,
+		gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+	return packetClaim(packet)
+}
+
+// packetClaim returns the address claimed by a decoded packet like parseClaim
+func packetClaim(packet gopacket.Packet) (arpClaim, bool) {
+	layer := packet.Layer(layers.LayerTypeARP)
+	if layer == nil {
+		return arpClaim{}, false
+	}
+
+	//nolint:errcheck // safe to have this assert
+	arp := layer.(*layers.ARP)
+
+	sender, ok := netip.AddrFromSlice(arp.SourceProtAddress)
+	if !ok || !sender.Is4() {
+		return arpClaim{}, false
+	}
+
+	// the frame is reused by the next read
+	hwAddr := make(net.HardwareAddr, len(arp.SourceHwAddress))
+	copy(hwAddr, arp.SourceHwAddress)
+
+	if !sender.IsUnspecified() {
+		return arpClaim{ip: sender, hwAddr: hwAddr}, true
+	}
+
+	if arp.Operation != layers.ARPRequest {
+		return arpClaim{}, false
+	}
+
+	probed, ok := netip.AddrFromSlice(arp.DstProtAddress)
+	if !ok || !probed.Is4() {
+		return arpClaim{}, false
+	}
+
+	return arpClaim{ip: probed, hwAddr: hwAddr}, true
+}
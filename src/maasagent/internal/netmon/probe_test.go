@@ -0,0 +1,143 @@
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+// arpFrame returns an Ethernet frame with an ARP packet from hwAddr
+func arpFrame(t *testing.T, op uint16, hwAddr net.HardwareAddr, sender, target netip.Addr) []byte {
+	t.Helper()
+
+	buf := gopacket.NewSerializeBuffer()
+
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true},
+		&layers.Ethernet{SrcMAC: hwAddr, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeARP},
+		&layers.ARP{
+			AddrType:          layers.LinkTypeEthernet,
+			Protocol:          layers.EthernetTypeIPv4,
+			HwAddressSize:     6,
+			ProtAddressSize:   4,
+			Operation:         op,
+			SourceHwAddress:   hwAddr,
+			SourceProtAddress: sender.AsSlice(),
+			DstHwAddress:      make([]byte, 6),
+			DstProtAddress:    target.AsSlice(),
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestParseClaim(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ip := netip.MustParseAddr("10.0.0.1")
+	other := netip.MustParseAddr("10.0.0.2")
+	unspecified := netip.IPv4Unspecified()
+
+	probe, err := arpProbe(hwAddr, ip, 0)
+	assert.NoError(t, err)
+
+	tagged, err := arpProbe(hwAddr, ip, 100)
+	assert.NoError(t, err)
+
+	testcases := map[string]struct {
+		in  []byte
+		out arpClaim
+		ok  bool
+	}{
+		"reply": {
+			in:  arpFrame(t, layers.ARPReply, hwAddr, ip, other),
+			out: arpClaim{ip: ip, hwAddr: hwAddr},
+			ok:  true,
+		},
+		"request from the address": {
+			in:  arpFrame(t, layers.ARPRequest, hwAddr, ip, other),
+			out: arpClaim{ip: ip, hwAddr: hwAddr},
+			ok:  true,
+		},
+		"probe": {
+			in:  probe,
+			out: arpClaim{ip: ip, hwAddr: hwAddr},
+			ok:  true,
+		},
+		"tagged probe": {
+			in:  tagged,
+			out: arpClaim{ip: ip, hwAddr: hwAddr},
+			ok:  true,
+		},
+		"reply without sender": {
+			in: arpFrame(t, layers.ARPReply, hwAddr, unspecified, ip),
+		},
+		"not ARP": {
+			in: make([]byte, 64),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, ok := parseClaim(tc.in)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestProbeEntryClaimedBy(t *testing.T) {
+	first := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	second := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+	now := time.Unix(1, 0)
+
+	var e ProbeEntry
+
+	e.claimedBy(first, now)
+	e.claimedBy(second, now.Add(time.Second))
+	e.claimedBy(first, now.Add(2*time.Second))
+
+	assert.Equal(t, ProbeEntry{InUse: true, MACs: []net.HardwareAddr{first, second}, SeenAt: now}, e)
+}
+
+func TestProbeEmpty(t *testing.T) {
+	res, err := Probe(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, res)
+}
+
+// TestProbe probes addresses of TEST_NETMON_SCAN, which are expected
+// to be in use, like for TestScan
+func TestProbe(t *testing.T) {
+	env := os.Getenv("TEST_NETMON_SCAN")
+	if env == "" {
+		t.Skip("set TEST_NETMON_SCAN to run this test")
+	}
+
+	var ips []netip.Addr
+
+	for _, v := range strings.Split(env, ",") {
+		ips = append(ips, netip.MustParseAddr(v))
+	}
+
+	result, err := Probe(context.TODO(), ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range ips {
+		assert.True(t, result[ip].InUse, ip)
+	}
+}
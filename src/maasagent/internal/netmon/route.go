@@ -0,0 +1,301 @@
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sources finds interfaces that probes leave through
+type sources struct {
+	// pinned is the interface set with WithInterface
+	pinned *net.Interface
+	// byAddr are interfaces keyed by their addresses
+	byAddr map[netip.Addr]*net.Interface
+	byName map[string]*net.Interface
+	// connected are prefixes of interface addresses, which are on-link
+	connected []connectedPrefix
+}
+
+// connectedPrefix is a prefix on the link of iface,
+// which holds addr in it
+type connectedPrefix struct {
+	prefix netip.Prefix
+	addr   netip.Addr
+	iface  *net.Interface
+}
+
+// newSources returns sources of probes sent through pinned,
+// or through interfaces chosen by the routing table if pinned is nil
+func newSources(pinned *net.Interface) (*sources, error) {
+	s := &sources{pinned: pinned}
+
+	if pinned != nil {
+		// an index of zero would list addresses of all interfaces
+		if pinned.Index > 0 {
+			if err := s.addInterface(pinned); err != nil {
+				return nil, err
+			}
+		}
+
+		return s, nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	s.byAddr = make(map[netip.Addr]*net.Interface)
+	s.byName = make(map[string]*net.Interface, len(ifaces))
+
+	for i := range ifaces {
+		iface := &ifaces[i]
+		s.byName[iface.Name] = iface
+
+		if err := s.addInterface(iface); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// addInterface adds addresses of iface and their prefixes
+func (s *sources) addInterface(iface *net.Interface) error {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		addr, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+
+		addr = addr.Unmap()
+
+		if s.byAddr != nil {
+			s.byAddr[addr] = iface
+		}
+
+		ones, bits := ipNet.Mask.Size()
+		if bits == addr.BitLen() && ones < bits {
+			s.connected = append(s.connected, connectedPrefix{
+				prefix: netip.PrefixFrom(addr, ones).Masked(),
+				addr:   addr,
+				iface:  iface,
+			})
+		}
+	}
+
+	return nil
+}
+
+// localAddrs returns addresses assigned to interfaces of this host at the
+// time of the call. Link-local addresses are zoned with both the name and
+// the index of their interface, so that either form of a zone matches.
+func localAddrs() (map[netip.Addr]struct{}, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[netip.Addr]struct{})
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+
+			addr, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+
+			addr = addr.Unmap()
+
+			if addr.Is6() && addr.IsLinkLocalUnicast() {
+				res[addr.WithZone(iface.Name)] = struct{}{}
+				res[addr.WithZone(strconv.Itoa(iface.Index))] = struct{}{}
+
+				continue
+			}
+
+			res[addr] = struct{}{}
+		}
+	}
+
+	return res, nil
+}
+
+// localHardwareAddrs returns hardware addresses of interfaces of this host
+// at the time of the call, as returned by net.HardwareAddr.String
+func localHardwareAddrs() (map[string]struct{}, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]struct{}, len(ifaces))
+
+	for _, iface := range ifaces {
+		if len(iface.HardwareAddr) > 0 {
+			res[iface.HardwareAddr.String()] = struct{}{}
+		}
+	}
+
+	return res, nil
+}
+
+// isLocal returns true if ip is one of local, as returned by localAddrs.
+// Zones only tell apart link-local addresses.
+func isLocal(local map[netip.Addr]struct{}, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsLinkLocalUnicast() {
+		ip = ip.WithZone("")
+	}
+
+	_, ok := local[ip]
+
+	return ok
+}
+
+// lookup returns the interface that probes to ip leave through and their
+// source address, either of which is zero if it is unknown. Link-local IPv6
+// addresses leave through their zone, addresses on the link of a single
+// interface through it, and other addresses through the interface holding
+// the source address that the routing table selects for them.
+// With a pinned interface, the source address is selected by the routing
+// table among routes through it.
+func (s *sources) lookup(ip netip.Addr) (*net.Interface, netip.Addr) {
+	if s.pinned != nil {
+		if c := s.onLink(ip); c != nil {
+			return s.pinned, c.addr
+		}
+
+		return s.pinned, routedSource(ip, s.pinned.Name)
+	}
+
+	if ip.Zone() != "" {
+		iface := s.byName[ip.Zone()]
+		if iface == nil {
+			return nil, netip.Addr{}
+		}
+
+		return iface, s.linkSource(ip, iface)
+	}
+
+	// every host of a scanned subnet is usually on-link, which saves
+	// a socket per address
+	if c := s.onLink(ip); c != nil {
+		return c.iface, c.addr
+	}
+
+	addr := routedSource(ip, "")
+	if !addr.IsValid() {
+		return nil, addr
+	}
+
+	return s.byAddr[addr], addr
+}
+
+// linkSource returns an address of iface on the link of the zoned ip,
+// prefixes don't contain zoned addresses
+func (s *sources) linkSource(ip netip.Addr, iface *net.Interface) netip.Addr {
+	ip = ip.WithZone("")
+
+	for _, c := range s.connected {
+		if c.iface.Index == iface.Index && c.prefix.Contains(ip) {
+			return c.addr
+		}
+	}
+
+	return netip.Addr{}
+}
+
+// routedSource returns the source address that the routing table selects
+// for ip, among routes through device if it is set
+func routedSource(ip netip.Addr, device string) netip.Addr {
+	d := net.Dialer{}
+
+	if device != "" {
+		d.Control = func(_, _ string, rc syscall.RawConn) error {
+			var err error
+
+			if cerr := rc.Control(func(fd uintptr) {
+				err = unix.BindToDevice(int(fd), device)
+			}); cerr != nil {
+				return cerr
+			}
+
+			return err
+		}
+	}
+
+	// connecting a UDP socket selects a route without sending anything
+	c, err := d.DialContext(context.Background(), "udp", netip.AddrPortFrom(ip, 9).String())
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	defer c.Close()
+
+	local, ok := c.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return netip.Addr{}
+	}
+
+	addr, ok := netip.AddrFromSlice(local.IP)
+	if !ok {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
+
+// onLink returns the longest connected prefix containing ip,
+// or nil if there is none. The routing table decides when prefixes
+// of the same length on different interfaces contain ip.
+func (s *sources) onLink(ip netip.Addr) *connectedPrefix {
+	var best *connectedPrefix
+
+	ambiguous := false
+
+	for i := range s.connected {
+		c := &s.connected[i]
+		if !c.prefix.Contains(ip) {
+			continue
+		}
+
+		switch {
+		case best == nil || c.prefix.Bits() > best.prefix.Bits():
+			best, ambiguous = c, false
+		case c.prefix.Bits() == best.prefix.Bits() && c.iface.Index != best.iface.Index:
+			ambiguous = true
+		}
+	}
+
+	if best == nil || ambiguous {
+		return nil
+	}
+
+	return best
+}
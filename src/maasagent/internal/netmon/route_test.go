@@ -0,0 +1,221 @@
+package netmon
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourcesLookup(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+
+	s, err := newSources(nil)
+	assert.NoError(t, err)
+
+	testcases := map[string]struct {
+		in   netip.Addr
+		out  string
+		addr netip.Addr
+	}{
+		"on-link": {
+			in:   netip.MustParseAddr("127.0.0.2"),
+			out:  lo.Name,
+			addr: netip.MustParseAddr("127.0.0.1"),
+		},
+		"zone": {
+			in:  netip.MustParseAddr("fe80::1%" + lo.Name),
+			out: lo.Name,
+		},
+		"unknown zone": {
+			in: netip.MustParseAddr("fe80::1%does-not-exist0"),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var name string
+
+			iface, addr := s.lookup(tc.in)
+			if iface != nil {
+				name = iface.Name
+			}
+
+			assert.Equal(t, tc.out, name)
+			assert.Equal(t, tc.addr, addr)
+		})
+	}
+}
+
+func TestSourcesLookupPinned(t *testing.T) {
+	pinned := &net.Interface{Name: "eth9"}
+
+	s, err := newSources(pinned)
+	assert.NoError(t, err)
+	iface, _ := s.lookup(netip.MustParseAddr("10.0.0.1"))
+	assert.Equal(t, pinned, iface)
+}
+
+func TestSourcesLookupPinnedSource(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+
+	s, err := newSources(lo)
+	assert.NoError(t, err)
+
+	iface, addr := s.lookup(netip.MustParseAddr("127.0.0.2"))
+	assert.Equal(t, lo, iface)
+	assert.Equal(t, netip.MustParseAddr("127.0.0.1"), addr)
+}
+
+func TestLocalAddrs(t *testing.T) {
+	local, err := localAddrs()
+	assert.NoError(t, err)
+	assert.True(t, isLocal(local, netip.MustParseAddr("127.0.0.1")))
+	assert.True(t, isLocal(local, netip.MustParseAddr("::ffff:127.0.0.1")))
+	assert.False(t, isLocal(local, netip.MustParseAddr("127.0.0.2")))
+}
+
+func TestIsLocal(t *testing.T) {
+	local := map[netip.Addr]struct{}{
+		netip.MustParseAddr("10.0.0.1"):     {},
+		netip.MustParseAddr("fd00::1"):      {},
+		netip.MustParseAddr("fe80::1%eth0"): {},
+		netip.MustParseAddr("fe80::1%2"):    {},
+	}
+
+	testcases := map[string]struct {
+		in  netip.Addr
+		out bool
+	}{
+		"local": {
+			in:  netip.MustParseAddr("10.0.0.1"),
+			out: true,
+		},
+		"mapped": {
+			in:  netip.MustParseAddr("::ffff:10.0.0.1"),
+			out: true,
+		},
+		"other": {
+			in: netip.MustParseAddr("10.0.0.2"),
+		},
+		"zone ignored": {
+			in:  netip.MustParseAddr("fd00::1%eth1"),
+			out: true,
+		},
+		"link-local by name": {
+			in:  netip.MustParseAddr("fe80::1%eth0"),
+			out: true,
+		},
+		"link-local by index": {
+			in:  netip.MustParseAddr("fe80::1%2"),
+			out: true,
+		},
+		"link-local of another interface": {
+			in: netip.MustParseAddr("fe80::1%eth1"),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, isLocal(local, tc.in))
+		})
+	}
+}
+
+func TestRoutedSource(t *testing.T) {
+	assert.Equal(t, netip.MustParseAddr("127.0.0.1"), routedSource(netip.MustParseAddr("127.0.0.2"), ""))
+	assert.False(t, routedSource(netip.MustParseAddr("127.0.0.2"), "does-not-exist0").IsValid())
+}
+
+func TestSourcesOnLink(t *testing.T) {
+	eth0 := &net.Interface{Index: 2, Name: "eth0"}
+	eth1 := &net.Interface{Index: 3, Name: "eth1"}
+
+	s := &sources{connected: []connectedPrefix{
+		{prefix: netip.MustParsePrefix("10.0.0.0/16"), addr: netip.MustParseAddr("10.0.0.1"), iface: eth0},
+		{prefix: netip.MustParsePrefix("10.0.1.0/24"), addr: netip.MustParseAddr("10.0.1.2"), iface: eth1},
+		{prefix: netip.MustParsePrefix("10.1.0.0/24"), addr: netip.MustParseAddr("10.1.0.2"), iface: eth0},
+		{prefix: netip.MustParsePrefix("10.1.0.0/24"), addr: netip.MustParseAddr("10.1.0.3"), iface: eth1},
+		{prefix: netip.MustParsePrefix("fd00::/64"), addr: netip.MustParseAddr("fd00::1"), iface: eth0},
+	}}
+
+	testcases := map[string]struct {
+		in   netip.Addr
+		out  *net.Interface
+		addr netip.Addr
+	}{
+		"on-link": {
+			in:   netip.MustParseAddr("10.0.2.1"),
+			out:  eth0,
+			addr: netip.MustParseAddr("10.0.0.1"),
+		},
+		"longest prefix": {
+			in:   netip.MustParseAddr("10.0.1.1"),
+			out:  eth1,
+			addr: netip.MustParseAddr("10.0.1.2"),
+		},
+		"ambiguous": {
+			in: netip.MustParseAddr("10.1.0.1"),
+		},
+		"IPv6": {
+			in:   netip.MustParseAddr("fd00::2"),
+			out:  eth0,
+			addr: netip.MustParseAddr("fd00::1"),
+		},
+		"routed": {
+			in: netip.MustParseAddr("192.0.2.1"),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := s.onLink(tc.in)
+			if tc.out == nil {
+				assert.Nil(t, c)
+				return
+			}
+
+			assert.Equal(t, tc.out, c.iface)
+			assert.Equal(t, tc.addr, c.addr)
+		})
+	}
+}
+
+func BenchmarkSourcesLookupSubnet(b *testing.B) {
+	s, err := newSources(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	hosts, err := prefixHosts(netip.MustParsePrefix("127.0.1.0/24"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, ip := range hosts {
+			s.lookup(ip)
+		}
+	}
+}
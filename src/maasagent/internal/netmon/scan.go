@@ -1,20 +1,32 @@
 package netmon
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"math/rand"
 	"net"
 	"net/netip"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 
-	pcap "github.com/packetcap/go-pcap"
+	"golang.org/x/exp/slog"
 	"golang.org/x/net/bpf"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -22,113 +34,1595 @@ const (
 	// https://github.com/google/gopacket/blob/v1.1.19/pcap/pcap.go#L124
 	BlockForever     time.Duration = -time.Millisecond * 10
 	OperationTimeout time.Duration = 3 * time.Second
-	SnapLen          int32         = 64
+	// SnapLen is the number of bytes read of every captured reply, enough
+	// for the link-layer option of a Neighbor Advertisement (86 bytes)
+	// behind an 802.1Q tag
+	SnapLen int32 = 128
 )
 
+var (
+	// ErrMissingZone is returned when a link-local IPv6 address is passed
+	// without a zone, as the outgoing interface would be ambiguous
+	ErrMissingZone = errors.New("link-local IPv6 address requires a zone")
+	// ErrInvalidAddr is set as ScanEntry.Err for addresses that are not valid
+	ErrInvalidAddr = errors.New("invalid address")
+	// ErrInterfaceNotFound is returned when ScanOptions.Interface
+	// does not name an existing interface
+	ErrInterfaceNotFound = errors.New("interface not found")
+	// ErrInterfaceDown is returned when ScanOptions.Interface is not up
+	ErrInterfaceDown = errors.New("interface is down")
+	// ErrNoInterfaceAddr is returned when ScanOptions.Interface has no address
+	// in the family of the scanned addresses
+	ErrNoInterfaceAddr = errors.New("interface has no address in the address family")
+	// ErrPermissionDenied is returned when sockets required for the scan
+	// can't be opened or used because of missing privileges, like CAP_NET_RAW
+	ErrPermissionDenied = errors.New("not permitted to scan")
+	// ErrNoRawSocket is returned when a raw or packet socket required for
+	// the scan can't be opened or set up for another reason than missing
+	// privileges, like a kernel without support for the address family.
+	// It wraps the error of the system call.
+	ErrNoRawSocket = errors.New("raw socket unavailable")
+	// ErrNoProbesSent is returned when not a single probe could be sent,
+	// it wraps the error that prevented the first probe from being sent
+	ErrNoProbesSent = errors.New("no probes sent")
+	// ErrPrefixTooLarge is returned by ScanPrefix for prefixes with more
+	// than 2^MaxPrefixHostBits addresses
+	ErrPrefixTooLarge = errors.New("prefix is too large to scan")
+	// ErrNoHardwareAddr is set as ScanEntry.Err of addresses probed with
+	// ARP through an interface that has no Ethernet address
+	ErrNoHardwareAddr = errors.New("interface has no hardware address")
+	// ErrNotOnLink is set as ProbeEntry.Err for addresses that are not
+	// on the link of any interface
+	ErrNotOnLink = errors.New("address is not on-link")
+	// ErrInvalidVLAN is returned when the ID set with WithVLAN is above MaxVLAN
+	ErrInvalidVLAN = errors.New("invalid VLAN ID")
+	// ErrVLANUnsupported is returned when probes can't be tagged with the VLAN
+	// set with WithVLAN, instead of sending them untagged
+	ErrVLANUnsupported = errors.New("VLAN tagging is not supported")
+	// ErrInvalidSourceIP is an error for when the address of WithSourceIP
+	// is not a host address of an IPv4 subnet of the scan interface
+	ErrInvalidSourceIP = errors.New("invalid source address")
+	// ErrNonEthernet is set as ScanEntry.Warning of addresses whose replies
+	// were dropped for hardware addresses that are not 6 byte Ethernet
+	// addresses, see WithAllowNonEthernet
+	ErrNonEthernet = errors.New("hardware address is not an Ethernet address")
+)
+
+// wrappedError is an error of the netmon package caused by another error,
+// both of them can be matched with errors.Is and errors.As
+type wrappedError struct {
+	kind error
+	err  error
+}
+
+func (e *wrappedError) Error() string { return e.kind.Error() + ": " + e.err.Error() }
+
+func (e *wrappedError) Is(target error) bool { return target == e.kind }
+
+func (e *wrappedError) Unwrap() error { return e.err }
+
+// permissionError wraps err with ErrPermissionDenied if it is caused by
+// missing privileges
+func permissionError(err error) error {
+	if errors.Is(err, ErrPermissionDenied) {
+		return err
+	}
+
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+		return &wrappedError{kind: ErrPermissionDenied, err: err}
+	}
+
+	return err
+}
+
+// socketError wraps err, which prevented opening a socket, with
+// ErrPermissionDenied if it is caused by missing privileges, or otherwise
+// with ErrNoRawSocket if a system call failed. Errors of the netmon
+// package, like ErrNoInterfaceAddr, are returned as is.
+func socketError(err error) error {
+	err = permissionError(err)
+	if errors.Is(err, ErrPermissionDenied) {
+		return err
+	}
+
+	var serr *os.SyscallError
+	if errors.As(err, &serr) {
+		return &wrappedError{kind: ErrNoRawSocket, err: err}
+	}
+
+	return err
+}
+
 var (
 	// Raw instruction of BPF filter generated with:
-	// tcpdump -dd "icmp[icmptype]=icmp-echoreply or icmp6[icmp6type]=icmp6-echoreply"
+	// tcpdump -dd "icmp[icmptype]=icmp-echoreply or \
+	// icmp6[icmp6type]=icmp6-echoreply or icmp6[icmp6type]=icmp6-neighboradvert or \
+	// arp[6:2]=2"
 	icmpEchoReplyFilter = []bpf.RawInstruction{
 		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
 		{Op: 0x15, Jt: 0, Jf: 7, K: 0x00000800},
 		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
-		{Op: 0x15, Jt: 0, Jf: 11, K: 0x00000001},
+		{Op: 0x15, Jt: 0, Jf: 15, K: 0x00000001},
 		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
-		{Op: 0x45, Jt: 9, Jf: 0, K: 0x00001fff},
+		{Op: 0x45, Jt: 13, Jf: 0, K: 0x00001fff},
 		{Op: 0xb1, Jt: 0, Jf: 0, K: 0x0000000e},
 		{Op: 0x50, Jt: 0, Jf: 0, K: 0x0000000e},
-		{Op: 0x15, Jt: 5, Jf: 6, K: 0x00000000},
+		{Op: 0x15, Jt: 9, Jf: 10, K: 0x00000000},
 		{Op: 0x15, Jt: 0, Jf: 5, K: 0x000086dd},
 		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000014},
-		{Op: 0x15, Jt: 0, Jf: 3, K: 0x0000003a},
+		{Op: 0x15, Jt: 0, Jf: 7, K: 0x0000003a},
 		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000036},
-		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000081},
+		{Op: 0x15, Jt: 4, Jf: 0, K: 0x00000081},
+		{Op: 0x15, Jt: 3, Jf: 4, K: 0x00000088},
+		{Op: 0x15, Jt: 0, Jf: 3, K: 0x00000806},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000002},
 		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
 		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
 	}
-)
+	// Raw instruction of BPF filter of WithDADMode generated with:
+	// tcpdump -dd "icmp[icmptype]=icmp-echoreply or \
+	// icmp6[icmp6type]=icmp6-echoreply or icmp6[icmp6type]=icmp6-neighboradvert or \
+	// arp"
+	dadFilter = []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 7, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 13, K: 0x00000001},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x45, Jt: 11, Jf: 0, K: 0x00001fff},
+		{Op: 0xb1, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x50, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x15, Jt: 7, Jf: 8, K: 0x00000000},
+		{Op: 0x15, Jt: 0, Jf: 5, K: 0x000086dd},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x15, Jt: 0, Jf: 5, K: 0x0000003a},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000036},
+		{Op: 0x15, Jt: 2, Jf: 0, K: 0x00000081},
+		{Op: 0x15, Jt: 1, Jf: 2, K: 0x00000088},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000806},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}
+)
+
+// IsRetryable returns false if a scan that failed with err will fail again
+// when retried, because of invalid input, a misconfigured interface or missing
+// privileges. Other errors, like running out of socket buffers, are transient.
+func IsRetryable(err error) bool {
+	for _, permanent := range []error{
+		ErrMissingZone,
+		ErrInterfaceNotFound,
+		ErrInterfaceDown,
+		ErrNoInterfaceAddr,
+		ErrPermissionDenied,
+		ErrInvalidVLAN,
+		ErrVLANUnsupported,
+		ErrInvalidSourceIP,
+		syscall.EPERM,
+		syscall.EACCES,
+	} {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// duplicateReplyWait is how long replies are still collected once every
+// address has replied, as a second host answering for the same address
+// replies right after the first one
+const duplicateReplyWait = 100 * time.Millisecond
+
+// captureReplySize is the receive buffer space reserved for every address
+// of a scan, which holds the kernel overhead of a reply and of duplicates
+// of it, like both directions of a reply on the loopback interface
+const captureReplySize = 4096
+
+// maxCaptureBuffer bounds the receive buffer of the capture
+const maxCaptureBuffer = 32 << 20
+
+// DADListenWindow is how long scans with WithDADMode collect claims after
+// their timeout, the ANNOUNCE_WAIT of RFC 5227, so that a host announcing
+// its address in answer to the last probe is still seen
+const DADListenWindow = 2 * time.Second
+
+// MaxPrefixHostBits is the largest number of host bits of a prefix
+// scanned by ScanPrefix
+const MaxPrefixHostBits = 16
+
+const (
+	// MinConcurrency is the default concurrency of agents with few CPUs
+	MinConcurrency = 256
+	// MaxConcurrency bounds the default concurrency of agents with many CPUs
+	MaxConcurrency = 4096
+	// concurrencyPerCPU is the default concurrency added by each CPU
+	concurrencyPerCPU = 64
+)
+
+// DefaultConcurrency is the number of probes awaiting a reply at once
+// when WithConcurrency is not used, derived from the number of CPUs
+var DefaultConcurrency = defaultConcurrency(runtime.NumCPU())
+
+// defaultConcurrency returns the default concurrency with cpus. Each probe
+// awaiting a reply has a worker that looked up its source and sent it,
+// more CPUs keep more of them busy.
+func defaultConcurrency(cpus int) int {
+	n := cpus * concurrencyPerCPU
+
+	switch {
+	case n < MinConcurrency:
+		return MinConcurrency
+	case n > MaxConcurrency:
+		return MaxConcurrency
+	}
+
+	return n
+}
+
+// scanOptions are options of a scan set with Option
+type scanOptions struct {
+	timeout          time.Duration
+	concurrency      int
+	iface            string
+	retries          int
+	icmpFallback     bool
+	rate             int
+	arpProbe         bool
+	onlyResponders   bool
+	probeTimeout     time.Duration
+	vlan             uint16
+	jitter           time.Duration
+	replyWait        time.Duration
+	batchSize        int
+	logger           *slog.Logger
+	waitForAll       bool
+	spreadOver       time.Duration
+	sourceIP         netip.Addr
+	allowNonEthernet bool
+	dad              bool
+	recvBufferBytes  int
+	dropCount        *uint64
+	transport        transport
+	entries          *ScanEntries
+}
+
+// Option allows to tune Scan, ScanDetailed and ScanStream
+type Option func(o *scanOptions)
+
+// WithTimeout sets how long replies are collected, shortened by the context
+// deadline if it is earlier. Without this option replies are collected until
+// the context deadline, or for OperationTimeout if the context has no deadline.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *scanOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithInterface sets the name of the interface probes are sent and replies are
+// captured on. Without this option the interface is chosen by the routing
+// table and replies are captured on all interfaces.
+func WithInterface(name string) Option {
+	return func(o *scanOptions) {
+		o.iface = name
+	}
+}
+
+// WithConcurrency sets the maximum number of probes awaiting a reply at once
+// (default: DefaultConcurrency)
+func WithConcurrency(n int) Option {
+	return func(o *scanOptions) {
+		o.concurrency = n
+	}
+}
+
+// WithICMPFallback enables a lookup of addresses that did not reply in the
+// kernel neighbor table once the scan is done. Probes make the kernel resolve
+// the hardware address of every address, so a host that answers ARP or
+// Neighbor Solicitation but drops ICMP Echo is still found this way.
+// Such hosts are reported the same way as hosts that replied, but without
+// a latency.
+func WithICMPFallback() Option {
+	return func(o *scanOptions) {
+		o.icmpFallback = true
+	}
+}
+
+// WithARPProbe makes IPv4 addresses probed with ARP probes as described in
+// RFC 5227 instead of ICMP Echo requests, the way DHCP clients check that
+// an offered address is free. Probes carry 0.0.0.0 as the sender address,
+// so they can be sent from an interface without an IPv4 address and do not
+// update neighbor caches of the scanned hosts.
+// As the kernel does not resolve the addresses, WithICMPFallback only finds
+// IPv4 addresses that were already in the neighbor table.
+// IPv6 addresses are still probed with ICMP Echo requests.
+func WithARPProbe() Option {
+	return func(o *scanOptions) {
+		o.arpProbe = true
+	}
+}
+
+// WithDADMode makes a scan detect duplicate addresses like a DHCP client
+// before it takes an address: IPv4 addresses are probed with ARP probes like
+// with WithARPProbe, and any ARP packet claiming a probed address is taken as
+// a reply from the host claiming it. Besides replies, these are gratuitous
+// ARP announcements, requests sent from the address, and probes for the same
+// address sent by another host at the same time. Probes of this host are
+// ignored. Claims of a host defending its address may come after the last
+// probe, so replies are collected for DADListenWindow after the timeout.
+// The deadline of the context is not extended, so a scan bounded by the
+// context alone must leave room for the window. IPv6 addresses are scanned
+// like without this option.
+func WithDADMode() Option {
+	return func(o *scanOptions) {
+		o.arpProbe = true
+		o.dad = true
+	}
+}
+
+// WithSourceIP makes IPv4 addresses probed with ARP requests claiming addr
+// as the sender address instead of ICMP Echo requests, like if the request
+// came from a host using addr. It observes how hosts treat a host taking
+// over an address, like one holding a candidate DHCP lease, which ARP
+// probes of WithARPProbe, sending 0.0.0.0, can't do. WithInterface is
+// required and addr must be a host address of an IPv4 subnet of the
+// interface, otherwise the scan fails with ErrInvalidSourceIP. Addresses
+// outside of that subnet are still probed, and counted in a warning of the
+// logger of WithLogger. IPv6 addresses are probed as without it, and Probe
+// ignores it.
+//
+// Unlike ARP probes, such requests update neighbor caches: every scanned
+// host, and any host on the link that already knows addr, then sends
+// traffic for addr to the scanning interface until its entry expires,
+// which cuts off the host actually using addr. It must only be used with
+// addresses that are known to be free, or owned by the host it is done for.
+func WithSourceIP(addr netip.Addr) Option {
+	return func(o *scanOptions) {
+		o.sourceIP = addr
+	}
+}
+
+// WithAllowNonEthernet keeps replies with hardware addresses of any length.
+// Without this option replies whose hardware address is not a 6 byte Ethernet
+// address, like those of InfiniBand or of malformed packets, are dropped and
+// reported with ScanEntry.Warning, so that they are not taken for MAC
+// addresses. Probe is not affected, any reply means that an address is in use.
+func WithAllowNonEthernet() Option {
+	return func(o *scanOptions) {
+		o.allowNonEthernet = true
+	}
+}
+
+// WithVLAN makes probes tagged with the 802.1Q VLAN id and replies captured
+// only from that VLAN, to scan a VLAN that the host has no interface on.
+// As tags are added to frames written by the scan, IPv4 addresses are probed
+// with ARP probes like with WithARPProbe, and IPv6 addresses with Neighbor
+// Solicitations sent from the unspecified address. WithInterface is required
+// and must name an Ethernet interface, otherwise the scan fails with
+// ErrVLANUnsupported. Ping does not support VLANs.
+// (default: 0, untagged)
+func WithVLAN(id uint16) Option {
+	return func(o *scanOptions) {
+		o.vlan = id
+	}
+}
+
+// WithOnlyResponders makes Scan and ScanDetailed return only addresses that
+// responded, dropping the ones that did not respond or could not be probed.
+// Addresses answered by several hosts are kept with all of their MACs, so
+// conflicts are still reported. ScanStream only sends responding addresses
+// regardless of this option.
+func WithOnlyResponders() Option {
+	return func(o *scanOptions) {
+		o.onlyResponders = true
+	}
+}
+
+// WithRate limits the number of probes sent per second, to avoid tripping
+// broadcast storm control of switches with the address resolution caused by
+// the probes. Probes that can't be sent before the deadline are not sent.
+// (default: unlimited)
+func WithRate(pps int) Option {
+	return func(o *scanOptions) {
+		o.rate = pps
+	}
+}
+
+// WithProbeTimeout sets how long every probe awaits a reply before the next
+// attempt or wave of probes is sent. Without this option the timeout of the
+// scan is shared equally between all waves and attempts. Replies are still
+// only collected until the timeout of the scan.
+func WithProbeTimeout(timeout time.Duration) Option {
+	return func(o *scanOptions) {
+		o.probeTimeout = timeout
+	}
+}
+
+// WithJitter delays the first probe of every address by a random offset up
+// to max, so that scans started at the same time by several hosts don't send
+// their probes in the same instant. The jitter of every wave of probes is
+// taken from the time left to await replies, and no probe is delayed past
+// the point where its reply could not be awaited before the timeout.
+// Retries are not delayed. (default: 0, no jitter)
+func WithJitter(max time.Duration) Option {
+	return func(o *scanOptions) {
+		o.jitter = max
+	}
+}
+
+// WithSpreadOver sends the first probes of all addresses at evenly spaced
+// times over d, so that agents scanning the same network at the same time
+// don't send a burst of probes. Unlike WithRate, the target is the duration
+// of the scan rather than a rate. Replies are collected for d on top of the
+// timeout of the scan, so that replies to the last probes are not missed.
+// With a context deadline and no WithTimeout, the window is taken from the
+// time left until the deadline, and shortened to half of it if it would not
+// leave any time to await replies. Retries are sent once every first probe
+// was sent. Probes are not spread when zero.
+func WithSpreadOver(d time.Duration) Option {
+	return func(o *scanOptions) {
+		o.spreadOver = d
+	}
+}
+
+// WithProbesPerHost sets how many probes are sent at most to every address.
+// It is equivalent to WithRetries(n - 1), whichever of them is passed last
+// applies. An address stops being probed once it replied. (default: 1)
+func WithProbesPerHost(n int) Option {
+	return WithRetries(n - 1)
+}
+
+// WithReplyWait sets how long replies are awaited after the last probe
+// of every address, so that late replies on slow or lossy links are still
+// caught without extending the timeout of the scan. It is taken from the
+// time shared by earlier attempts, which WithProbeTimeout overrides.
+// Replies are still only collected until the timeout of the scan.
+// Without this option the last probe waits as long as the others.
+func WithReplyWait(d time.Duration) Option {
+	return func(o *scanOptions) {
+		o.replyWait = d
+	}
+}
+
+// WithBatchSize splits scans of more than n addresses into batches of n
+// addresses scanned one after the other, each batch getting its share of
+// the timeout of the scan. It bounds the probes in flight, the capture
+// buffer and the state held for addresses awaiting a reply, at the cost of
+// a capture and sockets opened for every batch. Entries are the same as
+// those of a single scan, ScanStream sends results of every batch as they
+// arrive. Without this option, or if n is not positive, all addresses are
+// scanned at once.
+func WithBatchSize(n int) Option {
+	return func(o *scanOptions) {
+		o.batchSize = n
+	}
+}
+
+// WithRecvBufferBytes sets the receive buffer of the capture of replies to
+// n bytes, instead of room for the replies of every address of the scan
+// bounded by 32 MiB, so that replies of large and fast scans are not dropped.
+// The buffer is capped by net.core.rmem_max unless the process has
+// CAP_NET_ADMIN, and it is not made smaller than the default of the kernel.
+func WithRecvBufferBytes(n int) Option {
+	return func(o *scanOptions) {
+		o.recvBufferBytes = n
+	}
+}
+
+// WithDropCount adds to n the number of replies that the kernel dropped as
+// the receive buffer of the capture was full, counted for every batch (see
+// WithBatchSize). Addresses whose replies were dropped look as if they did
+// not reply, so a scan that dropped replies may have missed hosts, which
+// may be found by a scan of the addresses that did not reply or a larger
+// buffer (see WithRecvBufferBytes). n is updated atomically, so that it can
+// be shared by concurrent scans.
+func WithDropCount(n *uint64) Option {
+	return func(o *scanOptions) {
+		o.dropCount = n
+	}
+}
+
+// WithWaitForAll returns a scan as soon as every probed address has
+// replied, instead of collecting replies of other hosts answering for the
+// same addresses for a short while, which only ScanEntry.MACs would report.
+// Addresses that could not be probed don't delay the scan, and the timeout
+// still bounds it when an address does not reply. Scans stop early without
+// this option as well, once duplicate replies are no longer awaited.
+func WithWaitForAll() Option {
+	return func(o *scanOptions) {
+		o.waitForAll = true
+	}
+}
+
+// WithLogger sets the logger of Scan, ScanDetailed and ScanStream, which
+// log a summary of every scan at info level, and every probe sent, reply
+// received and address that timed out at debug level. Debug records are
+// only built if the handler of l is enabled for them when the scan starts.
+// Scans are not logged without this option.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *scanOptions) {
+		o.logger = l
+	}
+}
+
+// WithRetries sets how many times a probe is sent again to an address that
+// did not reply. Probes are sent again once every address has been probed,
+// and only to addresses that did not reply yet. Waiting for replies is shared
+// equally between the attempts, so retries do not extend the scan.
+// It is equivalent to WithProbesPerHost(n + 1). (default: 0)
+func WithRetries(n int) Option {
+	return func(o *scanOptions) {
+		o.retries = n
+	}
+}
+
+// WithEntries sets *e to the entries of every address scanned by ScanStream
+// once it returns, also on errors, so that a streamed scan tells addresses
+// that did not respond from those that could not be probed, which are not
+// streamed. ScanDetailed returns them already.
+func WithEntries(e *ScanEntries) Option {
+	return func(o *scanOptions) {
+		o.entries = e
+	}
+}
+
+// ScanEntry is the outcome of scanning a single address
+type ScanEntry struct {
+	// MAC is the hardware address of the first reply
+	MAC net.HardwareAddr
+	// MACs are all distinct hardware addresses that replied, starting with
+	// MAC. More than one means that several hosts use the address.
+	MACs []net.HardwareAddr
+	// Responded is true if a reply was received from the address
+	Responded bool
+	// Latency is the time between sending the probe and receiving the reply.
+	// When the address was probed more than once, it is measured from the
+	// last probe sent before the reply.
+	Latency time.Duration
+	// Err is set if the address could not be probed, in which case
+	// it is unknown whether the address is in use
+	Err error
+	// Interface is the name of the interface that the probe left through,
+	// InterfaceIndex its index and SourceMAC its hardware address. They are
+	// empty if the address was not probed or the interface is unknown.
+	Interface      string
+	InterfaceIndex int
+	SourceMAC      net.HardwareAddr
+	// SourceIP is the source address of the probe, which is zero if it is
+	// unknown, and 0.0.0.0 for ARP probes (see WithARPProbe)
+	SourceIP netip.Addr
+	// Attempts is the number of probes sent to the address
+	Attempts int
+	// SeenAt is the time the first reply was received
+	SeenAt time.Time
+	// Self is set if the address is assigned to an interface of this host,
+	// such addresses are not probed as a reply would not tell anything
+	Self bool
+	// Warning is set if a reply from the address was dropped, like with
+	// ErrNonEthernet. Other replies are still reported.
+	Warning error
+}
+
+// withSource returns the entry with the interface that its probe left
+// through and the source address of the probe
+func (e ScanEntry) withSource(iface *net.Interface, addr netip.Addr) ScanEntry {
+	if iface != nil {
+		e.Interface = iface.Name
+		e.InterfaceIndex = iface.Index
+		e.SourceMAC = iface.HardwareAddr
+	}
+
+	e.SourceIP = addr
+
+	return e
+}
+
+// ScanEntries are outcomes of ScanDetailed keyed by scanned address
+type ScanEntries map[netip.Addr]ScanEntry
+
+// HardwareAddrs returns hardware addresses of entries, with nil for
+// addresses that did not respond
+func (e ScanEntries) HardwareAddrs() map[netip.Addr]net.HardwareAddr {
+	res := make(map[netip.Addr]net.HardwareAddr, len(e))
+
+	for ip, entry := range e {
+		res[ip] = entry.MAC
+	}
+
+	return res
+}
+
+// responders returns entries of addresses that responded
+func (e ScanEntries) responders() ScanEntries {
+	if e == nil {
+		return nil
+	}
+
+	res := make(ScanEntries, len(e))
+
+	for ip, entry := range e {
+		if entry.Responded {
+			res[ip] = entry
+		}
+	}
+
+	return res
+}
+
+// Conflicts returns hardware addresses of entries that got replies from
+// more than one hardware address
+func (e ScanEntries) Conflicts() map[netip.Addr][]net.HardwareAddr {
+	res := make(map[netip.Addr][]net.HardwareAddr)
+
+	for ip, entry := range e {
+		if len(entry.MACs) > 1 {
+			res[ip] = entry.MACs
+		}
+	}
+
+	return res
+}
+
+// target is an address being probed by Scan
+type target struct {
+	// ip is the address as requested by the caller
+	ip netip.Addr
+	// replied is closed once a reply for ip has been received
+	replied chan struct{}
+	// err is set by the worker if the probe could not be sent
+	err error
+	// probed is set by the worker once the first probe is sent
+	probed bool
+	// source and sourceAddr are set by the worker before the first
+	// probe is sent
+	source     *net.Interface
+	sourceAddr netip.Addr
+	// sentAt is set by the worker right before the probe is sent
+	sentAt time.Time
+	// attempts is the number of probes sent by workers
+	attempts int
+	// jitter delays the first probe, it is cleared once it was waited for
+	jitter time.Duration
+	// sendAt is when the first probe is sent with WithSpreadOver,
+	// it is cleared once it was waited for
+	sendAt time.Time
+	id     int
+}
+
+func (t *target) isReplied() bool {
+	select {
+	case <-t.replied:
+		return true
+	default:
+		return false
+	}
+}
+
+// Scan sends ICMP Echo requests to provided IP addresses and returns hardware
+// addresses of the replies. See ScanDetailed for details.
+func Scan(ctx context.Context, ips []netip.Addr,
+	opts ...Option) (map[netip.Addr]net.HardwareAddr, error) {
+	return defaultScanner.Scan(ctx, ips, opts...)
+}
+
+// ScanPrefix scans every host address of prefix, see Scanner.ScanPrefix
+func ScanPrefix(ctx context.Context, prefix netip.Prefix,
+	opts ...Option) (map[netip.Addr]net.HardwareAddr, error) {
+	return defaultScanner.ScanPrefix(ctx, prefix, opts...)
+}
+
+// ScanDetailed sends ICMP Echo requests to provided IP addresses.
+// Hardware addresses are learned from Echo replies and also from ARP replies
+// and Neighbor Advertisements sent in response to the kernel's address
+// resolution, so hosts that resolve but drop ICMP Echo are still reported.
+// Replies from every host answering for an address are kept in
+// ScanEntry.MACs, which reveals address conflicts.
+// Link-local IPv6 addresses must carry a zone to select the outgoing interface.
+// A failure to probe one address family does not prevent probing the other,
+// ErrNoProbesSent is returned only if no probe could be sent at all. Otherwise
+// addresses that could not be probed have ScanEntry.Err set, to tell them
+// apart from addresses that did not respond.
+// Addresses assigned to an interface of the host are not probed, as the host
+// would answer itself, and have ScanEntry.Self set instead. They are read
+// again by every scan.
+// Replies are collected until the context deadline, or for OperationTimeout
+// if the context has no deadline, unless WithTimeout is used.
+// If the context is canceled, or its deadline passes while WithTimeout is
+// used, the scan stops right away and the context error is returned together
+// with the entries collected so far. Other errors that happen once replies
+// were collected, like a failure of a later batch of WithBatchSize, are
+// returned with the entries collected so far as well, so entries are only
+// nil if nothing was scanned. Sockets and goroutines of the scan are
+// released before it returns or shortly after.
+//
+// At most DefaultConcurrency probes (see WithConcurrency) await a reply
+// at once. When there are more addresses than that, probes are sent in waves
+// and the deadline is shared equally between them. Replies arriving after
+// the wave of a probe has ended are still collected until the deadline.
+func ScanDetailed(ctx context.Context, ips []netip.Addr, opts ...Option) (ScanEntries, error) {
+	return defaultScanner.ScanDetailed(ctx, ips, opts...)
+}
+
+func newScanOptions(opts []Option) scanOptions {
+	o := scanOptions{
+		concurrency: DefaultConcurrency,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.concurrency <= 0 {
+		o.concurrency = DefaultConcurrency
+	}
+
+	if o.retries < 0 {
+		o.retries = 0
+	}
+
+	return o
+}
+
+// ScanResult is a resolved address emitted by ScanStream
+type ScanResult struct {
+	IP      netip.Addr
+	MAC     net.HardwareAddr
+	Latency time.Duration
+	// Interface, InterfaceIndex, SourceMAC, SourceIP, Attempts and SeenAt
+	// are the same as in ScanEntry, as of the time the result is sent
+	Interface      string
+	InterfaceIndex int
+	SourceMAC      net.HardwareAddr
+	SourceIP       netip.Addr
+	Attempts       int
+	SeenAt         time.Time
+}
+
+// result returns the result of ip resolved to hwAddr,
+// which is one of MACs of the entry
+func (e ScanEntry) result(ip netip.Addr, hwAddr net.HardwareAddr) ScanResult {
+	return ScanResult{
+		IP:             ip,
+		MAC:            hwAddr,
+		Latency:        e.Latency,
+		Interface:      e.Interface,
+		InterfaceIndex: e.InterfaceIndex,
+		SourceMAC:      e.SourceMAC,
+		SourceIP:       e.SourceIP,
+		Attempts:       e.Attempts,
+		SeenAt:         e.SeenAt,
+	}
+}
+
+// ScanStream scans provided IP addresses like Scan, but sends every resolved
+// address to out as soon as its reply is parsed. An address answered by more
+// than one hardware address is sent once for each of them. out is closed when the scan
+// completes or the context is done. Sending blocks, so a slow reader delays
+// the collection of replies.
+func ScanStream(ctx context.Context, ips []netip.Addr, out chan<- ScanResult,
+	opts ...Option) error {
+	return defaultScanner.ScanStream(ctx, ips, out, opts...)
+}
+
+// scan implements ScanDetailed and ScanStream, every resolved address is
+// also sent to out unless it is nil
+func scan(ctx context.Context, ips []netip.Addr, opts scanOptions,
+	out chan<- ScanResult) (ScanEntries, error) {
+	result := make(ScanEntries, len(ips))
+
+	if len(ips) == 0 {
+		return result, nil
+	}
+
+	// parent is not bound by the scan deadline, so that addresses found by
+	// the fallback after the deadline can still be streamed
+	parent := ctx
+	// bounded is set when the scan has a deadline of its own, the caller has
+	// then given up if the deadline of parent passes
+	bounded := false
+
+	if err := checkZones(ips); err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok || opts.timeout > 0 {
+		timeout := OperationTimeout
+		if opts.timeout > 0 {
+			timeout = opts.timeout
+		}
+
+		if opts.dad {
+			timeout += DADListenWindow
+		}
+
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout+opts.spreadOver)
+		defer cancel()
+
+		bounded = true
+	}
+
+	if err := callerErr(parent, bounded); err != nil {
+		return nil, err
+	}
+
+	// trace is only set if debug records are handled, so that a scan
+	// does not build records that are dropped
+	var trace *slog.Logger
+	if opts.logger != nil && opts.logger.Enabled(ctx, slog.LevelDebug) {
+		trace = opts.logger
+	}
+
+	concurrency := opts.concurrency
+
+	var iface *net.Interface
+
+	if opts.iface != "" {
+		var err error
+
+		iface, err = net.InterfaceByName(opts.iface)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInterfaceNotFound, opts.iface)
+		}
+
+		// probes would not leave a down interface, and falling back to
+		// another interface would defeat the purpose of pinning
+		if iface.Flags&net.FlagUp == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInterfaceDown, opts.iface)
+		}
+	}
+
+	if opts.vlan != 0 {
+		if err := checkVLAN(opts.vlan, iface); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.sourceIP.IsValid() {
+		subnet, err := checkSourceIP(opts.sourceIP, iface)
+		if err != nil {
+			return nil, err
+		}
+
+		warnOffSubnet(ctx, opts.logger, subnet, ips)
+	}
+
+	cctx, ccancel := context.WithCancel(ctx)
+	defer ccancel()
+
+	bufSize := captureBufferSize(len(ips))
+	if opts.recvBufferBytes > 0 {
+		bufSize = opts.recvBufferBytes
+	}
+
+	var tr transport = rawTransport{}
+	if opts.transport != nil {
+		tr = opts.transport
+	}
+
+	pairs, drops, err := tr.capture(cctx, opts.iface, opts.vlan, bufSize, opts.dad)
+	if err != nil {
+		return nil, err
+	}
+
+	srcs, err := newSources(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	// addresses of the host are read on every scan, as they may change
+	// between scans
+	local, err := localAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	// probes of this host claim the probed addresses in DAD mode
+	var own map[string]struct{}
+	if opts.dad {
+		if own, err = localHardwareAddrs(); err != nil {
+			return nil, err
+		}
+	}
+
+	// offsets are drawn upfront, as a source can't be shared by workers
+	var rnd *rand.Rand
+	if opts.jitter > 0 {
+		rnd = rand.New(rand.NewSource(time.Now().UnixNano())) //nolint:gosec // not used for security
+	}
+
+	// targets are keyed by addresses as seen on the wire (without zone)
+	targets := make(map[netip.Addr]*target, len(ips))
+	conns := make(map[int]sender)
+	// connErrs keep errors of address families that can't be probed
+	connErrs := make(map[int]error)
+
+	var (
+		queue []*target
+		// sendErr keeps the first error that prevented sending a probe
+		sendErr error
+	)
+
+	for i, ip := range ips {
+		result[ip] = ScanEntry{}
+
+		if !ip.IsValid() {
+			result[ip] = ScanEntry{Err: ErrInvalidAddr}
+			continue
+		}
+
+		if isLocal(local, ip) {
+			result[ip] = ScanEntry{Self: true}
+			continue
+		}
+
+		if _, ok := targets[ip.WithZone("")]; ok {
+			continue
+		}
+
+		if err, ok := connErrs[ip.BitLen()]; ok {
+			result[ip] = ScanEntry{Err: err}
+			continue
+		}
+
+		c, ok := conns[ip.BitLen()]
+		if !ok {
+			c, err = tr.sender(ip, iface, opts)
+			if err != nil {
+				err = socketError(err)
+				connErrs[ip.BitLen()] = err
+				result[ip] = ScanEntry{Err: err}
+
+				if sendErr == nil {
+					sendErr = err
+				}
+
+				continue
+			}
+
+			defer func() {
+				err := c.Close()
+				if err != nil {
+					panic(err)
+				}
+			}()
+
+			conns[ip.BitLen()] = c
+		}
+
+		t := &target{ip: ip, id: i, replied: make(chan struct{})}
+		if rnd != nil {
+			t.jitter = time.Duration(rnd.Int63n(int64(opts.jitter)))
+		}
+
+		targets[ip.WithZone("")] = t
+		queue = append(queue, t)
+	}
+
+	if len(queue) == 0 && sendErr != nil {
+		return nil, &wrappedError{kind: ErrNoProbesSent, err: sendErr}
+	}
+
+	// nothing to wait for, like when only addresses of the host are scanned
+	if len(queue) == 0 {
+		return result, nil
+	}
+
+	var limiter *rate.Limiter
+	if opts.rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.rate), 1)
+	}
+
+	deadline, _ := ctx.Deadline()
+	attempts := opts.retries + 1
+
+	// probes of DAD scans end before the listen window
+	budget := time.Until(deadline)
+	if opts.dad && budget > DADListenWindow {
+		budget -= DADListenWindow
+	}
+
+	window := spreadWindow(opts.spreadOver, budget)
+	wait, lastWait := replyWaits(jitterBudget(budget-window, len(queue), concurrency, opts.jitter),
+		len(queue), concurrency, attempts, opts)
+
+	if window > 0 {
+		start := time.Now()
+
+		for i, t := range queue {
+			t.sendAt = start.Add(time.Duration(int64(window) * int64(i) / int64(len(queue))))
+		}
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		// sent is the number of probes that were handled by workers
+		sent int
+	)
+
+	// workers must stop before connections are closed
+	stop := func() {
+		ccancel()
+		wg.Wait()
+	}
+	defer stop()
+
+	work := make(chan *target)
+
+	// pass is done once every probe of the current attempt was handled
+	var pass sync.WaitGroup
+
+	go schedule(cctx, queue, attempts, work, &pass)
+
+	for i := 0; i < concurrency && i < len(queue); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for t := range work {
+				if !t.probed {
+					source, addr := srcs.lookup(t.ip)
+					// probes written as frames don't claim an address of the sender,
+					// unless it is set with WithSourceIP
+					if s, ok := conns[t.ip.BitLen()].(*frameSender); ok {
+						addr = netip.IPv4Unspecified()
+						if t.ip.Is6() {
+							addr = netip.IPv6Unspecified()
+						} else if s.sourceIP.IsValid() {
+							addr = s.sourceIP
+						}
+					}
+
+					mu.Lock()
+					t.source = source
+					t.sourceAddr = addr
+					mu.Unlock()
+				}
+
+				if !t.sendAt.IsZero() {
+					at := t.sendAt
+					t.sendAt = time.Time{}
+
+					if !sleep(cctx, time.Until(at)) {
+						pass.Done()
+						continue
+					}
+				}
+
+				if t.jitter > 0 {
+					delay := jitterDelay(t.jitter, time.Until(deadline), wait)
+					t.jitter = 0
+
+					if !sleep(cctx, delay) {
+						pass.Done()
+						continue
+					}
+				}
+
+				mu.Lock()
+				w := wait
+				if t.attempts == attempts-1 {
+					w = lastWait
+				}
+				mu.Unlock()
+
+				err := probe(cctx, conns[t.ip.BitLen()], t, w, limiter, &mu, trace)
+				t.err = err
+
+				mu.Lock()
+				if err != nil && sendErr == nil {
+					sendErr = err
+				}
+
+				if err == nil && !t.probed {
+					t.probed = true
+					sent++
+				}
+				mu.Unlock()
+
+				pass.Done()
+			}
+		}()
+	}
+
+	m := getMetrics()
+
+	workersDone := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+
+	var (
+		resolved int
+		// linger fires once duplicate replies are no longer awaited
+		linger <-chan time.Time
+	)
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case pair := <-pairs:
+			t, ok := targets[pair.IP]
+			if !ok {
+				continue
+			}
+
+			if _, ok := own[pair.HwAddress.String()]; ok {
+				continue
+			}
+
+			m.received(opScan)
+
+			if !opts.allowNonEthernet && !isEthernetAddr(pair.HwAddress) {
+				dropReply(result, t.ip, pair.HwAddress)
+
+				if trace != nil {
+					trace.LogAttrs(ctx, slog.LevelDebug, "dropped reply",
+						slog.String("ip", t.ip.String()), slog.String("mac", pair.HwAddress.String()))
+				}
+
+				continue
+			}
+
+			var entry ScanEntry
+
+			if t.isReplied() {
+				entry = result[t.ip]
+				if hasHardwareAddr(entry.MACs, pair.HwAddress) {
+					continue
+				}
+
+				entry.MACs = append(entry.MACs, pair.HwAddress)
+			} else {
+				now := time.Now()
+
+				mu.Lock()
+				entry = ScanEntry{
+					MAC:       pair.HwAddress,
+					MACs:      []net.HardwareAddr{pair.HwAddress},
+					Responded: true,
+					Latency:   rtt(t.sentAt, now),
+					Attempts:  t.attempts,
+					SeenAt:    now,
+				}.withSource(t.source, t.sourceAddr)
+				mu.Unlock()
+
+				resolved++
+
+				close(t.replied)
+			}
+
+			result[t.ip] = entry
+
+			if trace != nil {
+				trace.LogAttrs(ctx, slog.LevelDebug, "got reply",
+					slog.String("ip", t.ip.String()), slog.String("mac", pair.HwAddress.String()),
+					slog.Duration("latency", entry.Latency))
+			}
+
+			if out != nil {
+				select {
+				case out <- entry.result(t.ip, pair.HwAddress):
+				case <-ctx.Done():
+					break loop
+				}
+			}
+		case <-workersDone:
+			workersDone = nil
+
+			if sent == 0 && sendErr != nil {
+				return nil, &wrappedError{kind: ErrNoProbesSent, err: sendErr}
+			}
+		case <-linger:
+			break loop
+		}
+
+		// resolved is only updated by this loop, so every target replied
+		// even if workers did not account for their last probe yet
+		if opts.waitForAll && resolved == len(queue) {
+			break loop
+		}
+
+		// all probes were sent and all of them got a reply, give duplicate
+		// replies a chance to arrive
+		if workersDone == nil && resolved >= sent && linger == nil {
+			timer := time.NewTimer(duplicateReplyWait)
+			defer timer.Stop()
+
+			linger = timer.C
+		}
+	}
+
+	// drops of a capture closed on the deadline are kept until they are read
+	if dropped := drops(); dropped > 0 {
+		m.dropped(opScan, dropped)
+
+		if opts.dropCount != nil {
+			atomic.AddUint64(opts.dropCount, uint64(dropped))
+		}
+
+		if opts.logger != nil {
+			opts.logger.LogAttrs(ctx, slog.LevelWarn, "replies dropped",
+				slog.Int("dropped", dropped), slog.Int("buffer", bufSize))
+		}
+	}
+
+	// target errors are only safe to read once workers have stopped
+	stop()
+
+	for _, t := range queue {
+		if t.err != nil && !t.isReplied() {
+			result[t.ip] = ScanEntry{Err: t.err}
+		}
+
+		if trace != nil && !t.isReplied() {
+			if t.err != nil {
+				trace.LogAttrs(parent, slog.LevelDebug, "probe failed",
+					slog.String("ip", t.ip.String()), slog.String("error", t.err.Error()))
+			} else {
+				trace.LogAttrs(parent, slog.LevelDebug, "timed out",
+					slog.String("ip", t.ip.String()), slog.Int("attempts", t.attempts))
+			}
+		}
+
+		entry := result[t.ip].withSource(t.source, t.sourceAddr)
+		entry.Attempts = t.attempts
+		result[t.ip] = entry
+	}
+
+	if err := callerErr(parent, bounded); err != nil {
+		return result, err
+	}
+
+	// replies were collected already, so entries are kept with the error
+	if opts.icmpFallback {
+		if err := resolveFromNeighbors(parent, result, queue, opts.allowNonEthernet, out); err != nil {
+			return result, err
+		}
+	}
 
-// Scan sends ICMP Echo requests to provided IP addresses.
-func Scan(ctx context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
-	result := make(map[netip.Addr]net.HardwareAddr, len(ips))
+	return result, nil
+}
 
-	if len(ips) == 0 {
-		return result, nil
+// checkZones returns an error if a link-local IPv6 address of ips has no zone
+func checkZones(ips []netip.Addr) error {
+	for _, ip := range ips {
+		if ip.Is6() && ip.IsLinkLocalUnicast() && ip.Zone() == "" {
+			return fmt.Errorf("%w: %s", ErrMissingZone, ip)
+		}
 	}
 
-	queue := make(map[netip.Addr]struct{})
-	conns := make(map[int]*icmp.PacketConn)
-
-	cctx, ccancel := context.WithCancel(ctx)
-	defer ccancel()
+	return nil
+}
 
-	pairs, err := capture(cctx)
-	if err != nil {
-		return nil, err
+// callerErr returns the error of ctx if the caller gave up on the scan.
+// Passing the deadline of ctx is the normal end of the scan, unless
+// the scan is bounded by a deadline of its own.
+func callerErr(ctx context.Context, bounded bool) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) && !bounded {
+		return nil
 	}
 
-	for i, ip := range ips {
-		result[ip] = nil
+	return err
+}
 
-		if !ip.IsValid() {
+// resolveFromNeighbors looks up targets that did not reply in the kernel
+// neighbor table
+func resolveFromNeighbors(ctx context.Context, result ScanEntries, queue []*target,
+	allowNonEthernet bool, out chan<- ScanResult) error {
+	var neigh map[netip.Addr]net.HardwareAddr
+
+	for _, t := range queue {
+		if t.isReplied() || t.err != nil {
 			continue
 		}
 
-		c, ok := conns[ip.BitLen()]
-		if !ok {
-			c, err = getConn(ip)
+		if neigh == nil {
+			var err error
+
+			neigh, err = neighbors()
 			if err != nil {
-				return nil, err
+				return err
 			}
+		}
 
-			defer func() {
-				err := c.Close()
-				if err != nil {
-					panic(err)
-				}
-			}()
+		hwAddr, ok := neigh[t.ip.WithZone("")]
+		if !ok {
+			continue
+		}
 
-			conns[ip.BitLen()] = c
+		if !allowNonEthernet && !isEthernetAddr(hwAddr) {
+			dropReply(result, t.ip, hwAddr)
+			continue
 		}
 
-		_, err := c.WriteTo(icmpMessage(ip, i), &net.IPAddr{IP: ip.AsSlice()})
-		if err != nil {
-			return nil, err
+		entry := result[t.ip]
+		entry.MAC = hwAddr
+		entry.MACs = []net.HardwareAddr{hwAddr}
+		entry.Responded = true
+		entry.SeenAt = time.Now()
+		result[t.ip] = entry
+
+		if out != nil {
+			select {
+			case out <- entry.result(t.ip, hwAddr):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// rtt returns the round-trip time of a probe. Both times are expected to come
+// from time.Now(), so that the monotonic clock is used and changes of the wall
+// clock do not affect the result. The result is never negative and is zero
+// if the reply was captured before the probe was sent.
+func rtt(sentAt, receivedAt time.Time) time.Duration {
+	if sentAt.IsZero() {
+		return 0
+	}
+
+	d := receivedAt.Sub(sentAt)
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
+
+// isEthernetAddr returns true if hwAddr has the length of an Ethernet address.
+// Replies without a hardware address are kept, as their length is unknown.
+func isEthernetAddr(hwAddr net.HardwareAddr) bool {
+	return len(hwAddr) == 0 || len(hwAddr) == 6
+}
+
+// dropReply sets the warning of the entry of ip for a reply with hwAddr
+// that was dropped as it is not an Ethernet address
+func dropReply(result ScanEntries, ip netip.Addr, hwAddr net.HardwareAddr) {
+	entry := result[ip]
+	entry.Warning = fmt.Errorf("%w: %s", ErrNonEthernet, hwAddr)
+	result[ip] = entry
+}
+
+// hasHardwareAddr returns true if hwAddrs contain hwAddr
+func hasHardwareAddr(hwAddrs []net.HardwareAddr, hwAddr net.HardwareAddr) bool {
+	for _, a := range hwAddrs {
+		if bytes.Equal(a, hwAddr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// probeWait returns how long each attempt of a probe awaits a reply,
+// so that all attempts of all waves of probes fit within timeout
+func probeWait(timeout time.Duration, n, concurrency, attempts int) time.Duration {
+	waves := (n + concurrency - 1) / concurrency
+	if waves < 1 {
+		waves = 1
+	}
+
+	return timeout / time.Duration(waves*attempts)
+}
+
+// replyWaits returns how long probes before the last one of every address
+// and the last one await a reply, so that all attempts of all waves of probes
+// fit within timeout. The last probe waits for opts.replyWait if it is set,
+// and earlier ones share the rest, unless opts.probeTimeout sets their wait.
+func replyWaits(timeout time.Duration, n, concurrency, attempts int,
+	opts scanOptions) (time.Duration, time.Duration) {
+	wait := probeWait(timeout, n, concurrency, attempts)
+	if opts.replyWait <= 0 {
+		if opts.probeTimeout > 0 {
+			wait = opts.probeTimeout
+		}
+
+		return wait, wait
+	}
+
+	switch {
+	case opts.probeTimeout > 0:
+		wait = opts.probeTimeout
+	case attempts > 1:
+		waves := (n + concurrency - 1) / concurrency
+		if waves < 1 {
+			waves = 1
 		}
 
-		queue[ip] = struct{}{}
+		// a reply wait longer than the timeout leaves the default
+		if rest := timeout - time.Duration(waves)*opts.replyWait; rest > 0 {
+			wait = rest / time.Duration(waves*(attempts-1))
+		}
+	}
+
+	return wait, opts.replyWait
+}
+
+// jitterBudget returns the part of timeout left to await replies once every
+// wave of probes of n addresses was delayed by up to jitter. At least half
+// of timeout is left, delays are shortened by jitterDelay otherwise.
+func jitterBudget(timeout time.Duration, n, concurrency int, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return timeout
+	}
+
+	waves := (n + concurrency - 1) / concurrency
+	if waves < 1 {
+		waves = 1
+	}
+
+	budget := timeout - time.Duration(waves)*jitter
+	if budget < timeout/2 {
+		return timeout / 2
+	}
+
+	return budget
+}
+
+// spreadWindow returns the part of remaining that first probes are spread
+// over, which is spread unless it would leave no time to await replies
+func spreadWindow(spread, remaining time.Duration) time.Duration {
+	if spread <= 0 {
+		return 0
+	}
+
+	if spread >= remaining {
+		return remaining / 2
+	}
+
+	return spread
+}
+
+// jitterDelay returns how long a probe is delayed by its jitter, so that
+// its reply can still be awaited for wait within remaining
+func jitterDelay(jitter, remaining, wait time.Duration) time.Duration {
+	if jitter > remaining-wait {
+		jitter = remaining - wait
+	}
+
+	if jitter < 0 {
+		return 0
 	}
 
-	ch := make(chan struct{})
-	timer := time.AfterFunc(OperationTimeout, func() {
-		close(ch)
-	})
+	return jitter
+}
+
+// sleep waits for d and returns false if ctx is done before
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
 
+	timer := time.NewTimer(d)
 	defer timer.Stop()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return result, nil
-		case pair := <-pairs:
-			if _, ok := queue[pair.IP]; ok {
-				result[pair.IP] = pair.HwAddress
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// schedule sends targets to work once for every attempt and closes it.
+// An attempt starts once every probe of the previous one is marked done
+// in pass, and skips targets that replied or could not be probed.
+func schedule(ctx context.Context, queue []*target, attempts int,
+	work chan<- *target, pass *sync.WaitGroup) {
+	defer close(work)
+
+	for i := 0; i < attempts; i++ {
+		for _, t := range queue {
+			// target errors are safe to read, as workers are done
+			// with the previous attempt
+			if t.isReplied() || t.err != nil {
+				continue
 			}
 
-			delete(queue, pair.IP)
+			pass.Add(1)
 
-			if len(queue) == 0 {
-				ccancel()
-				return result, nil
+			select {
+			case work <- t:
+			case <-ctx.Done():
+				pass.Done()
+				return
 			}
-		case <-ch:
-			ccancel()
-			return result, nil
 		}
+
+		pass.Wait()
+	}
+}
+
+// probe sends a probe to the target and waits for a reply
+// for the wait duration, until a reply is received or the context is done.
+// The request waits for the limiter unless it is nil.
+// mu guards target's sentAt and attempts, which are read when the reply
+// is received. Sent probes are logged to trace unless it is nil.
+func probe(ctx context.Context, c sender, t *target, wait time.Duration,
+	limiter *rate.Limiter, mu *sync.Mutex, trace *slog.Logger) error {
+	if t.isReplied() || ctx.Err() != nil {
+		return nil
+	}
+
+	// Wait fails right away if the request can't be sent before
+	// the deadline, the address is then left unresolved
+	if limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil
+		}
+	}
+
+	mu.Lock()
+	t.sentAt = time.Now()
+	t.attempts++
+	attempt := t.attempts
+	mu.Unlock()
+
+	if err := c.send(t); err != nil {
+		return permissionError(err)
+	}
+
+	getMetrics().sent(opScan)
+
+	if trace != nil {
+		trace.LogAttrs(ctx, slog.LevelDebug, "sent probe",
+			slog.String("ip", t.ip.String()), slog.Int("attempt", attempt))
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-t.replied:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	return nil
+}
+
+// getSender returns a sender of probes to ip, ARP probes are sent to IPv4
+// addresses with WithARPProbe and ARP requests with WithSourceIP. Probes are
+// written as frames tagged with the VLAN of WithVLAN if it is set.
+func getSender(ip netip.Addr, iface *net.Interface, opts scanOptions) (sender, error) {
+	if opts.vlan != 0 || ((opts.arpProbe || opts.sourceIP.IsValid()) && ip.Is4()) {
+		s, err := newFrameSender(opts.vlan)
+		if err != nil {
+			return nil, err
+		}
+
+		s.sourceIP = opts.sourceIP.Unmap()
+
+		return s, nil
+	}
+
+	c, err := getConn(ip, iface)
+	if err != nil {
+		return nil, err
 	}
+
+	return icmpSender{c}, nil
 }
 
-func getConn(ip netip.Addr) (*icmp.PacketConn, error) {
+// getConn returns a connection for sending ICMP Echo requests to ip.
+// If iface is set, the connection is bound to it.
+func getConn(ip netip.Addr, iface *net.Interface) (net.PacketConn, error) {
+	if iface != nil {
+		return getInterfaceConn(ip, iface)
+	}
+
 	switch ip.BitLen() {
 	case 0, 32:
 		return icmp.ListenPacket("ip4:icmp", "0.0.0.0")
@@ -139,20 +1633,71 @@ func getConn(ip netip.Addr) (*icmp.PacketConn, error) {
 	}
 }
 
-func icmpMessage(ip netip.Addr, id int) []byte {
-	var icmpType icmp.Type
+// getInterfaceConn returns a raw ICMP socket bound with SO_BINDTODEVICE,
+// so that probes leave through iface regardless of the routing table
+func getInterfaceConn(ip netip.Addr, iface *net.Interface) (net.PacketConn, error) {
+	ok, err := hasFamilyAddr(iface, ip)
+	if err != nil {
+		return nil, err
+	}
 
+	family, network, address := "IPv6", "ip6:ipv6-icmp", "::"
 	if ip.Is4() {
-		icmpType = ipv4.ICMPTypeEcho
+		family, network, address = "IPv4", "ip4:icmp", "0.0.0.0"
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no %s address", ErrNoInterfaceAddr, iface.Name, family)
+	}
+
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var err error
+
+			if cerr := c.Control(func(fd uintptr) {
+				err = unix.BindToDevice(int(fd), iface.Name)
+			}); cerr != nil {
+				return cerr
+			}
+
+			return err
+		},
+	}
+
+	return lc.ListenPacket(context.Background(), network, address)
+}
+
+// hasFamilyAddr returns true if iface has an address of the same family as ip
+func hasFamilyAddr(iface *net.Interface, ip netip.Addr) (bool, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false, err
+	}
+
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		if (ipNet.IP.To4() != nil) == ip.Is4() {
+			return true, nil
+		}
 	}
 
+	return false, nil
+}
+
+// echoMessage returns an Echo request to ip with id and seq
+func echoMessage(ip netip.Addr, id, seq int) []byte {
+	var icmpType icmp.Type = ipv4.ICMPTypeEcho
 	if ip.Is6() {
 		icmpType = ipv6.ICMPTypeEchoRequest
 	}
 
 	msg := icmp.Message{
 		Type: icmpType,
-		Body: &icmp.Echo{ID: id},
+		Body: &icmp.Echo{ID: id, Seq: seq},
 	}
 
 	b, err := msg.Marshal(nil)
@@ -163,37 +1708,228 @@ func icmpMessage(ip netip.Addr, id int) []byte {
 	return b
 }
 
-func capture(ctx context.Context) (chan IPHwAddressPair, error) {
-	h, err := pcap.OpenLive("", SnapLen, false, BlockForever, true)
-	if err != nil {
-		return nil, err
+// capture returns pairs parsed from replies captured on iface,
+// or on all interfaces if iface is empty, from vlan if it is set.
+// The capture buffers bufSize bytes of replies, as replies to a whole
+// subnet arrive at once, and drops returns the number of replies dropped
+// since the last call as the buffer was full.
+// With dad, every ARP packet claiming an address is captured as its reply
+// (see WithDADMode). The capture stops once ctx is done.
+func capture(ctx context.Context, iface string, vlan uint16, bufSize int,
+	dad bool) (pairs chan IPHwAddressPair, drops func() int, err error) {
+	filter := icmpEchoReplyFilter
+	if dad {
+		filter = dadFilter
 	}
 
-	err = h.SetRawBPFFilter(icmpEchoReplyFilter)
+	f, err := openCapture(iface, vlan, filter, bufSize)
 	if err != nil {
-		h.Close()
-		return nil, err
+		return nil, nil, socketError(err)
 	}
 
-	packetSource := gopacket.NewPacketSource(h, layers.LinkTypeEthernet)
-	packetSource.Lazy = true
-	packetSource.NoCopy = true
-
 	out := make(chan IPHwAddressPair)
 
+	// the socket is pollable, so closing it interrupts a pending read
+	drops = closeOnDone(ctx, func() int { return captureDrops(f) }, func() { f.Close() })
+
 	go func() {
 		for {
+			// reads fail once the socket is closed, other errors
+			// like the interface going down end the capture as well
+			pair, err := readReply(f, dad)
+			if err != nil {
+				return
+			}
+
 			select {
+			case out <- pair:
 			case <-ctx.Done():
-				h.Close()
 				return
-			case packet := <-packetSource.Packets():
-				out <- getIPHwAddressPair(packet)
 			}
 		}
 	}()
 
-	return out, nil
+	return out, drops, nil
+}
+
+// readReply reads a reply of at most SnapLen bytes from r and returns
+// the pair parsed from it, see capture for dad
+func readReply(r io.Reader, dad bool) (IPHwAddressPair, error) {
+	b := make([]byte, SnapLen)
+
+	n, err := r.Read(b)
+	if err != nil {
+		return IPHwAddressPair{}, err
+	}
+
+	packet := gopacket.NewPacket(b[:n], layers.LinkTypeEthernet,
+		gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+	pair := getIPHwAddressPair(packet)
+	if dad {
+		if c, ok := packetClaim(packet); ok {
+			pair = IPHwAddressPair{IP: c.ip, HwAddress: c.hwAddr}
+		}
+	}
+
+	return pair, nil
+}
+
+// closeOnDone calls closeCapture once ctx is done and returns a function
+// returning the number of replies dropped since its last call, as counted
+// by stats. Statistics of a closed capture can't be read, so they are read
+// right before closing and returned by the next call, as scans read drops
+// once they are done, which may be after ctx.
+func closeOnDone(ctx context.Context, stats func() int, closeCapture func()) func() int {
+	var (
+		mu      sync.Mutex
+		closed  bool
+		pending int
+	)
+
+	go func() {
+		<-ctx.Done()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		pending += stats()
+		closed = true
+
+		closeCapture()
+	}()
+
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		n := pending
+		pending = 0
+
+		if !closed {
+			n += stats()
+		}
+
+		return n
+	}
+}
+
+// captureDrops returns the number of packets dropped by the packet socket f
+// since the last call, or zero if they can't be read like once f is closed.
+// The kernel resets the statistics of the socket when they are read.
+func captureDrops(f *os.File) int {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0
+	}
+
+	var stats *unix.TpacketStats
+
+	if cerr := rc.Control(func(fd uintptr) {
+		stats, err = unix.GetsockoptTpacketStats(int(fd), unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	}); cerr != nil || err != nil {
+		return 0
+	}
+
+	return int(stats.Drops)
+}
+
+// openCapture opens a non-blocking packet socket receiving packets
+// that match filter on iface, or on all interfaces if iface
+// is empty, and that are tagged with vlan if it is set. Packets longer than
+// SnapLen are truncated when read.
+// The receive buffer is grown to bufSize if it is larger than the default.
+func openCapture(iface string, vlan uint16, filter []bpf.RawInstruction, bufSize int) (*os.File, error) {
+	ifindex := 0
+
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInterfaceNotFound, iface)
+		}
+
+		ifindex = ifi.Index
+	}
+
+	proto := htons(unix.ETH_P_ALL)
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	raw := filter
+	if vlan != 0 {
+		if raw, err = vlanFilter(vlan, raw); err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+	}
+
+	if err = attachFilter(fd, raw); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	growReadBuffer(fd, bufSize)
+
+	err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifindex})
+	if err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	return os.NewFile(uintptr(fd), "capture"), nil
+}
+
+// attachFilter attaches the BPF filter raw to the socket fd
+func attachFilter(fd int, raw []bpf.RawInstruction) error {
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+
+	err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER,
+		&unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]})
+	if err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+
+	return nil
+}
+
+// captureBufferSize returns the receive buffer size for replies
+// of n addresses
+func captureBufferSize(n int) int {
+	if n > maxCaptureBuffer/captureReplySize {
+		return maxCaptureBuffer
+	}
+
+	return n * captureReplySize
+}
+
+// growReadBuffer sets the receive buffer of fd to size unless it is already
+// larger. SO_RCVBUFFORCE goes above net.core.rmem_max but needs CAP_NET_ADMIN,
+// SO_RCVBUF is capped by it. Replies are only dropped when the buffer is
+// full, so failures are ignored.
+func growReadBuffer(fd int, size int) {
+	cur, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF)
+	// the kernel reports twice the size that was set
+	if err != nil || cur/2 >= size {
+		return
+	}
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, size); err != nil {
+		_ = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, size)
+	}
+}
+
+// htons converts a short from host to network byte order
+func htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+
+	return nativeEndian.Uint16(b)
 }
 
 type IPHwAddressPair struct {
@@ -213,6 +1949,38 @@ func getIPHwAddressPair(p gopacket.Packet) IPHwAddressPair {
 		pair.HwAddress = p.SrcMAC
 	}
 
+	// ARP reply carries the resolved address in its body
+	layer = p.Layer(layers.LayerTypeARP)
+	if layer != nil {
+		//nolint:errcheck // safe to have this assert
+		p := layer.(*layers.ARP)
+		if p.Operation == layers.ARPReply {
+			ip, _ := netip.AddrFromSlice(p.SourceProtAddress)
+			pair.IP = ip
+			pair.HwAddress = net.HardwareAddr(p.SourceHwAddress)
+		}
+
+		return pair
+	}
+
+	// Neighbor Advertisement carries the resolved address in its body,
+	// which might differ from the source address of the IPv6 header
+	layer = p.Layer(layers.LayerTypeICMPv6NeighborAdvertisement)
+	if layer != nil {
+		//nolint:errcheck // safe to have this assert
+		p := layer.(*layers.ICMPv6NeighborAdvertisement)
+		ip, _ := netip.AddrFromSlice(p.TargetAddress)
+		pair.IP = ip
+
+		for _, opt := range p.Options {
+			if opt.Type == layers.ICMPv6OptTargetAddress && len(opt.Data) > 0 {
+				pair.HwAddress = net.HardwareAddr(opt.Data)
+			}
+		}
+
+		return pair
+	}
+
 	layer = p.Layer(layers.LayerTypeIPv4)
 	if layer != nil {
 		//nolint:errcheck // safe to have this assert
@@ -1,18 +1,94 @@
 package netmon
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/netip"
 	"os"
+	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+	"golang.org/x/time/rate"
 )
 
+func neighborAdvertisement(t *testing.T, target netip.Addr, hwAddr net.HardwareAddr) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       hwAddr,
+		DstMAC:       net.HardwareAddr{0x00, 0x16, 0x3e, 0xe5, 0x09, 0xa6},
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+	ip6 := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolICMPv6,
+		HopLimit:   255,
+		SrcIP:      target.AsSlice(),
+		DstIP:      net.ParseIP("fe80::216:3eff:fee5:9a6"),
+	}
+	icmp6 := &layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0),
+	}
+	na := &layers.ICMPv6NeighborAdvertisement{
+		Flags:         0x60,
+		TargetAddress: target.AsSlice(),
+		Options: layers.ICMPv6Options{
+			{Type: layers.ICMPv6OptTargetAddress, Data: hwAddr},
+		},
+	}
+
+	if err := icmp6.SetNetworkLayerForChecksum(ip6); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true},
+		eth, ip6, icmp6, na)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func arpPacket(t *testing.T, op uint16, ip netip.Addr, hwAddr net.HardwareAddr) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       hwAddr,
+		DstMAC:       net.HardwareAddr{0x00, 0x16, 0x3e, 0xe5, 0x09, 0xa6},
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         op,
+		SourceHwAddress:   hwAddr,
+		SourceProtAddress: ip.AsSlice(),
+		DstHwAddress:      []byte{0x00, 0x16, 0x3e, 0xe5, 0x09, 0xa6},
+		DstProtAddress:    []byte{10, 0, 0, 1},
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, arp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
 // TestScan can be used for testing
 // sudo is required because Scan is using privileged ping
 // sudo TEST_NETMON_SCAN=172.16.1.1,172.16.2.1 \
@@ -37,6 +113,129 @@ func TestScan(t *testing.T) {
 	t.Logf("%v\n", result)
 }
 
+// TestScanDADMode scans addresses of TEST_NETMON_SCAN like TestScan in DAD
+// mode, hosts using them are expected to claim them
+func TestScanDADMode(t *testing.T) {
+	env := os.Getenv("TEST_NETMON_SCAN")
+	if env == "" {
+		t.Skip("set TEST_NETMON_SCAN to run this test")
+	}
+
+	var ips []netip.Addr
+
+	for _, v := range strings.Split(env, ",") {
+		ips = append(ips, netip.MustParseAddr(v))
+	}
+
+	result, err := ScanDetailed(context.TODO(), ips, WithDADMode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range ips {
+		assert.True(t, result[ip].Responded, ip)
+	}
+}
+
+// TestScanCancel can be used for testing the same way as TestScan,
+// addresses that don't reply keep the scan running until it is canceled
+func TestScanCancel(t *testing.T) {
+	env := os.Getenv("TEST_NETMON_SCAN")
+	if env == "" {
+		t.Skip("set TEST_NETMON_SCAN to run this test")
+	}
+
+	var ips []netip.Addr
+
+	for _, v := range strings.Split(env, ",") {
+		ips = append(ips, netip.MustParseAddr(v))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+
+	result, err := ScanDetailed(ctx, ips, WithTimeout(time.Minute))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second)
+
+	t.Logf("%v\n", result)
+}
+
+// TestScanCancelLeaks can be used for testing the same way as TestScan,
+// a canceled scan must not leave goroutines or file descriptors behind
+func TestScanCancelLeaks(t *testing.T) {
+	env := os.Getenv("TEST_NETMON_SCAN")
+	if env == "" {
+		t.Skip("set TEST_NETMON_SCAN to run this test")
+	}
+
+	var ips []netip.Addr
+
+	for _, v := range strings.Split(env, ",") {
+		ips = append(ips, netip.MustParseAddr(v))
+	}
+
+	fds := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return len(entries)
+	}
+
+	goroutines, files := runtime.NumGoroutine(), fds()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	result, err := ScanDetailed(ctx, ips, WithTimeout(time.Minute))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, result, len(ips))
+
+	// goroutines of the scan may still be returning, assert.Eventually
+	// is not used as it runs the condition in a goroutine of its own
+	deadline := time.Now().Add(time.Second)
+
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+	assert.LessOrEqual(t, fds(), files)
+}
+
+// TestScanStream can be used for testing the same way as TestScan
+func TestScanStream(t *testing.T) {
+	env := os.Getenv("TEST_NETMON_SCAN")
+	if env == "" {
+		t.Skip("set TEST_NETMON_SCAN to run this test")
+	}
+
+	var ips []netip.Addr
+
+	for _, v := range strings.Split(env, ",") {
+		ips = append(ips, netip.MustParseAddr(v))
+	}
+
+	out := make(chan ScanResult)
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- ScanStream(context.TODO(), ips, out)
+	}()
+
+	for res := range out {
+		t.Logf("%s %s\n", res.IP, res.MAC)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGetIPHwAddressPair(t *testing.T) {
 	testcases := map[string]struct {
 		in  []byte
@@ -69,6 +268,29 @@ func TestGetIPHwAddressPair(t *testing.T) {
 				HwAddress: net.HardwareAddr([]byte{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}),
 			},
 		},
+		"test IPv6 neighbor advertisement": {
+			in: neighborAdvertisement(t, netip.MustParseAddr("fe80::216:3eff:febc:3446"),
+				net.HardwareAddr{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}),
+			out: IPHwAddressPair{
+				IP:        netip.MustParseAddr("fe80::216:3eff:febc:3446"),
+				HwAddress: net.HardwareAddr([]byte{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}),
+			},
+		},
+		"test ARP reply": {
+			in: arpPacket(t, layers.ARPReply, netip.MustParseAddr("10.0.0.2"),
+				net.HardwareAddr{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}),
+			out: IPHwAddressPair{
+				IP:        netip.MustParseAddr("10.0.0.2"),
+				HwAddress: net.HardwareAddr([]byte{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}),
+			},
+		},
+		"test ARP request": {
+			in: arpPacket(t, layers.ARPRequest, netip.MustParseAddr("10.0.0.2"),
+				net.HardwareAddr{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}),
+			out: IPHwAddressPair{
+				HwAddress: net.HardwareAddr([]byte{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}),
+			},
+		},
 	}
 
 	for name, tc := range testcases {
@@ -83,3 +305,832 @@ func TestGetIPHwAddressPair(t *testing.T) {
 		})
 	}
 }
+
+func TestReadReply(t *testing.T) {
+	target := netip.MustParseAddr("fd42:9fe5:6593:ce63:216:3eff:febc:3446")
+	hwAddr := net.HardwareAddr{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}
+
+	na := neighborAdvertisement(t, target, hwAddr)
+	// the advertisement is sent by a router on behalf of target, so only
+	// the body of the advertisement holds target and its hardware address
+	copy(na[6:12], []byte{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01})
+	copy(na[22:38], netip.MustParseAddr("fe80::1").AsSlice())
+
+	tagged := append(append(append([]byte(nil), na[:12]...), 0x81, 0x00, 0x00, 0x0a), na[12:]...)
+
+	testcases := map[string]struct {
+		in []byte
+	}{
+		"neighbor advertisement": {
+			in: na,
+		},
+		"802.1Q tagged neighbor advertisement": {
+			in: tagged,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// the link-layer option is past the bytes of a short read
+			assert.Less(t, 64, len(tc.in))
+			assert.LessOrEqual(t, len(tc.in), int(SnapLen))
+
+			// padding is truncated like frames longer than SnapLen
+			frame := append(append([]byte(nil), tc.in...), make([]byte, 64)...)
+
+			res, err := readReply(bytes.NewReader(frame), false)
+			assert.NoError(t, err)
+			assert.Equal(t, IPHwAddressPair{IP: target, HwAddress: hwAddr}, res)
+		})
+	}
+}
+
+func TestICMPEchoReplyFilter(t *testing.T) {
+	vm, err := bpf.NewVM(mustDisassemble(t, icmpEchoReplyFilter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := map[string]struct {
+		in     []byte
+		accept bool
+	}{
+		"IPv4 echo reply": {
+			in: []byte{
+				0xc0, 0x25, 0xa5, 0x8d, 0xd0, 0x68, 0xcc, 0x2d, 0xe0, 0xe7, 0x03, 0xf0,
+				0x08, 0x00, 0x45, 0x00, 0x00, 0x1c, 0x73, 0x72, 0x00, 0x00, 0x38, 0x01,
+				0x60, 0x52, 0x01, 0x01, 0x01, 0x01, 0xac, 0x10, 0x01, 0x0b, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			accept: true,
+		},
+		"IPv4 echo request": {
+			in: []byte{
+				0xc0, 0x25, 0xa5, 0x8d, 0xd0, 0x68, 0xcc, 0x2d, 0xe0, 0xe7, 0x03, 0xf0,
+				0x08, 0x00, 0x45, 0x00, 0x00, 0x1c, 0x73, 0x72, 0x00, 0x00, 0x38, 0x01,
+				0x60, 0x52, 0x01, 0x01, 0x01, 0x01, 0xac, 0x10, 0x01, 0x0b, 0x08, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			accept: false,
+		},
+		"IPv6 echo reply": {
+			in: []byte{
+				0x00, 0x16, 0x3e, 0xe5, 0x09, 0xa6, 0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46,
+				0x86, 0xdd, 0x60, 0x0d, 0xf8, 0xb4, 0x00, 0x08, 0x3a, 0x40, 0xfd, 0x42,
+				0x9f, 0xe5, 0x65, 0x93, 0xce, 0x63, 0x02, 0x16, 0x3e, 0xff, 0xfe, 0xbc,
+				0x34, 0x46, 0xfd, 0x42, 0x9f, 0xe5, 0x65, 0x93, 0xce, 0x63, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x81, 0x00, 0x68, 0x64, 0x00, 0x00,
+				0x00, 0x00,
+			},
+			accept: true,
+		},
+		"IPv6 neighbor advertisement": {
+			in: neighborAdvertisement(t, netip.MustParseAddr("fd42:9fe5:6593:ce63:216:3eff:febc:3446"),
+				net.HardwareAddr{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}),
+			accept: true,
+		},
+		"ARP reply": {
+			in: arpPacket(t, layers.ARPReply, netip.MustParseAddr("10.0.0.2"),
+				net.HardwareAddr{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}),
+			accept: true,
+		},
+		"ARP request": {
+			in: arpPacket(t, layers.ARPRequest, netip.MustParseAddr("10.0.0.2"),
+				net.HardwareAddr{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}),
+			accept: false,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			n, err := vm.Run(tc.in)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.accept, n > 0)
+		})
+	}
+}
+
+func TestDADFilter(t *testing.T) {
+	vm, err := bpf.NewVM(mustDisassemble(t, dadFilter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hwAddr := net.HardwareAddr{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}
+
+	testcases := map[string]struct {
+		in     []byte
+		accept bool
+	}{
+		"IPv4 echo reply": {
+			in: []byte{
+				0xc0, 0x25, 0xa5, 0x8d, 0xd0, 0x68, 0xcc, 0x2d, 0xe0, 0xe7, 0x03, 0xf0,
+				0x08, 0x00, 0x45, 0x00, 0x00, 0x1c, 0x73, 0x72, 0x00, 0x00, 0x38, 0x01,
+				0x60, 0x52, 0x01, 0x01, 0x01, 0x01, 0xac, 0x10, 0x01, 0x0b, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			accept: true,
+		},
+		"IPv4 echo request": {
+			in: []byte{
+				0xc0, 0x25, 0xa5, 0x8d, 0xd0, 0x68, 0xcc, 0x2d, 0xe0, 0xe7, 0x03, 0xf0,
+				0x08, 0x00, 0x45, 0x00, 0x00, 0x1c, 0x73, 0x72, 0x00, 0x00, 0x38, 0x01,
+				0x60, 0x52, 0x01, 0x01, 0x01, 0x01, 0xac, 0x10, 0x01, 0x0b, 0x08, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			accept: false,
+		},
+		"IPv6 neighbor advertisement": {
+			in:     neighborAdvertisement(t, netip.MustParseAddr("fd42:9fe5:6593:ce63:216:3eff:febc:3446"), hwAddr),
+			accept: true,
+		},
+		"ARP reply": {
+			in:     arpPacket(t, layers.ARPReply, netip.MustParseAddr("10.0.0.2"), hwAddr),
+			accept: true,
+		},
+		"ARP request": {
+			in:     arpPacket(t, layers.ARPRequest, netip.MustParseAddr("10.0.0.2"), hwAddr),
+			accept: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			n, err := vm.Run(tc.in)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.accept, n > 0)
+		})
+	}
+}
+
+func mustDisassemble(t *testing.T, raw []bpf.RawInstruction) []bpf.Instruction {
+	instructions, ok := bpf.Disassemble(raw)
+	if !ok {
+		t.Fatal("failed to disassemble BPF filter")
+	}
+
+	return instructions
+}
+
+func TestScanLinkLocalWithoutZone(t *testing.T) {
+	_, err := Scan(context.TODO(), []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("fe80::216:3eff:febc:3446"),
+	})
+	assert.ErrorIs(t, err, ErrMissingZone)
+}
+
+func TestProbeWait(t *testing.T) {
+	testcases := map[string]struct {
+		n           int
+		concurrency int
+		attempts    int
+		out         time.Duration
+	}{
+		"single wave": {
+			n: 10, concurrency: 256, attempts: 1, out: 3 * time.Second,
+		},
+		"exactly one wave": {
+			n: 256, concurrency: 256, attempts: 1, out: 3 * time.Second,
+		},
+		"two waves": {
+			n: 257, concurrency: 256, attempts: 1, out: 1500 * time.Millisecond,
+		},
+		"serial": {
+			n: 3, concurrency: 1, attempts: 1, out: time.Second,
+		},
+		"single wave with retries": {
+			n: 10, concurrency: 256, attempts: 3, out: time.Second,
+		},
+		"two waves with a retry": {
+			n: 257, concurrency: 256, attempts: 2, out: 750 * time.Millisecond,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, probeWait(3*time.Second, tc.n, tc.concurrency, tc.attempts))
+		})
+	}
+}
+
+func TestReplyWaits(t *testing.T) {
+	testcases := map[string]struct {
+		n        int
+		attempts int
+		opts     scanOptions
+		wait     time.Duration
+		last     time.Duration
+	}{
+		"default": {
+			n: 10, attempts: 3, wait: time.Second, last: time.Second,
+		},
+		"probe timeout": {
+			n: 10, attempts: 3, opts: scanOptions{probeTimeout: 100 * time.Millisecond},
+			wait: 100 * time.Millisecond, last: 100 * time.Millisecond,
+		},
+		"reply wait": {
+			n: 10, attempts: 3, opts: scanOptions{replyWait: 2 * time.Second},
+			wait: 500 * time.Millisecond, last: 2 * time.Second,
+		},
+		"reply wait of every wave": {
+			n: 257, attempts: 2, opts: scanOptions{replyWait: time.Second},
+			wait: 500 * time.Millisecond, last: time.Second,
+		},
+		"reply wait with probe timeout": {
+			n: 10, attempts: 3, opts: scanOptions{replyWait: 2 * time.Second, probeTimeout: 100 * time.Millisecond},
+			wait: 100 * time.Millisecond, last: 2 * time.Second,
+		},
+		"reply wait of a single probe": {
+			n: 10, attempts: 1, opts: scanOptions{replyWait: 2 * time.Second},
+			wait: 3 * time.Second, last: 2 * time.Second,
+		},
+		"reply wait above the timeout": {
+			n: 10, attempts: 3, opts: scanOptions{replyWait: 5 * time.Second},
+			wait: time.Second, last: 5 * time.Second,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			wait, last := replyWaits(3*time.Second, tc.n, 256, tc.attempts, tc.opts)
+			assert.Equal(t, tc.wait, wait)
+			assert.Equal(t, tc.last, last)
+		})
+	}
+}
+
+func TestJitterBudget(t *testing.T) {
+	testcases := map[string]struct {
+		n           int
+		concurrency int
+		jitter      time.Duration
+		out         time.Duration
+	}{
+		"no jitter": {
+			n: 10, concurrency: 256, out: 3 * time.Second,
+		},
+		"single wave": {
+			n: 10, concurrency: 256, jitter: time.Second, out: 2 * time.Second,
+		},
+		"two waves": {
+			n: 257, concurrency: 256, jitter: 500 * time.Millisecond, out: 2 * time.Second,
+		},
+		"jitter above half of the timeout": {
+			n: 10, concurrency: 256, jitter: 2 * time.Second, out: 1500 * time.Millisecond,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, jitterBudget(3*time.Second, tc.n, tc.concurrency, tc.jitter))
+		})
+	}
+}
+
+func TestJitterDelay(t *testing.T) {
+	testcases := map[string]struct {
+		jitter    time.Duration
+		remaining time.Duration
+		out       time.Duration
+	}{
+		"within the deadline": {
+			jitter: 500 * time.Millisecond, remaining: 3 * time.Second, out: 500 * time.Millisecond,
+		},
+		"shortened": {
+			jitter: 2 * time.Second, remaining: 3 * time.Second, out: time.Second,
+		},
+		"no time left": {
+			jitter: time.Second, remaining: time.Second, out: 0,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, jitterDelay(tc.jitter, tc.remaining, 2*time.Second))
+		})
+	}
+}
+
+func TestSpreadWindow(t *testing.T) {
+	testcases := map[string]struct {
+		spread    time.Duration
+		remaining time.Duration
+		out       time.Duration
+	}{
+		"not spread": {
+			remaining: 3 * time.Second,
+		},
+		"within the deadline": {
+			spread: time.Minute, remaining: time.Minute + 3*time.Second, out: time.Minute,
+		},
+		"no time left to await replies": {
+			spread: time.Minute, remaining: 10 * time.Second, out: 5 * time.Second,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, spreadWindow(tc.spread, tc.remaining))
+		})
+	}
+}
+
+func TestScanEntriesHardwareAddrs(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	entries := ScanEntries{
+		netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, Responded: true},
+		netip.MustParseAddr("10.0.0.2"): {},
+		netip.MustParseAddr("10.0.0.3"): {Err: ErrInvalidAddr},
+	}
+
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{
+		netip.MustParseAddr("10.0.0.1"): hwAddr,
+		netip.MustParseAddr("10.0.0.2"): nil,
+		netip.MustParseAddr("10.0.0.3"): nil,
+	}, entries.HardwareAddrs())
+}
+
+func TestScanEntriesResponders(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	entries := ScanEntries{
+		netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, Responded: true},
+		netip.MustParseAddr("10.0.0.2"): {},
+		netip.MustParseAddr("10.0.0.3"): {Err: ErrInvalidAddr},
+	}
+
+	assert.Equal(t, ScanEntries{
+		netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, Responded: true},
+	}, entries.responders())
+	assert.Nil(t, ScanEntries(nil).responders())
+}
+
+func TestCaptureBufferSize(t *testing.T) {
+	assert.Equal(t, 0, captureBufferSize(0))
+	assert.Equal(t, 254*captureReplySize, captureBufferSize(254))
+	assert.Equal(t, maxCaptureBuffer, captureBufferSize(1<<20))
+}
+
+func TestRTT(t *testing.T) {
+	now := time.Now()
+
+	testcases := map[string]struct {
+		sentAt     time.Time
+		receivedAt time.Time
+		out        time.Duration
+	}{
+		"reply after probe": {
+			sentAt:     now,
+			receivedAt: now.Add(5 * time.Millisecond),
+			out:        5 * time.Millisecond,
+		},
+		"reply before probe": {
+			receivedAt: now,
+		},
+		"negative duration": {
+			sentAt:     now,
+			receivedAt: now.Add(-time.Second),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, rtt(tc.sentAt, tc.receivedAt))
+		})
+	}
+}
+
+func TestIsEthernetAddr(t *testing.T) {
+	testcases := map[string]struct {
+		in  net.HardwareAddr
+		out bool
+	}{
+		"ethernet": {
+			in:  net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01},
+			out: true,
+		},
+		"no address": {
+			out: true,
+		},
+		"infiniband": {
+			in: make(net.HardwareAddr, 20),
+		},
+		"truncated": {
+			in: net.HardwareAddr{0xc0, 0xff, 0xee},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, isEthernetAddr(tc.in))
+		})
+	}
+}
+
+func TestDropReply(t *testing.T) {
+	ip := netip.MustParseAddr("10.0.0.1")
+	result := ScanEntries{ip: {Attempts: 1}}
+
+	dropReply(result, ip, net.HardwareAddr{0xc0, 0xff, 0xee})
+
+	assert.ErrorIs(t, result[ip].Warning, ErrNonEthernet)
+	assert.False(t, result[ip].Responded)
+	assert.Equal(t, 1, result[ip].Attempts)
+}
+
+func TestScanDropCount(t *testing.T) {
+	ip := netip.MustParseAddr("127.0.6.1")
+
+	var dropped uint64
+
+	entries, err := ScanDetailed(context.Background(), []netip.Addr{ip}, WithTimeout(time.Second),
+		WithRecvBufferBytes(1<<16), WithDropCount(&dropped))
+	if errors.Is(err, ErrPermissionDenied) {
+		t.Skip(err)
+	}
+
+	assert.NoError(t, err)
+	assert.True(t, entries[ip].Responded)
+	assert.Zero(t, dropped)
+}
+
+func TestCaptureDrops(t *testing.T) {
+	f, err := openCapture("lo", 0, acceptFilter, 0)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Zero(t, captureDrops(f))
+	assert.NoError(t, f.Close())
+	// statistics of a closed capture can't be read
+	assert.Zero(t, captureDrops(f))
+}
+
+func TestScanStreamClosesChannel(t *testing.T) {
+	out := make(chan ScanResult)
+
+	err := ScanStream(context.TODO(), nil, out)
+	assert.NoError(t, err)
+
+	_, ok := <-out
+	assert.False(t, ok)
+}
+
+func TestScanCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ScanDetailed(ctx, []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCallerErr(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	testcases := map[string]struct {
+		ctx     context.Context
+		bounded bool
+		err     error
+	}{
+		"active": {
+			ctx: context.Background(),
+		},
+		"canceled": {
+			ctx: canceled,
+			err: context.Canceled,
+		},
+		"deadline ends the scan": {
+			ctx: expired,
+		},
+		"deadline before the scan timeout": {
+			ctx:     expired,
+			bounded: true,
+			err:     context.DeadlineExceeded,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.ErrorIs(t, callerErr(tc.ctx, tc.bounded), tc.err)
+		})
+	}
+}
+
+func TestScanUnknownInterface(t *testing.T) {
+	_, err := ScanDetailed(context.TODO(), []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+	}, WithInterface("does-not-exist0"))
+	assert.ErrorIs(t, err, ErrInterfaceNotFound)
+}
+
+func TestNewScanOptions(t *testing.T) {
+	testcases := map[string]struct {
+		in  []Option
+		out scanOptions
+	}{
+		"defaults": {
+			out: scanOptions{concurrency: DefaultConcurrency},
+		},
+		"all options": {
+			in: []Option{
+				WithTimeout(time.Second),
+				WithInterface("eth0"),
+				WithConcurrency(16),
+				WithRetries(2),
+				WithICMPFallback(),
+				WithRate(100),
+				WithARPProbe(),
+				WithOnlyResponders(),
+				WithProbeTimeout(time.Millisecond),
+				WithVLAN(100),
+				WithJitter(time.Second),
+				WithReplyWait(2 * time.Second),
+				WithBatchSize(64),
+				WithWaitForAll(),
+				WithSpreadOver(time.Minute),
+			},
+			out: scanOptions{
+				timeout: time.Second, iface: "eth0", concurrency: 16, retries: 2, icmpFallback: true,
+				rate: 100, arpProbe: true, onlyResponders: true, probeTimeout: time.Millisecond,
+				vlan: 100, jitter: time.Second, replyWait: 2 * time.Second, batchSize: 64,
+				waitForAll: true, spreadOver: time.Minute,
+			},
+		},
+		"probes per host": {
+			in:  []Option{WithProbesPerHost(3)},
+			out: scanOptions{concurrency: DefaultConcurrency, retries: 2},
+		},
+		"invalid values fall back to defaults": {
+			in:  []Option{WithConcurrency(-1), WithRetries(-1)},
+			out: scanOptions{concurrency: DefaultConcurrency},
+		},
+		"no probes per host falls back to one": {
+			in:  []Option{WithProbesPerHost(0)},
+			out: scanOptions{concurrency: DefaultConcurrency},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, newScanOptions(tc.in))
+		})
+	}
+}
+
+func TestScanEntryResult(t *testing.T) {
+	first := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	second := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+	source := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x03}
+	seenAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	entry := ScanEntry{
+		MAC:       first,
+		MACs:      []net.HardwareAddr{first, second},
+		Responded: true,
+		Latency:   time.Millisecond,
+		Interface: "eth0",
+		SourceMAC: source,
+		Attempts:  2,
+		SeenAt:    seenAt,
+	}
+
+	assert.Equal(t, ScanResult{
+		IP:        ip,
+		MAC:       second,
+		Latency:   time.Millisecond,
+		Interface: "eth0",
+		SourceMAC: source,
+		Attempts:  2,
+		SeenAt:    seenAt,
+	}, entry.result(ip, second))
+}
+
+func TestScanEntriesConflicts(t *testing.T) {
+	first := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	second := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+
+	entries := ScanEntries{
+		netip.MustParseAddr("10.0.0.1"): {MAC: first, MACs: []net.HardwareAddr{first, second}, Responded: true},
+		netip.MustParseAddr("10.0.0.2"): {MAC: first, MACs: []net.HardwareAddr{first}, Responded: true},
+		netip.MustParseAddr("10.0.0.3"): {},
+	}
+
+	assert.Equal(t, map[netip.Addr][]net.HardwareAddr{
+		netip.MustParseAddr("10.0.0.1"): {first, second},
+	}, entries.Conflicts())
+}
+
+func TestIsRetryable(t *testing.T) {
+	testcases := map[string]struct {
+		in  error
+		out bool
+	}{
+		"missing zone": {
+			in: fmt.Errorf("%w: fe80::1", ErrMissingZone),
+		},
+		"interface not found": {
+			in: fmt.Errorf("%w: eth9", ErrInterfaceNotFound),
+		},
+		"invalid source IP": {
+			in: fmt.Errorf("%w: 10.0.0.1", ErrInvalidSourceIP),
+		},
+		"operation not permitted": {
+			in: &net.OpError{Op: "listen", Err: os.NewSyscallError("socket", syscall.EPERM)},
+		},
+		"no buffer space": {
+			in:  &net.OpError{Op: "write", Err: os.NewSyscallError("sendto", syscall.ENOBUFS)},
+			out: true,
+		},
+		"not permitted to scan": {
+			in: &wrappedError{kind: ErrNoProbesSent, err: permissionError(
+				os.NewSyscallError("socket", syscall.EACCES))},
+		},
+		"no probes sent": {
+			in:  &wrappedError{kind: ErrNoProbesSent, err: os.NewSyscallError("sendto", syscall.ENOBUFS)},
+			out: true,
+		},
+		"resource temporarily unavailable": {
+			in:  os.NewSyscallError("socket", syscall.EAGAIN),
+			out: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, IsRetryable(tc.in))
+		})
+	}
+}
+
+func TestPermissionError(t *testing.T) {
+	permitted := os.NewSyscallError("sendto", syscall.ENOBUFS)
+	assert.Equal(t, permitted, permissionError(permitted))
+
+	err := permissionError(&net.OpError{Op: "listen", Err: os.NewSyscallError("socket", syscall.EPERM)})
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+	assert.ErrorIs(t, err, syscall.EPERM)
+
+	var opErr *net.OpError
+
+	assert.ErrorAs(t, err, &opErr)
+	assert.Equal(t, err, permissionError(err))
+
+	err = &wrappedError{kind: ErrNoProbesSent, err: err}
+	assert.ErrorIs(t, err, ErrNoProbesSent)
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+	assert.Equal(t, "no probes sent: not permitted to scan: listen: socket: operation not permitted", err.Error())
+}
+
+func TestSocketError(t *testing.T) {
+	err := socketError(&net.OpError{Op: "listen", Err: os.NewSyscallError("socket", syscall.EAFNOSUPPORT)})
+	assert.ErrorIs(t, err, ErrNoRawSocket)
+	assert.ErrorIs(t, err, syscall.EAFNOSUPPORT)
+	assert.NotErrorIs(t, err, ErrPermissionDenied)
+	assert.True(t, IsRetryable(err))
+
+	err = socketError(os.NewSyscallError("socket", syscall.EPERM))
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+	assert.NotErrorIs(t, err, ErrNoRawSocket)
+
+	notFound := fmt.Errorf("%w: eth9", ErrInterfaceNotFound)
+	assert.Equal(t, notFound, socketError(notFound))
+}
+
+// writeConn is a net.PacketConn recording the time of every write
+type writeConn struct {
+	net.PacketConn
+	mu      sync.Mutex
+	written []time.Time
+}
+
+func (c *writeConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.written = append(c.written, time.Now())
+
+	return len(b), nil
+}
+
+func TestSchedule(t *testing.T) {
+	replied := &target{ip: netip.MustParseAddr("10.0.0.1"), replied: make(chan struct{})}
+	silent := &target{ip: netip.MustParseAddr("10.0.0.2"), replied: make(chan struct{})}
+	failed := &target{ip: netip.MustParseAddr("10.0.0.3"), replied: make(chan struct{})}
+
+	work := make(chan *target)
+
+	var pass sync.WaitGroup
+
+	go schedule(context.Background(), []*target{replied, silent, failed}, 3, work, &pass)
+
+	var probed []netip.Addr
+
+	for tgt := range work {
+		probed = append(probed, tgt.ip)
+
+		switch tgt {
+		case replied:
+			close(tgt.replied)
+		case failed:
+			tgt.err = ErrNoInterfaceAddr
+		}
+
+		pass.Done()
+	}
+
+	assert.Equal(t, []netip.Addr{replied.ip, silent.ip, failed.ip, silent.ip, silent.ip}, probed)
+}
+
+func TestProbeRate(t *testing.T) {
+	c := &writeConn{}
+	tgt := &target{ip: netip.MustParseAddr("10.0.0.1"), replied: make(chan struct{})}
+	limiter := rate.NewLimiter(rate.Limit(50), 1)
+
+	var mu sync.Mutex
+
+	for i := 0; i < 3; i++ {
+		err := probe(context.Background(), icmpSender{c}, tgt, 0, limiter, &mu, nil)
+		assert.NoError(t, err)
+	}
+
+	assert.Len(t, c.written, 3)
+	assert.Equal(t, 3, tgt.attempts)
+	// 50 probes per second are 20ms apart
+	assert.GreaterOrEqual(t, c.written[2].Sub(c.written[0]), 35*time.Millisecond)
+}
+
+func TestProbeRateDeadline(t *testing.T) {
+	c := &writeConn{}
+	tgt := &target{ip: netip.MustParseAddr("10.0.0.1"), replied: make(chan struct{})}
+	limiter := rate.NewLimiter(rate.Limit(1), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var mu sync.Mutex
+
+	start := time.Now()
+
+	for i := 0; i < 2; i++ {
+		err := probe(ctx, icmpSender{c}, tgt, 0, limiter, &mu, nil)
+		assert.NoError(t, err)
+	}
+
+	// the first probe is sent right away, the next one would miss the deadline
+	assert.Len(t, c.written, 1)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
@@ -0,0 +1,162 @@
+package netmon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// defaultScanner is used by the package-level Scan, ScanDetailed
+// and ScanStream
+var defaultScanner = NewScanner()
+
+// Scanner scans addresses with the same options for every scan, so that
+// a configuration can be reused across scans and passed around
+type Scanner struct {
+	opts []Option
+}
+
+// NewScanner returns a Scanner applying opts to every scan
+func NewScanner(opts ...Option) *Scanner {
+	return &Scanner{opts: opts}
+}
+
+// options returns options of the scanner followed by opts,
+// which take precedence
+func (s *Scanner) options(opts []Option) scanOptions {
+	all := make([]Option, 0, len(s.opts)+len(opts))
+	all = append(all, s.opts...)
+	all = append(all, opts...)
+
+	return newScanOptions(all)
+}
+
+// Scan is like the package-level Scan, opts are applied after
+// the options of the scanner
+func (s *Scanner) Scan(ctx context.Context, ips []netip.Addr,
+	opts ...Option) (map[netip.Addr]net.HardwareAddr, error) {
+	entries, err := s.ScanDetailed(ctx, ips, opts...)
+	if entries == nil {
+		return nil, err
+	}
+
+	return entries.HardwareAddrs(), err
+}
+
+// ScanDetailed is like the package-level ScanDetailed, opts are applied
+// after the options of the scanner
+func (s *Scanner) ScanDetailed(ctx context.Context, ips []netip.Addr,
+	opts ...Option) (ScanEntries, error) {
+	o := s.options(opts)
+	start := time.Now()
+
+	entries, err := scanBatches(ctx, ips, o, nil)
+	getMetrics().observe(opScan, start, err)
+	logScan(ctx, o.logger, entries, err, start)
+	if o.onlyResponders {
+		entries = entries.responders()
+	}
+
+	return entries, err
+}
+
+// ScanStream is like the package-level ScanStream, opts are applied
+// after the options of the scanner
+func (s *Scanner) ScanStream(ctx context.Context, ips []netip.Addr, out chan<- ScanResult,
+	opts ...Option) error {
+	defer close(out)
+
+	o := s.options(opts)
+	start := time.Now()
+
+	entries, err := scanBatches(ctx, ips, o, out)
+	getMetrics().observe(opScan, start, err)
+	logScan(ctx, o.logger, entries, err, start)
+
+	if o.entries != nil {
+		*o.entries = entries
+	}
+
+	return err
+}
+
+// logScan logs the summary of a scan that started at start to l,
+// unless it is nil
+func logScan(ctx context.Context, l *slog.Logger, entries ScanEntries, err error, start time.Time) {
+	if l == nil {
+		return
+	}
+
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+
+	if !l.Enabled(ctx, level) {
+		return
+	}
+
+	responded := 0
+
+	for _, e := range entries {
+		if e.Responded {
+			responded++
+		}
+	}
+
+	attrs := []slog.Attr{
+		slog.Int("scanned", len(entries)),
+		slog.Int("responded", responded),
+		slog.Duration("duration", time.Since(start)),
+	}
+
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	l.LogAttrs(ctx, level, "scan complete", attrs...)
+}
+
+// ScanPrefix is like Scan for every host address of prefix. The network and
+// the broadcast address of IPv4 prefixes shorter than /31 are not scanned.
+// Replies of the whole subnet are captured by a single capture, buffered
+// for all of its hosts.
+func (s *Scanner) ScanPrefix(ctx context.Context, prefix netip.Prefix,
+	opts ...Option) (map[netip.Addr]net.HardwareAddr, error) {
+	hosts, err := prefixHosts(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Scan(ctx, hosts, opts...)
+}
+
+// prefixHosts returns host addresses of prefix
+func prefixHosts(prefix netip.Prefix) ([]netip.Addr, error) {
+	if !prefix.IsValid() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddr, prefix)
+	}
+
+	prefix = prefix.Masked()
+
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > MaxPrefixHostBits {
+		return nil, fmt.Errorf("%w: %s", ErrPrefixTooLarge, prefix)
+	}
+
+	hosts := make([]netip.Addr, 0, 1<<hostBits)
+
+	for a := prefix.Addr(); a.IsValid() && prefix.Contains(a); a = a.Next() {
+		hosts = append(hosts, a)
+	}
+
+	if prefix.Addr().Is4() && prefix.Bits() < 31 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+
+	return hosts, nil
+}
@@ -0,0 +1,404 @@
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func TestScannerOptions(t *testing.T) {
+	s := NewScanner(WithInterface("eth0"), WithRetries(2), WithProbeTimeout(time.Second), WithRate(100))
+
+	testcases := map[string]struct {
+		in  []Option
+		out scanOptions
+	}{
+		"scanner options": {
+			out: scanOptions{
+				iface: "eth0", concurrency: DefaultConcurrency, retries: 2, rate: 100,
+				probeTimeout: time.Second,
+			},
+		},
+		"scan options take precedence": {
+			in: []Option{WithInterface("eth1"), WithTimeout(time.Minute)},
+			out: scanOptions{
+				timeout: time.Minute, iface: "eth1", concurrency: DefaultConcurrency, retries: 2,
+				rate: 100, probeTimeout: time.Second,
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, s.options(tc.in))
+		})
+	}
+
+	// options of a scan are not kept by the scanner
+	assert.Len(t, s.opts, 4)
+}
+
+func TestPrefixHosts(t *testing.T) {
+	testcases := map[string]struct {
+		in  netip.Prefix
+		out []netip.Addr
+		err error
+	}{
+		"IPv4 /30": {
+			in: netip.MustParsePrefix("10.0.0.1/30"),
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+			},
+		},
+		"IPv4 /31": {
+			in: netip.MustParsePrefix("10.0.0.0/31"),
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.0"),
+				netip.MustParseAddr("10.0.0.1"),
+			},
+		},
+		"IPv4 /32": {
+			in:  netip.MustParsePrefix("10.0.0.1/32"),
+			out: []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+		},
+		"IPv6 /127": {
+			in: netip.MustParsePrefix("fd00::/127"),
+			out: []netip.Addr{
+				netip.MustParseAddr("fd00::"),
+				netip.MustParseAddr("fd00::1"),
+			},
+		},
+		"invalid": {
+			err: ErrInvalidAddr,
+		},
+		"too large": {
+			in:  netip.MustParsePrefix("10.0.0.0/15"),
+			err: ErrPrefixTooLarge,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := prefixHosts(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+// BenchmarkScanPrefix scans a /24 on the loopback interface, where every
+// address replies at once. It needs privileges like TestScan.
+func BenchmarkScanPrefix(b *testing.B) {
+	prefix := netip.MustParsePrefix("127.0.1.0/24")
+
+	for i := 0; i < b.N; i++ {
+		res, err := ScanPrefix(context.Background(), prefix, WithTimeout(2*time.Second))
+		if errors.Is(err, ErrPermissionDenied) {
+			b.Skip(err)
+		}
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for ip, hwAddr := range res {
+			if hwAddr == nil {
+				b.Fatalf("%s did not reply", ip)
+			}
+		}
+	}
+}
+
+func TestDefaultConcurrency(t *testing.T) {
+	testcases := map[string]struct {
+		in  int
+		out int
+	}{
+		"single CPU": {
+			in:  1,
+			out: MinConcurrency,
+		},
+		"floor": {
+			in:  MinConcurrency/concurrencyPerCPU - 1,
+			out: MinConcurrency,
+		},
+		"per CPU": {
+			in:  16,
+			out: 16 * concurrencyPerCPU,
+		},
+		"ceiling": {
+			in:  1024,
+			out: MaxConcurrency,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, defaultConcurrency(tc.in))
+		})
+	}
+}
+
+func TestScanPrefixSequential(t *testing.T) {
+	prefix := netip.MustParsePrefix("127.0.2.0/29")
+
+	res, err := ScanPrefix(context.Background(), prefix, WithTimeout(2*time.Second), WithConcurrency(1))
+	if errors.Is(err, ErrPermissionDenied) {
+		t.Skip(err)
+	}
+
+	assert.NoError(t, err)
+	assert.Len(t, res, 6)
+
+	for ip, hwAddr := range res {
+		assert.NotNil(t, hwAddr, ip)
+	}
+}
+
+func TestScanPrefixJitter(t *testing.T) {
+	prefix := netip.MustParsePrefix("127.0.3.0/29")
+	start := time.Now()
+
+	res, err := ScanPrefix(context.Background(), prefix,
+		WithTimeout(2*time.Second), WithJitter(500*time.Millisecond))
+	if errors.Is(err, ErrPermissionDenied) {
+		t.Skip(err)
+	}
+
+	assert.NoError(t, err)
+	assert.Len(t, res, 6)
+	assert.Less(t, time.Since(start), 2*time.Second+duplicateReplyWait)
+}
+
+func TestScanPrefixBatched(t *testing.T) {
+	prefix := netip.MustParsePrefix("127.0.5.0/29")
+
+	out := make(chan ScanResult, 6)
+
+	err := ScanStream(context.Background(), hosts(t, prefix), out,
+		WithTimeout(2*time.Second), WithBatchSize(4))
+	if errors.Is(err, ErrPermissionDenied) {
+		t.Skip(err)
+	}
+
+	assert.NoError(t, err)
+	assert.Len(t, out, 6)
+
+	res, err := ScanDetailed(context.Background(), hosts(t, prefix),
+		WithTimeout(2*time.Second), WithBatchSize(4))
+	assert.NoError(t, err)
+	assert.Len(t, res, 6)
+
+	for ip, e := range res {
+		assert.True(t, e.Responded, ip)
+		assert.Equal(t, 1, e.Attempts, ip)
+	}
+}
+
+func hosts(t *testing.T, prefix netip.Prefix) []netip.Addr {
+	t.Helper()
+
+	ips, err := prefixHosts(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ips
+}
+
+func TestScanPrefixWaitForAll(t *testing.T) {
+	prefix := netip.MustParsePrefix("127.0.8.0/29")
+	start := time.Now()
+
+	res, err := ScanPrefix(context.Background(), prefix, WithTimeout(5*time.Second), WithWaitForAll())
+	if errors.Is(err, ErrPermissionDenied) {
+		t.Skip(err)
+	}
+
+	assert.NoError(t, err)
+	assert.Len(t, res, 6)
+
+	for ip, hwAddr := range res {
+		assert.NotNil(t, hwAddr, ip)
+	}
+
+	// the scan returns once every address replied, well before the timeout
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestScanPrefixSpreadOver(t *testing.T) {
+	prefix := netip.MustParsePrefix("127.0.10.0/29")
+	start := time.Now()
+
+	res, err := ScanDetailed(context.Background(), hosts(t, prefix),
+		WithTimeout(500*time.Millisecond), WithSpreadOver(600*time.Millisecond))
+	if errors.Is(err, ErrPermissionDenied) {
+		t.Skip(err)
+	}
+
+	assert.NoError(t, err)
+	assert.Len(t, res, 6)
+
+	var first, last time.Time
+
+	for ip, e := range res {
+		assert.True(t, e.Responded, ip)
+
+		if first.IsZero() || e.SeenAt.Before(first) {
+			first = e.SeenAt
+		}
+
+		if e.SeenAt.After(last) {
+			last = e.SeenAt
+		}
+	}
+
+	// the last of 6 probes is sent 500ms after the first one
+	assert.GreaterOrEqual(t, last.Sub(first), 400*time.Millisecond)
+	// replies are still collected once the window is over
+	assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestScanSelf(t *testing.T) {
+	self := netip.MustParseAddr("127.0.0.1")
+	other := netip.MustParseAddr("127.0.6.1")
+
+	res, err := ScanDetailed(context.Background(), []netip.Addr{self, other}, WithTimeout(2*time.Second))
+	if errors.Is(err, ErrPermissionDenied) {
+		t.Skip(err)
+	}
+
+	assert.NoError(t, err)
+	assert.Equal(t, ScanEntry{Self: true}, res[self])
+	assert.True(t, res[other].Responded)
+	assert.False(t, res[other].Self)
+
+	// a scan of addresses of the host alone has nothing to wait for
+	start := time.Now()
+
+	res, err = ScanDetailed(context.Background(), []netip.Addr{self}, WithTimeout(2*time.Second))
+	assert.NoError(t, err)
+	assert.True(t, res[self].Self)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestScanLogger(t *testing.T) {
+	ips := []netip.Addr{netip.MustParseAddr("127.0.7.1")}
+
+	testcases := map[string]struct {
+		level slog.Level
+		out   []string
+		no    []string
+	}{
+		"debug": {
+			level: slog.LevelDebug,
+			out: []string{
+				`msg="sent probe" ip=127.0.7.1 attempt=1`,
+				`msg="got reply" ip=127.0.7.1 mac=00:00:00:00:00:00`,
+				`msg="scan complete" scanned=1 responded=1`,
+			},
+		},
+		"info": {
+			level: slog.LevelInfo,
+			out:   []string{`msg="scan complete" scanned=1 responded=1`},
+			no:    []string{"sent probe", "got reply"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			l := slog.New(slog.HandlerOptions{Level: tc.level}.NewTextHandler(&buf))
+
+			_, err := ScanDetailed(context.Background(), ips, WithTimeout(time.Second), WithLogger(l))
+			if errors.Is(err, ErrPermissionDenied) {
+				t.Skip(err)
+			}
+
+			assert.NoError(t, err)
+
+			for _, s := range tc.out {
+				assert.Contains(t, buf.String(), s)
+			}
+
+			for _, s := range tc.no {
+				assert.NotContains(t, buf.String(), s)
+			}
+		})
+	}
+}
+
+func TestLogScan(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := slog.New(slog.NewTextHandler(&buf))
+	entries := ScanEntries{
+		netip.MustParseAddr("10.0.0.1"): {Responded: true},
+		netip.MustParseAddr("10.0.0.2"): {},
+	}
+
+	logScan(context.Background(), l, entries, nil, time.Now())
+	assert.Contains(t, buf.String(), `level=INFO msg="scan complete" scanned=2 responded=1`)
+
+	buf.Reset()
+	logScan(context.Background(), l, nil, context.Canceled, time.Now())
+	assert.Contains(t, buf.String(), `level=WARN msg="scan complete" scanned=0 responded=0`)
+	assert.Contains(t, buf.String(), `error="context canceled"`)
+
+	assert.NotPanics(t, func() { logScan(context.Background(), nil, entries, nil, time.Now()) })
+}
+
+// BenchmarkScanConcurrency scans a loopback /22 with the default concurrency
+// of a few CPU counts
+func BenchmarkScanConcurrency(b *testing.B) {
+	prefix := netip.MustParsePrefix("127.0.4.0/22")
+
+	for _, cpus := range []int{1, 4, 8, 16, 32} {
+		n := defaultConcurrency(cpus)
+
+		b.Run(fmt.Sprintf("cpus=%d/concurrency=%d", cpus, n), func(b *testing.B) {
+			hosts := 0
+
+			for i := 0; i < b.N; i++ {
+				res, err := ScanPrefix(context.Background(), prefix,
+					WithTimeout(4*time.Second), WithConcurrency(n))
+				if errors.Is(err, ErrPermissionDenied) {
+					b.Skip(err)
+				}
+
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				for _, hwAddr := range res {
+					if hwAddr != nil {
+						hosts++
+					}
+				}
+			}
+
+			b.ReportMetric(float64(hosts)/b.Elapsed().Seconds(), "hosts/s")
+		})
+	}
+}
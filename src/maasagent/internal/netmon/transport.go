@@ -0,0 +1,45 @@
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+)
+
+// transport sends the probes of a scan and captures their replies, so that
+// matching replies to probes does not depend on where packets come from.
+// Scans use rawTransport unless another one is set with withTransport.
+type transport interface {
+	// capture returns pairs parsed from replies captured on iface, or on all
+	// interfaces if iface is empty, until ctx is done, and a function
+	// returning the number of replies dropped since its last call, see
+	// capture for the other parameters
+	capture(ctx context.Context, iface string, vlan uint16, bufSize int,
+		dad bool) (chan IPHwAddressPair, func() int, error)
+	// sender returns a sender of probes to addresses of the family of ip
+	// leaving through iface, which is nil if no interface was set with
+	// WithInterface
+	sender(ip netip.Addr, iface *net.Interface, opts scanOptions) (sender, error)
+}
+
+// rawTransport is the transport of scans on the network, which sends probes
+// with ICMP and packet sockets and captures replies with a packet socket.
+// It needs CAP_NET_RAW.
+type rawTransport struct{}
+
+func (rawTransport) capture(ctx context.Context, iface string, vlan uint16, bufSize int,
+	dad bool) (chan IPHwAddressPair, func() int, error) {
+	return capture(ctx, iface, vlan, bufSize, dad)
+}
+
+func (rawTransport) sender(ip netip.Addr, iface *net.Interface, opts scanOptions) (sender, error) {
+	return getSender(ip, iface, opts)
+}
+
+// withTransport makes scans send probes and capture replies with t instead
+// of rawTransport, for tests of scans without privileges or a network
+func withTransport(t transport) Option {
+	return func(o *scanOptions) {
+		o.transport = t
+	}
+}
@@ -0,0 +1,448 @@
+package netmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReply is a reply that fakeTransport captures after a probe
+type fakeReply struct {
+	// ip is the address of the reply, the probed address if it is zero
+	ip     netip.Addr
+	hwAddr net.HardwareAddr
+	// delay is the time between the probe and the reply
+	delay time.Duration
+	// attempt is the probe that is answered, every probe is if it is zero
+	attempt int
+}
+
+// fakeTransport captures scripted replies to probes instead of using
+// the network
+type fakeTransport struct {
+	// replies are the replies to probes of an address
+	replies map[netip.Addr][]fakeReply
+	// errs fail sending probes to an address
+	errs map[netip.Addr]error
+	// senderErr fails getting a sender
+	senderErr error
+	// drops are the replies dropped by the capture, which like those of
+	// a socket are reset when read and can't be read once it is closed
+	drops int
+
+	mu     sync.Mutex
+	ctx    context.Context
+	pairs  chan IPHwAddressPair
+	sent   map[netip.Addr]int
+	closed bool
+}
+
+func (f *fakeTransport) capture(ctx context.Context, _ string, _ uint16, _ int,
+	_ bool) (chan IPHwAddressPair, func() int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.ctx = ctx
+	f.pairs = make(chan IPHwAddressPair)
+	f.sent = make(map[netip.Addr]int)
+
+	stats := func() int {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if f.closed {
+			return 0
+		}
+
+		n := f.drops
+		f.drops = 0
+
+		return n
+	}
+
+	closeCapture := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.closed = true
+	}
+
+	return f.pairs, closeOnDone(ctx, stats, closeCapture), nil
+}
+
+func (f *fakeTransport) sender(_ netip.Addr, _ *net.Interface, _ scanOptions) (sender, error) {
+	if f.senderErr != nil {
+		return nil, f.senderErr
+	}
+
+	return fakeSender{f: f}, nil
+}
+
+// fakeSender schedules the replies of fakeTransport to every probe
+type fakeSender struct {
+	f *fakeTransport
+}
+
+func (s fakeSender) send(t *target) error {
+	f := s.f
+
+	f.mu.Lock()
+	f.sent[t.ip]++
+	attempt := f.sent[t.ip]
+	ctx, pairs := f.ctx, f.pairs
+	f.mu.Unlock()
+
+	if err := f.errs[t.ip]; err != nil {
+		return err
+	}
+
+	for _, r := range f.replies[t.ip] {
+		if r.attempt != 0 && r.attempt != attempt {
+			continue
+		}
+
+		pair := IPHwAddressPair{IP: r.ip, HwAddress: r.hwAddr}
+		if !pair.IP.IsValid() {
+			pair.IP = t.ip
+		}
+
+		time.AfterFunc(r.delay, func() {
+			select {
+			case pairs <- pair:
+			case <-ctx.Done():
+			}
+		})
+	}
+
+	return nil
+}
+
+func (fakeSender) Close() error { return nil }
+
+// replyFields returns the fields of e that are set by matching replies
+func replyFields(e ScanEntry) ScanEntry {
+	return ScanEntry{
+		MAC:       e.MAC,
+		MACs:      e.MACs,
+		Responded: e.Responded,
+		Attempts:  e.Attempts,
+		Err:       e.Err,
+		Warning:   e.Warning,
+	}
+}
+
+func TestScanTransport(t *testing.T) {
+	first := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	second := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+	third := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x03}
+	infiniband := make(net.HardwareAddr, 20)
+	errSend := errors.New("no buffer space available")
+
+	ips := []netip.Addr{
+		netip.MustParseAddr("203.0.113.1"),
+		netip.MustParseAddr("203.0.113.2"),
+		netip.MustParseAddr("203.0.113.3"),
+	}
+
+	testcases := map[string]struct {
+		ips     []netip.Addr
+		opts    []Option
+		replies map[netip.Addr][]fakeReply
+		errs    map[netip.Addr]error
+		out     ScanEntries
+	}{
+		"reply": {
+			ips:     ips[:1],
+			replies: map[netip.Addr][]fakeReply{ips[0]: {{hwAddr: first, delay: 5 * time.Millisecond}}},
+			out: ScanEntries{
+				ips[0]: {MAC: first, MACs: []net.HardwareAddr{first}, Responded: true, Attempts: 1},
+			},
+		},
+		"timeout": {
+			ips: ips[:1],
+			out: ScanEntries{ips[0]: {Attempts: 1}},
+		},
+		"timeout after retries": {
+			ips:  ips[:1],
+			opts: []Option{WithRetries(2)},
+			out:  ScanEntries{ips[0]: {Attempts: 3}},
+		},
+		"reply to a retry": {
+			ips:     ips[:1],
+			opts:    []Option{WithRetries(2)},
+			replies: map[netip.Addr][]fakeReply{ips[0]: {{hwAddr: first, attempt: 2}}},
+			out: ScanEntries{
+				ips[0]: {MAC: first, MACs: []net.HardwareAddr{first}, Responded: true, Attempts: 2},
+			},
+		},
+		"conflict": {
+			ips: ips[:1],
+			replies: map[netip.Addr][]fakeReply{ips[0]: {
+				{hwAddr: first, delay: 5 * time.Millisecond},
+				{hwAddr: second, delay: 20 * time.Millisecond},
+			}},
+			out: ScanEntries{
+				ips[0]: {MAC: first, MACs: []net.HardwareAddr{first, second}, Responded: true, Attempts: 1},
+			},
+		},
+		"duplicate reply": {
+			ips: ips[:1],
+			replies: map[netip.Addr][]fakeReply{ips[0]: {
+				{hwAddr: first},
+				{hwAddr: first, delay: 10 * time.Millisecond},
+			}},
+			out: ScanEntries{
+				ips[0]: {MAC: first, MACs: []net.HardwareAddr{first}, Responded: true, Attempts: 1},
+			},
+		},
+		"out of order replies": {
+			ips: ips,
+			replies: map[netip.Addr][]fakeReply{
+				ips[0]: {{hwAddr: first, delay: 30 * time.Millisecond}},
+				ips[1]: {{hwAddr: second, delay: 20 * time.Millisecond}},
+				ips[2]: {{hwAddr: third}},
+			},
+			out: ScanEntries{
+				ips[0]: {MAC: first, MACs: []net.HardwareAddr{first}, Responded: true, Attempts: 1},
+				ips[1]: {MAC: second, MACs: []net.HardwareAddr{second}, Responded: true, Attempts: 1},
+				ips[2]: {MAC: third, MACs: []net.HardwareAddr{third}, Responded: true, Attempts: 1},
+			},
+		},
+		"reply of an address that was not scanned": {
+			ips: ips[:1],
+			replies: map[netip.Addr][]fakeReply{ips[0]: {
+				{ip: netip.MustParseAddr("203.0.113.99"), hwAddr: first},
+			}},
+			out: ScanEntries{ips[0]: {Attempts: 1}},
+		},
+		"send error": {
+			ips:     ips[:2],
+			replies: map[netip.Addr][]fakeReply{ips[0]: {{hwAddr: first}}},
+			errs:    map[netip.Addr]error{ips[1]: errSend},
+			out: ScanEntries{
+				ips[0]: {MAC: first, MACs: []net.HardwareAddr{first}, Responded: true, Attempts: 1},
+				ips[1]: {Attempts: 1, Err: errSend},
+			},
+		},
+		"non-Ethernet reply": {
+			ips:     ips[:1],
+			replies: map[netip.Addr][]fakeReply{ips[0]: {{hwAddr: infiniband}}},
+			out: ScanEntries{
+				ips[0]: {Attempts: 1, Warning: fmt.Errorf("%w: %s", ErrNonEthernet, infiniband)},
+			},
+		},
+		"non-Ethernet reply allowed": {
+			ips:     ips[:1],
+			opts:    []Option{WithAllowNonEthernet()},
+			replies: map[netip.Addr][]fakeReply{ips[0]: {{hwAddr: infiniband}}},
+			out: ScanEntries{
+				ips[0]: {MAC: infiniband, MACs: []net.HardwareAddr{infiniband}, Responded: true, Attempts: 1},
+			},
+		},
+		// the last batch ends with the deadline of the scan
+		"batches": {
+			ips:  ips,
+			opts: []Option{WithBatchSize(2)},
+			replies: map[netip.Addr][]fakeReply{
+				ips[0]: {{hwAddr: first}},
+				ips[2]: {{hwAddr: third}},
+			},
+			out: ScanEntries{
+				ips[0]: {MAC: first, MACs: []net.HardwareAddr{first}, Responded: true, Attempts: 1},
+				ips[1]: {Attempts: 1},
+				ips[2]: {MAC: third, MACs: []net.HardwareAddr{third}, Responded: true, Attempts: 1},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tr := &fakeTransport{replies: tc.replies, errs: tc.errs}
+			opts := append([]Option{withTransport(tr), WithTimeout(300 * time.Millisecond)}, tc.opts...)
+
+			entries, err := NewScanner().ScanDetailed(context.Background(), tc.ips, opts...)
+			assert.NoError(t, err)
+
+			res := make(ScanEntries, len(entries))
+			for ip, e := range entries {
+				res[ip] = replyFields(e)
+			}
+
+			assert.Equal(t, tc.out, res)
+		})
+	}
+}
+
+func TestScanTransportLatency(t *testing.T) {
+	ip := netip.MustParseAddr("203.0.113.1")
+	tr := &fakeTransport{replies: map[netip.Addr][]fakeReply{ip: {
+		{hwAddr: net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}, delay: 20 * time.Millisecond},
+	}}}
+
+	entries, err := ScanDetailed(context.Background(), []netip.Addr{ip}, withTransport(tr))
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, entries[ip].Latency, 20*time.Millisecond)
+	assert.False(t, entries[ip].SeenAt.IsZero())
+}
+
+func TestScanTransportStream(t *testing.T) {
+	first := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	second := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+	ip := netip.MustParseAddr("203.0.113.1")
+
+	tr := &fakeTransport{replies: map[netip.Addr][]fakeReply{ip: {
+		{hwAddr: first},
+		{hwAddr: second, delay: 10 * time.Millisecond},
+	}}}
+
+	out := make(chan ScanResult)
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- ScanStream(context.Background(), []netip.Addr{ip}, out, withTransport(tr))
+	}()
+
+	var macs []net.HardwareAddr
+
+	for res := range out {
+		assert.Equal(t, ip, res.IP)
+
+		macs = append(macs, res.MAC)
+	}
+
+	assert.NoError(t, <-errCh)
+	// every host answering for the address is streamed
+	assert.Equal(t, []net.HardwareAddr{first, second}, macs)
+}
+
+func TestScanTransportErrors(t *testing.T) {
+	ip := netip.MustParseAddr("203.0.113.1")
+	errSocket := errors.New("address family not supported")
+
+	_, err := ScanDetailed(context.Background(), []netip.Addr{ip},
+		withTransport(&fakeTransport{senderErr: errSocket}))
+	assert.ErrorIs(t, err, ErrNoProbesSent)
+	assert.ErrorIs(t, err, errSocket)
+
+	var dropped uint64
+
+	_, err = ScanDetailed(context.Background(), []netip.Addr{ip}, WithTimeout(50*time.Millisecond),
+		withTransport(&fakeTransport{drops: 3}), WithDropCount(&dropped))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), dropped)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = ScanDetailed(ctx, []netip.Addr{ip}, withTransport(&fakeTransport{}))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestScanDropsOnDeadline(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("203.0.113.1"),
+		netip.MustParseAddr("203.0.113.2"),
+	}
+
+	var dropped uint64
+
+	// no address replies, so the scan only ends on its deadline, which
+	// closes the capture before drops are read
+	tr := &fakeTransport{drops: 3}
+
+	_, err := ScanDetailed(context.Background(), ips, WithTimeout(50*time.Millisecond),
+		withTransport(tr), WithDropCount(&dropped))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), dropped)
+}
+
+func TestCloseOnDone(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		stats  = 2
+		closed = make(chan struct{})
+	)
+
+	read := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		n := stats
+		stats = 0
+
+		return n
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	drops := closeOnDone(ctx, read, func() { close(closed) })
+	assert.Equal(t, 2, drops())
+
+	mu.Lock()
+	stats = 3
+	mu.Unlock()
+
+	cancel()
+	<-closed
+
+	// drops counted before closing are kept for the next call
+	assert.Equal(t, 3, drops())
+	assert.Equal(t, 0, drops())
+}
+
+func TestScanStreamEntries(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	errSend := errors.New("no buffer space available")
+	ips := []netip.Addr{
+		netip.MustParseAddr("203.0.113.1"),
+		netip.MustParseAddr("203.0.113.2"),
+		netip.MustParseAddr("203.0.113.3"),
+	}
+
+	tr := &fakeTransport{
+		replies: map[netip.Addr][]fakeReply{ips[0]: {{hwAddr: hwAddr}}},
+		errs:    map[netip.Addr]error{ips[1]: errSend},
+	}
+
+	var entries ScanEntries
+
+	out := make(chan ScanResult)
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- ScanStream(context.Background(), ips, out, withTransport(tr),
+			WithTimeout(100*time.Millisecond), WithEntries(&entries))
+	}()
+
+	var streamed []netip.Addr
+
+	for res := range out {
+		streamed = append(streamed, res.IP)
+	}
+
+	assert.NoError(t, <-errCh)
+	assert.Equal(t, ips[:1], streamed)
+
+	res := make(ScanEntries, len(entries))
+	for ip, e := range entries {
+		res[ip] = replyFields(e)
+	}
+
+	// addresses that were not streamed have their entries as well
+	assert.Equal(t, ScanEntries{
+		ips[0]: {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true, Attempts: 1},
+		ips[1]: {Attempts: 1, Err: errSend},
+		ips[2]: {Attempts: 1},
+	}, res)
+}
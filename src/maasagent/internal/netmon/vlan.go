@@ -0,0 +1,129 @@
+package netmon
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// MaxVLAN is the highest VLAN ID that can be set with WithVLAN,
+// 4095 is reserved by 802.1Q
+const MaxVLAN uint16 = 4094
+
+// sysClassNet is where the kernel exposes attributes of network interfaces
+var sysClassNet = "/sys/class/net"
+
+// checkVLAN returns an error if probes tagged with vlan can't be sent
+// through iface, which is nil if no interface was set with WithInterface.
+// Tagged frames are only written to Ethernet interfaces, the kernel does
+// not tag them for other link types.
+func checkVLAN(vlan uint16, iface *net.Interface) error {
+	if vlan > MaxVLAN {
+		return fmt.Errorf("%w: %d", ErrInvalidVLAN, vlan)
+	}
+
+	if iface == nil {
+		return fmt.Errorf("%w: an interface is required", ErrVLANUnsupported)
+	}
+
+	if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+		return fmt.Errorf("%w: %s is not an Ethernet interface", ErrVLANUnsupported, iface.Name)
+	}
+
+	// ARPHRD_ETHER, other types with 6 byte addresses don't carry 802.1Q
+	b, err := os.ReadFile(filepath.Join(sysClassNet, iface.Name, "type"))
+	if err == nil && strings.TrimSpace(string(b)) != strconv.Itoa(1) {
+		return fmt.Errorf("%w: %s is not an Ethernet interface", ErrVLANUnsupported, iface.Name)
+	}
+
+	return nil
+}
+
+// ndProbe returns an Ethernet frame with a Neighbor Solicitation for ip sent
+// from the unspecified address, like for Duplicate Address Detection, to the
+// solicited-node multicast address of ip from hwAddr. It is tagged with vlan
+// if it is set.
+func ndProbe(hwAddr net.HardwareAddr, ip netip.Addr, vlan uint16) ([]byte, error) {
+	if len(hwAddr) != 6 {
+		return nil, ErrNoHardwareAddr
+	}
+
+	if !ip.Is6() || ip.Is4In6() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddr, ip)
+	}
+
+	target := ip.As16()
+
+	group := [16]byte{0: 0xff, 1: 0x02, 11: 0x01, 12: 0xff}
+	copy(group[13:], target[13:])
+
+	dst := net.HardwareAddr{0x33, 0x33, group[12], group[13], group[14], group[15]}
+
+	ip6 := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolICMPv6,
+		HopLimit:   255,
+		SrcIP:      net.IPv6unspecified,
+		DstIP:      group[:],
+	}
+
+	icmp6 := &layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0),
+	}
+
+	if err := icmp6.SetNetworkLayerForChecksum(ip6); err != nil {
+		return nil, err
+	}
+
+	// no source link-layer option is allowed from the unspecified address
+	ns := &layers.ICMPv6NeighborSolicitation{TargetAddress: target[:]}
+
+	return serializeFrame(hwAddr, dst, layers.EthernetTypeIPv6, vlan, ip6, icmp6, ns)
+}
+
+// vlanFilter returns a BPF filter that rejects packets not tagged with vlan
+// and passes tagged ones to filter. The kernel strips tags of received frames,
+// so the tag is read from packet metadata and filter sees the inner frame.
+func vlanFilter(vlan uint16, filter []bpf.RawInstruction) ([]bpf.RawInstruction, error) {
+	const checks = 5
+
+	// jumps are relative to the next instruction, the last one rejects
+	reject := checks + len(filter)
+	if reject-2 > 0xff {
+		return nil, fmt.Errorf("filter of %d instructions is too long", len(filter))
+	}
+
+	prefix, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadExtension{Num: bpf.ExtVLANTagPresent},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0, SkipTrue: uint8(reject - 2)},
+		bpf.LoadExtension{Num: bpf.ExtVLANTag},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0x0fff},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(vlan), SkipTrue: uint8(reject - checks)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	suffix, err := bpf.Assemble([]bpf.Instruction{bpf.RetConstant{Val: 0}})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]bpf.RawInstruction, 0, reject+1)
+	out = append(out, prefix...)
+	out = append(out, filter...)
+
+	return append(out, suffix...), nil
+}
+
+// acceptFilter is a BPF filter passing whole packets
+var acceptFilter = []bpf.RawInstruction{
+	{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+}
@@ -0,0 +1,161 @@
+package netmon
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+)
+
+func TestNDProbe(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	b, err := ndProbe(hwAddr, netip.MustParseAddr("fd00::12:3456"), 100)
+	assert.NoError(t, err)
+
+	p := gopacket.NewPacket(b, layers.LinkTypeEthernet, gopacket.Default)
+	assert.Nil(t, p.ErrorLayer())
+
+	eth, ok := p.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	assert.True(t, ok)
+	assert.Equal(t, hwAddr, eth.SrcMAC)
+	assert.Equal(t, net.HardwareAddr{0x33, 0x33, 0xff, 0x12, 0x34, 0x56}, eth.DstMAC)
+	assert.Equal(t, layers.EthernetTypeDot1Q, eth.EthernetType)
+
+	dot1q, ok := p.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(100), dot1q.VLANIdentifier)
+	assert.Equal(t, layers.EthernetTypeIPv6, dot1q.Type)
+
+	ip6, ok := p.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(255), ip6.HopLimit)
+	assert.True(t, ip6.SrcIP.IsUnspecified())
+	assert.Equal(t, net.ParseIP("ff02::1:ff12:3456"), ip6.DstIP)
+
+	ns, ok := p.Layer(layers.LayerTypeICMPv6NeighborSolicitation).(*layers.ICMPv6NeighborSolicitation)
+	assert.True(t, ok)
+	assert.Equal(t, net.ParseIP("fd00::12:3456"), ns.TargetAddress)
+	assert.Empty(t, ns.Options)
+}
+
+func TestNDProbeInvalid(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	testcases := map[string]struct {
+		hwAddr net.HardwareAddr
+		ip     netip.Addr
+		err    error
+	}{
+		"no hardware address": {
+			ip:  netip.MustParseAddr("fd00::1"),
+			err: ErrNoHardwareAddr,
+		},
+		"IPv4": {
+			hwAddr: hwAddr,
+			ip:     netip.MustParseAddr("10.0.0.1"),
+			err:    ErrInvalidAddr,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ndProbe(tc.hwAddr, tc.ip, 0)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestVLANFilter(t *testing.T) {
+	filter, err := vlanFilter(100, icmpEchoReplyFilter)
+	assert.NoError(t, err)
+	assert.Len(t, filter, len(icmpEchoReplyFilter)+6)
+	assert.Equal(t, icmpEchoReplyFilter, filter[5:len(filter)-1])
+
+	insts, ok := bpf.Disassemble(filter)
+	assert.True(t, ok)
+
+	reject := len(insts) - 1
+	assert.Equal(t, bpf.RetConstant{Val: 0}, insts[reject])
+
+	// both checks of the tag reject to the last instruction
+	assert.Equal(t, bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0, SkipTrue: uint8(reject - 2)}, insts[1])
+	assert.Equal(t, bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 100, SkipTrue: uint8(reject - 5)}, insts[4])
+
+	_, err = vlanFilter(100, make([]bpf.RawInstruction, 300))
+	assert.Error(t, err)
+}
+
+func TestCheckVLAN(t *testing.T) {
+	sys := t.TempDir()
+
+	for name, typ := range map[string]string{"eth0": "1\n", "ib0": "32\n"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(sys, name), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(sys, name, "type"), []byte(typ), 0o600))
+	}
+
+	orig := sysClassNet
+	sysClassNet = sys
+
+	t.Cleanup(func() { sysClassNet = orig })
+
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	testcases := map[string]struct {
+		vlan  uint16
+		iface *net.Interface
+		err   error
+	}{
+		"Ethernet": {
+			vlan:  100,
+			iface: &net.Interface{Name: "eth0", HardwareAddr: hwAddr},
+		},
+		"unknown type": {
+			vlan:  100,
+			iface: &net.Interface{Name: "eth1", HardwareAddr: hwAddr},
+		},
+		"invalid ID": {
+			vlan:  4095,
+			iface: &net.Interface{Name: "eth0", HardwareAddr: hwAddr},
+			err:   ErrInvalidVLAN,
+		},
+		"no interface": {
+			vlan: 100,
+			err:  ErrVLANUnsupported,
+		},
+		"loopback": {
+			vlan:  100,
+			iface: &net.Interface{Name: "lo", Flags: net.FlagLoopback},
+			err:   ErrVLANUnsupported,
+		},
+		"not Ethernet": {
+			vlan:  100,
+			iface: &net.Interface{Name: "ib0", HardwareAddr: hwAddr},
+			err:   ErrVLANUnsupported,
+		},
+	}
+
+	// sysClassNet is shared, so cases don't run in parallel
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			err := checkVLAN(tc.vlan, tc.iface)
+			if tc.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.err)
+			}
+		})
+	}
+}
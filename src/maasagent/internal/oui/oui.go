@@ -0,0 +1,71 @@
+package oui
+
+import (
+	"bufio"
+	_ "embed"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// registry is a bundled copy of the IEEE MA-L assignments
+//
+//go:embed oui.txt
+var registry string
+
+// LocallyAdministered is returned by Lookup for locally administered
+// addresses, which are not assigned to any organization
+const LocallyAdministered = "locally administered"
+
+var (
+	vendors     map[uint32]string
+	vendorsOnce sync.Once
+)
+
+// Lookup returns the organization the OUI of the hardware address is
+// assigned to, or LocallyAdministered if the address is locally administered.
+// An empty string is returned for unknown OUIs.
+func Lookup(hwAddr net.HardwareAddr) string {
+	if len(hwAddr) < 3 {
+		return ""
+	}
+
+	if hwAddr[0]&0x02 != 0 {
+		return LocallyAdministered
+	}
+
+	vendorsOnce.Do(func() {
+		vendors = parse(registry)
+	})
+
+	return vendors[uint32(hwAddr[0])<<16|uint32(hwAddr[1])<<8|uint32(hwAddr[2])]
+}
+
+// parse reads lines of a hex encoded OUI followed by a tab and the name of
+// the organization. Comments and malformed lines are skipped.
+func parse(s string) map[uint32]string {
+	res := make(map[uint32]string)
+	scanner := bufio.NewScanner(strings.NewReader(s))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		prefix, name, ok := strings.Cut(line, "\t")
+		if !ok || len(prefix) != 6 {
+			continue
+		}
+
+		v, err := strconv.ParseUint(prefix, 16, 32)
+		if err != nil {
+			continue
+		}
+
+		res[uint32(v)] = strings.TrimSpace(name)
+	}
+
+	return res
+}
@@ -0,0 +1,45 @@
+package oui
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	testcases := map[string]struct {
+		in  net.HardwareAddr
+		out string
+	}{
+		"known OUI": {
+			in:  net.HardwareAddr{0x00, 0x50, 0x56, 0x01, 0x02, 0x03},
+			out: "VMware, Inc.",
+		},
+		"unknown OUI": {
+			in: net.HardwareAddr{0x00, 0x00, 0x01, 0x01, 0x02, 0x03},
+		},
+		"locally administered": {
+			in:  net.HardwareAddr{0x52, 0x54, 0x00, 0x01, 0x02, 0x03},
+			out: LocallyAdministered,
+		},
+		"too short": {
+			in: net.HardwareAddr{0x00, 0x50},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, Lookup(tc.in))
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	in := "# comment\n001B21\tIntel Corporate\nZZZZZZ\tInvalid\n0050\tShort\nnot a line\n"
+
+	assert.Equal(t, map[uint32]string{0x001b21: "Intel Corporate"}, parse(in))
+}
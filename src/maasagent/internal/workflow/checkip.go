@@ -1,42 +1,3376 @@
 package workflow
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/netip"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
+	"golang.org/x/exp/slog"
 
 	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/oui"
+	wflog "maas.io/core/src/maasagent/internal/workflow/log"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
 )
 
+const (
+	// maxExpandedHostBits limits prefix and range expansion to 2^16 addresses,
+	// which is a /16 for IPv4 and a /112 for IPv6
+	maxExpandedHostBits = 16
+	maxExpandedAddrs    = 1 << maxExpandedHostBits
+
+	// checkIPActivityDuration is the StartToCloseTimeout of scan activities
+	// when neither CheckIPParam.Timeout nor ProbeTimeout is set
+	checkIPActivityDuration = 5 * time.Second
+	// checkIPActivityMargin is the time given to the activity on top of
+	// the scan deadline to return its result
+	checkIPActivityMargin = checkIPActivityDuration - netmon.OperationTimeout
+
+	// defaultCheckIPBatchSize is used when CheckIPParam.BatchSize is not set
+	defaultCheckIPBatchSize = 500
+
+	// defaultCheckIPMaxIPs is used when CheckIPParam.MaxIPs is not set
+	defaultCheckIPMaxIPs = 65536
+
+	// defaultCheckIPMaxAttempts and defaultCheckIPInitialInterval are used
+	// when CheckIPParam.ActivityMaxAttempts and ActivityInitialInterval
+	// are not set
+	defaultCheckIPMaxAttempts     = 3
+	defaultCheckIPInitialInterval = time.Second
+
+	// checkIPChunkSize is the number of addresses scanned between checks
+	// whether CheckIP should continue as new, after checkIPChunksPerRun chunks
+	// or once the history has checkIPMaxHistoryLength events. This keeps
+	// the history of a run bounded when sweeping large prefixes.
+	checkIPChunkSize        = 4096
+	checkIPChunksPerRun     = 4
+	checkIPMaxHistoryLength = 10000
+
+	// checkIPHostnameTimeout bounds each reverse lookup of resolveHostnames,
+	// which runs up to checkIPHostnameConcurrency lookups at once
+	checkIPHostnameTimeout     = 2 * time.Second
+	checkIPHostnameConcurrency = 32
+
+	// checkIPHeartbeatThreshold is the number of addresses above which CheckIP
+	// scans them with a single CheckIPHeartbeatActivity instead of batches of
+	// local activities. Up to this size a local activity is the fast path,
+	// above it the scan is visible to Temporal through heartbeats and a stuck
+	// scan is detected by checkIPHeartbeatTimeout rather than the scan timeout.
+	checkIPHeartbeatThreshold = 2048
+	checkIPHeartbeatTimeout   = 10 * time.Second
+	// checkIPHeartbeatInterval is the interval of heartbeats sent while
+	// no replies are received
+	checkIPHeartbeatInterval = checkIPHeartbeatTimeout / 2
+)
+
+var (
+	// ErrPrefixTooLarge is an error for when a prefix passed to CheckIP
+	// contains more addresses than CheckIP is willing to expand
+	ErrPrefixTooLarge = errors.New("prefix is too large to expand")
+	// ErrRangeTooLarge is an error for when a range passed to CheckIP
+	// contains more addresses than CheckIP is willing to expand
+	ErrRangeTooLarge = errors.New("range is too large to expand")
+	// ErrInvalidPrefix is an error for when a prefix passed to CheckIP
+	// is not a valid prefix
+	ErrInvalidPrefix = errors.New("invalid prefix")
+	// ErrInvalidRange is an error for when a range passed to CheckIP
+	// has invalid or mismatching bounds
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrInvalidTimeout is an error for when a negative timeout
+	// is passed to CheckIP
+	ErrInvalidTimeout = errors.New("timeout must be positive")
+	// ErrInvalidRetry is an error for when a negative number of retries
+	// or retry interval is passed to CheckIP
+	ErrInvalidRetry = errors.New("retries and retry interval must be positive")
+	// ErrInvalidRateLimit is an error for when a negative rate limit
+	// is passed to CheckIP
+	ErrInvalidRateLimit = errors.New("rate limit must not be negative")
+	// ErrInvalidJitter is an error for when a negative jitter
+	// is passed to CheckIP
+	ErrInvalidJitter = errors.New("jitter must not be negative")
+	// ErrInvalidSpreadOver is an error for when a negative spread window
+	// is passed to CheckIP
+	ErrInvalidSpreadOver = errors.New("spread window must not be negative")
+	// ErrInvalidBatchSize is an error for when a negative batch size
+	// is passed to CheckIP
+	ErrInvalidBatchSize = errors.New("batch size must be positive")
+	// ErrInvalidRecvBufferBytes is an error for when a negative receive
+	// buffer size is passed to CheckIP
+	ErrInvalidRecvBufferBytes = errors.New("receive buffer size must not be negative")
+	// ErrInvalidOverallDeadline is an error for when a negative overall
+	// deadline is passed to CheckIP
+	ErrInvalidOverallDeadline = errors.New("overall deadline must not be negative")
+	// ErrInvalidInterfaces is an error for when both Interface and Interfaces
+	// are passed to CheckIP
+	ErrInvalidInterfaces = errors.New("interface and interfaces are mutually exclusive")
+	// ErrTooManyIPs is an error for when CheckIP is asked to scan more
+	// addresses than CheckIPParam.MaxIPs
+	ErrTooManyIPs = errors.New("too many addresses to scan")
+	// ErrInvalidHostname is an error for when an empty hostname
+	// is passed to CheckIP
+	ErrInvalidHostname = errors.New("invalid hostname")
+	// ErrInvalidGracePeriod is an error for when a negative signal grace
+	// period is passed to CheckIP
+	ErrInvalidGracePeriod = errors.New("signal grace period must be positive")
+	// ErrInvalidParallelSubnets is an error for when a negative number
+	// of parallel subnets is passed to CheckIP
+	ErrInvalidParallelSubnets = errors.New("parallel subnets must be positive")
+	// ErrInvalidSubnetSize is an error for when a negative subnet size
+	// is passed to CheckIP
+	ErrInvalidSubnetSize = errors.New("subnet size must be positive")
+	// ErrInvalidCacheAge is an error for when a negative maximum
+	// cache age is passed to CheckIP
+	ErrInvalidCacheAge = errors.New("cache age must not be negative")
+	// ErrInvalidIP is an error for when an invalid or unspecified address
+	// is passed to CheckIP
+	ErrInvalidIP = errors.New("invalid or unspecified address")
+	// ErrInvalidMaxIPs is an error for when a negative limit of addresses
+	// is passed to CheckIP
+	ErrInvalidMaxIPs = errors.New("max IPs must be positive")
+	// ErrInvalidVLAN is an error for when a VLAN ID above netmon.MaxVLAN,
+	// or without an interface to tag probes on, is passed to CheckIP
+	ErrInvalidVLAN = errors.New("invalid VLAN")
+	// ErrInvalidPassive is an error for when a passive check is combined
+	// with an option that sends probes
+	ErrInvalidPassive = errors.New("passive check can't send probes")
+	// ErrInvalidKnown is an error for when an invalid address, or an address
+	// without a hardware address, is passed to CheckIP as known
+	ErrInvalidKnown = errors.New("invalid known address")
+	// ErrInvalidSourceIP is an error for when a source address that is not
+	// a unicast IPv4 address, or that can't be used with the other options,
+	// is passed to CheckIP
+	ErrInvalidSourceIP = errors.New("invalid source IP")
+)
+
+// IPRange is an inclusive range of IP addresses
+type IPRange struct {
+	Start netip.Addr `json:"start"`
+	End   netip.Addr `json:"end"`
+}
+
 // CheckIPParam is a workflow parameter for the CheckIP workflow
 type CheckIPParam struct {
+	// IPs are scanned once each in ascending order, together with the
+	// addresses of Prefixes and Ranges, even if an address is repeated.
+	// IPv4-mapped IPv6 addresses are scanned and reported as IPv4 addresses.
 	IPs []netip.Addr `json:"ips"`
+	// Prefixes are expanded into individual host addresses before scanning
+	Prefixes []netip.Prefix `json:"prefixes"`
+	// Ranges are expanded into individual addresses before scanning
+	Ranges []IPRange `json:"ranges"`
+	// Hostnames are resolved to addresses before scanning. A hostname that
+	// does not resolve is reported with no addresses rather than failing
+	// the workflow.
+	Hostnames []string `json:"hostnames,omitempty"`
+	// Exclude and addresses of ExcludePrefixes are removed from the addresses
+	// to scan, including signaled ones, and reported in CheckIPResult.Skipped
+	Exclude         []netip.Addr   `json:"exclude,omitempty"`
+	ExcludePrefixes []netip.Prefix `json:"exclude_prefixes,omitempty"`
+	// AddressPolicy selects which categories of addresses are scanned,
+	// see CheckIPAddressPolicy. It is applied to every address after
+	// Exclude, AddressPolicyAllowAll is used when unset.
+	AddressPolicy CheckIPAddressPolicy `json:"address_policy,omitempty"`
+	// Timeout is the deadline for the scan to collect replies,
+	// netmon.OperationTimeout is used when zero
+	Timeout time.Duration `json:"timeout"`
+	// ProbeTimeout is how long every scan activity awaits replies after
+	// the last probe of every address (see netmon.WithReplyWait), so that
+	// late replies are still caught. Timeout is extended to it if it is
+	// shorter, and it is used instead of netmon.OperationTimeout when
+	// Timeout is zero. Probes await replies as long as the others when zero.
+	//
+	// Neither of them is bound by how long Temporal waits for an activity:
+	// the StartToCloseTimeout of scan activities is the resulting Timeout
+	// plus checkIPActivityMargin, and SpreadOver if set, so the activity
+	// always outlives the scan while Temporal still has a hard bound on it.
+	ProbeTimeout time.Duration `json:"probe_timeout,omitempty"`
+	// ResolveVendors enables lookup of the organization that the OUI
+	// of each resolved hardware address is assigned to
+	ResolveVendors bool `json:"resolve_vendors"`
+	// ResolveHostnames enables reverse DNS lookup of every address
+	// that responded, once the scan is over
+	ResolveHostnames bool `json:"resolve_hostnames"`
+	// MaxRetries is the number of times addresses that did not respond
+	// are scanned again, waiting RetryInterval before each retry
+	MaxRetries    int           `json:"max_retries"`
+	RetryInterval time.Duration `json:"retry_interval"`
+	// Retries is the number of probes sent to every address by a single scan,
+	// probes are sent again only to addresses that did not reply yet.
+	// A single probe is sent when zero.
+	Retries int `json:"retries"`
+	// RateLimit is the maximum number of probes sent per second by every
+	// scan activity, to avoid tripping broadcast storm control of switches.
+	// Replies are captured regardless of it. Probes that can't be sent
+	// within Timeout are not sent, so Timeout should leave room for
+	// every address at that rate. Probes are not limited when zero.
+	// Scans of several interfaces or subnets run at once are limited
+	// separately.
+	RateLimit int `json:"rate_limit"`
+	// Jitter delays the first probe of every address by a random offset
+	// up to it, so that scans scheduled at the same time across workers
+	// don't send a burst of probes at once (see netmon.WithJitter).
+	// The delay is drawn by the scan activity, which keeps the workflow
+	// deterministic, and shortens how long replies are awaited rather
+	// than extending Timeout. Probes are not delayed when zero.
+	Jitter time.Duration `json:"jitter"`
+	// SpreadOver paces the first probes of every scan, so that they are
+	// evenly spread over it instead of being sent in a burst when many
+	// agents scan the same network at the same time (see
+	// netmon.WithSpreadOver). Unlike RateLimit, it sets the duration of the
+	// scan rather than a rate. Replies are awaited for Timeout on top of it,
+	// and batches of a scan share it. Probes are not spread when zero.
+	SpreadOver time.Duration `json:"spread_over,omitempty"`
+	// BatchSize is the maximum number of addresses scanned by a single
+	// local activity, defaultCheckIPBatchSize is used when zero.
+	// Scans above checkIPHeartbeatThreshold run in a single activity,
+	// which scans batches of BatchSize addresses one after the other
+	// (see netmon.WithBatchSize), so that probes in flight and addresses
+	// awaiting a reply stay bounded. Results are the same either way.
+	BatchSize int `json:"batch_size"`
+	// RecvBufferBytes sets the receive buffer of the capture of replies of
+	// every scan, for scans large and fast enough for the kernel to drop
+	// replies (see CheckIPResult.Dropped and netmon.WithRecvBufferBytes).
+	// The buffer is sized by the number of scanned addresses when zero.
+	RecvBufferBytes int `json:"recv_buffer_bytes,omitempty"`
+	// Interface is the name of the interface to scan on, when empty
+	// the interface is chosen by the routing table
+	Interface string `json:"interface"`
+	// Interfaces are scanned concurrently for the same addresses,
+	// it can't be combined with Interface. An interface that can't be
+	// scanned, for example because it is down, is reported in
+	// CheckIPResult.FailedInterfaces while the others are still scanned.
+	// CheckIP only fails if none of them could be scanned.
+	Interfaces []string `json:"interfaces"`
+	// MaxIPs limits the number of addresses to scan, counting every address
+	// of IPs, Prefixes and Ranges even if they overlap.
+	// defaultCheckIPMaxIPs is used when zero.
+	MaxIPs int `json:"max_ips"`
+	// ActivityMaxAttempts and ActivityInitialInterval tune the retry policy
+	// of scan activities that failed with a transient error
+	ActivityMaxAttempts     int           `json:"activity_max_attempts"`
+	ActivityInitialInterval time.Duration `json:"activity_initial_interval"`
+	// PingFallback enables a second pass over addresses that are unresolved
+	// once the scan is over, with ICMP Echo requests received by the ICMP
+	// sockets instead of captured on the link. Addresses that reply are
+	// reported in CheckIPResult.Alive, as they are in use even though their
+	// hardware address is unknown. The scan stays the only way to learn
+	// hardware addresses.
+	PingFallback bool `json:"ping_fallback"`
+	// DetectConflicts checks whether addresses are in use before they are
+	// assigned, with RFC 5227 ARP probes (see netmon.Probe) instead of the
+	// scan. An address claimed by any host responded, with the hardware
+	// addresses of every claiming host in CheckIPEntry.MACs, and addresses
+	// that nobody claimed are unresolved without CheckIPEntry.Error. Only
+	// IPv4 addresses on the link of an interface can be probed, the others
+	// have an entry with an error. The cache of MaxCacheAge is not used.
+	DetectConflicts bool `json:"detect_conflicts"`
+	// Passive reads hardware addresses from the neighbor table of the kernel
+	// (see netmon.Neighbors) instead of scanning, so that nothing is sent on
+	// networks that must not be probed. Addresses the table doesn't have,
+	// or only has as incomplete or failed, are unresolved. Entries are not
+	// written to the cache of MaxCacheAge, and PingFallback and
+	// DetectConflicts can't be combined with it.
+	Passive bool `json:"passive,omitempty"`
+	// VLANID tags probes with the 802.1Q VLAN ID and only collects replies
+	// from that VLAN (see netmon.WithVLAN), 0 scans untagged. It requires
+	// Interface or Interfaces and can't be combined with PingFallback, as
+	// Echo requests of the kernel can't be tagged. The cache of MaxCacheAge
+	// is neither read nor written.
+	VLANID uint16 `json:"vlan_id,omitempty"`
+	// SourceIP is the sender address of ARP requests probing IPv4 addresses,
+	// instead of the address the kernel picks for Echo requests, for hosts
+	// that only reply to requests from their subnet (see netmon.WithSourceIP).
+	// It must be an address of Interface, which is required, and it can't be
+	// combined with Interfaces, Passive or DetectConflicts. Targets outside
+	// of the subnet of SourceIP are still probed and a warning is logged.
+	// Requests claiming SourceIP update neighbor caches of the hosts
+	// receiving them, so it must be an address owned by the agent host.
+	SourceIP netip.Addr `json:"source_ip,omitempty"`
+	// AllowNonEthernet keeps replies whose hardware address is not a 6 byte
+	// Ethernet address, which are otherwise dropped and reported with
+	// CheckIPEntry.Warning (see netmon.WithAllowNonEthernet)
+	AllowNonEthernet bool `json:"allow_non_ethernet,omitempty"`
+	// OnlyResponders drops addresses that did not respond from every
+	// collection of the result, including addresses that could not be
+	// probed, so that results of large scans stay small. Unresolved is then
+	// empty while Responded and Total still count every scanned address.
+	// Skipped addresses are still reported, as they were never scanned.
+	// Conflict detection only involves addresses that responded, so Conflicts
+	// and MACs of entries are the same either way. A free address can't be
+	// told apart from an unknown one with OnlyResponders, so callers looking
+	// for free addresses must leave it false.
+	OnlyResponders bool `json:"only_responders"`
+	// MACPrefixes limits the result to addresses that resolved to a hardware
+	// address starting with one of them, like "00:1a:2b" for an OUI.
+	// Addresses that resolved to other hardware addresses are dropped from
+	// every collection of the result and counted in CheckIPResult.Filtered,
+	// while Responded and Total still count them. Addresses that did not
+	// resolve are kept, unless OnlyResponders is set.
+	MACPrefixes []string `json:"mac_prefixes,omitempty"`
+	// MaxCacheAge enables accepting entries of addresses that responded
+	// to scans of this worker within MaxCacheAge, instead of probing them
+	// again, only stale and unknown addresses are probed. Such entries are
+	// marked with CheckIPEntry.Cached and listed in CheckIPResult.Cached.
+	// The cache lives in the worker process and is only used by activities,
+	// it is not used when Interfaces is set, see SetCheckIPCache.
+	// Caching is disabled when zero.
+	MaxCacheAge time.Duration `json:"max_cache_age"`
+	// SignalGracePeriod is how long CheckIP waits for addresses signaled
+	// with CheckIPAddIPsSignal once every known address is scanned.
+	// CheckIP finishes as soon as the scan is over when zero.
+	SignalGracePeriod time.Duration `json:"signal_grace_period"`
+	// Known are hardware addresses that addresses were last known to resolve
+	// to, which CheckIPResult.Changed and CheckIPResult.Disappeared are
+	// compared against. Every address is still scanned, known addresses
+	// that are not scanned are left out of the comparison.
+	Known map[netip.Addr]net.HardwareAddr `json:"known,omitempty"`
+	// ParallelSubnets enables scanning every prefix of Prefixes, and every /24
+	// (/120 for IPv6) of other addresses, with a separate CheckIP child
+	// workflow. It is the maximum number of children running at once.
+	ParallelSubnets int `json:"parallel_subnets"`
+	// SubnetSize is the maximum number of addresses scanned by one child
+	// workflow of ParallelSubnets. Larger subnets, like a /16 in Prefixes,
+	// are split into consecutive sub-ranges of that size. Subnets are
+	// scanned whole when it is 0.
+	SubnetSize int `json:"subnet_size,omitempty"`
+	// TraceProbes logs every probe sent, reply received and address that
+	// timed out to the logger of scan activities at debug level, together
+	// with a summary of every scan (see netmon.WithLogger). It is meant for
+	// debugging discovery, as it logs several records for every address.
+	// Conflict detection is not traced.
+	TraceProbes bool `json:"trace_probes,omitempty"`
+	// DryRun stops CheckIP before the scan: addresses are expanded,
+	// deduplicated, excluded and skipped like for a scan, and the ones that
+	// would be probed are returned in CheckIPResult.Planned without sending
+	// a single probe. Hostnames are still resolved, the cache of MaxCacheAge
+	// is not read and nothing is done once the scan would be over, so the
+	// result is only made of Planned, Skipped, Total and ResolvedHostnames.
+	DryRun bool `json:"dry_run,omitempty"`
+	// OverallDeadline bounds the whole scan, across batches, retries, rounds
+	// of signaled addresses and runs continued as new, while Timeout only
+	// bounds each scan activity. Once it passes, running scans are canceled,
+	// nothing else is scanned or resolved, and the result holds the addresses
+	// scanned until then with CheckIPResult.Truncated set. Addresses of
+	// canceled scans are left out of it. The scan is unbounded when zero.
+	OverallDeadline time.Duration `json:"overall_deadline,omitempty"`
+	// Carry is set by CheckIP when it continues as new, with IPs set
+	// to the addresses that are left to scan. Callers leave it empty.
+	Carry *CheckIPCarry `json:"carry,omitempty"`
+}
+
+// CheckIPEntry is the outcome of checking a single address
+type CheckIPEntry struct {
+	MAC net.HardwareAddr `json:"mac"`
+	// MACs are all distinct hardware addresses that replied, starting with
+	// MAC. More than one of them means that several hosts use the address,
+	// for example because of a misconfigured static address or a rogue DHCP
+	// server.
+	MACs      []net.HardwareAddr `json:"macs,omitempty"`
+	Responded bool               `json:"responded"`
+	// Latency is measured by the activity, so it does not affect
+	// workflow determinism
+	Latency time.Duration `json:"latency"`
+	// Error is set if the address could not be probed, so it is unknown
+	// whether the address is in use
+	Error string `json:"error,omitempty"`
+	// Interface is the interface that the probe left through, InterfaceIndex
+	// its index and SourceMAC its hardware address. Without an interface set
+	// in CheckIPParam, probes to different addresses may leave through
+	// different interfaces.
+	Interface      string           `json:"interface,omitempty"`
+	InterfaceIndex int              `json:"interface_index,omitempty"`
+	SourceMAC      net.HardwareAddr `json:"source_mac,omitempty"`
+	// SourceIP is the source address of the probe, 0.0.0.0 for ARP probes
+	// and zero if it is unknown
+	SourceIP netip.Addr `json:"source_ip,omitempty"`
+	// Attempts is the number of probes sent to the address by the last scan
+	Attempts int `json:"attempts,omitempty"`
+	// SeenAt is the time the first reply was received, measured
+	// by the activity
+	SeenAt time.Time `json:"seen_at"`
+	// Cached is set if the entry comes from the cache of an earlier scan,
+	// see CheckIPParam.MaxCacheAge
+	Cached bool `json:"cached,omitempty"`
+	// Self is set if the address is assigned to an interface of the agent
+	// host, which is not probed
+	Self bool `json:"self,omitempty"`
+	// Warning is set if a reply from the address was dropped, like a reply
+	// with a hardware address that is not an Ethernet address
+	Warning string `json:"warning,omitempty"`
+}
+
+// CheckIPAddrEntry is the entry of an address, as listed by
+// CheckIPResult.Sorted
+type CheckIPAddrEntry struct {
+	IP    netip.Addr   `json:"ip"`
+	Entry CheckIPEntry `json:"entry"`
 }
 
 // CheckIPResult is a value returned by the CheckIP workflow
 type CheckIPResult struct {
+	// IPs and other maps of the result are keyed by the normalized form
+	// of each address, which turns IPv4-mapped IPv6 addresses into IPv4
 	IPs map[netip.Addr]net.HardwareAddr `json:"ips"`
+	// Entries tell apart addresses that did not respond from addresses
+	// that could not be probed
+	Entries map[netip.Addr]CheckIPEntry `json:"entries"`
+	// RTTs are round-trip times of the winning reply of every address that
+	// responded, measured from the last probe sent before it (see
+	// CheckIPEntry.Latency). Addresses that did not respond have none.
+	RTTs map[netip.Addr]time.Duration `json:"rtts"`
+	// Unresolved are scanned addresses that did not reply within the timeout,
+	// in the order they were scanned
+	Unresolved []netip.Addr `json:"unresolved"`
+	// SelfAddresses are addresses assigned to an interface of the agent host,
+	// in the order they were scanned. They are not probed, as the host would
+	// answer itself, and are neither resolved nor unresolved.
+	SelfAddresses []netip.Addr `json:"self_addresses,omitempty"`
+	// Vendors are set when CheckIPParam.ResolveVendors is true. Unknown OUIs
+	// map to an empty string and locally administered addresses map to
+	// oui.LocallyAdministered.
+	Vendors map[netip.Addr]string `json:"vendors,omitempty"`
+	// Hostnames are set when CheckIPParam.ResolveHostnames is true. Addresses
+	// without a PTR record, or whose lookup failed, map to an empty string.
+	Hostnames map[netip.Addr]string `json:"hostnames,omitempty"`
+	// ResolvedHostnames are addresses that CheckIPParam.Hostnames resolved to
+	ResolvedHostnames map[string][]netip.Addr `json:"resolved_hostnames,omitempty"`
+	// StartedAt and FinishedAt are the wall clock bounds of the scan, from
+	// the start of the earliest scan activity to the end of the latest one.
+	// They are measured by the activities, never by the workflow, so they
+	// tell when addresses were actually probed.
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Responded is the number of addresses resolved to a hardware address
+	// out of Total unique addresses scanned
+	Responded int `json:"responded"`
+	Total     int `json:"total"`
+	// Conflicts groups addresses that got replies from the same hardware
+	// address, keyed by the lowercase colon separated form of it, like
+	// InvertResult. This usually means an IP conflict or a proxy ARP device
+	// on the network. Addresses claimed by several hardware addresses are
+	// told by the MACs of their entries.
+	Conflicts map[string][]netip.Addr `json:"conflicts,omitempty"`
+	// PerInterface are hardware addresses found on each interface when
+	// CheckIPParam.Interfaces is set, IPs is the union of them
+	PerInterface map[string]map[netip.Addr]net.HardwareAddr `json:"per_interface,omitempty"`
+	// FailedInterfaces are errors of interfaces of CheckIPParam.Interfaces
+	// that could not be scanned, keyed by interface name. Addresses of the
+	// result were then only scanned on the other interfaces.
+	FailedInterfaces map[string]string `json:"failed_interfaces,omitempty"`
+	// Alive are addresses of Unresolved that replied to the second pass
+	// of CheckIPParam.PingFallback, in the order they were scanned.
+	// They are kept with CheckIPParam.OnlyResponders.
+	Alive []netip.Addr `json:"alive,omitempty"`
+	// Cached are addresses whose entry was taken from the cache of
+	// CheckIPParam.MaxCacheAge instead of being probed, in the order they
+	// were scanned. Every other address of Entries was probed by this call.
+	Cached []netip.Addr `json:"cached,omitempty"`
+	// Skipped are unspecified, loopback and multicast addresses, addresses
+	// skipped by CheckIPParam.AddressPolicy and addresses excluded by
+	// CheckIPParam, which are not scanned, in the order the other
+	// addresses are scanned in
+	Skipped []netip.Addr `json:"skipped,omitempty"`
+	// Planned are addresses that CheckIPParam.DryRun would have probed,
+	// in the order they would have been scanned. IPs and Entries are then
+	// empty.
+	Planned []netip.Addr `json:"planned,omitempty"`
+	// Truncated is set if CheckIPParam.OverallDeadline passed before every
+	// address was scanned. The result is then made of the addresses scanned
+	// earlier, and Vendors, Hostnames and Alive are not set.
+	Truncated bool `json:"truncated,omitempty"`
+	// Dropped is the number of replies that the kernel dropped as the
+	// capture buffer of a scan was full. Unlike with Truncated, every address
+	// was scanned, but addresses whose replies were dropped are reported as
+	// unresolved, so the result is not reliable when it is set. Scanning
+	// Unresolved again, or with a larger CheckIPParam.RecvBufferBytes, may
+	// resolve them. Addresses found with Passive or DetectConflicts don't
+	// count drops.
+	Dropped int `json:"dropped,omitempty"`
+	// Changed are the hardware addresses that addresses of CheckIPParam.Known
+	// now resolve to when they differ from the known ones, and Disappeared
+	// are addresses of CheckIPParam.Known that did not respond, in the order
+	// they were scanned. Addresses that could not be probed, that are Alive
+	// or that are assigned to the agent host are in neither of them.
+	// Disappeared is kept with CheckIPParam.OnlyResponders.
+	Changed     map[netip.Addr]net.HardwareAddr `json:"changed,omitempty"`
+	Disappeared []netip.Addr                    `json:"disappeared,omitempty"`
+	// Filtered is the number of addresses that responded and were dropped
+	// from the result by CheckIPParam.MACPrefixes
+	Filtered int `json:"filtered,omitempty"`
+}
+
+// CheckIPAddIPsSignal is the name of the CheckIP signal carrying
+// a []netip.Addr with more addresses to scan
+const CheckIPAddIPsSignal = "addIPs"
+
+// CheckIPProgressQuery is the name of the CheckIP query returning
+// CheckIPProgress
+const CheckIPProgressQuery = "scanProgress"
+
+// CheckIPPartialResultsQuery is the name of the CheckIP query returning
+// a map[netip.Addr]net.HardwareAddr of addresses resolved so far. It is
+// updated when scan activities complete, like CheckIPProgress.
+const CheckIPPartialResultsQuery = "partialResults"
+
+// CheckIPProgress is the progress of a running CheckIP workflow. It is updated
+// when a scan activity completes, so an address scanned by a heartbeating
+// activity is counted once the whole activity is done. Addresses scanned on
+// several interfaces are counted once per interface.
+type CheckIPProgress struct {
+	// Total is the number of addresses to scan
+	Total int `json:"total"`
+	// Completed is the number of addresses scanned at least once
+	Completed int `json:"completed"`
+	// Resolved is the number of addresses resolved to a hardware address
+	Resolved int `json:"resolved"`
 }
 
-// CheckIP is a Temporal workflow for checking available IP addresses
+// CheckIP is a Temporal workflow for checking available IP addresses.
+// Addresses signaled with CheckIPAddIPsSignal before the scan is over, or
+// within CheckIPParam.SignalGracePeriod after it, are scanned as well,
+// unless they were already scanned. If a scan fails once addresses were
+// scanned, the error carries the result scanned so far, which callers get
+// with PartialCheckIPResult.
 func CheckIP(ctx workflow.Context, param CheckIPParam) (CheckIPResult, error) {
-	ao := workflow.LocalActivityOptions{
-		ScheduleToCloseTimeout: 5 * time.Second,
+	log := workflow.GetLogger(ctx)
+
+	v := workflow.GetVersion(ctx, checkIPBatchedScanChange, workflow.DefaultVersion, checkIPBatchedScanVersion)
+	if v == workflow.DefaultVersion {
+		return checkIPDefaultVersion(ctx, param)
+	}
+
+	scanTimeout := checkIPScanTimeout(param.Timeout, param.ProbeTimeout)
+
+	log.Info("Starting IP check", tag.Builder().
+		KV("ips", len(param.IPs)).
+		KV("prefixes", len(param.Prefixes)).
+		KV("ranges", len(param.Ranges)).
+		KV("hostnames", len(param.Hostnames)).
+		KV("timeout", scanTimeout).KeyVals...)
+
+	if err := validateCheckIPParam(param); err != nil {
+		log.Error("Invalid IP check parameter", tag.Builder().Error(err).KeyVals...)
+		return CheckIPResult{}, err
 	}
-	ctx = workflow.WithLocalActivityOptions(ctx, ao)
 
-	var scanned map[netip.Addr]net.HardwareAddr
+	// state is only changed by the workflow code below,
+	// so replaying the workflow rebuilds the same state
+	state := newCheckIPState(param.Carry)
+	tracker := newCheckIPTracker(&state.Progress, state.Scanned.IPs)
 
-	err := workflow.ExecuteLocalActivity(ctx, netmon.Scan, param.IPs).Get(ctx, &scanned)
+	if err := setCheckIPQueryHandlers(ctx, &state.Progress, tracker); err != nil {
+		return CheckIPResult{}, err
+	}
+
+	param, err := resolveCheckIPHostnames(ctx, param, &state)
 	if err != nil {
 		return CheckIPResult{}, err
 	}
 
-	result := CheckIPResult{
-		IPs: scanned,
+	param.IPs = normalizeIPs(param.IPs)
+
+	ctx = withCheckIPActivityOptions(ctx, param)
+
+	ips, err := checkIPAddresses(ctx, param)
+	if err != nil {
+		return CheckIPResult{}, err
+	}
+
+	if param.OverallDeadline > 0 && state.Deadline.IsZero() {
+		state.Deadline = workflow.Now(ctx).Add(param.OverallDeadline)
+	}
+
+	// scans are done with sctx, which is canceled once the deadline passes
+	sctx, deadlinePassed, stopDeadline := withOverallDeadline(ctx, state.Deadline)
+	defer stopDeadline()
+
+	scan := newCheckIPScan(ctx, sctx, deadlinePassed, param, &state, tracker, ips)
+	if err := scan.run(ips); err != nil {
+		return CheckIPResult{}, err
+	}
+
+	ips = state.IPs
+
+	if param.DryRun {
+		log.Info("IP check dry run complete", tag.Builder().
+			KV("planned", len(ips)).
+			KV("skipped", len(state.Skipped)).KeyVals...)
+
+		return CheckIPResult{
+			IPs:               map[netip.Addr]net.HardwareAddr{},
+			Entries:           map[netip.Addr]CheckIPEntry{},
+			Planned:           ips,
+			Total:             countUnique(ips),
+			Skipped:           state.Skipped,
+			ResolvedHostnames: state.Hostnames,
+		}, nil
+	}
+
+	result := newCheckIPResult(ips, state, scan.truncated)
+
+	if scan.err != nil {
+		return CheckIPResult{}, partialCheckIPError(ctx, scan.err, result, param)
+	}
+
+	if result.Truncated {
+		log.Warn("IP check truncated by the overall deadline", tag.Builder().
+			KV("total", result.Total).
+			KV("resolved", result.Responded).
+			KV("unresolved", len(result.Unresolved)).KeyVals...)
+
+		result.Changed, result.Disappeared = knownChanges(ips, state.Scanned.Entries, param.Known, nil)
+
+		filterResult(&result, param)
+
+		return result, nil
+	}
+
+	if err := completeCheckIPResult(ctx, &result, ips, state.Scanned, param); err != nil {
+		return CheckIPResult{}, err
 	}
 
+	filterResult(&result, param)
+
 	return result, nil
 }
+
+// setCheckIPQueryHandlers makes progress and the addresses resolved by
+// tracker queryable, see CheckIPProgressQuery and CheckIPPartialResultsQuery
+func setCheckIPQueryHandlers(ctx workflow.Context, progress *CheckIPProgress,
+	tracker *checkIPTracker) error {
+	err := workflow.SetQueryHandler(ctx, CheckIPProgressQuery, func() (CheckIPProgress, error) {
+		return *progress, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return workflow.SetQueryHandler(ctx, CheckIPPartialResultsQuery,
+		func() (map[netip.Addr]net.HardwareAddr, error) {
+			return tracker.snapshot(), nil
+		})
+}
+
+// resolveCheckIPHostnames returns param with the addresses that its
+// Hostnames resolve to added to its IPs, recording them in state
+func resolveCheckIPHostnames(ctx workflow.Context, param CheckIPParam,
+	state *CheckIPCarry) (CheckIPParam, error) {
+	if len(param.Hostnames) == 0 {
+		return param, nil
+	}
+
+	lctx := workflow.WithLocalActivityOptions(ctx, workflow.LocalActivityOptions{
+		StartToCloseTimeout: resolveHostnamesTimeout(len(param.Hostnames)),
+	})
+
+	err := workflow.ExecuteLocalActivity(lctx, lookupHosts, param.Hostnames).Get(ctx, &state.Hostnames)
+	if err != nil {
+		return param, err
+	}
+
+	for _, name := range param.Hostnames {
+		param.IPs = append(param.IPs, state.Hostnames[name]...)
+	}
+
+	param.Hostnames = nil
+
+	// hostnames may resolve to more addresses than allowed
+	if err := validateCheckIPParam(param); err != nil {
+		workflow.GetLogger(ctx).Error("Invalid IP check parameter", tag.Builder().Error(err).KeyVals...)
+		return param, err
+	}
+
+	return param, nil
+}
+
+// checkIPAddresses returns the addresses of param to scan, its IPs
+// together with the addresses of its Prefixes and Ranges
+func checkIPAddresses(ctx workflow.Context, param CheckIPParam) ([]netip.Addr, error) {
+	if len(param.Prefixes) == 0 && len(param.Ranges) == 0 {
+		return param.IPs, nil
+	}
+
+	var ips []netip.Addr
+
+	err := workflow.ExecuteLocalActivity(ctx, expandCheckIPParam, param).Get(ctx, &ips)
+	if err != nil {
+		return nil, err
+	}
+
+	return ips, nil
+}
+
+// checkIPScan scans the addresses of a run of CheckIP in rounds. Every round
+// after the first one scans addresses that were signaled while the previous
+// round was running. Scanned addresses are recorded in state.
+type checkIPScan struct {
+	ctx workflow.Context
+	// sctx is canceled once the overall deadline passes
+	sctx           workflow.Context
+	deadlinePassed func() bool
+	param          CheckIPParam
+	state          *CheckIPCarry
+	tracker        *checkIPTracker
+
+	addIPs    workflow.ReceiveChannel
+	seen      map[netip.Addr]struct{}
+	exclusion checkIPExclusion
+	// perAddr is the number of scans of every address, as addresses
+	// scanned on every interface count once per interface
+	perAddr int
+
+	round int
+	// chunks is the number of chunks scanned by this run
+	chunks int
+	// truncated is set when the deadline passed with addresses left to scan
+	truncated bool
+	// err ends the check with the addresses scanned before it
+	err error
+}
+
+// newCheckIPScan returns the scan of ips by a run of CheckIP with state
+// carried over by previous runs, see withOverallDeadline for sctx and
+// deadlinePassed
+func newCheckIPScan(ctx, sctx workflow.Context, deadlinePassed func() bool, param CheckIPParam,
+	state *CheckIPCarry, tracker *checkIPTracker, ips []netip.Addr) *checkIPScan {
+	seen := make(map[netip.Addr]struct{}, len(ips)+len(state.IPs)+len(state.Skipped))
+	for _, list := range [][]netip.Addr{state.IPs, state.Skipped, ips} {
+		for _, ip := range list {
+			seen[ip] = struct{}{}
+		}
+	}
+
+	perAddr := 1
+	if len(param.Interfaces) > 0 {
+		perAddr = len(param.Interfaces)
+	}
+
+	return &checkIPScan{
+		ctx:            ctx,
+		sctx:           sctx,
+		deadlinePassed: deadlinePassed,
+		param:          param,
+		state:          state,
+		tracker:        tracker,
+		addIPs:         workflow.GetSignalChannel(ctx, CheckIPAddIPsSignal),
+		seen:           seen,
+		exclusion:      newCheckIPExclusion(param.Exclude, param.ExcludePrefixes),
+		perAddr:        perAddr,
+	}
+}
+
+// run scans pending and the addresses signaled until no more are signaled,
+// the deadline passes or a scan fails, which sets s.err. The returned error
+// ends the run, like the error continuing it as new.
+func (s *checkIPScan) run(pending []netip.Addr) error {
+	log := workflow.GetLogger(s.ctx)
+
+	for len(pending) > 0 {
+		if s.deadlinePassed() {
+			s.truncated = true
+			return nil
+		}
+
+		var (
+			skipped []netip.Addr
+			err     error
+		)
+
+		pending, skipped, err = s.skip(pending)
+		if err != nil {
+			return err
+		}
+
+		if len(pending) == 0 {
+			pending = s.receive()
+			continue
+		}
+
+		if s.param.DryRun {
+			s.state.IPs = append(s.state.IPs, pending...)
+			pending = s.receive()
+
+			continue
+		}
+
+		s.round++
+
+		log.Info("Scanning addresses", tag.Builder().
+			KV("round", s.round).
+			KV("ips", len(pending)).
+			KV("skipped", len(skipped)).KeyVals...)
+
+		if done, err := s.scanChunks(pending); done || err != nil {
+			return err
+		}
+
+		pending = s.receive()
+	}
+
+	return nil
+}
+
+// skip returns addresses of pending to scan and records the others as
+// skipped, those that are excluded, not allowed by the address policy or
+// can't be scanned
+func (s *checkIPScan) skip(pending []netip.Addr) (toScan, skipped []netip.Addr, err error) {
+	var excluded, dropped []netip.Addr
+
+	pending, excluded = s.exclusion.split(pending)
+
+	pending, dropped, err = s.param.AddressPolicy.split(pending)
+	if err != nil {
+		workflow.GetLogger(s.ctx).Error("Address not allowed by policy", tag.Builder().Error(err).KeyVals...)
+		return nil, nil, err
+	}
+
+	pending, skipped = skipUnscannable(pending)
+	skipped = append(append(skipped, dropped...), excluded...)
+	s.state.Skipped = append(s.state.Skipped, skipped...)
+
+	return pending, skipped, nil
+}
+
+// receive returns addresses signaled to the run, waiting for them
+// for the grace period of the parameter
+func (s *checkIPScan) receive() []netip.Addr {
+	return receiveAddIPs(s.sctx, s.addIPs, s.seen, checkIPMaxIPs(s.param), s.param.SignalGracePeriod)
+}
+
+// scanChunks scans a round of pending addresses in chunks. It returns true
+// if no other round follows, as the deadline passed or a scan failed.
+func (s *checkIPScan) scanChunks(pending []netip.Addr) (bool, error) {
+	s.state.Progress.Total += len(pending) * s.perAddr
+
+	for len(pending) > 0 {
+		if s.deadlinePassed() {
+			s.truncated = true
+			return true, nil
+		}
+
+		if s.chunks > 0 && shouldContinueCheckIPAsNew(s.ctx, s.chunks) {
+			return true, s.continueAsNew(pending)
+		}
+
+		chunk := pending
+		// child workflows keep the history of this run small,
+		// so a round is never split when they are used
+		if len(chunk) > checkIPChunkSize && s.param.ParallelSubnets == 0 {
+			chunk = chunk[:checkIPChunkSize:checkIPChunkSize]
+		}
+
+		pending = pending[len(chunk):]
+
+		done, err := s.scanChunk(chunk, len(pending) > 0)
+		if done || err != nil {
+			return true, err
+		}
+
+		s.state.IPs = append(s.state.IPs, chunk...)
+		s.chunks++
+	}
+
+	return false, nil
+}
+
+// scanChunk scans chunk, taking cached entries of MaxCacheAge. It returns
+// true if the deadline passed or the scan failed, which only records the
+// scanned addresses of chunk. more tells whether addresses are left after
+// chunk, so the scan is truncated if the deadline passed.
+func (s *checkIPScan) scanChunk(chunk []netip.Addr, more bool) (bool, error) {
+	state, param := s.state, s.param
+
+	toScan, err := s.lookupCache(chunk)
+	if err != nil {
+		return true, err
+	}
+
+	if len(toScan) > 0 {
+		res, resPerInterface, failed, err := scanRound(s.sctx, toScan, param, s.tracker)
+		// failed scans return what they scanned, which is kept
+		// if the deadline canceled them
+		mergeCheckIPActivityResult(&state.Scanned, res)
+		state.PerInterface = mergePerInterface(state.PerInterface, resPerInterface)
+		state.FailedInterfaces = mergeFailedInterfaces(state.FailedInterfaces, failed)
+
+		if err != nil && !s.deadlinePassed() {
+			s.err = err
+			state.IPs = append(state.IPs, scannedIPs(chunk, state.Scanned.Entries)...)
+
+			return true, nil
+		}
+	}
+
+	if s.deadlinePassed() {
+		done := scannedIPs(chunk, state.Scanned.Entries)
+		state.IPs = append(state.IPs, done...)
+		s.truncated = len(done) < len(chunk) || more
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// lookupCache returns addresses of chunk that are not in the cache of
+// MaxCacheAge, recording the cached ones
+func (s *checkIPScan) lookupCache(chunk []netip.Addr) ([]netip.Addr, error) {
+	param := s.param
+
+	if param.MaxCacheAge <= 0 || len(param.Interfaces) > 0 || param.DetectConflicts || param.VLANID != 0 {
+		return chunk, nil
+	}
+
+	var cached CheckIPActivityResult
+
+	err := workflow.ExecuteLocalActivity(s.ctx, lookupCheckIPCache, checkIPCacheParam{
+		IPs:       chunk,
+		MaxAge:    param.MaxCacheAge,
+		Interface: param.Interface,
+	}).Get(s.ctx, &cached)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cached.IPs) == 0 {
+		return chunk, nil
+	}
+
+	s.tracker.record(cached, mergeCheckIPActivityResult(&s.state.Scanned, cached), len(cached.IPs))
+	toScan := uncached(chunk, cached.IPs)
+
+	workflow.GetLogger(s.ctx).Info("Using cached entries", tag.Builder().
+		KV("cached", len(cached.IPs)).
+		KV("ips", len(toScan)).KeyVals...)
+
+	return toScan, nil
+}
+
+// continueAsNew returns the error continuing the run as new, which scans
+// pending and the addresses signaled that were not received yet
+func (s *checkIPScan) continueAsNew(pending []netip.Addr) error {
+	// signals are not carried over, so pending ones are
+	// scanned by the next run
+	pending = append(pending, receiveAddIPs(s.ctx, s.addIPs, s.seen, checkIPMaxIPs(s.param), 0)...)
+	s.state.Progress.Total -= len(pending) * s.perAddr
+
+	workflow.GetLogger(s.ctx).Info("Continuing IP check as new", tag.Builder().
+		KV("scanned", len(s.state.IPs)).
+		KV("remaining", len(pending)).KeyVals...)
+
+	next := s.param
+	next.IPs = pending
+	next.Prefixes = nil
+	next.Hostnames = nil
+	next.Ranges = nil
+	next.Carry = s.state
+
+	return workflow.NewContinueAsNewError(s.ctx, CheckIP, next)
+}
+
+// newCheckIPResult returns the result of scanning ips, as recorded in state
+func newCheckIPResult(ips []netip.Addr, state CheckIPCarry, truncated bool) CheckIPResult {
+	scanned := state.Scanned
+
+	result := CheckIPResult{
+		IPs:        scanned.IPs,
+		Entries:    scanned.Entries,
+		RTTs:       rtts(scanned.Entries),
+		Unresolved: withoutSelf(unresolved(ips, scanned.IPs), scanned.Entries),
+		StartedAt:  scanned.StartedAt,
+		FinishedAt: scanned.FinishedAt,
+		Total:      countUnique(ips),
+
+		PerInterface: state.PerInterface,
+		Cached:       cachedIPs(ips, scanned.Entries),
+		Skipped:      state.Skipped,
+
+		FailedInterfaces: state.FailedInterfaces,
+
+		SelfAddresses: selfAddresses(ips, scanned.Entries),
+
+		ResolvedHostnames: state.Hostnames,
+		Truncated:         truncated,
+		Dropped:           scanned.Dropped,
+	}
+
+	result.Responded = result.Total - len(result.Unresolved) - len(result.SelfAddresses)
+	result.Conflicts = conflicts(result)
+
+	return result
+}
+
+// completeCheckIPResult adds to result of a scan that was not truncated
+// what param asks for on top of the scan: addresses that answered pings,
+// changes of Known addresses, vendors and hostnames
+func completeCheckIPResult(ctx workflow.Context, result *CheckIPResult, ips []netip.Addr,
+	scanned CheckIPActivityResult, param CheckIPParam) error {
+	log := workflow.GetLogger(ctx)
+
+	if param.PingFallback && len(result.Unresolved) > 0 {
+		err := workflow.ExecuteLocalActivity(ctx, pingUnresolved, CheckIPActivityParam{
+			IPs:       result.Unresolved,
+			Timeout:   param.Timeout,
+			Interface: param.Interface,
+			Retries:   param.Retries,
+			RateLimit: param.RateLimit,
+		}).Get(ctx, &result.Alive)
+		if err != nil {
+			return err
+		}
+	}
+
+	result.Changed, result.Disappeared = knownChanges(ips, scanned.Entries, param.Known, result.Alive)
+
+	if result.Dropped > 0 {
+		log.Warn("IP check dropped replies, unresolved addresses may have replied", tag.Builder().
+			KV("dropped", result.Dropped).
+			KV("unresolved", len(result.Unresolved)).KeyVals...)
+	}
+
+	log.Info("IP check complete", tag.Builder().
+		KV("total", result.Total).
+		KV("resolved", result.Responded).
+		KV("unresolved", len(result.Unresolved)).
+		KV("alive", len(result.Alive)).
+		KV("cached", len(result.Cached)).
+		KV("skipped", len(result.Skipped)).
+		KV("conflicts", len(result.Conflicts)).
+		KV("changed", len(result.Changed)).
+		KV("disappeared", len(result.Disappeared)).KeyVals...)
+
+	if param.ResolveVendors {
+		err := workflow.ExecuteLocalActivity(ctx, resolveVendors, scanned.IPs).Get(ctx, &result.Vendors)
+		if err != nil {
+			return err
+		}
+	}
+
+	if param.ResolveHostnames {
+		hctx := workflow.WithLocalActivityOptions(ctx, workflow.LocalActivityOptions{
+			StartToCloseTimeout: resolveHostnamesTimeout(result.Responded),
+		})
+
+		err := workflow.ExecuteLocalActivity(hctx, resolveHostnames, scanned.IPs).Get(ctx, &result.Hostnames)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// partialCheckIPError returns err of a failed scan, with res attached to it
+// as the details of an application error if any address was scanned before
+// the failure, see PartialCheckIPResult. The error keeps the type of err,
+// so that callers telling scan errors apart by their type still can.
+func partialCheckIPError(ctx workflow.Context, err error, res CheckIPResult, param CheckIPParam) error {
+	if len(res.Entries) == 0 {
+		return err
+	}
+
+	workflow.GetLogger(ctx).Warn("IP check failed with a partial result", tag.Builder().
+		KV("total", res.Total).
+		KV("resolved", res.Responded).
+		Error(err).KeyVals...)
+
+	filterResult(&res, param)
+
+	errType := "checkIPFailed"
+
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		errType = appErr.Type()
+
+		if appErr.NonRetryable() {
+			return temporal.NewNonRetryableApplicationError("IP check failed", errType, err, res)
+		}
+	}
+
+	return temporal.NewApplicationErrorWithCause("IP check failed", errType, err, res)
+}
+
+// PartialCheckIPResult returns the result that a CheckIP workflow failing
+// with err had when its scan failed. It only holds addresses scanned before
+// the failure, including those resolved by the failing scan, and no
+// Vendors, Hostnames, Alive, Changed or Disappeared. It returns false if
+// nothing was scanned.
+func PartialCheckIPResult(err error) (CheckIPResult, bool) {
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || !appErr.HasDetails() {
+		return CheckIPResult{}, false
+	}
+
+	var res CheckIPResult
+	if appErr.Details(&res) != nil {
+		return CheckIPResult{}, false
+	}
+
+	return res, true
+}
+
+// filterResult drops addresses of res that param does not ask for
+func filterResult(res *CheckIPResult, param CheckIPParam) {
+	// prefixes were validated with the parameter
+	prefixes, _ := parseMACPrefixes(param.MACPrefixes)
+	filterMACPrefixes(res, prefixes)
+
+	if param.OnlyResponders {
+		onlyResponders(res)
+	}
+}
+
+// onlyResponders drops addresses that did not respond from collections
+// of res. Vendors, Hostnames, RTTs and conflicts are left as they
+// only hold addresses that responded.
+func onlyResponders(res *CheckIPResult) {
+	responded := func(ip netip.Addr) bool {
+		return len(res.IPs[ip]) > 0
+	}
+
+	filter := func(ips []netip.Addr) []netip.Addr {
+		var kept []netip.Addr
+
+		for _, ip := range ips {
+			if responded(ip) {
+				kept = append(kept, ip)
+			}
+		}
+
+		return kept
+	}
+
+	for ip, e := range res.Entries {
+		if !e.Responded {
+			delete(res.Entries, ip)
+		}
+	}
+
+	for _, found := range res.PerInterface {
+		for ip, hwAddr := range found {
+			if len(hwAddr) == 0 {
+				delete(found, ip)
+			}
+		}
+	}
+
+	for name, ips := range res.ResolvedHostnames {
+		res.ResolvedHostnames[name] = filter(ips)
+	}
+
+	// IPs are filtered last, as the other collections are filtered by them
+	for ip, hwAddr := range res.IPs {
+		if len(hwAddr) == 0 {
+			delete(res.IPs, ip)
+		}
+	}
+
+	res.Unresolved = nil
+}
+
+// checkIPTracker follows the progress and the addresses resolved by scans
+// of a CheckIP workflow as their activities complete
+type checkIPTracker struct {
+	progress *CheckIPProgress
+	found    map[netip.Addr]net.HardwareAddr
+}
+
+// newCheckIPTracker returns a tracker updating progress, which starts with
+// the addresses resolved in scanned
+func newCheckIPTracker(progress *CheckIPProgress,
+	scanned map[netip.Addr]net.HardwareAddr) *checkIPTracker {
+	t := &checkIPTracker{
+		progress: progress,
+		found:    make(map[netip.Addr]net.HardwareAddr, len(scanned)),
+	}
+
+	for ip, hwAddr := range scanned {
+		if len(hwAddr) > 0 {
+			t.found[ip] = hwAddr
+		}
+	}
+
+	return t
+}
+
+// record adds the result of an activity that resolved that many addresses
+// for the first time and scanned completed addresses for the first time
+func (t *checkIPTracker) record(res CheckIPActivityResult, resolved, completed int) {
+	t.progress.Resolved += resolved
+	t.progress.Completed += completed
+
+	for ip, hwAddr := range res.IPs {
+		if len(hwAddr) > 0 {
+			t.found[ip] = hwAddr
+		}
+	}
+}
+
+// snapshot returns a copy of the addresses resolved so far
+func (t *checkIPTracker) snapshot() map[netip.Addr]net.HardwareAddr {
+	res := make(map[netip.Addr]net.HardwareAddr, len(t.found))
+
+	for ip, hwAddr := range t.found {
+		res[ip] = append(net.HardwareAddr(nil), hwAddr...)
+	}
+
+	return res
+}
+
+// CheckIPCarry is the state of a CheckIP workflow carried over
+// when it continues as new
+type CheckIPCarry struct {
+	Scanned      CheckIPActivityResult                      `json:"scanned"`
+	PerInterface map[string]map[netip.Addr]net.HardwareAddr `json:"per_interface,omitempty"`
+	Skipped      []netip.Addr                               `json:"skipped,omitempty"`
+	// FailedInterfaces are interfaces that could not be scanned by any
+	// run, see CheckIPResult
+	FailedInterfaces map[string]string `json:"failed_interfaces,omitempty"`
+	// IPs are the scanned addresses in the order of scanning
+	IPs      []netip.Addr    `json:"ips"`
+	Progress CheckIPProgress `json:"progress"`
+	// Hostnames are resolved once by the first run
+	Hostnames map[string][]netip.Addr `json:"hostnames,omitempty"`
+	// Deadline is when CheckIPParam.OverallDeadline passes, as set by the
+	// first run
+	Deadline time.Time `json:"deadline,omitempty"`
+}
+
+// newCheckIPState returns the state carried over by the previous run,
+// or an empty state for the first run
+func newCheckIPState(carry *CheckIPCarry) CheckIPCarry {
+	var state CheckIPCarry
+	if carry != nil {
+		state = *carry
+	}
+
+	if state.Scanned.IPs == nil {
+		state.Scanned.IPs = make(map[netip.Addr]net.HardwareAddr)
+	}
+
+	if state.Scanned.Entries == nil {
+		state.Scanned.Entries = make(map[netip.Addr]CheckIPEntry)
+	}
+
+	return state
+}
+
+// withOverallDeadline returns a child context of ctx that is canceled once
+// deadline passes, unless it is zero, and a function returning true once it
+// did. stop releases the timer of the deadline.
+func withOverallDeadline(ctx workflow.Context,
+	deadline time.Time) (sctx workflow.Context, passed func() bool, stop func()) {
+	if deadline.IsZero() {
+		return ctx, func() bool { return false }, func() {}
+	}
+
+	sctx, cancel := workflow.WithCancel(ctx)
+	tctx, stopTimer := workflow.WithCancel(ctx)
+
+	var done bool
+
+	expire := func() {
+		done = true
+		cancel()
+	}
+
+	// a run continued as new may start past the deadline
+	if d := deadline.Sub(workflow.Now(ctx)); d <= 0 {
+		expire()
+	} else {
+		workflow.Go(tctx, func(ctx workflow.Context) {
+			if err := workflow.NewTimer(ctx, d).Get(ctx, nil); err == nil {
+				expire()
+			}
+		})
+	}
+
+	return sctx, func() bool { return done }, func() {
+		stopTimer()
+		cancel()
+	}
+}
+
+// scannedIPs returns addresses of ips that have an entry, keeping their order
+func scannedIPs(ips []netip.Addr, entries map[netip.Addr]CheckIPEntry) []netip.Addr {
+	var res []netip.Addr
+
+	for _, ip := range ips {
+		if _, ok := entries[ip]; ok {
+			res = append(res, ip)
+		}
+	}
+
+	return res
+}
+
+// shouldContinueCheckIPAsNew returns true once a run of CheckIP has scanned
+// checkIPChunksPerRun chunks or its history has grown above
+// checkIPMaxHistoryLength events
+func shouldContinueCheckIPAsNew(ctx workflow.Context, chunks int) bool {
+	return chunks >= checkIPChunksPerRun ||
+		workflow.GetInfo(ctx).GetCurrentHistoryLength() >= checkIPMaxHistoryLength
+}
+
+// checkIPScanTimeout returns how long a scan collects replies with the
+// Timeout and ProbeTimeout of CheckIPParam, see CheckIPParam.ProbeTimeout
+func checkIPScanTimeout(timeout, probeTimeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		timeout = netmon.OperationTimeout
+		if probeTimeout > 0 {
+			timeout = probeTimeout
+		}
+	}
+
+	if probeTimeout > timeout {
+		return probeTimeout
+	}
+
+	return timeout
+}
+
+// withCheckIPActivityOptions returns ctx with options of scan activities
+func withCheckIPActivityOptions(ctx workflow.Context, param CheckIPParam) workflow.Context {
+	// replies are awaited once probes have been spread
+	timeout := checkIPScanTimeout(param.Timeout, param.ProbeTimeout) + checkIPActivityMargin + param.SpreadOver
+
+	retryPolicy := &temporal.RetryPolicy{
+		InitialInterval: defaultCheckIPInitialInterval,
+		MaximumAttempts: defaultCheckIPMaxAttempts,
+	}
+
+	if param.ActivityInitialInterval > 0 {
+		retryPolicy.InitialInterval = param.ActivityInitialInterval
+	}
+
+	if param.ActivityMaxAttempts > 0 {
+		retryPolicy.MaximumAttempts = int32(param.ActivityMaxAttempts)
+	}
+
+	// timeout applies to each attempt, the number of attempts is bounded
+	// by the retry policy
+	return workflow.WithLocalActivityOptions(ctx, workflow.LocalActivityOptions{
+		StartToCloseTimeout: timeout,
+		RetryPolicy:         retryPolicy,
+	})
+}
+
+// scanRound scans ips on param.Interface, or on every interface of
+// param.Interfaces together with hardware addresses found on each of them
+// and errors of interfaces that could not be scanned.
+// With param.ParallelSubnets the scan is done by child workflows.
+func scanRound(ctx workflow.Context, ips []netip.Addr, param CheckIPParam,
+	tracker *checkIPTracker) (CheckIPActivityResult, map[string]map[netip.Addr]net.HardwareAddr,
+	map[string]string, error) {
+	if param.ParallelSubnets > 0 {
+		return scanSubnets(ctx, ips, param, tracker)
+	}
+
+	if len(param.Interfaces) == 0 {
+		res, err := scanIPs(ctx, ips, param, param.Interface, tracker)
+
+		return res, nil, nil, err
+	}
+
+	return scanInterfaces(ctx, ips, param, tracker)
+}
+
+// subnet is a group of addresses scanned by a child workflow of CheckIP
+type subnet struct {
+	prefix netip.Prefix
+	// part is the number of the sub-range of prefix, starting with 1,
+	// or 0 when the subnet was not split
+	part int
+	ips  []netip.Addr
+}
+
+// String returns the prefix of s followed by its part if it is a sub-range
+func (s subnet) String() string {
+	if s.part == 0 {
+		return s.prefix.String()
+	}
+
+	return fmt.Sprintf("%s-%d", s.prefix, s.part)
+}
+
+// subnets groups ips by the first of prefixes containing them, or by their
+// /24 for IPv4 and /120 for IPv6. Subnets with more than size addresses are
+// split when size is positive. Subnets and their addresses follow the order
+// of ips, so that the result is the same on replay.
+func subnets(ips []netip.Addr, prefixes []netip.Prefix, size int) []subnet {
+	var res []subnet
+
+	index := make(map[netip.Prefix]int)
+
+	for _, ip := range ips {
+		var key netip.Prefix
+
+		for _, p := range prefixes {
+			if p.Contains(ip.WithZone("")) {
+				key = p.Masked()
+				break
+			}
+		}
+
+		if !key.IsValid() {
+			bits := 120
+			if ip.Is4() {
+				bits = 24
+			}
+
+			key, _ = ip.WithZone("").Prefix(bits)
+		}
+
+		i, ok := index[key]
+		if !ok {
+			i = len(res)
+			index[key] = i
+			res = append(res, subnet{prefix: key})
+		}
+
+		res[i].ips = append(res[i].ips, ip)
+	}
+
+	if size <= 0 {
+		return res
+	}
+
+	var split []subnet
+
+	for _, s := range res {
+		if len(s.ips) <= size {
+			split = append(split, s)
+			continue
+		}
+
+		for i, ips := range batches(s.ips, size) {
+			split = append(split, subnet{prefix: s.prefix, part: i + 1, ips: ips})
+		}
+	}
+
+	return split
+}
+
+// scanSubnets scans every subnet of ips with a CheckIP child workflow, running
+// at most param.ParallelSubnets of them at once. A child that fails does not
+// fail the others, its addresses get an entry with the error instead, and
+// addresses of a canceled child are left out.
+func scanSubnets(ctx workflow.Context, ips []netip.Addr, param CheckIPParam,
+	tracker *checkIPTracker) (CheckIPActivityResult, map[string]map[netip.Addr]net.HardwareAddr,
+	map[string]string, error) {
+	groups := subnets(ips, param.Prefixes, param.SubnetSize)
+	results := make([]CheckIPResult, len(groups))
+	errs := make([]error, len(groups))
+
+	perAddr := 1
+	if len(param.Interfaces) > 0 {
+		perAddr = len(param.Interfaces)
+	}
+
+	info := workflow.GetInfo(ctx)
+	selector := workflow.NewSelector(ctx)
+
+	for next, running := 0, 0; next < len(groups) || running > 0; {
+		for ; running < param.ParallelSubnets && next < len(groups); next++ {
+			i := next
+
+			childParam := param
+			childParam.IPs = groups[i].ips
+			childParam.Prefixes = nil
+			childParam.Hostnames = nil
+			childParam.Ranges = nil
+			childParam.Exclude = nil
+			childParam.ExcludePrefixes = nil
+			childParam.ParallelSubnets = 0
+			childParam.SubnetSize = 0
+			childParam.MaxCacheAge = 0
+			childParam.OnlyResponders = false
+			childParam.MACPrefixes = nil
+			childParam.PingFallback = false
+			childParam.ResolveVendors = false
+			childParam.ResolveHostnames = false
+			childParam.SignalGracePeriod = 0
+			childParam.OverallDeadline = 0
+			childParam.Carry = nil
+			childParam.Known = nil
+
+			cctx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+				WorkflowID: fmt.Sprintf("%s-%s", info.WorkflowExecution.ID, groups[i]),
+			})
+
+			selector.AddFuture(workflow.ExecuteChildWorkflow(cctx, CheckIP, childParam), func(f workflow.Future) {
+				running--
+				errs[i] = f.Get(ctx, &results[i])
+			})
+
+			running++
+		}
+
+		selector.Select(ctx)
+	}
+
+	union := CheckIPActivityResult{
+		IPs:     make(map[netip.Addr]net.HardwareAddr, len(ips)),
+		Entries: make(map[netip.Addr]CheckIPEntry, len(ips)),
+	}
+
+	var (
+		perInterface map[string]map[netip.Addr]net.HardwareAddr
+		failed       map[string]string
+	)
+
+	// results are merged in the order of subnets, so that the result
+	// is the same on replay
+	for i, group := range groups {
+		res := CheckIPActivityResult{
+			IPs:        results[i].IPs,
+			Entries:    results[i].Entries,
+			StartedAt:  results[i].StartedAt,
+			FinishedAt: results[i].FinishedAt,
+			Dropped:    results[i].Dropped,
+		}
+
+		// subnets canceled by CheckIPParam.OverallDeadline were not scanned
+		if temporal.IsCanceledError(errs[i]) {
+			continue
+		}
+
+		if errs[i] != nil {
+			workflow.GetLogger(ctx).Warn("Subnet scan failed", tag.Builder().
+				KV("subnet", group.String()).
+				Error(errs[i]).KeyVals...)
+
+			res = CheckIPActivityResult{
+				IPs:     make(map[netip.Addr]net.HardwareAddr, len(group.ips)),
+				Entries: make(map[netip.Addr]CheckIPEntry, len(group.ips)),
+			}
+
+			for _, ip := range group.ips {
+				res.IPs[ip] = nil
+				res.Entries[ip] = CheckIPEntry{Error: errs[i].Error()}
+			}
+		}
+
+		resolved := mergeCheckIPActivityResult(&union, res)
+		if len(results[i].PerInterface) > 0 {
+			resolved = 0
+
+			for _, found := range results[i].PerInterface {
+				resolved += len(found) - len(unresolved(group.ips, found))
+			}
+		}
+
+		tracker.record(res, resolved, len(group.ips)*perAddr)
+		perInterface = mergePerInterface(perInterface, results[i].PerInterface)
+		failed = mergeFailedInterfaces(failed, results[i].FailedInterfaces)
+	}
+
+	return union, perInterface, failed, nil
+}
+
+// mergePerInterface adds hardware addresses found on each interface by src
+// to dst, which is allocated when needed
+func mergePerInterface(dst,
+	src map[string]map[netip.Addr]net.HardwareAddr) map[string]map[netip.Addr]net.HardwareAddr {
+	for iface, found := range src {
+		if dst == nil {
+			dst = make(map[string]map[netip.Addr]net.HardwareAddr, len(src))
+		}
+
+		if dst[iface] == nil {
+			dst[iface] = make(map[netip.Addr]net.HardwareAddr, len(found))
+		}
+
+		for ip, hwAddr := range found {
+			dst[iface][ip] = hwAddr
+		}
+	}
+
+	return dst
+}
+
+// mergeFailedInterfaces adds errors of interfaces of src to dst, which is
+// allocated when needed. The first error of an interface is kept.
+func mergeFailedInterfaces(dst, src map[string]string) map[string]string {
+	for iface, err := range src {
+		if dst == nil {
+			dst = make(map[string]string, len(src))
+		}
+
+		if _, ok := dst[iface]; !ok {
+			dst[iface] = err
+		}
+	}
+
+	return dst
+}
+
+// receiveAddIPs drains addresses signaled to ch. If there are no new
+// addresses, it waits for them until ch has been idle for grace. Invalid and
+// unspecified addresses, addresses in seen and addresses above the limit of
+// maxIPs addresses in seen are dropped, new ones are added to seen.
+func receiveAddIPs(ctx workflow.Context, ch workflow.ReceiveChannel,
+	seen map[netip.Addr]struct{}, maxIPs int, grace time.Duration) []netip.Addr {
+	var res []netip.Addr
+
+	receive := func(c workflow.ReceiveChannel, _ bool) {
+		var ips []netip.Addr
+
+		c.Receive(ctx, &ips)
+
+		for _, ip := range normalizeIPs(ips) {
+			if _, ok := seen[ip]; ok || !ip.IsValid() || ip.IsUnspecified() || len(seen) >= maxIPs {
+				continue
+			}
+
+			seen[ip] = struct{}{}
+			res = append(res, ip)
+		}
+	}
+
+	drained := false
+
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(ch, receive)
+	selector.AddDefault(func() {
+		drained = true
+	})
+
+	for !drained {
+		selector.Select(ctx)
+	}
+
+	if len(res) > 0 || grace <= 0 {
+		return res
+	}
+
+	tctx, cancel := workflow.WithCancel(ctx)
+	defer cancel()
+
+	expired := false
+
+	selector = workflow.NewSelector(ctx)
+	selector.AddReceive(ch, receive)
+	selector.AddFuture(workflow.NewTimer(tctx, grace), func(workflow.Future) {
+		expired = true
+	})
+
+	// signals carrying only known addresses don't end the wait
+	for !expired && len(res) == 0 {
+		selector.Select(ctx)
+	}
+
+	return res
+}
+
+// normalizeIPs unmaps IPv4-mapped IPv6 addresses, sorts them and drops
+// duplicates, so that scans get the same input regardless of the order
+// callers built it in. IPv4 addresses sort before IPv6 ones.
+func normalizeIPs(ips []netip.Addr) []netip.Addr {
+	sorted := make([]netip.Addr, len(ips))
+	for i, ip := range ips {
+		sorted[i] = ip.Unmap()
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Compare(sorted[j]) < 0
+	})
+
+	res := make([]netip.Addr, 0, len(sorted))
+
+	for i, ip := range sorted {
+		if i > 0 && ip == sorted[i-1] {
+			continue
+		}
+
+		res = append(res, ip)
+	}
+
+	return res
+}
+
+// skipUnscannable splits ips into addresses that can be scanned and the ones
+// that can't own a hardware address on the link, dropping duplicates of the
+// latter. The order of both is kept, so that the result is the same on replay.
+func skipUnscannable(ips []netip.Addr) ([]netip.Addr, []netip.Addr) {
+	var skipped []netip.Addr
+
+	res := make([]netip.Addr, 0, len(ips))
+	seen := make(map[netip.Addr]struct{})
+
+	for _, ip := range ips {
+		if !ip.IsUnspecified() && !ip.IsLoopback() && !ip.IsMulticast() && !ip.IsLinkLocalMulticast() {
+			res = append(res, ip)
+			continue
+		}
+
+		if _, ok := seen[ip]; ok {
+			continue
+		}
+
+		seen[ip] = struct{}{}
+		skipped = append(skipped, ip)
+	}
+
+	return res, skipped
+}
+
+// checkIPExclusion is a set of addresses and prefixes that are not scanned
+type checkIPExclusion struct {
+	ips map[netip.Addr]struct{}
+	// prefixes are sorted and do not overlap, so that the only prefix
+	// that may contain an address is the last one starting at or before it
+	prefixes []netip.Prefix
+}
+
+// newCheckIPExclusion returns the exclusion of ips and prefixes. IPv4-mapped
+// IPv6 addresses and prefixes are unmapped like the addresses to scan.
+func newCheckIPExclusion(ips []netip.Addr, prefixes []netip.Prefix) checkIPExclusion {
+	e := checkIPExclusion{ips: make(map[netip.Addr]struct{}, len(ips))}
+
+	for _, ip := range ips {
+		e.ips[ip.Unmap()] = struct{}{}
+	}
+
+	sorted := make([]netip.Prefix, 0, len(prefixes))
+
+	for _, p := range prefixes {
+		p = p.Masked()
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+
+		sorted = append(sorted, p)
+	}
+
+	// prefixes starting at the same address sort from the widest,
+	// so that nested ones are dropped below
+	sort.Slice(sorted, func(i, j int) bool {
+		if c := sorted[i].Addr().Compare(sorted[j].Addr()); c != 0 {
+			return c < 0
+		}
+
+		return sorted[i].Bits() < sorted[j].Bits()
+	})
+
+	for _, p := range sorted {
+		if n := len(e.prefixes); n > 0 && e.prefixes[n-1].Contains(p.Addr()) {
+			continue
+		}
+
+		e.prefixes = append(e.prefixes, p)
+	}
+
+	return e
+}
+
+// contains returns true if ip is excluded
+func (e checkIPExclusion) contains(ip netip.Addr) bool {
+	if _, ok := e.ips[ip]; ok {
+		return true
+	}
+
+	// Prefix.Contains is false for zoned addresses, while a link-local
+	// prefix is meant to exclude them on every link
+	ip = ip.WithZone("")
+
+	i := sort.Search(len(e.prefixes), func(i int) bool {
+		return ip.Less(e.prefixes[i].Addr())
+	})
+
+	return i > 0 && e.prefixes[i-1].Contains(ip)
+}
+
+// split splits ips into addresses to scan and excluded ones,
+// keeping the order of both
+func (e checkIPExclusion) split(ips []netip.Addr) ([]netip.Addr, []netip.Addr) {
+	if len(e.ips) == 0 && len(e.prefixes) == 0 {
+		return ips, nil
+	}
+
+	var excluded []netip.Addr
+
+	res := make([]netip.Addr, 0, len(ips))
+
+	for _, ip := range ips {
+		if e.contains(ip) {
+			excluded = append(excluded, ip)
+			continue
+		}
+
+		res = append(res, ip)
+	}
+
+	return res, excluded
+}
+
+// scanInterfaces scans ips on every interface of param.Interfaces concurrently.
+// It returns the union of all scans together with hardware addresses found
+// on each interface. Interfaces whose scan failed are returned with their
+// error instead, the first error is only returned if every interface failed.
+func scanInterfaces(ctx workflow.Context, ips []netip.Addr, param CheckIPParam,
+	tracker *checkIPTracker) (CheckIPActivityResult, map[string]map[netip.Addr]net.HardwareAddr,
+	map[string]string, error) {
+	results := make([]CheckIPActivityResult, len(param.Interfaces))
+	errs := make([]error, len(param.Interfaces))
+
+	wg := workflow.NewWaitGroup(ctx)
+
+	for i, iface := range param.Interfaces {
+		i, iface := i, iface
+
+		wg.Add(1)
+
+		workflow.Go(ctx, func(ctx workflow.Context) {
+			defer wg.Done()
+
+			workflow.GetLogger(ctx).Info("Scanning on interface", tag.Builder().
+				KV("interface", iface).
+				KV("ips", len(ips)).KeyVals...)
+
+			results[i], errs[i] = scanIPs(ctx, ips, param, iface, tracker)
+		})
+	}
+
+	wg.Wait(ctx)
+
+	union := CheckIPActivityResult{
+		IPs:     make(map[netip.Addr]net.HardwareAddr, len(ips)),
+		Entries: make(map[netip.Addr]CheckIPEntry, len(ips)),
+	}
+	perInterface := make(map[string]map[netip.Addr]net.HardwareAddr, len(param.Interfaces))
+
+	var (
+		failed   map[string]string
+		firstErr error
+	)
+
+	// results are merged in the order of interfaces, so that the result
+	// is the same on replay
+	for i, iface := range param.Interfaces {
+		// addresses scanned before the failure are kept
+		mergeCheckIPActivityResult(&union, results[i])
+
+		// interfaces canceled by CheckIPParam.OverallDeadline did not fail
+		if temporal.IsCanceledError(errs[i]) {
+			continue
+		}
+
+		if errs[i] != nil {
+			workflow.GetLogger(ctx).Warn("Interface scan failed", tag.Builder().
+				KV("interface", iface).
+				Error(errs[i]).KeyVals...)
+
+			if failed == nil {
+				failed = make(map[string]string, len(param.Interfaces))
+			}
+
+			failed[iface] = errs[i].Error()
+
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+
+			continue
+		}
+
+		perInterface[iface] = results[i].IPs
+	}
+
+	// no interface could be scanned
+	if len(perInterface) == 0 && firstErr != nil {
+		return union, nil, nil, firstErr
+	}
+
+	return union, perInterface, failed, nil
+}
+
+// scanIPs scans ips on iface with batches of local activities, or with
+// a heartbeating activity above checkIPHeartbeatThreshold, retrying addresses
+// that did not respond up to param.MaxRetries times. tracker is updated
+// after every activity. Addresses scanned by earlier activities, and those
+// resolved by the failing one, are returned together with the error of
+// an activity.
+func scanIPs(ctx workflow.Context, ips []netip.Addr, param CheckIPParam,
+	iface string, tracker *checkIPTracker) (CheckIPActivityResult, error) {
+	lao := workflow.GetLocalActivityOptions(ctx)
+
+	hctx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: lao.StartToCloseTimeout,
+		HeartbeatTimeout:    checkIPHeartbeatTimeout,
+		RetryPolicy:         lao.RetryPolicy,
+	})
+
+	batchSize := defaultCheckIPBatchSize
+	if param.BatchSize > 0 {
+		batchSize = param.BatchSize
+	}
+
+	activityParam := CheckIPActivityParam{
+		Timeout:          param.Timeout,
+		Interface:        iface,
+		Retries:          param.Retries,
+		RateLimit:        param.RateLimit,
+		Jitter:           param.Jitter,
+		ProbeTimeout:     param.ProbeTimeout,
+		DetectConflicts:  param.DetectConflicts,
+		Passive:          param.Passive,
+		VLANID:           param.VLANID,
+		SourceIP:         param.SourceIP,
+		AllowNonEthernet: param.AllowNonEthernet,
+		BatchSize:        batchSize,
+		RecvBufferBytes:  param.RecvBufferBytes,
+		TraceProbes:      param.TraceProbes,
+	}
+
+	scanned := CheckIPActivityResult{
+		IPs:     make(map[netip.Addr]net.HardwareAddr, len(ips)),
+		Entries: make(map[netip.Addr]CheckIPEntry, len(ips)),
+	}
+
+	pending := ips
+
+	for i := 0; i <= param.MaxRetries; i++ {
+		if i > 0 {
+			pending = withoutSelf(unresolved(ips, scanned.IPs), scanned.Entries)
+			if len(pending) == 0 {
+				break
+			}
+
+			if err := workflow.Sleep(ctx, param.RetryInterval); err != nil {
+				return scanned, err
+			}
+		}
+
+		// probes claimed by hosts and neighbor tables are not streamed
+		if len(pending) > checkIPHeartbeatThreshold && !param.DetectConflicts && !param.Passive {
+			var res CheckIPActivityResult
+
+			activityParam.IPs = pending
+			activityParam.SpreadOver = param.SpreadOver
+
+			err := workflow.ExecuteActivity(hctx, CheckIPHeartbeatActivity, activityParam).Get(ctx, &res)
+			if err != nil {
+				keepPartialResult(&scanned, err, tracker)
+
+				return scanned, err
+			}
+
+			var completed int
+			if i == 0 {
+				completed = len(pending)
+			}
+
+			tracker.record(res, mergeCheckIPActivityResult(&scanned, res), completed)
+
+			continue
+		}
+
+		// Every batch is a separate local activity, which gives Temporal
+		// a checkpoint between batches of a large scan
+		for _, batch := range batches(pending, batchSize) {
+			var res CheckIPActivityResult
+
+			activityParam.IPs = batch
+			// batches are scanned one after the other within the window
+			activityParam.SpreadOver = time.Duration(int64(param.SpreadOver) * int64(len(batch)) /
+				int64(len(pending)))
+
+			err := workflow.ExecuteLocalActivity(ctx, CheckIPActivity, activityParam).Get(ctx, &res)
+			if err != nil {
+				keepPartialResult(&scanned, err, tracker)
+
+				return scanned, err
+			}
+
+			var completed int
+			if i == 0 {
+				completed = len(batch)
+			}
+
+			tracker.record(res, mergeCheckIPActivityResult(&scanned, res), completed)
+		}
+	}
+
+	return scanned, nil
+}
+
+// keepPartialResult adds entries that the activity failing with err resolved
+// to scanned, see partialScanResult
+func keepPartialResult(scanned *CheckIPActivityResult, err error, tracker *checkIPTracker) {
+	if partial, ok := partialActivityResult(err); ok {
+		tracker.record(partial, mergeCheckIPActivityResult(scanned, partial), 0)
+	}
+}
+
+// batches splits ips into consecutive batches of at most size addresses.
+// There is always at least one batch, so that an empty scan is still executed.
+func batches(ips []netip.Addr, size int) [][]netip.Addr {
+	var res [][]netip.Addr
+
+	for len(ips) > size {
+		res = append(res, ips[:size:size])
+		ips = ips[size:]
+	}
+
+	return append(res, ips)
+}
+
+// mergeCheckIPActivityResult adds the result of a batch, a retry or a scan
+// on another interface to dst. An address that responded is never replaced
+// by one that did not, as the same address can appear in more than one batch.
+// It returns the number of addresses that were not resolved in dst before.
+func mergeCheckIPActivityResult(dst *CheckIPActivityResult, src CheckIPActivityResult) int {
+	var resolved int
+
+	for ip, hwAddr := range src.IPs {
+		if len(hwAddr) > 0 && len(dst.IPs[ip]) == 0 {
+			resolved++
+		}
+
+		if len(hwAddr) > 0 || len(dst.IPs[ip]) == 0 {
+			dst.IPs[ip] = hwAddr
+		}
+	}
+
+	for ip, e := range src.Entries {
+		if e.Responded || !dst.Entries[ip].Responded {
+			dst.Entries[ip] = e
+		}
+	}
+
+	// src has no bounds when its addresses were not scanned,
+	// like those of a failed subnet
+	if !src.StartedAt.IsZero() && (dst.StartedAt.IsZero() || src.StartedAt.Before(dst.StartedAt)) {
+		dst.StartedAt = src.StartedAt
+	}
+
+	if src.FinishedAt.After(dst.FinishedAt) {
+		dst.FinishedAt = src.FinishedAt
+	}
+
+	dst.Dropped += src.Dropped
+
+	return resolved
+}
+
+// unresolved returns addresses without a hardware address in the scan result.
+// It follows the order of ips, so that the result is the same on replay.
+func unresolved(ips []netip.Addr, scanned map[netip.Addr]net.HardwareAddr) []netip.Addr {
+	var res []netip.Addr
+
+	seen := make(map[netip.Addr]struct{}, len(ips))
+
+	for _, ip := range ips {
+		if _, ok := seen[ip]; ok {
+			continue
+		}
+
+		seen[ip] = struct{}{}
+
+		if len(scanned[ip]) == 0 {
+			res = append(res, ip)
+		}
+	}
+
+	return res
+}
+
+// selfAddresses returns distinct addresses of ips whose entry is set as an
+// address of the agent host, keeping their order
+func selfAddresses(ips []netip.Addr, entries map[netip.Addr]CheckIPEntry) []netip.Addr {
+	var res []netip.Addr
+
+	seen := make(map[netip.Addr]struct{})
+
+	for _, ip := range ips {
+		if _, ok := seen[ip]; ok || !entries[ip].Self {
+			continue
+		}
+
+		seen[ip] = struct{}{}
+		res = append(res, ip)
+	}
+
+	return res
+}
+
+// withoutSelf returns ips without addresses of the agent host
+func withoutSelf(ips []netip.Addr, entries map[netip.Addr]CheckIPEntry) []netip.Addr {
+	var res []netip.Addr
+
+	for _, ip := range ips {
+		if !entries[ip].Self {
+			res = append(res, ip)
+		}
+	}
+
+	return res
+}
+
+// conflicts returns the hardware addresses of InvertResult of res that
+// got replies for more than one address
+func conflicts(res CheckIPResult) map[string][]netip.Addr {
+	var shared map[string][]netip.Addr
+
+	for hwAddr, addrs := range InvertResult(res) {
+		if len(addrs) < 2 {
+			continue
+		}
+
+		if shared == nil {
+			shared = make(map[string][]netip.Addr)
+		}
+
+		shared[hwAddr] = addrs
+	}
+
+	return shared
+}
+
+// InvertResult groups addresses of res by the hardware addresses they
+// resolved to, keyed by the lowercase colon separated form of them like
+// CheckIPResult.Conflicts. An address that got replies from several hardware
+// addresses is listed under each of them, and a hardware address with more
+// than one address reveals a conflict or a proxy ARP device. Addresses are
+// sorted, so that the result is the same on replay.
+func InvertResult(res CheckIPResult) map[string][]netip.Addr {
+	inverted := make(map[string][]netip.Addr)
+
+	add := func(ip netip.Addr, hwAddr net.HardwareAddr) {
+		key := hwAddr.String()
+		for _, a := range inverted[key] {
+			if a == ip {
+				return
+			}
+		}
+
+		inverted[key] = append(inverted[key], ip)
+	}
+
+	for ip, hwAddr := range res.IPs {
+		if len(hwAddr) > 0 {
+			add(ip, hwAddr)
+		}
+
+		for _, other := range res.Entries[ip].MACs {
+			add(ip, other)
+		}
+	}
+
+	for _, addrs := range inverted {
+		sort.Slice(addrs, func(i, j int) bool {
+			return addrs[i].Less(addrs[j])
+		})
+	}
+
+	return inverted
+}
+
+// Sorted returns entries of every address of r.IPs and r.Entries sorted by
+// address, IPv4 addresses first, so that they can be listed in the same
+// order every time. Addresses of r.IPs without an entry get one made of
+// their hardware address.
+func (r CheckIPResult) Sorted() []CheckIPAddrEntry {
+	res := make([]CheckIPAddrEntry, 0, len(r.Entries))
+
+	for ip, e := range r.Entries {
+		res = append(res, CheckIPAddrEntry{IP: ip, Entry: e})
+	}
+
+	for ip, hwAddr := range r.IPs {
+		if _, ok := r.Entries[ip]; !ok {
+			res = append(res, CheckIPAddrEntry{
+				IP:    ip,
+				Entry: CheckIPEntry{MAC: hwAddr, Responded: len(hwAddr) > 0},
+			})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].IP.Less(res[j].IP)
+	})
+
+	return res
+}
+
+// knownChanges returns hardware addresses of ips that differ from those of
+// known, and addresses of known that did not respond without being alive.
+// Addresses that could not be probed or that are assigned to the host are
+// left out, as nothing is known about them.
+func knownChanges(ips []netip.Addr, entries map[netip.Addr]CheckIPEntry,
+	known map[netip.Addr]net.HardwareAddr, alive []netip.Addr) (map[netip.Addr]net.HardwareAddr, []netip.Addr) {
+	if len(known) == 0 {
+		return nil, nil
+	}
+
+	var (
+		changed     map[netip.Addr]net.HardwareAddr
+		disappeared []netip.Addr
+	)
+
+	lookup := make(map[netip.Addr]net.HardwareAddr, len(known))
+	for ip, hwAddr := range known {
+		lookup[ip.Unmap()] = hwAddr
+	}
+
+	isAlive := make(map[netip.Addr]struct{}, len(alive))
+	for _, ip := range alive {
+		isAlive[ip] = struct{}{}
+	}
+
+	seen := make(map[netip.Addr]struct{}, len(ips))
+
+	for _, ip := range ips {
+		if _, ok := seen[ip]; ok {
+			continue
+		}
+
+		seen[ip] = struct{}{}
+
+		hwAddr, ok := lookup[ip]
+		if !ok {
+			continue
+		}
+
+		e, ok := entries[ip]
+
+		switch {
+		case !ok || e.Error != "" || e.Self:
+		case e.Responded:
+			if !bytes.Equal(e.MAC, hwAddr) {
+				if changed == nil {
+					changed = make(map[netip.Addr]net.HardwareAddr)
+				}
+
+				changed[ip] = e.MAC
+			}
+		default:
+			if _, ok := isAlive[ip]; !ok {
+				disappeared = append(disappeared, ip)
+			}
+		}
+	}
+
+	return changed, disappeared
+}
+
+// rtts returns round-trip times of entries that responded
+func rtts(entries map[netip.Addr]CheckIPEntry) map[netip.Addr]time.Duration {
+	res := make(map[netip.Addr]time.Duration)
+
+	for ip, e := range entries {
+		if e.Responded {
+			res[ip] = e.Latency
+		}
+	}
+
+	return res
+}
+
+// countUnique returns the number of distinct addresses in ips
+func countUnique(ips []netip.Addr) int {
+	seen := make(map[netip.Addr]struct{}, len(ips))
+
+	for _, ip := range ips {
+		seen[ip] = struct{}{}
+	}
+
+	return len(seen)
+}
+
+// CheckIPActivityParam is the activity parameter for CheckIPActivity
+type CheckIPActivityParam struct {
+	IPs       []netip.Addr  `json:"ips"`
+	Timeout   time.Duration `json:"timeout"`
+	Interface string        `json:"interface"`
+	// Retries is the number of probes sent to every address, see CheckIPParam
+	Retries int `json:"retries"`
+	// RateLimit is the maximum number of probes sent per second,
+	// see CheckIPParam
+	RateLimit int `json:"rate_limit"`
+	// Jitter is the maximum delay of the first probe, see CheckIPParam
+	Jitter time.Duration `json:"jitter"`
+	// ProbeTimeout is how long replies are awaited after the last probe,
+	// see CheckIPParam
+	ProbeTimeout time.Duration `json:"probe_timeout,omitempty"`
+	// SpreadOver is the window that first probes are spread over,
+	// see CheckIPParam
+	SpreadOver time.Duration `json:"spread_over,omitempty"`
+	// DetectConflicts probes addresses with netmon.Probe, see CheckIPParam
+	DetectConflicts bool `json:"detect_conflicts"`
+	// Passive reads the neighbor table instead of scanning, see CheckIPParam
+	Passive bool `json:"passive,omitempty"`
+	// VLANID tags probes with the VLAN ID, see CheckIPParam
+	VLANID uint16 `json:"vlan_id,omitempty"`
+	// SourceIP is the sender address of ARP requests, see CheckIPParam
+	SourceIP netip.Addr `json:"source_ip,omitempty"`
+	// AllowNonEthernet keeps replies of any hardware address, see CheckIPParam
+	AllowNonEthernet bool `json:"allow_non_ethernet,omitempty"`
+	// BatchSize is the number of addresses scanned at once, all of them
+	// are scanned at once when zero, see CheckIPParam
+	BatchSize int `json:"batch_size,omitempty"`
+	// RecvBufferBytes sets the receive buffer of the capture, see CheckIPParam
+	RecvBufferBytes int `json:"recv_buffer_bytes,omitempty"`
+	// TraceProbes logs scans to the activity logger, see CheckIPParam
+	TraceProbes bool `json:"trace_probes,omitempty"`
+}
+
+// CheckIPActivityResult is a value returned by CheckIPActivity
+type CheckIPActivityResult struct {
+	IPs     map[netip.Addr]net.HardwareAddr `json:"ips"`
+	Entries map[netip.Addr]CheckIPEntry     `json:"entries"`
+	// StartedAt and FinishedAt are measured by the activity, because time
+	// spent scheduling the local activity should not count as scan time
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Dropped is the number of replies dropped by the kernel during the
+	// scan, see CheckIPResult
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// CheckIPScanner scans addresses for CheckIP activities, it is implemented
+// by netmon.Scanner, and by netmon.FakeScanner for tests
+type CheckIPScanner interface {
+	ScanDetailed(ctx context.Context, ips []netip.Addr, opts ...netmon.Option) (netmon.ScanEntries, error)
+	ScanStream(ctx context.Context, ips []netip.Addr, out chan<- netmon.ScanResult, opts ...netmon.Option) error
+	Ping(ctx context.Context, ips []netip.Addr, opts ...netmon.Option) (map[netip.Addr]time.Duration, error)
+	Probe(ctx context.Context, ips []netip.Addr, opts ...netmon.Option) (netmon.ProbeEntries, error)
+	Neighbors(ctx context.Context, opts ...netmon.Option) (map[netip.Addr]net.HardwareAddr, error)
+}
+
+var (
+	checkIPScannerMu sync.RWMutex
+	checkIPScanner   CheckIPScanner = netmon.NewScanner()
+)
+
+// SetCheckIPScanner sets the scanner of CheckIP activities of this process,
+// for example a netmon.FakeScanner in tests of workflows running CheckIP.
+// It is meant to be called before the worker starts, a nil scanner restores
+// the default netmon.Scanner.
+func SetCheckIPScanner(s CheckIPScanner) {
+	checkIPScannerMu.Lock()
+	defer checkIPScannerMu.Unlock()
+
+	if s == nil {
+		s = netmon.NewScanner()
+	}
+
+	checkIPScanner = s
+}
+
+func getCheckIPScanner() CheckIPScanner {
+	checkIPScannerMu.RLock()
+	defer checkIPScannerMu.RUnlock()
+
+	return checkIPScanner
+}
+
+// CheckIPActivity scans provided IP addresses with netmon.ScanDetailed, which waits
+// for replies until the Timeout elapses (netmon.OperationTimeout when zero).
+// The scan deadline is always set explicitly, because otherwise Scan would wait
+// until the activity deadline and the activity would time out.
+func CheckIPActivity(ctx context.Context,
+	param CheckIPActivityParam) (CheckIPActivityResult, error) {
+	return checkIPActivity(ctx, getCheckIPScanner(), getCheckIPResultSink(), param)
+}
+
+// checkIPActivity implements CheckIPActivity with s, recording entries
+// to sink unless it is nil
+func checkIPActivity(ctx context.Context, s CheckIPScanner, sink CheckIPResultSink,
+	param CheckIPActivityParam) (CheckIPActivityResult, error) {
+	timeout := checkIPScanTimeout(param.Timeout, param.ProbeTimeout)
+
+	startedAt := time.Now()
+	metrics := getCheckIPMetrics()
+
+	var (
+		entries map[netip.Addr]CheckIPEntry
+		dropped uint64
+	)
+
+	switch {
+	case param.Passive:
+		neigh, err := s.Neighbors(ctx, netmon.WithInterface(param.Interface))
+		if err != nil {
+			return CheckIPActivityResult{}, scanError(err)
+		}
+
+		entries = neighborEntries(param.IPs, neigh, param.Interface)
+	case param.DetectConflicts:
+		probed, err := s.Probe(ctx, param.IPs, scanOptions(param, timeout)...)
+		if err != nil {
+			return CheckIPActivityResult{}, scanError(err)
+		}
+
+		entries = probeEntries(probed)
+	default:
+		opts := append(scanOptions(param, timeout), scanLogger(ctx, param)...)
+		opts = append(opts, netmon.WithDropCount(&dropped))
+
+		scanned, err := s.ScanDetailed(ctx, param.IPs, opts...)
+		if err != nil {
+			return CheckIPActivityResult{}, scanError(err, partialScanResult(checkIPEntries(scanned), startedAt)...)
+		}
+
+		entries = checkIPEntries(scanned)
+	}
+
+	metrics.observe(param.IPs, entries)
+
+	result := CheckIPActivityResult{
+		IPs:        make(map[netip.Addr]net.HardwareAddr, len(entries)),
+		Entries:    entries,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+		Dropped:    int(dropped),
+	}
+
+	for ip, e := range entries {
+		result.IPs[ip] = e.MAC
+	}
+
+	if err := recordCheckIPEntries(ctx, sink, param.IPs, result.Entries); err != nil {
+		return CheckIPActivityResult{}, err
+	}
+
+	// entries of a VLAN would be taken for untagged ones, and entries of
+	// the neighbor table were not probed by this worker
+	if param.VLANID == 0 && !param.Passive {
+		cacheCheckIPEntries(result.Entries)
+	}
+
+	return result, nil
+}
+
+// neighborEntries returns entries of ips from neigh, the neighbor table of
+// iface, where addresses that the table doesn't have are unresolved
+func neighborEntries(ips []netip.Addr, neigh map[netip.Addr]net.HardwareAddr,
+	iface string) map[netip.Addr]CheckIPEntry {
+	res := make(map[netip.Addr]CheckIPEntry, len(ips))
+	now := time.Now()
+
+	for _, ip := range ips {
+		hwAddr, ok := neigh[ip]
+		if !ok {
+			res[ip] = CheckIPEntry{}
+			continue
+		}
+
+		res[ip] = CheckIPEntry{
+			MAC:       hwAddr,
+			MACs:      []net.HardwareAddr{hwAddr},
+			Responded: true,
+			Interface: iface,
+			SeenAt:    now,
+		}
+	}
+
+	return res
+}
+
+// pingUnresolved is a local activity pinging addresses that the scan did not
+// resolve, it returns the ones that replied in the order of param.IPs.
+// The interface is chosen by the routing table unless it is set in param.
+func pingUnresolved(ctx context.Context, param CheckIPActivityParam) ([]netip.Addr, error) {
+	return pingAddrs(ctx, getCheckIPScanner(), param)
+}
+
+// pingAddrs implements pingUnresolved with s
+func pingAddrs(ctx context.Context, s CheckIPScanner, param CheckIPActivityParam) ([]netip.Addr, error) {
+	timeout := checkIPScanTimeout(param.Timeout, param.ProbeTimeout)
+
+	replied, err := s.Ping(ctx, param.IPs, scanOptions(param, timeout)...)
+	if err != nil {
+		return nil, scanError(err)
+	}
+
+	var alive []netip.Addr
+
+	for _, ip := range param.IPs {
+		if _, ok := replied[ip]; ok {
+			alive = append(alive, ip)
+		}
+	}
+
+	return alive, nil
+}
+
+// checkIPHeartbeat is the heartbeat detail of CheckIPHeartbeatActivity,
+// which allows a retried activity to resume the scan
+type checkIPHeartbeat struct {
+	// Resolved is the number of addresses resolved so far
+	Resolved int `json:"resolved"`
+	// Entries are entries of resolved addresses
+	Entries   map[netip.Addr]CheckIPEntry `json:"entries"`
+	StartedAt time.Time                   `json:"started_at"`
+}
+
+// CheckIPHeartbeatActivity scans provided IP addresses like CheckIPActivity,
+// but it is a regular activity that records the addresses resolved so far
+// as a heartbeat. When the activity is retried, addresses resolved by previous
+// attempts are not scanned again. Once the context is done, for example
+// because the activity was cancelled after a missed heartbeat, the partial
+// result is returned. Addresses that did not respond get the same entries as
+// with CheckIPActivity, telling why, once the scan is over.
+func CheckIPHeartbeatActivity(ctx context.Context,
+	param CheckIPActivityParam) (CheckIPActivityResult, error) {
+	return checkIPHeartbeatActivity(ctx, getCheckIPScanner(), getCheckIPResultSink(), param)
+}
+
+// checkIPHeartbeatActivity implements CheckIPHeartbeatActivity with s,
+// recording entries to sink as they are found unless it is nil.
+// Entries of previous attempts were recorded by them.
+func checkIPHeartbeatActivity(ctx context.Context, s CheckIPScanner, sink CheckIPResultSink,
+	param CheckIPActivityParam) (CheckIPActivityResult, error) {
+	timeout := checkIPScanTimeout(param.Timeout, param.ProbeTimeout)
+
+	hb := checkIPHeartbeat{
+		Entries: make(map[netip.Addr]CheckIPEntry),
+	}
+
+	if activity.HasHeartbeatDetails(ctx) {
+		var prev checkIPHeartbeat
+		// a detail that can't be decoded only means starting over
+		if err := activity.GetHeartbeatDetails(ctx, &prev); err == nil && prev.Entries != nil {
+			hb = prev
+		}
+	}
+
+	if hb.StartedAt.IsZero() {
+		hb.StartedAt = time.Now()
+	}
+
+	result := CheckIPActivityResult{
+		IPs:       make(map[netip.Addr]net.HardwareAddr, len(param.IPs)),
+		Entries:   make(map[netip.Addr]CheckIPEntry, len(param.IPs)),
+		StartedAt: hb.StartedAt,
+	}
+
+	pending := make([]netip.Addr, 0, len(param.IPs))
+
+	for _, ip := range param.IPs {
+		if e, ok := hb.Entries[ip]; ok {
+			result.IPs[ip] = e.MAC
+			result.Entries[ip] = e
+
+			continue
+		}
+
+		result.IPs[ip] = nil
+		result.Entries[ip] = CheckIPEntry{}
+		pending = append(pending, ip)
+	}
+
+	// only addresses scanned by this attempt are observed
+	metrics := getCheckIPMetrics()
+
+	// the scan is stopped when the sink fails
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	out := make(chan netmon.ScanResult)
+	errCh := make(chan error, 1)
+	opts := append(scanOptions(param, timeout), scanLogger(ctx, param)...)
+
+	// drops of earlier attempts are not known, their replies were recorded
+	var (
+		dropped uint64
+		scanned netmon.ScanEntries
+	)
+
+	opts = append(opts, netmon.WithDropCount(&dropped), netmon.WithEntries(&scanned))
+
+	go func() {
+		errCh <- s.ScanStream(scanCtx, pending, out, opts...)
+	}()
+
+	var sinkErr error
+
+	ticker := time.NewTicker(checkIPHeartbeatInterval)
+	defer ticker.Stop()
+
+	for done := false; !done; {
+		select {
+		case res, ok := <-out:
+			if !ok {
+				done = true
+				break
+			}
+
+			// results sent before the scan stops are dropped
+			if sinkErr != nil {
+				continue
+			}
+
+			entry := CheckIPEntry{
+				MAC:            res.MAC,
+				MACs:           []net.HardwareAddr{res.MAC},
+				Responded:      true,
+				Latency:        res.Latency,
+				Interface:      res.Interface,
+				InterfaceIndex: res.InterfaceIndex,
+				SourceMAC:      res.SourceMAC,
+				SourceIP:       res.SourceIP,
+				Attempts:       res.Attempts,
+				SeenAt:         res.SeenAt,
+			}
+
+			// another host answering for an address that already replied
+			if prev := result.Entries[res.IP]; prev.Responded {
+				entry = prev
+				entry.MACs = append(entry.MACs, res.MAC)
+			}
+
+			// entries are only kept once recorded, so that a retried
+			// activity records the others
+			if sink != nil {
+				if err := recordCheckIPEntry(ctx, sink, res.IP, entry); err != nil {
+					sinkErr = err
+					cancel()
+
+					continue
+				}
+			}
+
+			result.IPs[res.IP] = entry.MAC
+			result.Entries[res.IP] = entry
+			hb.Entries[res.IP] = entry
+			hb.Resolved = len(hb.Entries)
+
+			activity.RecordHeartbeat(ctx, hb)
+		case <-ticker.C:
+			activity.RecordHeartbeat(ctx, hb)
+		}
+	}
+
+	if err := <-errCh; sinkErr == nil && err != nil {
+		return CheckIPActivityResult{}, scanError(err, partialScanResult(result.Entries, result.StartedAt)...)
+	}
+
+	mergeUnstreamedEntries(&result, scanned)
+
+	metrics.observe(pending, result.Entries)
+
+	if sinkErr != nil {
+		return CheckIPActivityResult{}, sinkErr
+	}
+
+	result.FinishedAt = time.Now()
+	result.Dropped = int(dropped)
+
+	// entries of a VLAN would be taken for untagged ones
+	if param.VLANID == 0 {
+		cacheCheckIPEntries(result.Entries)
+	}
+
+	return result, nil
+}
+
+// scanOptions returns options of the scan done by activities
+func scanOptions(param CheckIPActivityParam, timeout time.Duration) []netmon.Option {
+	opts := []netmon.Option{netmon.WithTimeout(timeout), netmon.WithInterface(param.Interface)}
+
+	// netmon counts probes sent again after the first one
+	if param.Retries > 1 {
+		opts = append(opts, netmon.WithRetries(param.Retries-1))
+	}
+
+	if param.RateLimit > 0 {
+		opts = append(opts, netmon.WithRate(param.RateLimit))
+	}
+
+	if param.Jitter > 0 {
+		opts = append(opts, netmon.WithJitter(param.Jitter))
+	}
+
+	if param.SpreadOver > 0 {
+		opts = append(opts, netmon.WithSpreadOver(param.SpreadOver))
+	}
+
+	if param.ProbeTimeout > 0 {
+		opts = append(opts, netmon.WithReplyWait(param.ProbeTimeout))
+	}
+
+	if param.VLANID != 0 {
+		opts = append(opts, netmon.WithVLAN(param.VLANID))
+	}
+
+	if param.SourceIP.IsValid() {
+		opts = append(opts, netmon.WithSourceIP(param.SourceIP))
+	}
+
+	if param.AllowNonEthernet {
+		opts = append(opts, netmon.WithAllowNonEthernet())
+	}
+
+	if param.BatchSize > 0 {
+		opts = append(opts, netmon.WithBatchSize(param.BatchSize))
+	}
+
+	if param.RecvBufferBytes > 0 {
+		opts = append(opts, netmon.WithRecvBufferBytes(param.RecvBufferBytes))
+	}
+
+	return opts
+}
+
+// scanLogger returns the option logging scans to the logger of the activity
+// of ctx if param.TraceProbes is set, or only their warnings if
+// param.SourceIP is set. The logger of an activity can't be retrieved
+// outside of activities.
+func scanLogger(ctx context.Context, param CheckIPActivityParam) []netmon.Option {
+	level := slog.LevelDebug
+
+	if !param.TraceProbes {
+		// scans from a source address warn of targets outside of its subnet
+		if !param.SourceIP.IsValid() {
+			return nil
+		}
+
+		level = slog.LevelWarn
+	}
+
+	h := wflog.NewSlogHandler(activity.GetLogger(ctx), level)
+
+	return []netmon.Option{netmon.WithLogger(slog.New(h))}
+}
+
+// scanErrorTypes are application error types of scan errors, in the order
+// they are matched
+var scanErrorTypes = []struct {
+	err     error
+	errType string
+}{
+	{err: netmon.ErrPermissionDenied, errType: "scanNotPermitted"},
+	{err: netmon.ErrNoRawSocket, errType: "scanNoRawSocket"},
+	{err: netmon.ErrMissingZone, errType: "scanMissingZone"},
+	{err: netmon.ErrInterfaceNotFound, errType: "scanInterfaceNotFound"},
+	{err: netmon.ErrInterfaceDown, errType: "scanInterfaceDown"},
+	{err: netmon.ErrNoInterfaceAddr, errType: "scanNoInterfaceAddr"},
+	{err: netmon.ErrNoProbesSent, errType: "scanNoProbesSent"},
+	{err: netmon.ErrInvalidVLAN, errType: "scanInvalidVLAN"},
+	{err: netmon.ErrVLANUnsupported, errType: "scanVLANUnsupported"},
+	{err: netmon.ErrInvalidSourceIP, errType: "scanInvalidSourceIP"},
+}
+
+// scanError converts scan errors to application errors, so that callers
+// can tell them apart by their type. Permanent scan errors are made
+// non retryable, as retrying the scan won't fix them. details are attached
+// to the application error, see partialScanResult.
+func scanError(err error, details ...interface{}) error {
+	errType := ""
+
+	for _, t := range scanErrorTypes {
+		if errors.Is(err, t.err) {
+			errType = t.errType
+			break
+		}
+	}
+
+	if !netmon.IsRetryable(err) {
+		if errType == "" {
+			errType = "permanentScanError"
+		}
+
+		return temporal.NewNonRetryableApplicationError("Failed to scan", errType, err, details...)
+	}
+
+	// details can only be carried by an application error
+	if errType == "" && len(details) > 0 {
+		errType = "scanError"
+	}
+
+	if errType != "" {
+		return temporal.NewApplicationErrorWithCause("Failed to scan", errType, err, details...)
+	}
+
+	return err
+}
+
+// partialScanResult returns the details of a scan error carrying entries
+// resolved before the scan failed, or none if nothing was resolved, so that
+// CheckIP can keep them (see partialActivityResult). Results of activities
+// are dropped by Temporal when they return an error.
+func partialScanResult(entries map[netip.Addr]CheckIPEntry, startedAt time.Time) []interface{} {
+	var res CheckIPActivityResult
+
+	for ip, e := range entries {
+		if !e.Responded {
+			continue
+		}
+
+		if res.IPs == nil {
+			res = CheckIPActivityResult{
+				IPs:        make(map[netip.Addr]net.HardwareAddr),
+				Entries:    make(map[netip.Addr]CheckIPEntry),
+				StartedAt:  startedAt,
+				FinishedAt: time.Now(),
+			}
+		}
+
+		res.IPs[ip] = e.MAC
+		res.Entries[ip] = e
+	}
+
+	if res.IPs == nil {
+		return nil
+	}
+
+	return []interface{}{res}
+}
+
+// partialActivityResult returns the entries that a CheckIP activity resolved
+// before failing with err, see partialScanResult
+func partialActivityResult(err error) (CheckIPActivityResult, bool) {
+	var (
+		appErr *temporal.ApplicationError
+		res    CheckIPActivityResult
+	)
+
+	if !errors.As(err, &appErr) || !appErr.HasDetails() {
+		return res, false
+	}
+
+	if appErr.Details(&res) != nil {
+		return CheckIPActivityResult{}, false
+	}
+
+	return res, len(res.IPs) > 0
+}
+
+// mergeUnstreamedEntries sets the entries of res of addresses that did not
+// respond, which are not streamed, to their entries of the scan, which tell
+// why, like those of CheckIPActivity
+func mergeUnstreamedEntries(res *CheckIPActivityResult, entries netmon.ScanEntries) {
+	for ip, e := range checkIPEntries(entries) {
+		if !res.Entries[ip].Responded {
+			res.IPs[ip] = e.MAC
+			res.Entries[ip] = e
+		}
+	}
+}
+
+// checkIPEntries converts scan entries to a serializable form
+func checkIPEntries(entries netmon.ScanEntries) map[netip.Addr]CheckIPEntry {
+	res := make(map[netip.Addr]CheckIPEntry, len(entries))
+
+	for ip, e := range entries {
+		entry := CheckIPEntry{
+			MAC:            e.MAC,
+			MACs:           e.MACs,
+			Responded:      e.Responded,
+			Latency:        e.Latency,
+			Interface:      e.Interface,
+			InterfaceIndex: e.InterfaceIndex,
+			SourceMAC:      e.SourceMAC,
+			SourceIP:       e.SourceIP,
+			Attempts:       e.Attempts,
+			SeenAt:         e.SeenAt,
+			Self:           e.Self,
+		}
+		if e.Err != nil {
+			entry.Error = e.Err.Error()
+		}
+
+		if e.Warning != nil {
+			entry.Warning = e.Warning.Error()
+		}
+
+		res[ip] = entry
+	}
+
+	return res
+}
+
+// probeEntries converts outcomes of netmon.Probe, an address claimed
+// by a host is responded with the hardware address of the first one
+func probeEntries(entries netmon.ProbeEntries) map[netip.Addr]CheckIPEntry {
+	res := make(map[netip.Addr]CheckIPEntry, len(entries))
+
+	for ip, e := range entries {
+		entry := CheckIPEntry{
+			MACs:           e.MACs,
+			Responded:      e.InUse,
+			Interface:      e.Interface,
+			InterfaceIndex: e.InterfaceIndex,
+			SourceMAC:      e.SourceMAC,
+			SourceIP:       netip.IPv4Unspecified(),
+			Attempts:       e.Attempts,
+			SeenAt:         e.SeenAt,
+		}
+		if len(e.MACs) > 0 {
+			entry.MAC = e.MACs[0]
+		}
+
+		if e.Err != nil {
+			entry.Error = e.Err.Error()
+			entry.SourceIP = netip.Addr{}
+		}
+
+		res[ip] = entry
+	}
+
+	return res
+}
+
+// resolveVendors is a local activity returning the OUI organization of every
+// resolved hardware address. It runs as an activity, so that the bundled
+// registry is not loaded by the workflow and can be updated without
+// breaking replay.
+func resolveVendors(_ context.Context,
+	scanned map[netip.Addr]net.HardwareAddr) (map[netip.Addr]string, error) {
+	res := make(map[netip.Addr]string, len(scanned))
+
+	for ip, hwAddr := range scanned {
+		if len(hwAddr) == 0 {
+			continue
+		}
+
+		res[ip] = oui.Lookup(hwAddr)
+	}
+
+	return res, nil
+}
+
+// resolveHostnames is a local activity returning the PTR hostname of every
+// resolved address, without the trailing dot. A failed lookup results
+// in an empty hostname, so that one slow or broken DNS server does not
+// discard the result of the scan.
+func resolveHostnames(ctx context.Context,
+	scanned map[netip.Addr]net.HardwareAddr) (map[netip.Addr]string, error) {
+	return lookupHostnames(ctx, scanned, net.DefaultResolver.LookupAddr), nil
+}
+
+// lookupHostnames implements resolveHostnames with lookup
+func lookupHostnames(ctx context.Context, scanned map[netip.Addr]net.HardwareAddr,
+	lookup func(context.Context, string) ([]string, error)) map[netip.Addr]string {
+	res := make(map[netip.Addr]string, len(scanned))
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, checkIPHostnameConcurrency)
+	)
+
+	for ip, hwAddr := range scanned {
+		if len(hwAddr) == 0 {
+			continue
+		}
+
+		ip := ip
+
+		wg.Add(1)
+
+		sem <- struct{}{}
+
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			lctx, cancel := context.WithTimeout(ctx, checkIPHostnameTimeout)
+			defer cancel()
+
+			var hostname string
+
+			names, err := lookup(lctx, ip.String())
+			if err == nil && len(names) > 0 {
+				hostname = strings.TrimSuffix(names[0], ".")
+			}
+
+			mu.Lock()
+			res[ip] = hostname
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	return res
+}
+
+// lookupHosts is a local activity resolving names to their addresses,
+// it runs as an activity because DNS answers change between replays.
+// A name that fails to resolve maps to no addresses.
+func lookupHosts(ctx context.Context, names []string) (map[string][]netip.Addr, error) {
+	return lookupNames(ctx, names, net.DefaultResolver.LookupNetIP), nil
+}
+
+// lookupNames implements lookupHosts with lookup
+func lookupNames(ctx context.Context, names []string,
+	lookup func(context.Context, string, string) ([]netip.Addr, error)) map[string][]netip.Addr {
+	res := make(map[string][]netip.Addr, len(names))
+	// res is written by lookups, so names are deduplicated apart
+	seen := make(map[string]struct{}, len(names))
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, checkIPHostnameConcurrency)
+	)
+
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+
+		seen[name] = struct{}{}
+		name := name
+
+		wg.Add(1)
+
+		sem <- struct{}{}
+
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			lctx, cancel := context.WithTimeout(ctx, checkIPHostnameTimeout)
+			defer cancel()
+
+			addrs, err := lookup(lctx, "ip", name)
+			if err != nil {
+				addrs = nil
+			}
+
+			for i, addr := range addrs {
+				addrs[i] = addr.Unmap()
+			}
+
+			mu.Lock()
+			res[name] = addrs
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	return res
+}
+
+// resolveHostnamesTimeout returns the timeout of resolveHostnames
+// and lookupHosts for n lookups, long enough for all of them to time out
+func resolveHostnamesTimeout(n int) time.Duration {
+	waves := (n + checkIPHostnameConcurrency - 1) / checkIPHostnameConcurrency
+	if waves < 1 {
+		waves = 1
+	}
+
+	return time.Duration(waves)*checkIPHostnameTimeout + checkIPActivityMargin
+}
+
+// validateCheckIPParam checks the parameter in the workflow body, so that
+// invalid input fails fast instead of being retried as a local activity
+func validateCheckIPParam(param CheckIPParam) error {
+	if param.Timeout < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidTimeout, param.Timeout)
+	}
+
+	if param.ProbeTimeout < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidTimeout, param.ProbeTimeout)
+	}
+
+	if param.MaxRetries < 0 || param.RetryInterval < 0 {
+		return fmt.Errorf("%w: %d, %s", ErrInvalidRetry, param.MaxRetries, param.RetryInterval)
+	}
+
+	if param.Retries < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidRetry, param.Retries)
+	}
+
+	if param.ActivityMaxAttempts < 0 || param.ActivityInitialInterval < 0 {
+		return fmt.Errorf("%w: %d, %s", ErrInvalidRetry,
+			param.ActivityMaxAttempts, param.ActivityInitialInterval)
+	}
+
+	if param.SignalGracePeriod < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidGracePeriod, param.SignalGracePeriod)
+	}
+
+	if param.MaxCacheAge < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidCacheAge, param.MaxCacheAge)
+	}
+
+	if param.ParallelSubnets < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidParallelSubnets, param.ParallelSubnets)
+	}
+
+	if param.SubnetSize < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidSubnetSize, param.SubnetSize)
+	}
+
+	if param.RateLimit < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidRateLimit, param.RateLimit)
+	}
+
+	if param.Jitter < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidJitter, param.Jitter)
+	}
+
+	if param.SpreadOver < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidSpreadOver, param.SpreadOver)
+	}
+
+	if param.BatchSize < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidBatchSize, param.BatchSize)
+	}
+
+	if param.RecvBufferBytes < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidRecvBufferBytes, param.RecvBufferBytes)
+	}
+
+	if param.OverallDeadline < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidOverallDeadline, param.OverallDeadline)
+	}
+
+	if param.Passive && param.PingFallback {
+		return fmt.Errorf("%w: can't be combined with ping fallback", ErrInvalidPassive)
+	}
+
+	if param.Passive && param.DetectConflicts {
+		return fmt.Errorf("%w: can't be combined with conflict detection", ErrInvalidPassive)
+	}
+
+	// invalid addresses are counted rather than listed, as the order of
+	// a map is not deterministic
+	if n := invalidKnown(param.Known); n > 0 {
+		return fmt.Errorf("%w: %d addresses", ErrInvalidKnown, n)
+	}
+
+	if _, err := parseMACPrefixes(param.MACPrefixes); err != nil {
+		return err
+	}
+
+	if param.Interface != "" && len(param.Interfaces) > 0 {
+		return fmt.Errorf("%w: %s, %v", ErrInvalidInterfaces, param.Interface, param.Interfaces)
+	}
+
+	if param.MaxIPs < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidMaxIPs, param.MaxIPs)
+	}
+
+	if param.VLANID > netmon.MaxVLAN {
+		return fmt.Errorf("%w: %d is above %d", ErrInvalidVLAN, param.VLANID, netmon.MaxVLAN)
+	}
+
+	if param.VLANID != 0 && param.Interface == "" && len(param.Interfaces) == 0 {
+		return fmt.Errorf("%w: %d requires an interface", ErrInvalidVLAN, param.VLANID)
+	}
+
+	if param.VLANID != 0 && param.PingFallback {
+		return fmt.Errorf("%w: %d can't be combined with ping fallback", ErrInvalidVLAN, param.VLANID)
+	}
+
+	if err := validateSourceIP(param); err != nil {
+		return err
+	}
+
+	if !param.AddressPolicy.valid() {
+		return fmt.Errorf("%w: %d", ErrInvalidAddressPolicy, param.AddressPolicy)
+	}
+
+	maxIPs := checkIPMaxIPs(param)
+
+	for _, ip := range param.IPs {
+		if !ip.IsValid() || ip.Unmap().IsUnspecified() {
+			return fmt.Errorf("%w: %s", ErrInvalidIP, ip)
+		}
+	}
+
+	for _, ip := range param.Exclude {
+		if !ip.IsValid() {
+			return fmt.Errorf("%w: %s", ErrInvalidIP, ip)
+		}
+	}
+
+	for _, p := range param.ExcludePrefixes {
+		if !p.IsValid() {
+			return fmt.Errorf("%w: %s", ErrInvalidPrefix, p)
+		}
+	}
+
+	n := len(param.IPs) + len(param.Hostnames)
+
+	for _, name := range param.Hostnames {
+		if name == "" {
+			return fmt.Errorf("%w: %q", ErrInvalidHostname, name)
+		}
+	}
+
+	for _, p := range param.Prefixes {
+		if !p.IsValid() {
+			return fmt.Errorf("%w: %s", ErrInvalidPrefix, p)
+		}
+
+		if p.Addr().BitLen()-p.Bits() > maxExpandedHostBits {
+			return fmt.Errorf("%w: %s", ErrPrefixTooLarge, p)
+		}
+
+		n += prefixHostCount(p)
+	}
+
+	for _, r := range param.Ranges {
+		size, err := validateRange(r)
+		if err != nil {
+			return err
+		}
+
+		n += size
+	}
+
+	if n > maxIPs {
+		return fmt.Errorf("%w: %d exceeds %d", ErrTooManyIPs, n, maxIPs)
+	}
+
+	return nil
+}
+
+// validateSourceIP returns an error if param.SourceIP is set and can't be used
+func validateSourceIP(param CheckIPParam) error {
+	if !param.SourceIP.IsValid() {
+		return nil
+	}
+
+	addr := param.SourceIP.Unmap()
+	if !addr.Is4() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsMulticast() {
+		return fmt.Errorf("%w: %s", ErrInvalidSourceIP, param.SourceIP)
+	}
+
+	// Interfaces can't be set together with Interface
+	switch {
+	case param.Interface == "":
+		return fmt.Errorf("%w: %s requires an interface", ErrInvalidSourceIP, param.SourceIP)
+	case param.Passive:
+		return fmt.Errorf("%w: %s can't be combined with a passive check", ErrInvalidSourceIP, param.SourceIP)
+	case param.DetectConflicts:
+		return fmt.Errorf("%w: %s can't be combined with conflict detection", ErrInvalidSourceIP, param.SourceIP)
+	}
+
+	return nil
+}
+
+// invalidKnown returns the number of invalid addresses of known and of
+// addresses without a hardware address
+func invalidKnown(known map[netip.Addr]net.HardwareAddr) int {
+	var n int
+
+	for ip, hwAddr := range known {
+		if !ip.IsValid() || len(hwAddr) == 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// checkIPMaxIPs returns the limit of addresses to scan
+func checkIPMaxIPs(param CheckIPParam) int {
+	if param.MaxIPs > 0 {
+		return param.MaxIPs
+	}
+
+	return defaultCheckIPMaxIPs
+}
+
+// validateRange returns the number of addresses in the range, or an error
+// if the range is invalid or exceeds the expansion limit
+func validateRange(r IPRange) (int, error) {
+	if !r.Start.IsValid() || !r.End.IsValid() ||
+		r.Start.BitLen() != r.End.BitLen() || r.End.Less(r.Start) {
+		return 0, fmt.Errorf("%w: %s-%s", ErrInvalidRange, r.Start, r.End)
+	}
+
+	n := 1
+
+	for a := r.Start; a != r.End; a = a.Next() {
+		n++
+		if n > maxExpandedAddrs {
+			return 0, fmt.Errorf("%w: %s-%s", ErrRangeTooLarge, r.Start, r.End)
+		}
+	}
+
+	return n, nil
+}
+
+// prefixHostCount returns the number of addresses returned by prefixHosts
+// without expanding the prefix
+func prefixHostCount(p netip.Prefix) int {
+	n := 1 << (p.Addr().BitLen() - p.Bits())
+
+	if p.Addr().Is4() && p.Bits() < 31 {
+		n -= 2
+	}
+
+	return n
+}
+
+// expandCheckIPParam merges explicit IPs with the addresses of all prefixes and
+// ranges, normalized with normalizeIPs, so that all of them are scanned in
+// ascending order
+func expandCheckIPParam(_ context.Context, param CheckIPParam) ([]netip.Addr, error) {
+	ips := append([]netip.Addr{}, param.IPs...)
+
+	for _, p := range param.Prefixes {
+		hosts, err := prefixHosts(p)
+		if err != nil {
+			return nil, err
+		}
+
+		ips = append(ips, hosts...)
+	}
+
+	for _, r := range param.Ranges {
+		for a := r.Start; ; a = a.Next() {
+			ips = append(ips, a)
+
+			if a == r.End {
+				break
+			}
+		}
+	}
+
+	return normalizeIPs(ips), nil
+}
+
+// prefixHosts returns host addresses of the prefix, or an error for prefixes
+// with more than maxExpandedHostBits host bits, checked before allocating
+func prefixHosts(p netip.Prefix) ([]netip.Addr, error) {
+	if !p.IsValid() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPrefix, p)
+	}
+
+	p = p.Masked()
+
+	hostBits := p.Addr().BitLen() - p.Bits()
+	if hostBits > maxExpandedHostBits {
+		return nil, fmt.Errorf("%w: %s", ErrPrefixTooLarge, p)
+	}
+
+	hosts := make([]netip.Addr, 0, 1<<hostBits)
+
+	for a := p.Addr(); a.IsValid() && p.Contains(a); a = a.Next() {
+		hosts = append(hosts, a)
+	}
+
+	// IPv4 prefixes shorter than /31 reserve the first and the last address
+	// as network and broadcast addresses (RFC 3021)
+	if p.Addr().Is4() && p.Bits() < 31 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+
+	return hosts, nil
+}
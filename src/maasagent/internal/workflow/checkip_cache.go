@@ -0,0 +1,214 @@
+package workflow
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCheckIPCacheTTL is how long the default cache keeps entries,
+	// which bounds CheckIPParam.MaxCacheAge
+	defaultCheckIPCacheTTL = 10 * time.Minute
+	// checkIPCacheSweepSize is the number of entries that the memory cache
+	// holds before expired entries are dropped for the first time
+	checkIPCacheSweepSize = 1024
+)
+
+// CheckIPCache keeps entries of addresses that responded to scans of CheckIP
+// activities, so that later scans accepting CheckIPParam.MaxCacheAge don't
+// probe them again. Implementations must be safe for concurrent use, as
+// activities of several workflows run at once.
+type CheckIPCache interface {
+	// Get returns the last entry stored for ip
+	Get(ip netip.Addr) (CheckIPEntry, bool)
+	// Put stores entry for ip, replacing the previous one
+	Put(ip netip.Addr, entry CheckIPEntry)
+}
+
+var (
+	checkIPCacheMu sync.RWMutex
+	checkIPCache   CheckIPCache = NewCheckIPMemoryCache(defaultCheckIPCacheTTL)
+)
+
+// SetCheckIPCache replaces the cache used by CheckIP activities of this
+// process, an in-memory cache keeping entries for 10 minutes is used
+// by default. It is meant to be called before the worker starts,
+// a nil cache disables caching.
+func SetCheckIPCache(c CheckIPCache) {
+	checkIPCacheMu.Lock()
+	defer checkIPCacheMu.Unlock()
+
+	checkIPCache = c
+}
+
+func getCheckIPCache() CheckIPCache {
+	checkIPCacheMu.RLock()
+	defer checkIPCacheMu.RUnlock()
+
+	return checkIPCache
+}
+
+// CheckIPMemoryCache is a CheckIPCache keeping entries in memory until
+// they are older than its TTL. The age of an entry is the time since its
+// first reply was received.
+type CheckIPMemoryCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[netip.Addr]CheckIPEntry
+	// sweepAt is the number of entries at which expired ones are dropped
+	sweepAt int
+}
+
+// NewCheckIPMemoryCache returns an empty CheckIPMemoryCache
+func NewCheckIPMemoryCache(ttl time.Duration) *CheckIPMemoryCache {
+	return &CheckIPMemoryCache{
+		ttl:     ttl,
+		entries: make(map[netip.Addr]CheckIPEntry),
+		sweepAt: checkIPCacheSweepSize,
+	}
+}
+
+// Get implements CheckIPCache
+func (c *CheckIPMemoryCache) Get(ip netip.Addr) (CheckIPEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[ip]
+	if !ok {
+		return CheckIPEntry{}, false
+	}
+
+	if c.expired(entry, time.Now()) {
+		delete(c.entries, ip)
+		return CheckIPEntry{}, false
+	}
+
+	return entry, true
+}
+
+// Put implements CheckIPCache. Entries of addresses that did not respond
+// are not stored.
+func (c *CheckIPMemoryCache) Put(ip netip.Addr, entry CheckIPEntry) {
+	if !entry.Responded || entry.SeenAt.IsZero() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[ip] = entry
+
+	// addresses that are never looked up again would otherwise stay forever
+	if len(c.entries) >= c.sweepAt {
+		now := time.Now()
+
+		for ip, entry := range c.entries {
+			if c.expired(entry, now) {
+				delete(c.entries, ip)
+			}
+		}
+
+		c.sweepAt = 2 * len(c.entries)
+		if c.sweepAt < checkIPCacheSweepSize {
+			c.sweepAt = checkIPCacheSweepSize
+		}
+	}
+}
+
+func (c *CheckIPMemoryCache) expired(entry CheckIPEntry, now time.Time) bool {
+	return now.Sub(entry.SeenAt) > c.ttl
+}
+
+// checkIPCacheParam is the parameter of lookupCheckIPCache
+type checkIPCacheParam struct {
+	IPs       []netip.Addr  `json:"ips"`
+	MaxAge    time.Duration `json:"max_age"`
+	Interface string        `json:"interface"`
+}
+
+// lookupCheckIPCache is a local activity returning cached entries of addresses
+// that responded within param.MaxAge, marked as cached. Entries probed through
+// another interface than param.Interface, when it is set, are not accepted.
+// It has no bounds, as none of the addresses are probed.
+func lookupCheckIPCache(_ context.Context, param checkIPCacheParam) (CheckIPActivityResult, error) {
+	cache := getCheckIPCache()
+	if cache == nil {
+		return CheckIPActivityResult{}, nil
+	}
+
+	return cachedEntries(cache, param, time.Now()), nil
+}
+
+// cachedEntries implements lookupCheckIPCache with cache
+func cachedEntries(cache CheckIPCache, param checkIPCacheParam, now time.Time) CheckIPActivityResult {
+	res := CheckIPActivityResult{
+		IPs:     make(map[netip.Addr]net.HardwareAddr),
+		Entries: make(map[netip.Addr]CheckIPEntry),
+	}
+
+	for _, ip := range param.IPs {
+		entry, ok := cache.Get(ip)
+		if !ok || !entry.Responded || now.Sub(entry.SeenAt) > param.MaxAge {
+			continue
+		}
+
+		if param.Interface != "" && entry.Interface != param.Interface {
+			continue
+		}
+
+		entry.Cached = true
+		res.IPs[ip] = entry.MAC
+		res.Entries[ip] = entry
+	}
+
+	return res
+}
+
+// cacheCheckIPEntries stores entries of addresses that responded to a scan
+func cacheCheckIPEntries(entries map[netip.Addr]CheckIPEntry) {
+	cache := getCheckIPCache()
+	if cache == nil {
+		return
+	}
+
+	for ip, entry := range entries {
+		if entry.Responded {
+			cache.Put(ip, entry)
+		}
+	}
+}
+
+// cachedIPs returns addresses of ips whose entry came from the cache,
+// keeping their order
+func cachedIPs(ips []netip.Addr, entries map[netip.Addr]CheckIPEntry) []netip.Addr {
+	var res []netip.Addr
+
+	for _, ip := range ips {
+		if entries[ip].Cached {
+			res = append(res, ip)
+		}
+	}
+
+	return res
+}
+
+// uncached returns addresses of ips that are not in cached,
+// keeping their order
+func uncached(ips []netip.Addr, cached map[netip.Addr]net.HardwareAddr) []netip.Addr {
+	if len(cached) == 0 {
+		return ips
+	}
+
+	res := make([]netip.Addr, 0, len(ips))
+
+	for _, ip := range ips {
+		if _, ok := cached[ip]; !ok {
+			res = append(res, ip)
+		}
+	}
+
+	return res
+}
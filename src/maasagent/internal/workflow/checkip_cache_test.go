@@ -0,0 +1,197 @@
+package workflow
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIPMemoryCache(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	fresh := CheckIPEntry{MAC: hwAddr, Responded: true, SeenAt: time.Now()}
+
+	testcases := map[string]struct {
+		in CheckIPEntry
+		ok bool
+	}{
+		"fresh entry": {
+			in: fresh,
+			ok: true,
+		},
+		"expired entry": {
+			in: CheckIPEntry{MAC: hwAddr, Responded: true, SeenAt: time.Now().Add(-time.Hour)},
+		},
+		"not responded": {
+			in: CheckIPEntry{SeenAt: time.Now()},
+		},
+		"never seen": {
+			in: CheckIPEntry{MAC: hwAddr, Responded: true},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := NewCheckIPMemoryCache(time.Minute)
+			ip := netip.MustParseAddr("10.0.0.1")
+
+			c.Put(ip, tc.in)
+
+			entry, ok := c.Get(ip)
+			assert.Equal(t, tc.ok, ok)
+
+			if tc.ok {
+				assert.Equal(t, tc.in, entry)
+			}
+		})
+	}
+}
+
+func TestCheckIPMemoryCacheSweep(t *testing.T) {
+	c := NewCheckIPMemoryCache(time.Minute)
+	stale := CheckIPEntry{Responded: true, SeenAt: time.Now().Add(-time.Hour)}
+
+	// expired entries are not looked up again, so only a sweep drops them
+	c.entries[netip.MustParseAddr("10.0.0.1")] = stale
+
+	ip := netip.MustParseAddr("10.1.0.0")
+	for i := 0; i < checkIPCacheSweepSize; i++ {
+		c.Put(ip, CheckIPEntry{Responded: true, SeenAt: time.Now()})
+		ip = ip.Next()
+	}
+
+	assert.Len(t, c.entries, checkIPCacheSweepSize)
+	assert.NotContains(t, c.entries, netip.MustParseAddr("10.0.0.1"))
+	// the sweep ran before the last entry was stored
+	assert.Equal(t, 2*(checkIPCacheSweepSize-1), c.sweepAt)
+}
+
+func TestCheckIPMemoryCacheConcurrent(t *testing.T) {
+	c := NewCheckIPMemoryCache(time.Minute)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			ip := netip.AddrFrom4([4]byte{10, 0, byte(i), 0})
+
+			for j := 0; j < 100; j++ {
+				c.Put(ip, CheckIPEntry{Responded: true, SeenAt: time.Now()})
+
+				_, ok := c.Get(ip)
+				assert.True(t, ok)
+
+				ip = ip.Next()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	assert.Len(t, c.entries, 800)
+}
+
+func TestCachedEntries(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	now := time.Now()
+
+	c := NewCheckIPMemoryCache(time.Hour)
+	c.Put(netip.MustParseAddr("10.0.0.1"), CheckIPEntry{
+		MAC: hwAddr, Responded: true, Interface: "eth0", SeenAt: now.Add(-time.Second),
+	})
+	c.Put(netip.MustParseAddr("10.0.0.2"), CheckIPEntry{
+		MAC: hwAddr, Responded: true, Interface: "eth0", SeenAt: now.Add(-time.Minute),
+	})
+	c.Put(netip.MustParseAddr("10.0.0.3"), CheckIPEntry{
+		MAC: hwAddr, Responded: true, Interface: "eth1", SeenAt: now.Add(-time.Second),
+	})
+
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.0.3"),
+		netip.MustParseAddr("10.0.0.4"),
+	}
+
+	testcases := map[string]struct {
+		in  checkIPCacheParam
+		out []netip.Addr
+	}{
+		"fresh entries": {
+			in: checkIPCacheParam{IPs: ips, MaxAge: 10 * time.Second},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.3"),
+			},
+		},
+		"older entries": {
+			in: checkIPCacheParam{IPs: ips, MaxAge: time.Hour},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.0.3"),
+			},
+		},
+		"interface": {
+			in:  checkIPCacheParam{IPs: ips, MaxAge: 10 * time.Second, Interface: "eth1"},
+			out: []netip.Addr{netip.MustParseAddr("10.0.0.3")},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res := cachedEntries(c, tc.in, now)
+			assert.Len(t, res.IPs, len(tc.out))
+			assert.True(t, res.StartedAt.IsZero())
+
+			for _, ip := range tc.out {
+				assert.Equal(t, hwAddr, res.IPs[ip])
+				assert.True(t, res.Entries[ip].Cached)
+			}
+		})
+	}
+}
+
+func TestUncached(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.3"),
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+	}
+
+	assert.Equal(t, ips, uncached(ips, nil))
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.3"), netip.MustParseAddr("10.0.0.2")},
+		uncached(ips, map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): nil}))
+}
+
+func TestCachedIPs(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.3"),
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+	}
+
+	entries := map[netip.Addr]CheckIPEntry{
+		ips[0]: {Responded: true, Cached: true},
+		ips[1]: {Responded: true},
+		ips[2]: {Responded: true, Cached: true},
+	}
+
+	assert.Equal(t, []netip.Addr{ips[0], ips[2]}, cachedIPs(ips, entries))
+	assert.Nil(t, cachedIPs(ips, nil))
+}
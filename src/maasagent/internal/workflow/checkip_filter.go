@@ -0,0 +1,137 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+var (
+	// ErrInvalidMACPrefix is an error for when a hardware address prefix
+	// that can't be parsed is passed to CheckIP
+	ErrInvalidMACPrefix = errors.New("invalid hardware address prefix")
+)
+
+// parseMACPrefixes parses hardware address prefixes of CheckIPParam.MACPrefixes
+func parseMACPrefixes(prefixes []string) ([][]byte, error) {
+	res := make([][]byte, 0, len(prefixes))
+
+	for _, s := range prefixes {
+		p, err := parseMACPrefix(s)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, p)
+	}
+
+	return res, nil
+}
+
+// parseMACPrefix parses one to six bytes in hexadecimal, optionally
+// separated by colons, hyphens or dots like "00:1a:2b" or "001a.2b"
+func parseMACPrefix(s string) ([]byte, error) {
+	digits := strings.NewReplacer(":", "", "-", "", ".", "").Replace(s)
+
+	p, err := hex.DecodeString(digits)
+	if err != nil || len(p) == 0 || len(p) > 6 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidMACPrefix, s)
+	}
+
+	return p, nil
+}
+
+// matchesMACPrefix returns true if an address with entry e and hardware
+// address of the scan result hwAddr resolved to a hardware address starting
+// with one of prefixes
+func matchesMACPrefix(e CheckIPEntry, hwAddr []byte, prefixes [][]byte) bool {
+	for _, p := range prefixes {
+		if bytes.HasPrefix(hwAddr, p) {
+			return true
+		}
+
+		for _, mac := range e.MACs {
+			if bytes.HasPrefix(mac, p) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// filterMACPrefixes drops addresses that resolved to hardware addresses
+// not starting with any of prefixes from every collection of res, and counts
+// them in res.Filtered. Addresses that did not resolve are kept, like
+// counters and Disappeared, which don't depend on hardware addresses.
+func filterMACPrefixes(res *CheckIPResult, prefixes [][]byte) {
+	if len(prefixes) == 0 {
+		return
+	}
+
+	dropped := make(map[netip.Addr]struct{})
+
+	for ip, hwAddr := range res.IPs {
+		if len(hwAddr) > 0 && !matchesMACPrefix(res.Entries[ip], hwAddr, prefixes) {
+			dropped[ip] = struct{}{}
+		}
+	}
+
+	if len(dropped) == 0 {
+		return
+	}
+
+	kept := func(ip netip.Addr) bool {
+		_, ok := dropped[ip]
+		return !ok
+	}
+
+	filter := func(ips []netip.Addr) []netip.Addr {
+		var res []netip.Addr
+
+		for _, ip := range ips {
+			if kept(ip) {
+				res = append(res, ip)
+			}
+		}
+
+		return res
+	}
+
+	for ip := range dropped {
+		delete(res.IPs, ip)
+		delete(res.Entries, ip)
+		delete(res.RTTs, ip)
+		delete(res.Vendors, ip)
+		delete(res.Hostnames, ip)
+		delete(res.Changed, ip)
+	}
+
+	for _, found := range res.PerInterface {
+		for ip := range found {
+			if !kept(ip) {
+				delete(found, ip)
+			}
+		}
+	}
+
+	for mac, ips := range res.Conflicts {
+		ips = filter(ips)
+		if len(ips) < 2 {
+			delete(res.Conflicts, mac)
+			continue
+		}
+
+		res.Conflicts[mac] = ips
+	}
+
+	for name, ips := range res.ResolvedHostnames {
+		res.ResolvedHostnames[name] = filter(ips)
+	}
+
+	res.Cached = filter(res.Cached)
+	res.Filtered = len(dropped)
+}
@@ -0,0 +1,114 @@
+package workflow
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMACPrefix(t *testing.T) {
+	testcases := map[string]struct {
+		in  string
+		out []byte
+		err error
+	}{
+		"colon separated": {
+			in:  "00:1a:2B",
+			out: []byte{0x00, 0x1a, 0x2b},
+		},
+		"hyphen separated": {
+			in:  "00-1a-2b-3c",
+			out: []byte{0x00, 0x1a, 0x2b, 0x3c},
+		},
+		"dotted": {
+			in:  "001a.2b3c",
+			out: []byte{0x00, 0x1a, 0x2b, 0x3c},
+		},
+		"plain": {
+			in:  "c0",
+			out: []byte{0xc0},
+		},
+		"empty": {
+			err: ErrInvalidMACPrefix,
+		},
+		"odd digits": {
+			in:  "00:1",
+			err: ErrInvalidMACPrefix,
+		},
+		"not hexadecimal": {
+			in:  "zz",
+			err: ErrInvalidMACPrefix,
+		},
+		"longer than a hardware address": {
+			in:  "00:11:22:33:44:55:66",
+			err: ErrInvalidMACPrefix,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := parseMACPrefix(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestFilterMACPrefixes(t *testing.T) {
+	match := net.HardwareAddr{0x00, 0x1a, 0x2b, 0x00, 0x00, 0x01}
+	other := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.0.3"),
+		netip.MustParseAddr("10.0.0.4"),
+	}
+
+	res := CheckIPResult{
+		IPs: map[netip.Addr]net.HardwareAddr{
+			ips[0]: match, ips[1]: other, ips[2]: nil, ips[3]: other,
+		},
+		Entries: map[netip.Addr]CheckIPEntry{
+			ips[0]: {MAC: match, Responded: true},
+			ips[1]: {MAC: other, Responded: true},
+			ips[2]: {},
+			ips[3]: {MAC: other, MACs: []net.HardwareAddr{other, match}, Responded: true},
+		},
+		RTTs:       map[netip.Addr]time.Duration{ips[0]: 1, ips[1]: 1, ips[3]: 1},
+		Unresolved: []netip.Addr{ips[2]},
+		Vendors:    map[netip.Addr]string{ips[1]: "vendor"},
+		Conflicts:  map[string][]netip.Addr{other.String(): {ips[1], ips[3]}},
+		Cached:     []netip.Addr{ips[1]},
+		Responded:  3,
+		Total:      4,
+	}
+
+	filterMACPrefixes(&res, [][]byte{{0x00, 0x1a, 0x2b}})
+
+	// an address that also replied with a matching hardware address is kept
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{ips[0]: match, ips[2]: nil, ips[3]: other}, res.IPs)
+	assert.NotContains(t, res.Entries, ips[1])
+	assert.Len(t, res.Entries, 3)
+	assert.NotContains(t, res.RTTs, ips[1])
+	assert.Empty(t, res.Vendors)
+	assert.Empty(t, res.Conflicts)
+	assert.Empty(t, res.Cached)
+	assert.Equal(t, []netip.Addr{ips[2]}, res.Unresolved)
+	assert.Equal(t, 1, res.Filtered)
+	assert.Equal(t, 3, res.Responded)
+	assert.Equal(t, 4, res.Total)
+
+	// without prefixes, nothing is filtered
+	res = CheckIPResult{IPs: map[netip.Addr]net.HardwareAddr{ips[1]: other}}
+	filterMACPrefixes(&res, nil)
+	assert.Len(t, res.IPs, 1)
+	assert.Zero(t, res.Filtered)
+}
@@ -0,0 +1,246 @@
+package workflow
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/netip"
+)
+
+// CheckIPResult and CheckIPEntry are marshaled with hardware addresses in
+// their colon separated form instead of the default base64 of their bytes,
+// so that results can be logged and passed to other services. Addresses are
+// map keys in their canonical text form. An empty string stands for a missing
+// hardware address. The base64 form of results recorded in histories before
+// is still accepted.
+
+// checkIPResultAlias prevents recursion into CheckIPResult.MarshalJSON,
+// fields of checkIPResultJSON take precedence over the embedded ones
+type checkIPResultAlias CheckIPResult
+
+type checkIPResultJSON struct {
+	checkIPResultAlias
+	IPs          map[netip.Addr]string            `json:"ips"`
+	PerInterface map[string]map[netip.Addr]string `json:"per_interface,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler for CheckIPResult
+func (r CheckIPResult) MarshalJSON() ([]byte, error) {
+	v := checkIPResultJSON{
+		checkIPResultAlias: checkIPResultAlias(r),
+		IPs:                macStringMap(r.IPs),
+	}
+
+	if r.PerInterface != nil {
+		v.PerInterface = make(map[string]map[netip.Addr]string, len(r.PerInterface))
+
+		for iface, found := range r.PerInterface {
+			v.PerInterface[iface] = macStringMap(found)
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler for CheckIPResult
+func (r *CheckIPResult) UnmarshalJSON(b []byte) error {
+	var v checkIPResultJSON
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	res := CheckIPResult(v.checkIPResultAlias)
+
+	var err error
+
+	if res.IPs, err = parseMACMap(v.IPs); err != nil {
+		return err
+	}
+
+	if v.PerInterface != nil {
+		res.PerInterface = make(map[string]map[netip.Addr]net.HardwareAddr, len(v.PerInterface))
+
+		for iface, found := range v.PerInterface {
+			if res.PerInterface[iface], err = parseMACMap(found); err != nil {
+				return err
+			}
+		}
+	}
+
+	*r = res
+
+	return nil
+}
+
+// checkIPEntryAlias prevents recursion into CheckIPEntry.MarshalJSON
+type checkIPEntryAlias CheckIPEntry
+
+type checkIPEntryJSON struct {
+	checkIPEntryAlias
+	MAC       string   `json:"mac"`
+	MACs      []string `json:"macs,omitempty"`
+	SourceMAC string   `json:"source_mac,omitempty"`
+	// SourceIP is omitted when it is unknown
+	SourceIP string `json:"source_ip,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler for CheckIPEntry
+func (e CheckIPEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(checkIPEntryJSON{
+		checkIPEntryAlias: checkIPEntryAlias(e),
+		MAC:               macString(e.MAC),
+		MACs:              macStrings(e.MACs),
+		SourceMAC:         macString(e.SourceMAC),
+		SourceIP:          addrString(e.SourceIP),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler for CheckIPEntry
+func (e *CheckIPEntry) UnmarshalJSON(b []byte) error {
+	var v checkIPEntryJSON
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	res := CheckIPEntry(v.checkIPEntryAlias)
+
+	var err error
+
+	if res.MAC, err = parseMAC(v.MAC); err != nil {
+		return err
+	}
+
+	if res.MACs, err = parseMACs(v.MACs); err != nil {
+		return err
+	}
+
+	if res.SourceMAC, err = parseMAC(v.SourceMAC); err != nil {
+		return err
+	}
+
+	if res.SourceIP, err = parseAddr(v.SourceIP); err != nil {
+		return err
+	}
+
+	*e = res
+
+	return nil
+}
+
+// macString returns the colon separated form of hwAddr,
+// or an empty string if it is empty
+func macString(hwAddr net.HardwareAddr) string {
+	if len(hwAddr) == 0 {
+		return ""
+	}
+
+	return hwAddr.String()
+}
+
+// parseMAC is the reverse of macString. The base64 form that hardware
+// addresses had in histories recorded before is accepted as well, it can't
+// be mistaken for the colon separated form, which base64 has no colons for.
+func parseMAC(s string) (net.HardwareAddr, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	hwAddr, err := net.ParseMAC(s)
+	if err == nil {
+		return hwAddr, nil
+	}
+
+	// the lengths of EUI-48, EUI-64 and IPoIB addresses that ParseMAC accepts
+	if b, berr := base64.StdEncoding.DecodeString(s); berr == nil && (len(b) == 6 || len(b) == 8 || len(b) == 20) {
+		return net.HardwareAddr(b), nil
+	}
+
+	return nil, err
+}
+
+func macStrings(hwAddrs []net.HardwareAddr) []string {
+	if hwAddrs == nil {
+		return nil
+	}
+
+	res := make([]string, len(hwAddrs))
+
+	for i, hwAddr := range hwAddrs {
+		res[i] = macString(hwAddr)
+	}
+
+	return res
+}
+
+func parseMACs(s []string) ([]net.HardwareAddr, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	res := make([]net.HardwareAddr, len(s))
+
+	for i, v := range s {
+		hwAddr, err := parseMAC(v)
+		if err != nil {
+			return nil, err
+		}
+
+		res[i] = hwAddr
+	}
+
+	return res, nil
+}
+
+func macStringMap(m map[netip.Addr]net.HardwareAddr) map[netip.Addr]string {
+	if m == nil {
+		return nil
+	}
+
+	res := make(map[netip.Addr]string, len(m))
+
+	for ip, hwAddr := range m {
+		res[ip] = macString(hwAddr)
+	}
+
+	return res
+}
+
+func parseMACMap(m map[netip.Addr]string) (map[netip.Addr]net.HardwareAddr, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	res := make(map[netip.Addr]net.HardwareAddr, len(m))
+
+	for ip, v := range m {
+		hwAddr, err := parseMAC(v)
+		if err != nil {
+			return nil, err
+		}
+
+		res[ip] = hwAddr
+	}
+
+	return res, nil
+}
+
+// addrString returns ip in its text form, or an empty string if it is zero
+func addrString(ip netip.Addr) string {
+	if !ip.IsValid() {
+		return ""
+	}
+
+	return ip.String()
+}
+
+// parseAddr parses the text form of an address, an empty string
+// is the zero address
+func parseAddr(s string) (netip.Addr, error) {
+	if s == "" {
+		return netip.Addr{}, nil
+	}
+
+	return netip.ParseAddr(s)
+}
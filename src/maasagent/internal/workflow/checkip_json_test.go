@@ -0,0 +1,157 @@
+package workflow
+
+import (
+	"encoding/json"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIPResultJSON(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	other := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+
+	testcases := map[string]struct {
+		in   CheckIPResult
+		json string
+	}{
+		"empty": {
+			in: CheckIPResult{},
+			json: `{"ips":null,"entries":null,"rtts":null,"unresolved":null,` +
+				`"started_at":"0001-01-01T00:00:00Z","finished_at":"0001-01-01T00:00:00Z",` +
+				`"responded":0,"total":0}`,
+		},
+		"IPv4 with an empty MAC": {
+			in: CheckIPResult{
+				IPs: map[netip.Addr]net.HardwareAddr{
+					netip.MustParseAddr("10.0.0.1"): hwAddr,
+					netip.MustParseAddr("10.0.0.2"): nil,
+				},
+				Entries: map[netip.Addr]CheckIPEntry{
+					netip.MustParseAddr("10.0.0.1"): {
+						MAC:            hwAddr,
+						MACs:           []net.HardwareAddr{hwAddr},
+						Responded:      true,
+						Latency:        time.Millisecond,
+						Interface:      "eth0",
+						InterfaceIndex: 2,
+						SourceMAC:      other,
+						SourceIP:       netip.MustParseAddr("10.0.0.254"),
+					},
+					netip.MustParseAddr("10.0.0.2"): {},
+				},
+			},
+			json: `{"entries":{"10.0.0.1":{"responded":true,"latency":1000000,"interface":"eth0",` +
+				`"interface_index":2,"source_ip":"10.0.0.254",` +
+				`"mac":"c0:ff:ee:15:c0:01","macs":["c0:ff:ee:15:c0:01"],"source_mac":"c0:ff:ee:15:c0:02",` +
+				`"seen_at":"0001-01-01T00:00:00Z"},` +
+				`"10.0.0.2":{"responded":false,"latency":0,"mac":"","seen_at":"0001-01-01T00:00:00Z"}},` +
+				`"rtts":null,"unresolved":null,` +
+				`"started_at":"0001-01-01T00:00:00Z","finished_at":"0001-01-01T00:00:00Z",` +
+				`"responded":0,"total":0,` +
+				`"ips":{"10.0.0.1":"c0:ff:ee:15:c0:01","10.0.0.2":""}}`,
+		},
+		"IPv6 with conflicts and interfaces": {
+			in: CheckIPResult{
+				IPs: map[netip.Addr]net.HardwareAddr{
+					netip.MustParseAddr("fd00::1"):      hwAddr,
+					netip.MustParseAddr("fe80::1%eth0"): nil,
+				},
+				Conflicts: map[string][]netip.Addr{
+					hwAddr.String(): {netip.MustParseAddr("fd00::1"), netip.MustParseAddr("fd00::2")},
+				},
+				PerInterface: map[string]map[netip.Addr]net.HardwareAddr{
+					"eth0": {netip.MustParseAddr("fd00::1"): hwAddr},
+					"eth1": {netip.MustParseAddr("fd00::1"): nil},
+				},
+			},
+			json: `{"entries":null,"rtts":null,"unresolved":null,` +
+				`"started_at":"0001-01-01T00:00:00Z","finished_at":"0001-01-01T00:00:00Z",` +
+				`"responded":0,"total":0,` +
+				`"conflicts":{"c0:ff:ee:15:c0:01":["fd00::1","fd00::2"]},` +
+				`"ips":{"fd00::1":"c0:ff:ee:15:c0:01","fe80::1%eth0":""},` +
+				`"per_interface":{"eth0":{"fd00::1":"c0:ff:ee:15:c0:01"},"eth1":{"fd00::1":""}}}`,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := json.Marshal(tc.in)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.json, string(b))
+
+			var out CheckIPResult
+
+			err = json.Unmarshal(b, &out)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.in, out)
+		})
+	}
+}
+
+func TestCheckIPResultUnmarshalJSONInvalidMAC(t *testing.T) {
+	testcases := map[string]string{
+		"ips":           `{"ips":{"10.0.0.1":"not a mac"}}`,
+		"entries":       `{"entries":{"10.0.0.1":{"mac":"not a mac"}}}`,
+		"per interface": `{"per_interface":{"eth0":{"10.0.0.1":"not a mac"}}}`,
+	}
+
+	for name, in := range testcases {
+		in := in
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var out CheckIPResult
+
+			assert.Error(t, json.Unmarshal([]byte(in), &out))
+		})
+	}
+}
+
+func TestParseMAC(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	testcases := map[string]struct {
+		in  string
+		out net.HardwareAddr
+		err bool
+	}{
+		"empty": {},
+		"colon separated": {
+			in: "c0:ff:ee:15:c0:01", out: hwAddr,
+		},
+		"base64": {
+			in: "wP/uFcAB", out: hwAddr,
+		},
+		"base64 of an invalid length": {
+			in: "wP/u", err: true,
+		},
+		"invalid": {
+			in: "not a mac", err: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := parseMAC(tc.in)
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.out, out)
+			}
+		})
+	}
+}
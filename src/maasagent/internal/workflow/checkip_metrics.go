@@ -0,0 +1,84 @@
+package workflow
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const checkIPMetricsNamespace = "maas_agent_checkip"
+
+// checkIPMetrics are collectors updated by CheckIP scan activities.
+// They are only updated by activities, so workflow code stays deterministic.
+// Probes, replies, errors and durations of scans are counted by collectors
+// of netmon, which activities scan with.
+type checkIPMetrics struct {
+	unresolved prometheus.Counter
+}
+
+var (
+	checkIPMetricsMu         sync.RWMutex
+	registeredCheckIPMetrics *checkIPMetrics
+)
+
+// RegisterCheckIPMetrics registers collectors of CheckIP scan activities of
+// this process with reg, for example a *prometheus.Registry served by the
+// metrics endpoint of the worker. Activities count scanned addresses that
+// were left unresolved, on top of the collectors of netmon.RegisterMetrics.
+// It is meant to be called once before the worker starts, a nil reg stops
+// updating collectors.
+func RegisterCheckIPMetrics(reg prometheus.Registerer) error {
+	var m *checkIPMetrics
+
+	if reg != nil {
+		m = newCheckIPMetrics()
+
+		if err := reg.Register(m.unresolved); err != nil {
+			return fmt.Errorf("failed to register CheckIP metrics: %w", err)
+		}
+	}
+
+	checkIPMetricsMu.Lock()
+	defer checkIPMetricsMu.Unlock()
+
+	registeredCheckIPMetrics = m
+
+	return nil
+}
+
+func getCheckIPMetrics() *checkIPMetrics {
+	checkIPMetricsMu.RLock()
+	defer checkIPMetricsMu.RUnlock()
+
+	return registeredCheckIPMetrics
+}
+
+func newCheckIPMetrics() *checkIPMetrics {
+	return &checkIPMetrics{
+		unresolved: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: checkIPMetricsNamespace,
+			Name:      "addresses_unresolved_total",
+			Help:      "Number of scanned addresses that did not reply or could not be probed.",
+		}),
+	}
+}
+
+// observe updates collectors with entries of ips scanned by an activity,
+// nothing is updated if m is nil
+func (m *checkIPMetrics) observe(ips []netip.Addr, entries map[netip.Addr]CheckIPEntry) {
+	if m == nil {
+		return
+	}
+
+	var unresolved int
+
+	for _, ip := range ips {
+		if !entries[ip].Responded {
+			unresolved++
+		}
+	}
+
+	m.unresolved.Add(float64(unresolved))
+}
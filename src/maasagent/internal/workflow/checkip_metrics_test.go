@@ -0,0 +1,85 @@
+package workflow
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIPMetricsObserve(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.0.3"),
+	}
+
+	testcases := map[string]struct {
+		ips        []netip.Addr
+		entries    map[netip.Addr]CheckIPEntry
+		unresolved float64
+	}{
+		"scan": {
+			ips: ips,
+			entries: map[netip.Addr]CheckIPEntry{
+				ips[0]: {Responded: true, Attempts: 1},
+				ips[1]: {Attempts: 2},
+				ips[2]: {Error: "failed"},
+			},
+			unresolved: 2,
+		},
+		"entries of other addresses": {
+			ips: ips[:1],
+			entries: map[netip.Addr]CheckIPEntry{
+				ips[0]: {Responded: true, Attempts: 1},
+				ips[1]: {Attempts: 1},
+			},
+		},
+		"missing entries": {
+			ips:        ips[:2],
+			unresolved: 2,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newCheckIPMetrics()
+			m.observe(tc.ips, tc.entries)
+
+			assert.Equal(t, tc.unresolved, testutil.ToFloat64(m.unresolved))
+		})
+	}
+}
+
+func TestCheckIPMetricsObserveNil(t *testing.T) {
+	var m *checkIPMetrics
+
+	assert.NotPanics(t, func() { m.observe([]netip.Addr{netip.MustParseAddr("10.0.0.1")}, nil) })
+}
+
+func TestRegisterCheckIPMetrics(t *testing.T) {
+	t.Cleanup(func() { assert.NoError(t, RegisterCheckIPMetrics(nil)) })
+
+	reg := prometheus.NewRegistry()
+
+	assert.NoError(t, RegisterCheckIPMetrics(reg))
+	assert.NotNil(t, getCheckIPMetrics())
+
+	getCheckIPMetrics().observe([]netip.Addr{netip.MustParseAddr("10.0.0.1")}, nil)
+
+	count, err := testutil.GatherAndCount(reg, "maas_agent_checkip_addresses_unresolved_total")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// the same collectors can't be registered twice
+	assert.Error(t, RegisterCheckIPMetrics(reg))
+
+	assert.NoError(t, RegisterCheckIPMetrics(nil))
+	assert.Nil(t, getCheckIPMetrics())
+}
@@ -0,0 +1,135 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// CheckIPAddressPolicy is an enum value for the addresses that CheckIP
+// scans. Addresses are classified with netip.Addr methods, in workflow
+// code, so that replays apply the policy the same way.
+type CheckIPAddressPolicy uint8
+
+const (
+	// AddressPolicyAllowAll scans every address, including link-local
+	// unicast addresses (169.254.0.0/16 and fe80::/10) and the limited
+	// broadcast address 255.255.255.255. Unspecified, loopback and multicast
+	// addresses are still skipped, as no host answers for them on the link.
+	// It is the default policy.
+	AddressPolicyAllowAll CheckIPAddressPolicy = iota
+	// AddressPolicyGlobalOnly only scans global unicast addresses, as
+	// reported by netip.Addr.IsGlobalUnicast, which includes private IPv4
+	// addresses (RFC 1918) and unique local IPv6 addresses (fc00::/7).
+	// Link-local unicast, limited broadcast, unspecified, loopback and
+	// multicast addresses are reported in CheckIPResult.Skipped instead.
+	// It suits callers looking for allocatable addresses.
+	AddressPolicyGlobalOnly
+	// AddressPolicyRejectNonGlobal fails CheckIP with ErrAddressPolicy
+	// before scanning, if an address that AddressPolicyGlobalOnly would
+	// skip is to be scanned, including signaled addresses. Excluded
+	// addresses are not checked.
+	AddressPolicyRejectNonGlobal
+)
+
+const (
+	addressPolicyAllowAllStr        = "ALLOW_ALL"
+	addressPolicyGlobalOnlyStr      = "GLOBAL_ONLY"
+	addressPolicyRejectNonGlobalStr = "REJECT_NON_GLOBAL"
+)
+
+var (
+	addressPolicyToString = map[CheckIPAddressPolicy]string{
+		AddressPolicyAllowAll:        addressPolicyAllowAllStr,
+		AddressPolicyGlobalOnly:      addressPolicyGlobalOnlyStr,
+		AddressPolicyRejectNonGlobal: addressPolicyRejectNonGlobalStr,
+	}
+
+	stringToAddressPolicy = map[string]CheckIPAddressPolicy{
+		addressPolicyAllowAllStr:        AddressPolicyAllowAll,
+		addressPolicyGlobalOnlyStr:      AddressPolicyGlobalOnly,
+		addressPolicyRejectNonGlobalStr: AddressPolicyRejectNonGlobal,
+	}
+)
+
+var (
+	// ErrInvalidAddressPolicy is an error for when an unknown
+	// CheckIPAddressPolicy is passed to CheckIP
+	ErrInvalidAddressPolicy = errors.New("invalid address policy")
+	// ErrAddressPolicy is an error for when an address to scan is not
+	// allowed by AddressPolicyRejectNonGlobal
+	ErrAddressPolicy = errors.New("address not allowed by address policy")
+)
+
+// String returns the string version of the CheckIPAddressPolicy
+func (p CheckIPAddressPolicy) String() string {
+	str, ok := addressPolicyToString[p]
+	if ok {
+		return str
+	}
+
+	return "UNKNOWN"
+}
+
+// MarshalJSON implements json.Marshaler for CheckIPAddressPolicy
+func (p CheckIPAddressPolicy) MarshalJSON() ([]byte, error) {
+	str, ok := addressPolicyToString[p]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidAddressPolicy, p)
+	}
+
+	return json.Marshal(str)
+}
+
+// UnmarshalJSON implements json.Unmarshaler for CheckIPAddressPolicy
+func (p *CheckIPAddressPolicy) UnmarshalJSON(b []byte) error {
+	var str string
+
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+
+	policy, ok := stringToAddressPolicy[str]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidAddressPolicy, str)
+	}
+
+	*p = policy
+
+	return nil
+}
+
+// valid returns true if p is a known policy
+func (p CheckIPAddressPolicy) valid() bool {
+	_, ok := addressPolicyToString[p]
+	return ok
+}
+
+// split splits ips into addresses allowed by p and the ones it skips,
+// keeping the order of both. Addresses always skipped by skipUnscannable
+// are left to it by AddressPolicyAllowAll.
+func (p CheckIPAddressPolicy) split(ips []netip.Addr) ([]netip.Addr, []netip.Addr, error) {
+	if p == AddressPolicyAllowAll {
+		return ips, nil, nil
+	}
+
+	var skipped []netip.Addr
+
+	res := make([]netip.Addr, 0, len(ips))
+
+	for _, ip := range ips {
+		if ip.IsGlobalUnicast() {
+			res = append(res, ip)
+			continue
+		}
+
+		if p == AddressPolicyRejectNonGlobal {
+			return nil, nil, fmt.Errorf("%w: %s is not a global unicast address", ErrAddressPolicy, ip)
+		}
+
+		skipped = append(skipped, ip)
+	}
+
+	return res, skipped, nil
+}
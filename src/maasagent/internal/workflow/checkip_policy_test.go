@@ -0,0 +1,121 @@
+package workflow
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIPAddressPolicySplit(t *testing.T) {
+	global := netip.MustParseAddr("192.0.2.1")
+	private := netip.MustParseAddr("10.0.0.1")
+	ula := netip.MustParseAddr("fd00::1")
+	linkLocal := netip.MustParseAddr("fe80::1%eth0")
+	linkLocal4 := netip.MustParseAddr("169.254.0.1")
+	multicast := netip.MustParseAddr("ff02::1")
+	unspecified := netip.MustParseAddr("::")
+	broadcast := netip.MustParseAddr("255.255.255.255")
+
+	all := []netip.Addr{global, linkLocal, private, multicast, ula, linkLocal4, unspecified, broadcast}
+
+	testcases := map[string]struct {
+		policy  CheckIPAddressPolicy
+		out     []netip.Addr
+		skipped []netip.Addr
+		err     error
+	}{
+		"allow all": {
+			policy: AddressPolicyAllowAll,
+			out:    all,
+		},
+		"global only": {
+			policy:  AddressPolicyGlobalOnly,
+			out:     []netip.Addr{global, private, ula},
+			skipped: []netip.Addr{linkLocal, multicast, linkLocal4, unspecified, broadcast},
+		},
+		"reject non global": {
+			policy: AddressPolicyRejectNonGlobal,
+			err:    ErrAddressPolicy,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, skipped, err := tc.policy.split(all)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, out)
+			assert.Equal(t, tc.skipped, skipped)
+		})
+	}
+
+	// global addresses pass the strictest policy
+	out, _, err := AddressPolicyRejectNonGlobal.split([]netip.Addr{global, ula})
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Addr{global, ula}, out)
+}
+
+func TestCheckIPAddressPolicyJSON(t *testing.T) {
+	testcases := map[string]struct {
+		in  CheckIPAddressPolicy
+		out string
+		err error
+	}{
+		"allow all": {
+			in:  AddressPolicyAllowAll,
+			out: `"ALLOW_ALL"`,
+		},
+		"global only": {
+			in:  AddressPolicyGlobalOnly,
+			out: `"GLOBAL_ONLY"`,
+		},
+		"reject non global": {
+			in:  AddressPolicyRejectNonGlobal,
+			out: `"REJECT_NON_GLOBAL"`,
+		},
+		"unknown": {
+			in:  CheckIPAddressPolicy(0xff),
+			err: ErrInvalidAddressPolicy,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := json.Marshal(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+
+			if tc.err != nil {
+				assert.Equal(t, "UNKNOWN", tc.in.String())
+				return
+			}
+
+			assert.Equal(t, tc.out, string(b))
+
+			var out CheckIPAddressPolicy
+
+			assert.NoError(t, json.Unmarshal(b, &out))
+			assert.Equal(t, tc.in, out)
+		})
+	}
+}
+
+func TestCheckIPAddressPolicyUnmarshalJSONInvalid(t *testing.T) {
+	var p CheckIPAddressPolicy
+
+	assert.ErrorIs(t, json.Unmarshal([]byte(`"LINK_LOCAL"`), &p), ErrInvalidAddressPolicy)
+	assert.Error(t, json.Unmarshal([]byte(`1`), &p))
+
+	// the policy is omitted from parameters when it is the default
+	b, err := json.Marshal(CheckIPParam{})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "address_policy")
+}
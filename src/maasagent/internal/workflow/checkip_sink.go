@@ -0,0 +1,80 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+)
+
+// CheckIPResultSink receives entries of addresses that responded to scans of
+// CheckIP activities as they are found, for example to persist them before
+// the workflow returns its result. Record is called by the activity that
+// found the entry, so a slow sink slows the scan down, and an error fails
+// the activity, which is then retried like after any other error.
+// An address is recorded again when another host answers for it, or when
+// a failed activity is retried. Implementations must be safe for concurrent
+// use, as activities of several workflows run at once.
+type CheckIPResultSink interface {
+	Record(ctx context.Context, ip netip.Addr, entry CheckIPEntry) error
+}
+
+var (
+	// ErrResultSink is an error for when the sink set with
+	// SetCheckIPResultSink fails to record an entry
+	ErrResultSink = errors.New("failed to record entry")
+)
+
+var (
+	checkIPResultSinkMu sync.RWMutex
+	checkIPResultSink   CheckIPResultSink
+)
+
+// SetCheckIPResultSink sets the sink that CheckIP activities of this process
+// record entries to. It is meant to be called before the worker starts,
+// a nil sink, which is the default, disables recording.
+func SetCheckIPResultSink(s CheckIPResultSink) {
+	checkIPResultSinkMu.Lock()
+	defer checkIPResultSinkMu.Unlock()
+
+	checkIPResultSink = s
+}
+
+func getCheckIPResultSink() CheckIPResultSink {
+	checkIPResultSinkMu.RLock()
+	defer checkIPResultSinkMu.RUnlock()
+
+	return checkIPResultSink
+}
+
+// recordCheckIPEntry records entry of ip to sink
+func recordCheckIPEntry(ctx context.Context, sink CheckIPResultSink, ip netip.Addr, entry CheckIPEntry) error {
+	if err := sink.Record(ctx, ip, entry); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrResultSink, ip, err)
+	}
+
+	return nil
+}
+
+// recordCheckIPEntries records entries of addresses of ips that responded
+// to sink, in the order of ips. Nothing is recorded if sink is nil.
+func recordCheckIPEntries(ctx context.Context, sink CheckIPResultSink, ips []netip.Addr,
+	entries map[netip.Addr]CheckIPEntry) error {
+	if sink == nil {
+		return nil
+	}
+
+	for _, ip := range ips {
+		entry, ok := entries[ip]
+		if !ok || !entry.Responded {
+			continue
+		}
+
+		if err := recordCheckIPEntry(ctx, sink, ip, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.temporal.io/sdk/testsuite"
+
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+// errSink is returned by fakeSink
+var errSink = errors.New("sink is unavailable")
+
+// fakeSink records entries in memory, with fail set it fails once
+// limit entries are recorded
+type fakeSink struct {
+	mu       sync.Mutex
+	recorded []netip.Addr
+	entries  map[netip.Addr]CheckIPEntry
+	fail     bool
+	limit    int
+}
+
+func (s *fakeSink) Record(_ context.Context, ip netip.Addr, entry CheckIPEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail && len(s.recorded) >= s.limit {
+		return errSink
+	}
+
+	if s.entries == nil {
+		s.entries = make(map[netip.Addr]CheckIPEntry)
+	}
+
+	s.recorded = append(s.recorded, ip)
+	s.entries[ip] = entry
+
+	return nil
+}
+
+func TestRecordCheckIPEntries(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("192.0.2.3"),
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("192.0.2.2"),
+	}
+	entries := map[netip.Addr]CheckIPEntry{
+		ips[0]: {Responded: true},
+		ips[1]: {},
+		ips[2]: {Responded: true},
+	}
+
+	testcases := map[string]struct {
+		sink *fakeSink
+		out  []netip.Addr
+		err  error
+	}{
+		"responders in scan order": {
+			sink: &fakeSink{},
+			out:  []netip.Addr{ips[0], ips[2]},
+		},
+		"sink error": {
+			sink: &fakeSink{fail: true, limit: 1},
+			out:  []netip.Addr{ips[0]},
+			err:  ErrResultSink,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := recordCheckIPEntries(context.Background(), tc.sink, ips, entries)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, tc.sink.recorded)
+		})
+	}
+}
+
+func TestRecordCheckIPEntriesNilSink(t *testing.T) {
+	ips := []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+
+	err := recordCheckIPEntries(context.Background(), nil, ips,
+		map[netip.Addr]CheckIPEntry{ips[0]: {Responded: true}})
+	assert.NoError(t, err)
+}
+
+func TestCheckIPActivitySink(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")}
+	scanned := netmon.ScanEntries{
+		ips[0]: {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true},
+		ips[1]: {},
+	}
+
+	testcases := map[string]struct {
+		sink *fakeSink
+		out  []netip.Addr
+		err  error
+	}{
+		"recorded": {
+			sink: &fakeSink{},
+			out:  []netip.Addr{ips[0]},
+		},
+		"sink error": {
+			sink: &fakeSink{fail: true},
+			err:  ErrResultSink,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := checkIPActivity(context.Background(), &netmon.FakeScanner{Entries: scanned}, tc.sink,
+				CheckIPActivityParam{IPs: ips})
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, tc.sink.recorded)
+
+			if err == nil {
+				assert.Equal(t, hwAddr, tc.sink.entries[ips[0]].MAC)
+			}
+		})
+	}
+}
+
+func TestCheckIPHeartbeatActivitySink(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")}
+	scanned := netmon.ScanEntries{
+		ips[0]: {MAC: hwAddr, Responded: true},
+		ips[1]: {MAC: hwAddr, Responded: true},
+	}
+
+	testcases := map[string]struct {
+		sink *fakeSink
+		n    int
+		err  string
+	}{
+		"recorded": {
+			sink: &fakeSink{},
+			n:    2,
+		},
+		"sink error": {
+			sink: &fakeSink{fail: true, limit: 1},
+			n:    1,
+			err:  ErrResultSink.Error(),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var suite testsuite.WorkflowTestSuite
+
+			fn := func(ctx context.Context, param CheckIPActivityParam) (CheckIPActivityResult, error) {
+				return checkIPHeartbeatActivity(ctx, &netmon.FakeScanner{Entries: scanned}, tc.sink, param)
+			}
+
+			env := suite.NewTestActivityEnvironment()
+			env.RegisterActivity(fn)
+
+			val, err := env.ExecuteActivity(fn, CheckIPActivityParam{IPs: ips})
+			assert.Len(t, tc.sink.recorded, tc.n)
+
+			// errors are converted to application errors by the environment
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				var res CheckIPActivityResult
+
+				assert.NoError(t, val.Get(&res))
+				assert.Equal(t, hwAddr, res.IPs[ips[0]])
+			}
+		})
+	}
+}
@@ -0,0 +1,2288 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+
+	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/oui"
+)
+
+func TestValidateCheckIPParam(t *testing.T) {
+	ips := []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+
+	testcases := map[string]struct {
+		in  CheckIPParam
+		err error
+	}{
+		"explicit IPs only": {
+			in: CheckIPParam{IPs: []netip.Addr{netip.MustParseAddr("10.0.0.1")}},
+		},
+		"positive timeout": {
+			in: CheckIPParam{IPs: ips, Timeout: time.Second},
+		},
+		"negative timeout": {
+			in:  CheckIPParam{Timeout: -time.Second},
+			err: ErrInvalidTimeout,
+		},
+		"retries": {
+			in: CheckIPParam{IPs: ips, MaxRetries: 2, RetryInterval: time.Second},
+		},
+		"hostnames": {
+			in: CheckIPParam{Hostnames: []string{"node1.maas"}},
+		},
+		"empty hostname": {
+			in:  CheckIPParam{Hostnames: []string{""}},
+			err: ErrInvalidHostname,
+		},
+		"exclusions": {
+			in: CheckIPParam{
+				IPs:             ips,
+				Exclude:         []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+				ExcludePrefixes: []netip.Prefix{netip.MustParsePrefix("10.0.1.0/24")},
+			},
+		},
+		"rate limit": {
+			in: CheckIPParam{IPs: ips, RateLimit: 100},
+		},
+		"negative rate limit": {
+			in:  CheckIPParam{IPs: ips, RateLimit: -1},
+			err: ErrInvalidRateLimit,
+		},
+		"negative jitter": {
+			in:  CheckIPParam{IPs: ips, Jitter: -time.Second},
+			err: ErrInvalidJitter,
+		},
+		"negative spread window": {
+			in:  CheckIPParam{IPs: ips, SpreadOver: -time.Second},
+			err: ErrInvalidSpreadOver,
+		},
+		"negative probe timeout": {
+			in:  CheckIPParam{IPs: ips, ProbeTimeout: -time.Second},
+			err: ErrInvalidTimeout,
+		},
+		"negative overall deadline": {
+			in:  CheckIPParam{IPs: ips, OverallDeadline: -time.Second},
+			err: ErrInvalidOverallDeadline,
+		},
+		"known address without hardware address": {
+			in:  CheckIPParam{IPs: ips, Known: map[netip.Addr]net.HardwareAddr{ips[0]: nil}},
+			err: ErrInvalidKnown,
+		},
+		"passive with ping fallback": {
+			in:  CheckIPParam{IPs: ips, Passive: true, PingFallback: true},
+			err: ErrInvalidPassive,
+		},
+		"passive with conflict detection": {
+			in:  CheckIPParam{IPs: ips, Passive: true, DetectConflicts: true},
+			err: ErrInvalidPassive,
+		},
+		"negative cache age": {
+			in:  CheckIPParam{IPs: ips, MaxCacheAge: -time.Second},
+			err: ErrInvalidCacheAge,
+		},
+		"invalid excluded address": {
+			in:  CheckIPParam{IPs: ips, Exclude: []netip.Addr{{}}},
+			err: ErrInvalidIP,
+		},
+		"invalid excluded prefix": {
+			in:  CheckIPParam{IPs: ips, ExcludePrefixes: []netip.Prefix{{}}},
+			err: ErrInvalidPrefix,
+		},
+		"negative probe retries": {
+			in:  CheckIPParam{IPs: ips, Retries: -1},
+			err: ErrInvalidRetry,
+		},
+		"negative retries": {
+			in:  CheckIPParam{MaxRetries: -1},
+			err: ErrInvalidRetry,
+		},
+		"negative retry interval": {
+			in:  CheckIPParam{MaxRetries: 1, RetryInterval: -time.Second},
+			err: ErrInvalidRetry,
+		},
+		"signal grace period": {
+			in: CheckIPParam{IPs: ips, SignalGracePeriod: time.Second},
+		},
+		"negative signal grace period": {
+			in:  CheckIPParam{IPs: ips, SignalGracePeriod: -time.Second},
+			err: ErrInvalidGracePeriod,
+		},
+		"invalid address": {
+			in:  CheckIPParam{IPs: []netip.Addr{{}}},
+			err: ErrInvalidIP,
+		},
+		"unspecified address": {
+			in:  CheckIPParam{IPs: []netip.Addr{netip.MustParseAddr("::")}},
+			err: ErrInvalidIP,
+		},
+		"IPv4-mapped unspecified address": {
+			in:  CheckIPParam{IPs: []netip.Addr{netip.MustParseAddr("::ffff:0.0.0.0")}},
+			err: ErrInvalidIP,
+		},
+		"negative max IPs": {
+			in:  CheckIPParam{IPs: ips, MaxIPs: -1},
+			err: ErrInvalidMaxIPs,
+		},
+		"VLAN on interface": {
+			in: CheckIPParam{IPs: ips, Interface: "eth0", VLANID: 100},
+		},
+		"VLAN on interfaces": {
+			in: CheckIPParam{IPs: ips, Interfaces: []string{"eth0", "eth1"}, VLANID: 100},
+		},
+		"VLAN above maximum": {
+			in:  CheckIPParam{IPs: ips, Interface: "eth0", VLANID: 4095},
+			err: ErrInvalidVLAN,
+		},
+		"VLAN without interface": {
+			in:  CheckIPParam{IPs: ips, VLANID: 100},
+			err: ErrInvalidVLAN,
+		},
+		"VLAN with ping fallback": {
+			in:  CheckIPParam{IPs: ips, Interface: "eth0", VLANID: 100, PingFallback: true},
+			err: ErrInvalidVLAN,
+		},
+		"source IP": {
+			in: CheckIPParam{IPs: ips, Interface: "eth0", SourceIP: netip.MustParseAddr("10.0.0.254")},
+		},
+		"IPv6 source IP": {
+			in:  CheckIPParam{IPs: ips, Interface: "eth0", SourceIP: netip.MustParseAddr("fd00::1")},
+			err: ErrInvalidSourceIP,
+		},
+		"source IP without interface": {
+			in:  CheckIPParam{IPs: ips, SourceIP: netip.MustParseAddr("10.0.0.254")},
+			err: ErrInvalidSourceIP,
+		},
+		"source IP on interfaces": {
+			in:  CheckIPParam{IPs: ips, Interfaces: []string{"eth0", "eth1"}, SourceIP: netip.MustParseAddr("10.0.0.254")},
+			err: ErrInvalidSourceIP,
+		},
+		"source IP with a passive check": {
+			in:  CheckIPParam{IPs: ips, Interface: "eth0", Passive: true, SourceIP: netip.MustParseAddr("10.0.0.254")},
+			err: ErrInvalidSourceIP,
+		},
+		"source IP with conflict detection": {
+			in:  CheckIPParam{IPs: ips, Interface: "eth0", DetectConflicts: true, SourceIP: netip.MustParseAddr("10.0.0.254")},
+			err: ErrInvalidSourceIP,
+		},
+		"addresses within max IPs": {
+			in: CheckIPParam{
+				IPs:      ips,
+				Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/30")},
+				MaxIPs:   3,
+			},
+		},
+		"addresses above max IPs": {
+			in: CheckIPParam{
+				IPs:      ips,
+				Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/30")},
+				Ranges: []IPRange{{
+					Start: netip.MustParseAddr("10.0.0.1"),
+					End:   netip.MustParseAddr("10.0.0.2"),
+				}},
+				MaxIPs: 4,
+			},
+			err: ErrTooManyIPs,
+		},
+		"addresses above default max IPs": {
+			in: CheckIPParam{
+				IPs:      ips,
+				Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")},
+				Ranges: []IPRange{{
+					Start: netip.MustParseAddr("10.1.0.0"),
+					End:   netip.MustParseAddr("10.1.0.2"),
+				}},
+			},
+			err: ErrTooManyIPs,
+		},
+		"interface and interfaces": {
+			in:  CheckIPParam{Interface: "eth0", Interfaces: []string{"eth1"}},
+			err: ErrInvalidInterfaces,
+		},
+		"negative activity max attempts": {
+			in:  CheckIPParam{IPs: ips, ActivityMaxAttempts: -1},
+			err: ErrInvalidRetry,
+		},
+		"negative activity initial interval": {
+			in:  CheckIPParam{IPs: ips, ActivityInitialInterval: -time.Second},
+			err: ErrInvalidRetry,
+		},
+		"negative batch size": {
+			in:  CheckIPParam{BatchSize: -1},
+			err: ErrInvalidBatchSize,
+		},
+		"negative receive buffer": {
+			in:  CheckIPParam{IPs: ips, RecvBufferBytes: -1},
+			err: ErrInvalidRecvBufferBytes,
+		},
+		"unknown address policy": {
+			in:  CheckIPParam{IPs: ips, AddressPolicy: CheckIPAddressPolicy(0xff)},
+			err: ErrInvalidAddressPolicy,
+		},
+		"negative parallel subnets": {
+			in: CheckIPParam{
+				IPs:             []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+				ParallelSubnets: -1,
+			},
+			err: ErrInvalidParallelSubnets,
+		},
+		"negative subnet size": {
+			in: CheckIPParam{
+				IPs:        []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+				SubnetSize: -1,
+			},
+			err: ErrInvalidSubnetSize,
+		},
+		"invalid MAC prefix": {
+			in:  CheckIPParam{IPs: ips, MACPrefixes: []string{"00:1a", "not-a-prefix"}},
+			err: ErrInvalidMACPrefix,
+		},
+		"IPv4 /16 prefix": {
+			in: CheckIPParam{Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")}},
+		},
+		"IPv4 /15 prefix": {
+			in:  CheckIPParam{Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/15")}},
+			err: ErrPrefixTooLarge,
+		},
+		"IPv6 /112 prefix": {
+			in: CheckIPParam{Prefixes: []netip.Prefix{netip.MustParsePrefix("fd00::/112")}},
+		},
+		"IPv6 /64 prefix": {
+			in:  CheckIPParam{Prefixes: []netip.Prefix{netip.MustParsePrefix("fd00::/64")}},
+			err: ErrPrefixTooLarge,
+		},
+		"invalid prefix": {
+			in:  CheckIPParam{Prefixes: []netip.Prefix{{}}},
+			err: ErrInvalidPrefix,
+		},
+		"valid range": {
+			in: CheckIPParam{Ranges: []IPRange{{
+				Start: netip.MustParseAddr("10.0.0.1"),
+				End:   netip.MustParseAddr("10.0.0.10"),
+			}}},
+		},
+		"reversed range": {
+			in: CheckIPParam{Ranges: []IPRange{{
+				Start: netip.MustParseAddr("10.0.0.10"),
+				End:   netip.MustParseAddr("10.0.0.1"),
+			}}},
+			err: ErrInvalidRange,
+		},
+		"mixed family range": {
+			in: CheckIPParam{Ranges: []IPRange{{
+				Start: netip.MustParseAddr("10.0.0.1"),
+				End:   netip.MustParseAddr("fd00::1"),
+			}}},
+			err: ErrInvalidRange,
+		},
+		"too large range": {
+			in: CheckIPParam{Ranges: []IPRange{{
+				Start: netip.MustParseAddr("10.0.0.0"),
+				End:   netip.MustParseAddr("10.1.0.0"),
+			}}},
+			err: ErrRangeTooLarge,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := validateCheckIPParam(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestCheckIPDryRun(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	// no scan activity is registered, running one fails the workflow
+	env.ExecuteWorkflow(CheckIP, CheckIPParam{
+		IPs: []netip.Addr{
+			netip.MustParseAddr("10.0.1.1"),
+			netip.MustParseAddr("127.0.0.1"),
+			netip.MustParseAddr("10.0.0.1"),
+		},
+		Prefixes:      []netip.Prefix{netip.MustParsePrefix("10.0.0.0/29")},
+		Exclude:       []netip.Addr{netip.MustParseAddr("10.0.0.2")},
+		AddressPolicy: AddressPolicyGlobalOnly,
+		DryRun:        true,
+	})
+
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var res CheckIPResult
+
+	assert.NoError(t, env.GetWorkflowResult(&res))
+	// addresses of IPs and expanded prefixes are sorted together
+	assert.Equal(t, []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.3"),
+		netip.MustParseAddr("10.0.0.4"),
+		netip.MustParseAddr("10.0.0.5"),
+		netip.MustParseAddr("10.0.0.6"),
+		netip.MustParseAddr("10.0.1.1"),
+	}, res.Planned)
+	assert.Equal(t, []netip.Addr{
+		netip.MustParseAddr("127.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+	}, res.Skipped)
+	assert.Equal(t, 6, res.Total)
+	assert.Empty(t, res.IPs)
+	assert.Empty(t, res.Entries)
+	assert.Empty(t, res.Unresolved)
+}
+
+func TestPrefixHosts(t *testing.T) {
+	testcases := map[string]struct {
+		in  netip.Prefix
+		out []netip.Addr
+	}{
+		"IPv4 /30 skips network and broadcast": {
+			in: netip.MustParsePrefix("10.0.0.0/30"),
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+			},
+		},
+		"IPv4 /31 point-to-point": {
+			in: netip.MustParsePrefix("10.0.0.0/31"),
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.0"),
+				netip.MustParseAddr("10.0.0.1"),
+			},
+		},
+		"IPv4 /32": {
+			in:  netip.MustParsePrefix("10.0.0.1/32"),
+			out: []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+		},
+		"IPv4 unmasked prefix": {
+			in: netip.MustParsePrefix("10.0.0.2/30"),
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+			},
+		},
+		"IPv6 /126 keeps all addresses": {
+			in: netip.MustParsePrefix("fd00::/126"),
+			out: []netip.Addr{
+				netip.MustParseAddr("fd00::"),
+				netip.MustParseAddr("fd00::1"),
+				netip.MustParseAddr("fd00::2"),
+				netip.MustParseAddr("fd00::3"),
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			res, err := prefixHosts(tc.in)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.out, res)
+			assert.Equal(t, len(tc.out), prefixHostCount(tc.in))
+		})
+	}
+}
+
+func TestPrefixHostsTooLarge(t *testing.T) {
+	// expanding a /64 would allocate 2^64 addresses
+	_, err := prefixHosts(netip.MustParsePrefix("fd00::/64"))
+	assert.ErrorIs(t, err, ErrPrefixTooLarge)
+
+	_, err = expandCheckIPParam(context.TODO(), CheckIPParam{
+		Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	})
+	assert.ErrorIs(t, err, ErrPrefixTooLarge)
+}
+
+func TestExpandCheckIPParam(t *testing.T) {
+	testcases := map[string]struct {
+		in  CheckIPParam
+		out []netip.Addr
+	}{
+		"explicit IPs are sorted": {
+			in: CheckIPParam{IPs: []netip.Addr{
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.0.1"),
+			}},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+			},
+		},
+		"prefix is merged with explicit IPs": {
+			in: CheckIPParam{
+				IPs:      []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+				Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/30")},
+			},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+			},
+		},
+		"ranges and prefixes are sorted with explicit IPs": {
+			in: CheckIPParam{
+				IPs:      []netip.Addr{netip.MustParseAddr("10.0.0.9"), netip.MustParseAddr("10.0.0.5")},
+				Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/30")},
+				Ranges: []IPRange{{
+					Start: netip.MustParseAddr("::ffff:10.0.0.8"),
+					End:   netip.MustParseAddr("::ffff:10.0.0.9"),
+				}},
+			},
+			// mapped addresses of the range are duplicates of their IPv4 forms
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.0.5"),
+				netip.MustParseAddr("10.0.0.8"),
+				netip.MustParseAddr("10.0.0.9"),
+			},
+		},
+		"overlapping prefixes": {
+			in: CheckIPParam{
+				Prefixes: []netip.Prefix{
+					netip.MustParsePrefix("10.0.0.0/29"),
+					netip.MustParsePrefix("10.0.0.4/30"),
+				},
+			},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.0.3"),
+				netip.MustParseAddr("10.0.0.4"),
+				netip.MustParseAddr("10.0.0.5"),
+				netip.MustParseAddr("10.0.0.6"),
+			},
+		},
+		"overlapping range and prefix": {
+			in: CheckIPParam{
+				Prefixes: []netip.Prefix{netip.MustParsePrefix("fd00::/127")},
+				Ranges: []IPRange{{
+					Start: netip.MustParseAddr("fd00::1"),
+					End:   netip.MustParseAddr("fd00::2"),
+				}},
+			},
+			out: []netip.Addr{
+				netip.MustParseAddr("fd00::"),
+				netip.MustParseAddr("fd00::1"),
+				netip.MustParseAddr("fd00::2"),
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			res, err := expandCheckIPParam(context.TODO(), tc.in)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.out, res)
+		})
+	}
+}
+
+func TestUnresolved(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	testcases := map[string]struct {
+		ips     []netip.Addr
+		scanned map[netip.Addr]net.HardwareAddr
+		out     []netip.Addr
+	}{
+		"all resolved": {
+			ips:     []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+			scanned: map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): hwAddr},
+		},
+		"keeps order of scanned addresses": {
+			ips: []netip.Addr{
+				netip.MustParseAddr("10.0.0.3"),
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+			},
+			scanned: map[netip.Addr]net.HardwareAddr{
+				netip.MustParseAddr("10.0.0.1"): hwAddr,
+				netip.MustParseAddr("10.0.0.2"): nil,
+			},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.3"),
+				netip.MustParseAddr("10.0.0.2"),
+			},
+		},
+		"duplicates are reported once": {
+			ips: []netip.Addr{
+				netip.MustParseAddr("fd00::1"),
+				netip.MustParseAddr("fd00::1"),
+			},
+			scanned: map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("fd00::1"): nil},
+			out:     []netip.Addr{netip.MustParseAddr("fd00::1")},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, unresolved(tc.ips, tc.scanned))
+		})
+	}
+}
+
+func TestSelfAddresses(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.3"),
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.0.3"),
+	}
+	entries := map[netip.Addr]CheckIPEntry{
+		ips[0]: {Self: true},
+		ips[1]: {Responded: true},
+		ips[2]: {},
+	}
+
+	assert.Equal(t, []netip.Addr{ips[0]}, selfAddresses(ips, entries))
+	assert.Equal(t, []netip.Addr{ips[1], ips[2]}, withoutSelf(ips, entries))
+	assert.Nil(t, selfAddresses(ips, nil))
+
+	res := checkIPEntries(netmon.ScanEntries{ips[0]: {Self: true}})
+	assert.True(t, res[ips[0]].Self)
+}
+
+func TestScanError(t *testing.T) {
+	testcases := map[string]struct {
+		in           error
+		errType      string
+		nonRetryable bool
+	}{
+		"interface not found": {
+			in:           fmt.Errorf("%w: eth9", netmon.ErrInterfaceNotFound),
+			errType:      "scanInterfaceNotFound",
+			nonRetryable: true,
+		},
+		"not permitted to scan": {
+			in:           fmt.Errorf("%w: socket: operation not permitted", netmon.ErrPermissionDenied),
+			errType:      "scanNotPermitted",
+			nonRetryable: true,
+		},
+		"no probes sent": {
+			in:      fmt.Errorf("%w: sendto: no buffer space available", netmon.ErrNoProbesSent),
+			errType: "scanNoProbesSent",
+		},
+		"no raw socket": {
+			in:      fmt.Errorf("%w: socket: address family not supported by protocol", netmon.ErrNoRawSocket),
+			errType: "scanNoRawSocket",
+		},
+		"other permanent error": {
+			in:           syscall.EPERM,
+			errType:      "permanentScanError",
+			nonRetryable: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var appErr *temporal.ApplicationError
+
+			err := scanError(tc.in)
+			assert.ErrorAs(t, err, &appErr)
+			assert.ErrorIs(t, err, tc.in)
+			assert.Equal(t, tc.errType, appErr.Type())
+			assert.Equal(t, tc.nonRetryable, appErr.NonRetryable())
+		})
+	}
+
+	err := os.NewSyscallError("sendto", syscall.ENOBUFS)
+	assert.Equal(t, err, scanError(err))
+}
+
+func TestResolveVendors(t *testing.T) {
+	scanned := map[netip.Addr]net.HardwareAddr{
+		netip.MustParseAddr("10.0.0.1"): {0x00, 0x50, 0x56, 0x01, 0x02, 0x03},
+		netip.MustParseAddr("10.0.0.2"): {0x52, 0x54, 0x00, 0x01, 0x02, 0x03},
+		netip.MustParseAddr("10.0.0.3"): {0x00, 0x00, 0x01, 0x01, 0x02, 0x03},
+		netip.MustParseAddr("10.0.0.4"): nil,
+	}
+
+	res, err := resolveVendors(context.TODO(), scanned)
+	assert.NoError(t, err)
+	assert.Equal(t, map[netip.Addr]string{
+		netip.MustParseAddr("10.0.0.1"): "VMware, Inc.",
+		netip.MustParseAddr("10.0.0.2"): oui.LocallyAdministered,
+		netip.MustParseAddr("10.0.0.3"): "",
+	}, res)
+}
+
+func TestLookupHostnames(t *testing.T) {
+	scanned := map[netip.Addr]net.HardwareAddr{
+		netip.MustParseAddr("10.0.0.1"): {0x00, 0x50, 0x56, 0x01, 0x02, 0x03},
+		netip.MustParseAddr("10.0.0.2"): {0x00, 0x50, 0x56, 0x01, 0x02, 0x04},
+		netip.MustParseAddr("10.0.0.3"): {0x00, 0x50, 0x56, 0x01, 0x02, 0x05},
+		netip.MustParseAddr("fd00::1"):  {0x00, 0x50, 0x56, 0x01, 0x02, 0x06},
+		netip.MustParseAddr("10.0.0.4"): nil,
+	}
+
+	lookup := func(ctx context.Context, addr string) ([]string, error) {
+		switch addr {
+		case "10.0.0.1":
+			return []string{"node1.maas.", "alias.maas."}, nil
+		case "fd00::1":
+			return []string{"node6.maas."}, nil
+		case "10.0.0.2":
+			// a lookup that never completes is bound by its timeout
+			<-ctx.Done()
+			return nil, ctx.Err()
+		default:
+			return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+		}
+	}
+
+	assert.Equal(t, map[netip.Addr]string{
+		netip.MustParseAddr("10.0.0.1"): "node1.maas",
+		netip.MustParseAddr("10.0.0.2"): "",
+		netip.MustParseAddr("10.0.0.3"): "",
+		netip.MustParseAddr("fd00::1"):  "node6.maas",
+	}, lookupHostnames(context.TODO(), scanned, lookup))
+}
+
+func TestLookupNames(t *testing.T) {
+	lookup := func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		switch host {
+		case "node1.maas":
+			return []netip.Addr{netip.MustParseAddr("::ffff:10.0.0.1"), netip.MustParseAddr("fd00::1")}, nil
+		case "slow.maas":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		default:
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+	}
+
+	assert.Equal(t, map[string][]netip.Addr{
+		"node1.maas":   {netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("fd00::1")},
+		"slow.maas":    nil,
+		"unknown.maas": nil,
+	}, lookupNames(context.TODO(), []string{"node1.maas", "slow.maas", "unknown.maas", "node1.maas"}, lookup))
+}
+
+func TestResolveHostnamesTimeout(t *testing.T) {
+	assert.Equal(t, checkIPHostnameTimeout+checkIPActivityMargin, resolveHostnamesTimeout(0))
+	assert.Equal(t, checkIPHostnameTimeout+checkIPActivityMargin,
+		resolveHostnamesTimeout(checkIPHostnameConcurrency))
+	assert.Equal(t, 2*checkIPHostnameTimeout+checkIPActivityMargin,
+		resolveHostnamesTimeout(checkIPHostnameConcurrency+1))
+}
+
+func TestCountUnique(t *testing.T) {
+	testcases := map[string]struct {
+		in  []netip.Addr
+		out int
+	}{
+		"empty": {},
+		"duplicates are counted once": {
+			in: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.0.1"),
+			},
+			out: 2,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, countUnique(tc.in))
+		})
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xC0, 0xFF, 0xEE, 0x15, 0xC0, 0x01}
+	other := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+
+	testcases := map[string]struct {
+		in  CheckIPResult
+		out map[string][]netip.Addr
+	}{
+		"no conflicts": {
+			in: CheckIPResult{
+				IPs: map[netip.Addr]net.HardwareAddr{
+					netip.MustParseAddr("10.0.0.1"): hwAddr,
+					netip.MustParseAddr("10.0.0.2"): other,
+					netip.MustParseAddr("10.0.0.3"): nil,
+				},
+			},
+		},
+		"same hardware address for two addresses": {
+			in: CheckIPResult{
+				IPs: map[netip.Addr]net.HardwareAddr{
+					netip.MustParseAddr("10.0.0.1"): other,
+					netip.MustParseAddr("10.0.0.2"): hwAddr,
+					netip.MustParseAddr("10.0.0.3"): hwAddr,
+				},
+			},
+			out: map[string][]netip.Addr{
+				"c0:ff:ee:15:c0:01": {
+					netip.MustParseAddr("10.0.0.2"),
+					netip.MustParseAddr("10.0.0.3"),
+				},
+			},
+		},
+		"two hosts answering one address": {
+			in: CheckIPResult{
+				IPs: map[netip.Addr]net.HardwareAddr{
+					netip.MustParseAddr("10.0.0.1"): hwAddr,
+				},
+				Entries: map[netip.Addr]CheckIPEntry{
+					netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr, other},
+						Responded: true},
+				},
+			},
+		},
+		"secondary reply for another address": {
+			in: CheckIPResult{
+				IPs: map[netip.Addr]net.HardwareAddr{
+					netip.MustParseAddr("10.0.0.1"): hwAddr,
+					netip.MustParseAddr("10.0.0.2"): other,
+				},
+				Entries: map[netip.Addr]CheckIPEntry{
+					netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr, other},
+						Responded: true},
+				},
+			},
+			out: map[string][]netip.Addr{
+				"c0:ff:ee:15:c0:02": {
+					netip.MustParseAddr("10.0.0.1"),
+					netip.MustParseAddr("10.0.0.2"),
+				},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, conflicts(tc.in))
+		})
+	}
+}
+
+func TestInvertResult(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xC0, 0xFF, 0xEE, 0x15, 0xC0, 0x01}
+	other := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+
+	testcases := map[string]struct {
+		in  CheckIPResult
+		out map[string][]netip.Addr
+	}{
+		"empty": {
+			out: map[string][]netip.Addr{},
+		},
+		"unresolved addresses are left out": {
+			in: CheckIPResult{IPs: map[netip.Addr]net.HardwareAddr{
+				netip.MustParseAddr("10.0.0.1"): hwAddr,
+				netip.MustParseAddr("10.0.0.2"): nil,
+			}},
+			out: map[string][]netip.Addr{
+				"c0:ff:ee:15:c0:01": {netip.MustParseAddr("10.0.0.1")},
+			},
+		},
+		"shared hardware address is sorted": {
+			in: CheckIPResult{IPs: map[netip.Addr]net.HardwareAddr{
+				netip.MustParseAddr("10.0.0.3"): hwAddr,
+				netip.MustParseAddr("10.0.0.1"): hwAddr,
+				netip.MustParseAddr("10.0.0.2"): other,
+			}},
+			out: map[string][]netip.Addr{
+				"c0:ff:ee:15:c0:01": {netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.3")},
+				"c0:ff:ee:15:c0:02": {netip.MustParseAddr("10.0.0.2")},
+			},
+		},
+		"address with several hardware addresses": {
+			in: CheckIPResult{
+				IPs: map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): hwAddr},
+				Entries: map[netip.Addr]CheckIPEntry{
+					netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr, other}},
+				},
+			},
+			out: map[string][]netip.Addr{
+				"c0:ff:ee:15:c0:01": {netip.MustParseAddr("10.0.0.1")},
+				"c0:ff:ee:15:c0:02": {netip.MustParseAddr("10.0.0.1")},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, InvertResult(tc.in))
+		})
+	}
+}
+
+func TestCheckIPResultSorted(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("fd00::1"),
+		netip.MustParseAddr("10.0.0.10"),
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("192.0.2.1"),
+	}
+
+	res := CheckIPResult{
+		IPs: map[netip.Addr]net.HardwareAddr{
+			ips[0]: hwAddr, ips[1]: nil, ips[2]: hwAddr, ips[3]: nil, ips[4]: hwAddr,
+		},
+		Entries: map[netip.Addr]CheckIPEntry{
+			ips[0]: {MAC: hwAddr, Responded: true},
+			ips[1]: {},
+			ips[2]: {MAC: hwAddr, Responded: true},
+			ips[3]: {Error: "address is not on-link"},
+		},
+	}
+
+	assert.Equal(t, []CheckIPAddrEntry{
+		{IP: ips[3], Entry: CheckIPEntry{Error: "address is not on-link"}},
+		{IP: ips[0], Entry: CheckIPEntry{MAC: hwAddr, Responded: true}},
+		{IP: ips[2], Entry: CheckIPEntry{MAC: hwAddr, Responded: true}},
+		// addresses without an entry get one of their hardware address
+		{IP: ips[4], Entry: CheckIPEntry{MAC: hwAddr, Responded: true}},
+		{IP: ips[1], Entry: CheckIPEntry{}},
+	}, res.Sorted())
+
+	assert.Empty(t, CheckIPResult{}.Sorted())
+}
+
+func TestPingAddrs(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("192.0.2.3"),
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("192.0.2.2"),
+	}
+
+	testcases := map[string]struct {
+		scanner *netmon.FakeScanner
+		out     []netip.Addr
+		err     error
+	}{
+		"alive in scan order": {
+			scanner: &netmon.FakeScanner{Entries: netmon.ScanEntries{
+				ips[0]: {Responded: true},
+				ips[1]: {Responded: true},
+			}},
+			out: []netip.Addr{ips[0], ips[1]},
+		},
+		"none alive": {
+			scanner: &netmon.FakeScanner{},
+		},
+		"ping error": {
+			scanner: &netmon.FakeScanner{Err: netmon.ErrPermissionDenied},
+			err:     netmon.ErrPermissionDenied,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := pingAddrs(context.Background(), tc.scanner, CheckIPActivityParam{IPs: ips})
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, out)
+			assert.Equal(t, [][]netip.Addr{ips}, tc.scanner.Scanned())
+		})
+	}
+}
+
+func TestCheckIPActivity(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")}
+
+	testcases := map[string]struct {
+		scanner *netmon.FakeScanner
+		out     map[netip.Addr]net.HardwareAddr
+		err     error
+		// partial are addresses attached to err
+		partial map[netip.Addr]net.HardwareAddr
+	}{
+		"scanned": {
+			scanner: &netmon.FakeScanner{Entries: netmon.ScanEntries{
+				ips[0]: {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true},
+				ips[1]: {},
+			}},
+			out: map[netip.Addr]net.HardwareAddr{ips[0]: hwAddr, ips[1]: nil},
+		},
+		"scan error": {
+			scanner: &netmon.FakeScanner{Err: netmon.ErrInterfaceNotFound},
+			err:     netmon.ErrInterfaceNotFound,
+		},
+		"scan error after a reply": {
+			scanner: &netmon.FakeScanner{
+				Entries: netmon.ScanEntries{
+					ips[0]: {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true},
+					ips[1]: {},
+				},
+				Err: netmon.ErrInterfaceDown,
+			},
+			err:     netmon.ErrInterfaceDown,
+			partial: map[netip.Addr]net.HardwareAddr{ips[0]: hwAddr},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := checkIPActivity(context.Background(), tc.scanner, nil, CheckIPActivityParam{IPs: ips})
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, [][]netip.Addr{ips}, tc.scanner.Scanned())
+			assert.Equal(t, tc.out, res.IPs)
+
+			partial, ok := partialActivityResult(err)
+			assert.Equal(t, tc.partial != nil, ok)
+			assert.Equal(t, tc.partial, partial.IPs)
+
+			if err == nil {
+				assert.False(t, res.StartedAt.After(res.FinishedAt))
+				assert.True(t, res.Entries[ips[0]].Responded)
+			}
+		})
+	}
+}
+
+func TestCheckIPHeartbeatActivityEntries(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("192.0.2.2"),
+		netip.MustParseAddr("192.0.2.3"),
+		netip.MustParseAddr("192.0.2.4"),
+	}
+	scanner := &netmon.FakeScanner{Entries: netmon.ScanEntries{
+		ips[0]: {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true, Attempts: 1},
+		ips[1]: {Attempts: 1, Err: netmon.ErrInterfaceDown},
+		ips[2]: {Self: true},
+		ips[3]: {Attempts: 2},
+	}}
+
+	local, err := checkIPActivity(context.Background(), scanner, nil, CheckIPActivityParam{IPs: ips})
+	assert.NoError(t, err)
+
+	var suite testsuite.WorkflowTestSuite
+
+	fn := func(ctx context.Context, param CheckIPActivityParam) (CheckIPActivityResult, error) {
+		return checkIPHeartbeatActivity(ctx, scanner, nil, param)
+	}
+
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(fn)
+
+	val, err := env.ExecuteActivity(fn, CheckIPActivityParam{IPs: ips})
+	assert.NoError(t, err)
+
+	var res CheckIPActivityResult
+
+	assert.NoError(t, val.Get(&res))
+
+	// entries don't depend on whether the scan was streamed
+	for _, ip := range ips {
+		assert.Equal(t, local.Entries[ip].Error, res.Entries[ip].Error, ip)
+		assert.Equal(t, local.Entries[ip].Self, res.Entries[ip].Self, ip)
+		assert.Equal(t, local.Entries[ip].Attempts, res.Entries[ip].Attempts, ip)
+		assert.Equal(t, local.Entries[ip].Responded, res.Entries[ip].Responded, ip)
+	}
+
+	assert.Equal(t, []netip.Addr{ips[2]}, selfAddresses(ips, res.Entries))
+	assert.Equal(t, netmon.ErrInterfaceDown.Error(), res.Entries[ips[1]].Error)
+}
+
+func TestCheckIPActivityDetectConflicts(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("192.0.2.2"),
+		netip.MustParseAddr("fd00::1"),
+	}
+	scanner := &netmon.FakeScanner{Entries: netmon.ScanEntries{
+		ips[0]: {MACs: []net.HardwareAddr{hwAddr}, Responded: true},
+		ips[1]: {},
+		ips[2]: {Err: netmon.ErrInvalidAddr},
+	}}
+
+	res, err := checkIPActivity(context.Background(), scanner, nil,
+		CheckIPActivityParam{IPs: ips, DetectConflicts: true})
+	assert.NoError(t, err)
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{ips[0]: hwAddr, ips[1]: nil, ips[2]: nil}, res.IPs)
+	assert.True(t, res.Entries[ips[0]].Responded)
+	assert.Equal(t, netip.IPv4Unspecified(), res.Entries[ips[1]].SourceIP)
+	assert.Equal(t, "invalid address", res.Entries[ips[2]].Error)
+}
+
+func TestCheckIPActivityPassive(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.3")}
+
+	testcases := map[string]struct {
+		scanner *netmon.FakeScanner
+		out     map[netip.Addr]net.HardwareAddr
+		err     error
+	}{
+		"neighbor table": {
+			scanner: &netmon.FakeScanner{Entries: netmon.ScanEntries{
+				ips[0]:                           {MAC: hwAddr, Responded: true},
+				netip.MustParseAddr("192.0.2.4"): {MAC: hwAddr, Responded: true},
+			}},
+			out: map[netip.Addr]net.HardwareAddr{ips[0]: hwAddr, ips[1]: nil},
+		},
+		"neighbor table error": {
+			scanner: &netmon.FakeScanner{Err: netmon.ErrInterfaceNotFound},
+			err:     netmon.ErrInterfaceNotFound,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := checkIPActivity(context.Background(), tc.scanner, nil,
+				CheckIPActivityParam{IPs: ips, Interface: "eth0", Passive: true})
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, res.IPs)
+			// nothing is sent
+			assert.Empty(t, tc.scanner.Scanned())
+
+			if err == nil {
+				assert.Equal(t, []net.HardwareAddr{hwAddr}, res.Entries[ips[0]].MACs)
+				assert.Equal(t, "eth0", res.Entries[ips[0]].Interface)
+				assert.False(t, res.Entries[ips[1]].Responded)
+			}
+		})
+	}
+}
+
+func TestKnownChanges(t *testing.T) {
+	first := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	second := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.0.3"),
+		netip.MustParseAddr("10.0.0.4"),
+		netip.MustParseAddr("10.0.0.5"),
+		netip.MustParseAddr("10.0.0.6"),
+		netip.MustParseAddr("10.0.0.7"),
+		netip.MustParseAddr("10.0.0.2"),
+	}
+	entries := map[netip.Addr]CheckIPEntry{
+		ips[0]: {MAC: first, Responded: true},
+		ips[1]: {MAC: second, Responded: true},
+		ips[2]: {},
+		ips[3]: {Error: "address is not on-link"},
+		ips[4]: {Self: true},
+		ips[5]: {},
+		ips[6]: {MAC: first, Responded: true},
+	}
+	known := map[netip.Addr]net.HardwareAddr{
+		ips[0]: first,
+		// mapped addresses are compared unmapped
+		netip.AddrFrom16(ips[1].As16()): first,
+		ips[2]:                          first,
+		ips[3]:                          first,
+		ips[4]:                          first,
+		ips[5]:                          first,
+		netip.MustParseAddr("10.0.0.8"): first,
+	}
+
+	changed, disappeared := knownChanges(ips, entries, known, []netip.Addr{ips[5]})
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{ips[1]: second}, changed)
+	assert.Equal(t, []netip.Addr{ips[2]}, disappeared)
+
+	changed, disappeared = knownChanges(ips, entries, nil, nil)
+	assert.Nil(t, changed)
+	assert.Nil(t, disappeared)
+}
+
+func TestScanOptions(t *testing.T) {
+	testcases := map[string]struct {
+		in  CheckIPActivityParam
+		out int
+	}{
+		"timeout and interface": {
+			out: 2,
+		},
+		"single probe": {
+			in:  CheckIPActivityParam{Retries: 1},
+			out: 2,
+		},
+		"retries and rate limit": {
+			in:  CheckIPActivityParam{Retries: 3, RateLimit: 100},
+			out: 4,
+		},
+		"VLAN": {
+			in:  CheckIPActivityParam{Interface: "eth0", VLANID: 100},
+			out: 3,
+		},
+		"source IP": {
+			in:  CheckIPActivityParam{Interface: "eth0", SourceIP: netip.MustParseAddr("10.0.0.254")},
+			out: 3,
+		},
+		"jitter": {
+			in:  CheckIPActivityParam{Jitter: time.Second},
+			out: 3,
+		},
+		"allow non-Ethernet": {
+			in:  CheckIPActivityParam{AllowNonEthernet: true},
+			out: 3,
+		},
+		"receive buffer": {
+			in:  CheckIPActivityParam{RecvBufferBytes: 1 << 20},
+			out: 3,
+		},
+		"batch size": {
+			in:  CheckIPActivityParam{BatchSize: 500},
+			out: 3,
+		},
+		"spread over": {
+			in:  CheckIPActivityParam{SpreadOver: time.Minute},
+			out: 3,
+		},
+		"probe timeout": {
+			in:  CheckIPActivityParam{ProbeTimeout: 5 * time.Second},
+			out: 3,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Len(t, scanOptions(tc.in, time.Second), tc.out)
+		})
+	}
+}
+
+func TestCheckIPScanTimeout(t *testing.T) {
+	testcases := map[string]struct {
+		timeout      time.Duration
+		probeTimeout time.Duration
+		out          time.Duration
+	}{
+		"default": {
+			out: netmon.OperationTimeout,
+		},
+		"timeout": {
+			timeout: 10 * time.Second,
+			out:     10 * time.Second,
+		},
+		"probe timeout alone": {
+			probeTimeout: 20 * time.Second,
+			out:          20 * time.Second,
+		},
+		"probe timeout within timeout": {
+			timeout:      10 * time.Second,
+			probeTimeout: 5 * time.Second,
+			out:          10 * time.Second,
+		},
+		"probe timeout extends timeout": {
+			timeout:      10 * time.Second,
+			probeTimeout: 20 * time.Second,
+			out:          20 * time.Second,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, checkIPScanTimeout(tc.timeout, tc.probeTimeout))
+		})
+	}
+}
+
+func TestScanLogger(t *testing.T) {
+	assert.Nil(t, scanLogger(context.Background(), CheckIPActivityParam{}))
+
+	var suite testsuite.WorkflowTestSuite
+
+	traced := func(ctx context.Context) (int, error) {
+		return len(scanLogger(ctx, CheckIPActivityParam{TraceProbes: true})), nil
+	}
+
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(traced)
+
+	val, err := env.ExecuteActivity(traced)
+	assert.NoError(t, err)
+
+	var n int
+
+	assert.NoError(t, val.Get(&n))
+	assert.Equal(t, 1, n)
+
+	// warnings of scans from a source address are logged without tracing
+	warned := func(ctx context.Context) (int, error) {
+		opts := scanLogger(ctx, CheckIPActivityParam{SourceIP: netip.MustParseAddr("10.0.0.254")})
+		return len(opts), nil
+	}
+
+	env.RegisterActivity(warned)
+
+	val, err = env.ExecuteActivity(warned)
+	assert.NoError(t, err)
+	assert.NoError(t, val.Get(&n))
+	assert.Equal(t, 1, n)
+}
+
+func TestCheckIPEntries(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	entries := netmon.ScanEntries{
+		netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, Responded: true, Latency: time.Millisecond,
+			Interface: "eth0", InterfaceIndex: 2, SourceMAC: hwAddr, SourceIP: netip.MustParseAddr("10.0.0.254"),
+			Attempts: 2, SeenAt: time.Unix(1, 0)},
+		netip.MustParseAddr("10.0.0.2"): {},
+		netip.MustParseAddr("10.0.0.3"): {Warning: netmon.ErrNonEthernet},
+		netip.MustParseAddr("fd00::1"):  {Err: netmon.ErrInvalidAddr},
+	}
+
+	assert.Equal(t, map[netip.Addr]CheckIPEntry{
+		netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, Responded: true, Latency: time.Millisecond,
+			Interface: "eth0", InterfaceIndex: 2, SourceMAC: hwAddr, SourceIP: netip.MustParseAddr("10.0.0.254"),
+			Attempts: 2, SeenAt: time.Unix(1, 0)},
+		netip.MustParseAddr("10.0.0.2"): {},
+		netip.MustParseAddr("10.0.0.3"): {Warning: "hardware address is not an Ethernet address"},
+		netip.MustParseAddr("fd00::1"):  {Error: "invalid address"},
+	}, checkIPEntries(entries))
+}
+
+func TestOnlyResponders(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	live := netip.MustParseAddr("10.0.0.1")
+	silent := netip.MustParseAddr("10.0.0.2")
+	failed := netip.MustParseAddr("10.0.0.3")
+
+	res := CheckIPResult{
+		IPs: map[netip.Addr]net.HardwareAddr{live: hwAddr, silent: nil, failed: nil},
+		Entries: map[netip.Addr]CheckIPEntry{
+			live:   {MAC: hwAddr, Responded: true},
+			silent: {},
+			failed: {Error: "not permitted"},
+		},
+		RTTs:       map[netip.Addr]time.Duration{live: time.Millisecond},
+		Unresolved: []netip.Addr{silent, failed},
+		Vendors:    map[netip.Addr]string{live: "Coffee"},
+		Responded:  1,
+		Total:      3,
+		PerInterface: map[string]map[netip.Addr]net.HardwareAddr{
+			"eth0": {live: hwAddr, silent: nil},
+		},
+		ResolvedHostnames: map[string][]netip.Addr{"node1.maas": {silent, live}},
+		Skipped:           []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+	}
+
+	onlyResponders(&res)
+
+	assert.Equal(t, CheckIPResult{
+		IPs:       map[netip.Addr]net.HardwareAddr{live: hwAddr},
+		Entries:   map[netip.Addr]CheckIPEntry{live: {MAC: hwAddr, Responded: true}},
+		RTTs:      map[netip.Addr]time.Duration{live: time.Millisecond},
+		Vendors:   map[netip.Addr]string{live: "Coffee"},
+		Responded: 1,
+		Total:     3,
+		PerInterface: map[string]map[netip.Addr]net.HardwareAddr{
+			"eth0": {live: hwAddr},
+		},
+		ResolvedHostnames: map[string][]netip.Addr{"node1.maas": {live}},
+		Skipped:           []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+	}, res)
+}
+
+func TestRTTs(t *testing.T) {
+	entries := map[netip.Addr]CheckIPEntry{
+		netip.MustParseAddr("10.0.0.1"): {Responded: true, Latency: time.Millisecond},
+		netip.MustParseAddr("10.0.0.2"): {},
+		netip.MustParseAddr("10.0.0.3"): {Error: "invalid address"},
+	}
+
+	assert.Equal(t, map[netip.Addr]time.Duration{
+		netip.MustParseAddr("10.0.0.1"): time.Millisecond,
+	}, rtts(entries))
+}
+
+func TestMergeCheckIPActivityResult(t *testing.T) {
+	hwAddr := net.HardwareAddr{0x00, 0x50, 0x56, 0x15, 0xc0, 0x01}
+	startedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	finishedAt := startedAt.Add(time.Minute)
+
+	dst := CheckIPActivityResult{
+		IPs: map[netip.Addr]net.HardwareAddr{
+			netip.MustParseAddr("10.0.0.1"): hwAddr,
+			netip.MustParseAddr("10.0.0.2"): nil,
+		},
+		Entries: map[netip.Addr]CheckIPEntry{
+			netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, Responded: true},
+			netip.MustParseAddr("10.0.0.2"): {},
+		},
+		StartedAt:  startedAt,
+		FinishedAt: startedAt.Add(time.Second),
+		Dropped:    1,
+	}
+
+	src := CheckIPActivityResult{
+		IPs: map[netip.Addr]net.HardwareAddr{
+			netip.MustParseAddr("10.0.0.2"): hwAddr,
+		},
+		Entries: map[netip.Addr]CheckIPEntry{
+			netip.MustParseAddr("10.0.0.2"): {MAC: hwAddr, Responded: true},
+		},
+		StartedAt:  finishedAt.Add(-time.Second),
+		FinishedAt: finishedAt,
+		Dropped:    2,
+	}
+
+	assert.Equal(t, 1, mergeCheckIPActivityResult(&dst, src))
+	assert.Equal(t, CheckIPActivityResult{
+		IPs: map[netip.Addr]net.HardwareAddr{
+			netip.MustParseAddr("10.0.0.1"): hwAddr,
+			netip.MustParseAddr("10.0.0.2"): hwAddr,
+		},
+		Entries: map[netip.Addr]CheckIPEntry{
+			netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, Responded: true},
+			netip.MustParseAddr("10.0.0.2"): {MAC: hwAddr, Responded: true},
+		},
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+		Dropped:    3,
+	}, dst)
+}
+
+func TestMergeCheckIPActivityResultWithoutBounds(t *testing.T) {
+	startedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	finishedAt := startedAt.Add(time.Minute)
+
+	dst := CheckIPActivityResult{
+		IPs:        map[netip.Addr]net.HardwareAddr{},
+		Entries:    map[netip.Addr]CheckIPEntry{},
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	}
+
+	src := CheckIPActivityResult{
+		IPs: map[netip.Addr]net.HardwareAddr{
+			netip.MustParseAddr("10.0.0.1"): nil,
+		},
+		Entries: map[netip.Addr]CheckIPEntry{
+			netip.MustParseAddr("10.0.0.1"): {Error: "child workflow failed"},
+		},
+	}
+
+	mergeCheckIPActivityResult(&dst, src)
+	assert.Equal(t, startedAt, dst.StartedAt)
+	assert.Equal(t, finishedAt, dst.FinishedAt)
+}
+
+func TestMergeCheckIPActivityResultKeepsResponded(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	startedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	dst := CheckIPActivityResult{
+		IPs:     map[netip.Addr]net.HardwareAddr{},
+		Entries: map[netip.Addr]CheckIPEntry{},
+	}
+
+	mergeCheckIPActivityResult(&dst, CheckIPActivityResult{
+		IPs:        map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): hwAddr},
+		Entries:    map[netip.Addr]CheckIPEntry{netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, Responded: true}},
+		StartedAt:  startedAt,
+		FinishedAt: startedAt.Add(time.Second),
+	})
+	resolved := mergeCheckIPActivityResult(&dst, CheckIPActivityResult{
+		IPs:        map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): nil},
+		Entries:    map[netip.Addr]CheckIPEntry{netip.MustParseAddr("10.0.0.1"): {}},
+		StartedAt:  startedAt.Add(time.Second),
+		FinishedAt: startedAt.Add(2 * time.Second),
+	})
+
+	assert.Zero(t, resolved)
+	assert.Equal(t, CheckIPActivityResult{
+		IPs:        map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): hwAddr},
+		Entries:    map[netip.Addr]CheckIPEntry{netip.MustParseAddr("10.0.0.1"): {MAC: hwAddr, Responded: true}},
+		StartedAt:  startedAt,
+		FinishedAt: startedAt.Add(2 * time.Second),
+	}, dst)
+}
+
+func TestMergeUnstreamedEntries(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	live := netip.MustParseAddr("10.0.0.1")
+	silent := netip.MustParseAddr("10.0.0.2")
+	failed := netip.MustParseAddr("10.0.0.3")
+
+	res := CheckIPActivityResult{
+		IPs: map[netip.Addr]net.HardwareAddr{live: hwAddr, silent: nil, failed: nil},
+		Entries: map[netip.Addr]CheckIPEntry{
+			live:   {MAC: hwAddr, Responded: true, Latency: time.Millisecond},
+			silent: {},
+			failed: {},
+		},
+	}
+
+	mergeUnstreamedEntries(&res, netmon.ScanEntries{
+		live:   {MAC: hwAddr, Responded: true},
+		silent: {},
+		failed: {Err: netmon.ErrInvalidAddr},
+	})
+
+	// streamed replies are kept, others get the entries of the scan
+	assert.Equal(t, map[netip.Addr]CheckIPEntry{
+		live:   {MAC: hwAddr, Responded: true, Latency: time.Millisecond},
+		silent: {},
+		failed: {Error: netmon.ErrInvalidAddr.Error()},
+	}, res.Entries)
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{live: hwAddr, silent: nil, failed: nil}, res.IPs)
+}
+
+func TestBatches(t *testing.T) {
+	a := netip.MustParseAddr("10.0.0.1")
+	b := netip.MustParseAddr("10.0.0.2")
+	c := netip.MustParseAddr("10.0.0.3")
+
+	testcases := map[string]struct {
+		in   []netip.Addr
+		size int
+		out  [][]netip.Addr
+	}{
+		"empty": {
+			size: 2,
+			out:  [][]netip.Addr{nil},
+		},
+		"single batch": {
+			in:   []netip.Addr{a, b},
+			size: 2,
+			out:  [][]netip.Addr{{a, b}},
+		},
+		"last batch is shorter": {
+			in:   []netip.Addr{a, b, c},
+			size: 2,
+			out:  [][]netip.Addr{{a, b}, {c}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, batches(tc.in, tc.size))
+		})
+	}
+}
+
+func TestCheckIPExclusion(t *testing.T) {
+	in := []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.1.1"),
+		netip.MustParseAddr("10.0.1.200"),
+		netip.MustParseAddr("10.0.2.1"),
+		netip.MustParseAddr("fd00::1"),
+		netip.MustParseAddr("fd00::1:1"),
+		netip.MustParseAddr("fe80::1%eth0"),
+	}
+
+	testcases := map[string]struct {
+		ips      []netip.Addr
+		prefixes []netip.Prefix
+		out      []netip.Addr
+		excluded []netip.Addr
+	}{
+		"nothing excluded": {
+			out: in,
+		},
+		"addresses": {
+			ips: []netip.Addr{
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("::ffff:10.0.2.1"),
+				netip.MustParseAddr("fe80::1%eth0"),
+			},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.1.1"),
+				netip.MustParseAddr("10.0.1.200"),
+				netip.MustParseAddr("fd00::1"),
+				netip.MustParseAddr("fd00::1:1"),
+			},
+			excluded: []netip.Addr{
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.2.1"),
+				netip.MustParseAddr("fe80::1%eth0"),
+			},
+		},
+		"nested and overlapping prefixes": {
+			prefixes: []netip.Prefix{
+				netip.MustParsePrefix("10.0.1.128/25"),
+				netip.MustParsePrefix("10.0.1.7/24"),
+				netip.MustParsePrefix("::ffff:10.0.0.1/128"),
+				netip.MustParsePrefix("fd00::/112"),
+				netip.MustParsePrefix("fe80::/10"),
+			},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.2.1"),
+				netip.MustParseAddr("fd00::1:1"),
+			},
+			excluded: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.1.1"),
+				netip.MustParseAddr("10.0.1.200"),
+				netip.MustParseAddr("fd00::1"),
+				netip.MustParseAddr("fe80::1%eth0"),
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, excluded := newCheckIPExclusion(tc.ips, tc.prefixes).split(in)
+			assert.Equal(t, tc.out, out)
+			assert.Equal(t, tc.excluded, excluded)
+		})
+	}
+}
+
+func TestNewCheckIPExclusion(t *testing.T) {
+	e := newCheckIPExclusion(nil, []netip.Prefix{
+		netip.MustParsePrefix("10.0.1.0/25"),
+		netip.MustParsePrefix("10.0.0.0/16"),
+		netip.MustParsePrefix("fd00::/64"),
+		netip.MustParsePrefix("10.1.0.0/16"),
+	})
+
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/16"),
+		netip.MustParsePrefix("10.1.0.0/16"),
+		netip.MustParsePrefix("fd00::/64"),
+	}, e.prefixes)
+}
+
+func TestSkipUnscannable(t *testing.T) {
+	testcases := map[string]struct {
+		in      []netip.Addr
+		out     []netip.Addr
+		skipped []netip.Addr
+	}{
+		"nothing to skip": {
+			in:  []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+			out: []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+		},
+		"order is kept": {
+			in: []netip.Addr{
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("0.0.0.0"),
+				netip.MustParseAddr("127.0.0.1"),
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("224.0.0.1"),
+				netip.MustParseAddr("::"),
+				netip.MustParseAddr("::1"),
+				netip.MustParseAddr("ff02::1"),
+				netip.MustParseAddr("fd00::1"),
+			},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("fd00::1"),
+			},
+			skipped: []netip.Addr{
+				netip.MustParseAddr("0.0.0.0"),
+				netip.MustParseAddr("127.0.0.1"),
+				netip.MustParseAddr("224.0.0.1"),
+				netip.MustParseAddr("::"),
+				netip.MustParseAddr("::1"),
+				netip.MustParseAddr("ff02::1"),
+			},
+		},
+		"skipped duplicates are reported once": {
+			in: []netip.Addr{
+				netip.MustParseAddr("127.0.0.1"),
+				netip.MustParseAddr("127.0.0.1"),
+			},
+			out:     []netip.Addr{},
+			skipped: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			out, skipped := skipUnscannable(tc.in)
+			assert.Equal(t, tc.out, out)
+			assert.Equal(t, tc.skipped, skipped)
+		})
+	}
+}
+
+func TestNormalizeIPs(t *testing.T) {
+	testcases := map[string]struct {
+		in  []netip.Addr
+		out []netip.Addr
+	}{
+		"empty": {
+			out: []netip.Addr{},
+		},
+		"sorted without duplicates": {
+			in: []netip.Addr{
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.0.10"),
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+			},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.0.10"),
+			},
+		},
+		"IPv4-mapped addresses are unmapped": {
+			in: []netip.Addr{
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("::ffff:10.0.0.1"),
+				netip.MustParseAddr("10.0.0.1"),
+			},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2"),
+			},
+		},
+		"IPv4 before IPv6": {
+			in: []netip.Addr{
+				netip.MustParseAddr("fe80::1%eth1"),
+				netip.MustParseAddr("fd00::1"),
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("fe80::1%eth0"),
+				netip.MustParseAddr("fe80::1"),
+			},
+			out: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("fd00::1"),
+				netip.MustParseAddr("fe80::1"),
+				netip.MustParseAddr("fe80::1%eth0"),
+				netip.MustParseAddr("fe80::1%eth1"),
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, normalizeIPs(tc.in))
+		})
+	}
+}
+
+func TestSubnets(t *testing.T) {
+	testcases := map[string]struct {
+		ips      []netip.Addr
+		prefixes []netip.Prefix
+		size     int
+		out      []subnet
+	}{
+		"empty": {},
+		"grouped by /24 in order of appearance": {
+			ips: []netip.Addr{
+				netip.MustParseAddr("10.0.1.1"),
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.1.2"),
+			},
+			out: []subnet{
+				{
+					prefix: netip.MustParsePrefix("10.0.1.0/24"),
+					ips: []netip.Addr{
+						netip.MustParseAddr("10.0.1.1"),
+						netip.MustParseAddr("10.0.1.2"),
+					},
+				},
+				{
+					prefix: netip.MustParsePrefix("10.0.0.0/24"),
+					ips:    []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+				},
+			},
+		},
+		"grouped by prefixes": {
+			ips: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.1.1"),
+				netip.MustParseAddr("fe80::1%eth0"),
+			},
+			prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/23")},
+			out: []subnet{
+				{
+					prefix: netip.MustParsePrefix("10.0.0.0/23"),
+					ips: []netip.Addr{
+						netip.MustParseAddr("10.0.0.1"),
+						netip.MustParseAddr("10.0.1.1"),
+					},
+				},
+				{
+					prefix: netip.MustParsePrefix("fe80::/120"),
+					ips:    []netip.Addr{netip.MustParseAddr("fe80::1%eth0")},
+				},
+			},
+		},
+		"split by size": {
+			ips: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.1.1"),
+				netip.MustParseAddr("10.0.0.2"),
+				netip.MustParseAddr("10.0.2.1"),
+			},
+			prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/23")},
+			size:     2,
+			out: []subnet{
+				{
+					prefix: netip.MustParsePrefix("10.0.0.0/23"),
+					part:   1,
+					ips: []netip.Addr{
+						netip.MustParseAddr("10.0.0.1"),
+						netip.MustParseAddr("10.0.1.1"),
+					},
+				},
+				{
+					prefix: netip.MustParsePrefix("10.0.0.0/23"),
+					part:   2,
+					ips:    []netip.Addr{netip.MustParseAddr("10.0.0.2")},
+				},
+				{
+					prefix: netip.MustParsePrefix("10.0.2.0/24"),
+					ips:    []netip.Addr{netip.MustParseAddr("10.0.2.1")},
+				},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.out, subnets(tc.ips, tc.prefixes, tc.size))
+		})
+	}
+}
+
+func TestCheckIPSubnetSize(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	var suite testsuite.WorkflowTestSuite
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	var (
+		mu     sync.Mutex
+		counts []int
+	)
+
+	env.RegisterWorkflow(CheckIP)
+	env.RegisterActivity(CheckIPActivity)
+	env.OnActivity(CheckIPActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, param CheckIPActivityParam) (CheckIPActivityResult, error) {
+			mu.Lock()
+			counts = append(counts, len(param.IPs))
+			mu.Unlock()
+
+			res := CheckIPActivityResult{
+				IPs:     make(map[netip.Addr]net.HardwareAddr, len(param.IPs)),
+				Entries: make(map[netip.Addr]CheckIPEntry, len(param.IPs)),
+			}
+
+			for _, ip := range param.IPs {
+				res.IPs[ip] = hwAddr
+				res.Entries[ip] = CheckIPEntry{MAC: hwAddr, Responded: true}
+			}
+
+			return res, nil
+		})
+
+	env.ExecuteWorkflow(CheckIP, CheckIPParam{
+		Prefixes:        []netip.Prefix{netip.MustParsePrefix("10.0.0.0/27")},
+		IPs:             []netip.Addr{netip.MustParseAddr("10.0.0.8")},
+		ParallelSubnets: 2,
+		SubnetSize:      8,
+	})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var res CheckIPResult
+
+	assert.NoError(t, env.GetWorkflowResult(&res))
+	// the address of IPs also in the prefix is scanned once
+	assert.Equal(t, 30, res.Total)
+	assert.Equal(t, 30, res.Responded)
+	assert.Len(t, res.IPs, 30)
+	assert.ElementsMatch(t, []int{8, 8, 8, 6}, counts)
+}
+
+func TestMergePerInterface(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	dst := mergePerInterface(nil, nil)
+	assert.Nil(t, dst)
+
+	dst = mergePerInterface(dst, map[string]map[netip.Addr]net.HardwareAddr{
+		"eth0": {netip.MustParseAddr("10.0.0.1"): hwAddr},
+	})
+	dst = mergePerInterface(dst, map[string]map[netip.Addr]net.HardwareAddr{
+		"eth0": {netip.MustParseAddr("10.0.0.2"): nil},
+		"eth1": {netip.MustParseAddr("10.0.0.2"): hwAddr},
+	})
+
+	assert.Equal(t, map[string]map[netip.Addr]net.HardwareAddr{
+		"eth0": {
+			netip.MustParseAddr("10.0.0.1"): hwAddr,
+			netip.MustParseAddr("10.0.0.2"): nil,
+		},
+		"eth1": {netip.MustParseAddr("10.0.0.2"): hwAddr},
+	}, dst)
+}
+
+func TestNewCheckIPState(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	state := newCheckIPState(nil)
+	assert.Equal(t, CheckIPCarry{
+		Scanned: CheckIPActivityResult{
+			IPs:     map[netip.Addr]net.HardwareAddr{},
+			Entries: map[netip.Addr]CheckIPEntry{},
+		},
+	}, state)
+
+	carry := &CheckIPCarry{
+		Scanned: CheckIPActivityResult{
+			IPs: map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): hwAddr},
+		},
+		IPs:      []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+		Progress: CheckIPProgress{Total: 1, Completed: 1, Resolved: 1},
+	}
+
+	state = newCheckIPState(carry)
+	assert.Equal(t, CheckIPCarry{
+		Scanned: CheckIPActivityResult{
+			IPs:     map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): hwAddr},
+			Entries: map[netip.Addr]CheckIPEntry{},
+		},
+		IPs:      []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+		Progress: CheckIPProgress{Total: 1, Completed: 1, Resolved: 1},
+	}, state)
+}
+
+func TestCheckIPTracker(t *testing.T) {
+	first := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	second := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+
+	progress := &CheckIPProgress{Total: 3}
+	tracker := newCheckIPTracker(progress, map[netip.Addr]net.HardwareAddr{
+		netip.MustParseAddr("10.0.0.1"): first,
+		netip.MustParseAddr("10.0.0.2"): nil,
+	})
+
+	tracker.record(CheckIPActivityResult{
+		IPs: map[netip.Addr]net.HardwareAddr{
+			netip.MustParseAddr("10.0.0.2"): second,
+			netip.MustParseAddr("10.0.0.3"): nil,
+		},
+	}, 1, 2)
+
+	snapshot := tracker.snapshot()
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{
+		netip.MustParseAddr("10.0.0.1"): first,
+		netip.MustParseAddr("10.0.0.2"): second,
+	}, snapshot)
+	assert.Equal(t, CheckIPProgress{Total: 3, Completed: 2, Resolved: 1}, *progress)
+
+	// the snapshot is not changed by later results
+	snapshot[netip.MustParseAddr("10.0.0.1")][0] = 0
+	tracker.record(CheckIPActivityResult{
+		IPs: map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.3"): first},
+	}, 1, 0)
+
+	assert.Len(t, snapshot, 2)
+	assert.Equal(t, first, tracker.snapshot()[netip.MustParseAddr("10.0.0.1")])
+}
+
+func TestCheckIPOverallDeadline(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+
+	var suite testsuite.WorkflowTestSuite
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	// every batch takes a minute, the deadline passes during the second one
+	env.RegisterActivity(CheckIPActivity)
+	env.OnActivity(CheckIPActivity, mock.Anything, mock.Anything).After(time.Minute).Return(
+		func(_ context.Context, param CheckIPActivityParam) (CheckIPActivityResult, error) {
+			res := CheckIPActivityResult{
+				IPs:     make(map[netip.Addr]net.HardwareAddr, len(param.IPs)),
+				Entries: make(map[netip.Addr]CheckIPEntry, len(param.IPs)),
+			}
+
+			for _, ip := range param.IPs {
+				res.IPs[ip] = hwAddr
+				res.Entries[ip] = CheckIPEntry{MAC: hwAddr, Responded: true}
+			}
+
+			return res, nil
+		})
+
+	env.ExecuteWorkflow(CheckIP, CheckIPParam{
+		Prefixes:        []netip.Prefix{netip.MustParsePrefix("10.0.0.0/29")},
+		Timeout:         time.Minute,
+		BatchSize:       2,
+		OverallDeadline: 90 * time.Second,
+		ResolveVendors:  true,
+	})
+
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var res CheckIPResult
+
+	assert.NoError(t, env.GetWorkflowResult(&res))
+	assert.True(t, res.Truncated)
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{
+		netip.MustParseAddr("10.0.0.1"): hwAddr,
+		netip.MustParseAddr("10.0.0.2"): hwAddr,
+	}, res.IPs)
+	assert.Len(t, res.Entries, 2)
+	assert.Equal(t, 2, res.Total)
+	assert.Equal(t, 2, res.Responded)
+	assert.Empty(t, res.Unresolved)
+	assert.Nil(t, res.Vendors)
+}
+
+func TestCheckIPFailedInterfaces(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")}
+
+	testcases := map[string]struct {
+		down   map[string]bool
+		failed []string
+		err    bool
+	}{
+		"one interface down": {
+			down:   map[string]bool{"eth1": true},
+			failed: []string{"eth1"},
+		},
+		"every interface down": {
+			down: map[string]bool{"eth0": true, "eth1": true},
+			err:  true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var suite testsuite.WorkflowTestSuite
+
+			env := suite.NewTestWorkflowEnvironment()
+
+			env.RegisterActivity(CheckIPActivity)
+			env.OnActivity(CheckIPActivity, mock.Anything, mock.Anything).Return(
+				func(_ context.Context, param CheckIPActivityParam) (CheckIPActivityResult, error) {
+					if tc.down[param.Interface] {
+						return CheckIPActivityResult{},
+							scanError(fmt.Errorf("%w: %s", netmon.ErrInterfaceDown, param.Interface))
+					}
+
+					res := CheckIPActivityResult{
+						IPs:     map[netip.Addr]net.HardwareAddr{ips[0]: hwAddr, ips[1]: nil},
+						Entries: map[netip.Addr]CheckIPEntry{ips[0]: {MAC: hwAddr, Responded: true}, ips[1]: {}},
+					}
+
+					return res, nil
+				})
+
+			env.ExecuteWorkflow(CheckIP, CheckIPParam{IPs: ips, Interfaces: []string{"eth0", "eth1"}})
+			assert.True(t, env.IsWorkflowCompleted())
+
+			if tc.err {
+				assert.ErrorContains(t, env.GetWorkflowError(), "interface is down")
+				return
+			}
+
+			assert.NoError(t, env.GetWorkflowError())
+
+			var res CheckIPResult
+
+			assert.NoError(t, env.GetWorkflowResult(&res))
+			assert.Equal(t, map[netip.Addr]net.HardwareAddr{ips[0]: hwAddr, ips[1]: nil}, res.IPs)
+			assert.Equal(t, []netip.Addr{ips[1]}, res.Unresolved)
+			assert.Contains(t, res.PerInterface, "eth0")
+			assert.NotContains(t, res.PerInterface, "eth1")
+
+			for _, iface := range tc.failed {
+				assert.Contains(t, res.FailedInterfaces[iface], "interface is down")
+			}
+		})
+	}
+}
+
+func TestCheckIPPartialResult(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.0.3"),
+		netip.MustParseAddr("10.0.0.4"),
+	}
+
+	var suite testsuite.WorkflowTestSuite
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	// the first batch is scanned, sending fails during the second one once
+	// its first address replied
+	env.RegisterActivity(CheckIPActivity)
+	env.OnActivity(CheckIPActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, param CheckIPActivityParam) (CheckIPActivityResult, error) {
+			entries := map[netip.Addr]CheckIPEntry{
+				param.IPs[0]: {MAC: hwAddr, Responded: true},
+				param.IPs[1]: {},
+			}
+
+			if param.IPs[0] == ips[2] {
+				err := fmt.Errorf("%w: sendto: network is down", netmon.ErrInterfaceDown)
+
+				return CheckIPActivityResult{}, scanError(err, partialScanResult(entries, time.Now())...)
+			}
+
+			res := CheckIPActivityResult{
+				IPs:     map[netip.Addr]net.HardwareAddr{param.IPs[0]: hwAddr, param.IPs[1]: nil},
+				Entries: entries,
+			}
+
+			return res, nil
+		})
+
+	env.ExecuteWorkflow(CheckIP, CheckIPParam{IPs: ips, BatchSize: 2})
+	assert.True(t, env.IsWorkflowCompleted())
+
+	err := env.GetWorkflowError()
+	assert.ErrorContains(t, err, "interface is down")
+
+	var appErr *temporal.ApplicationError
+	if assert.ErrorAs(t, err, &appErr) {
+		assert.Equal(t, "scanInterfaceDown", appErr.Type())
+	}
+
+	res, ok := PartialCheckIPResult(err)
+	assert.True(t, ok)
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{ips[0]: hwAddr, ips[1]: nil, ips[2]: hwAddr}, res.IPs)
+	assert.Equal(t, 3, res.Total)
+	assert.Equal(t, 2, res.Responded)
+	assert.Equal(t, []netip.Addr{ips[1]}, res.Unresolved)
+
+	_, ok = PartialCheckIPResult(errors.New("failed"))
+	assert.False(t, ok)
+}
+
+// TestCheckIPFakeScanner runs CheckIP with its activities scanning with
+// a netmon.FakeScanner, without mocking them
+func TestCheckIPFakeScanner(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.0.3"),
+		netip.MustParseAddr("10.0.0.4"),
+	}
+
+	scanner := &netmon.FakeScanner{Entries: netmon.ScanEntries{
+		ips[0]: {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true},
+		ips[3]: {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true},
+	}}
+
+	SetCheckIPScanner(scanner)
+	defer SetCheckIPScanner(nil)
+
+	var suite testsuite.WorkflowTestSuite
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterActivity(CheckIPActivity)
+
+	env.ExecuteWorkflow(CheckIP, CheckIPParam{
+		IPs:       []netip.Addr{ips[0], ips[1], ips[0], ips[2], ips[3]},
+		Exclude:   []netip.Addr{ips[3]},
+		BatchSize: 2,
+	})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var res CheckIPResult
+
+	assert.NoError(t, env.GetWorkflowResult(&res))
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{ips[0]: hwAddr, ips[1]: nil, ips[2]: nil}, res.IPs)
+	assert.Equal(t, []netip.Addr{ips[1], ips[2]}, res.Unresolved)
+	assert.Equal(t, 3, res.Total)
+	assert.Equal(t, 1, res.Responded)
+
+	// duplicates are scanned once and excluded addresses are not scanned
+	assert.Equal(t, [][]netip.Addr{{ips[0], ips[1]}, {ips[2]}}, scanner.Scanned())
+}
+
+// TestCheckIPHeartbeatSelfAddresses scans more than checkIPHeartbeatThreshold
+// addresses, which a heartbeating activity scans in a single streamed scan
+func TestCheckIPHeartbeatSelfAddresses(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	prefix := netip.MustParsePrefix("10.0.0.0/20")
+	self := netip.MustParseAddr("10.0.0.2")
+	responder := netip.MustParseAddr("10.0.0.3")
+
+	SetCheckIPScanner(&netmon.FakeScanner{Entries: netmon.ScanEntries{
+		self:      {Self: true},
+		responder: {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true},
+	}})
+	defer SetCheckIPScanner(nil)
+
+	var suite testsuite.WorkflowTestSuite
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterActivity(CheckIPActivity)
+	env.RegisterActivity(CheckIPHeartbeatActivity)
+
+	env.ExecuteWorkflow(CheckIP, CheckIPParam{Prefixes: []netip.Prefix{prefix}})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var res CheckIPResult
+
+	assert.NoError(t, env.GetWorkflowResult(&res))
+	assert.Greater(t, res.Total, checkIPHeartbeatThreshold)
+	assert.Equal(t, []netip.Addr{self}, res.SelfAddresses)
+	assert.NotContains(t, res.Unresolved, self)
+	assert.Equal(t, 1, res.Responded)
+	assert.Len(t, res.Unresolved, res.Total-2)
+}
+
+func TestCheckIPMappedAddresses(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	first := netip.MustParseAddr("192.0.2.10")
+	second := netip.MustParseAddr("192.0.2.11")
+
+	scanner := &netmon.FakeScanner{Entries: netmon.ScanEntries{
+		first:  {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true},
+		second: {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true},
+	}}
+
+	SetCheckIPScanner(scanner)
+	defer SetCheckIPScanner(nil)
+
+	var suite testsuite.WorkflowTestSuite
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterActivity(CheckIPActivity)
+
+	env.ExecuteWorkflow(CheckIP, CheckIPParam{
+		IPs: []netip.Addr{
+			netip.MustParseAddr("::ffff:192.0.2.10"),
+			second,
+			// the plain form of a mapped address is a duplicate
+			netip.MustParseAddr("::ffff:192.0.2.11"),
+		},
+	})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var res CheckIPResult
+
+	assert.NoError(t, env.GetWorkflowResult(&res))
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{first: hwAddr, second: hwAddr}, res.IPs)
+	assert.Equal(t, 2, res.Total)
+	assert.Equal(t, 2, res.Responded)
+
+	// mapped addresses are scanned as IPv4 addresses
+	assert.Equal(t, [][]netip.Addr{{first, second}}, scanner.Scanned())
+}
+
+func TestCheckIPDropped(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.0.3"),
+	}
+
+	SetCheckIPScanner(&netmon.FakeScanner{
+		Entries: netmon.ScanEntries{ips[0]: {MAC: hwAddr, MACs: []net.HardwareAddr{hwAddr}, Responded: true}},
+		Dropped: 2,
+	})
+	defer SetCheckIPScanner(nil)
+
+	var suite testsuite.WorkflowTestSuite
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterActivity(CheckIPActivity)
+
+	env.ExecuteWorkflow(CheckIP, CheckIPParam{IPs: ips, BatchSize: 2})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var res CheckIPResult
+
+	assert.NoError(t, env.GetWorkflowResult(&res))
+
+	// drops of every batch are counted, every address was still scanned
+	assert.Equal(t, 4, res.Dropped)
+	assert.False(t, res.Truncated)
+	assert.Equal(t, []netip.Addr{ips[1], ips[2]}, res.Unresolved)
+}
@@ -0,0 +1,54 @@
+package workflow
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+// Changes of the commands of CheckIP are guarded with workflow.GetVersion,
+// so that executions started by an older agent replay on the path they were
+// recorded with after an upgrade, while new executions take the new path.
+// A change adds a change ID, or bumps the maximum version of an existing one,
+// and keeps the older paths until no execution can be running them.
+const (
+	// checkIPBatchedScanChange is the change from a single netmon.Scan local
+	// activity to the batched scan with CheckIPActivity, which came together
+	// with every other CheckIP feature recorded by checkIPBatchedScanVersion
+	checkIPBatchedScanChange = "checkip-batched-scan"
+	// checkIPBatchedScanVersion is the version of CheckIP scanning in batches
+	checkIPBatchedScanVersion workflow.Version = 1
+)
+
+// checkIPV0 holds the local activity of the first version of CheckIP.
+// Histories of that version record it as "Scan", which is the name that the
+// SDK gives to the Scan method value.
+type checkIPV0 struct{}
+
+// Scan scans ips like the local activity of the first version of CheckIP
+func (checkIPV0) Scan(ctx context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+	return netmon.Scan(ctx, ips)
+}
+
+// checkIPDefaultVersion is the first version of CheckIP, which scans
+// param.IPs with a single local activity. It is only run by executions
+// recorded before checkIPBatchedScanChange.
+func checkIPDefaultVersion(ctx workflow.Context, param CheckIPParam) (CheckIPResult, error) {
+	ctx = workflow.WithLocalActivityOptions(ctx, workflow.LocalActivityOptions{
+		ScheduleToCloseTimeout: 5 * time.Second,
+	})
+
+	var scanned map[netip.Addr]net.HardwareAddr
+
+	err := workflow.ExecuteLocalActivity(ctx, checkIPV0{}.Scan, param.IPs).Get(ctx, &scanned)
+	if err != nil {
+		return CheckIPResult{}, err
+	}
+
+	return CheckIPResult{IPs: scanned}, nil
+}
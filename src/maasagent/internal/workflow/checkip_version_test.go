@@ -0,0 +1,18 @@
+package workflow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.temporal.io/sdk/worker"
+)
+
+// TestCheckIPReplayDefaultVersion replays a history recorded by the first
+// version of CheckIP, which scanned with a single netmon.Scan local activity
+func TestCheckIPReplayDefaultVersion(t *testing.T) {
+	replayer := worker.NewWorkflowReplayer()
+	replayer.RegisterWorkflow(CheckIP)
+
+	err := replayer.ReplayWorkflowHistoryFromJSONFile(nil, "testdata/checkip_v0_history.json")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,169 @@
+package workflow
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+const (
+	// CheckIPRecurringStopSignal is the name of the CheckIPRecurring signal
+	// stopping it, its value is ignored
+	CheckIPRecurringStopSignal = "stop"
+	// CheckIPRecurringLastCycleQuery is the name of the CheckIPRecurring query
+	// returning the CheckIPRecurringCycle of the last scan
+	CheckIPRecurringLastCycleQuery = "lastCycle"
+	// CheckIPRecurringCycleSignal is the name of the signal carrying
+	// a CheckIPRecurringCycle sent by CheckIPRecurring after every scan
+	CheckIPRecurringCycleSignal = "check-ip-recurring-cycle"
+)
+
+// CheckIPRecurringParam is a workflow parameter for the CheckIPRecurring
+// workflow
+type CheckIPRecurringParam struct {
+	// CheckIP is the parameter of every scan
+	CheckIP CheckIPParam `json:"check_ip"`
+	// Interval is the time between the end of a scan and the next one
+	Interval time.Duration `json:"interval"`
+	// NotifyWorkflowID is the workflow that receives CheckIPRecurringCycleSignal
+	// after every scan, no workflow is notified when empty
+	NotifyWorkflowID string `json:"notify_workflow_id,omitempty"`
+	// Last is the last scan, it is carried over when the workflow continues
+	// as new. Callers leave it empty.
+	Last *CheckIPRecurringCycle `json:"last,omitempty"`
+}
+
+// CheckIPRecurringCycle is a scan done by CheckIPRecurring
+type CheckIPRecurringCycle struct {
+	// Cycle is the number of the scan, starting with 1
+	Cycle int `json:"cycle"`
+	// Result is the result of CheckIP, or the partial result carried
+	// by its error (see PartialCheckIPResult)
+	Result CheckIPResult `json:"result"`
+	// Error is the error of CheckIP if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// CheckIPRecurring is a Temporal workflow running CheckIP as a child workflow
+// every Interval, to keep the hardware addresses of a subnet fresh without
+// anything outside of MAAS starting the scans. A failed scan is reported like
+// the others and does not stop the workflow. It continues as new after every
+// scan, which keeps its history bounded, and runs until it gets
+// CheckIPRecurringStopSignal, which cancels a running scan, or is cancelled.
+func CheckIPRecurring(ctx workflow.Context, param CheckIPRecurringParam) error {
+	log := workflow.GetLogger(ctx)
+
+	if err := validateCheckIPParam(param.CheckIP); err != nil {
+		return err
+	}
+
+	if param.Interval <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidInterval, param.Interval)
+	}
+
+	err := workflow.SetQueryHandler(ctx, CheckIPRecurringLastCycleQuery, func() (CheckIPRecurringCycle, error) {
+		if param.Last == nil {
+			return CheckIPRecurringCycle{}, nil
+		}
+
+		return *param.Last, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	stop := workflow.GetSignalChannel(ctx, CheckIPRecurringStopSignal)
+	stopped := false
+
+	onStop := func(c workflow.ReceiveChannel, _ bool) {
+		c.Receive(ctx, nil)
+
+		stopped = true
+	}
+
+	cycle := 1
+	if param.Last != nil {
+		cycle = param.Last.Cycle + 1
+	}
+
+	childParam := param.CheckIP
+	childParam.Carry = nil
+
+	cctx, cancel := workflow.WithCancel(ctx)
+	defer cancel()
+
+	cctx = workflow.WithChildOptions(cctx, workflow.ChildWorkflowOptions{
+		WorkflowID: fmt.Sprintf("%s-%d", workflow.GetInfo(ctx).WorkflowExecution.ID, cycle),
+	})
+
+	var (
+		res     CheckIPResult
+		scanErr error
+	)
+
+	scan := workflow.ExecuteChildWorkflow(cctx, CheckIP, childParam)
+
+	selector := workflow.NewSelector(ctx)
+	selector.AddFuture(scan, func(f workflow.Future) {
+		scanErr = f.Get(ctx, &res)
+	})
+	selector.AddReceive(stop, onStop)
+	selector.Select(ctx)
+
+	if stopped {
+		log.Info("Stopping recurring IP check", tag.Builder().KV("cycle", cycle).KeyVals...)
+
+		// the scan is waited for, so that it is not left running
+		cancel()
+		_ = scan.Get(ctx, nil)
+
+		return nil
+	}
+
+	last := CheckIPRecurringCycle{Cycle: cycle, Result: res}
+
+	if scanErr != nil {
+		log.Warn("Recurring IP check failed", tag.Builder().
+			KV("cycle", cycle).
+			Error(scanErr).KeyVals...)
+
+		last.Error = scanErr.Error()
+		last.Result, _ = PartialCheckIPResult(scanErr)
+	}
+
+	param.Last = &last
+
+	if param.NotifyWorkflowID != "" {
+		err := workflow.SignalExternalWorkflow(ctx, param.NotifyWorkflowID, "",
+			CheckIPRecurringCycleSignal, last).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	tctx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	selector = workflow.NewSelector(ctx)
+	selector.AddFuture(workflow.NewTimer(tctx, param.Interval), func(f workflow.Future) {
+		err = f.Get(ctx, nil)
+	})
+	selector.AddReceive(stop, onStop)
+	selector.Select(ctx)
+
+	// a stop signal received right before continuing as new would be lost
+	if stopped || stop.ReceiveAsync(nil) {
+		log.Info("Stopping recurring IP check", tag.Builder().KV("cycle", cycle).KeyVals...)
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return workflow.NewContinueAsNewError(ctx, CheckIPRecurring, param)
+}
@@ -0,0 +1,125 @@
+package workflow
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+func TestCheckIPRecurring(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	testcases := map[string]struct {
+		last  *CheckIPRecurringCycle
+		stop  time.Duration
+		cycle int
+		done  bool
+	}{
+		"continues as new after the interval": {
+			cycle: 1,
+		},
+		"counts cycles": {
+			last:  &CheckIPRecurringCycle{Cycle: 4},
+			cycle: 5,
+		},
+		"stop while sleeping": {
+			stop:  30 * time.Second,
+			cycle: 1,
+			done:  true,
+		},
+		"stop while scanning": {
+			stop: time.Second,
+			done: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var suite testsuite.WorkflowTestSuite
+
+			env := suite.NewTestWorkflowEnvironment()
+
+			env.RegisterWorkflow(CheckIP)
+			env.RegisterActivity(CheckIPActivity)
+			env.OnActivity(CheckIPActivity, mock.Anything, mock.Anything).After(10 * time.Second).Return(
+				func(_ context.Context, param CheckIPActivityParam) (CheckIPActivityResult, error) {
+					return CheckIPActivityResult{
+						IPs:     map[netip.Addr]net.HardwareAddr{ip: hwAddr},
+						Entries: map[netip.Addr]CheckIPEntry{ip: {MAC: hwAddr, Responded: true}},
+					}, nil
+				})
+
+			if tc.stop > 0 {
+				env.RegisterDelayedCallback(func() {
+					env.SignalWorkflow(CheckIPRecurringStopSignal, nil)
+				}, tc.stop)
+			}
+
+			env.ExecuteWorkflow(CheckIPRecurring, CheckIPRecurringParam{
+				CheckIP:  CheckIPParam{IPs: []netip.Addr{ip}},
+				Interval: time.Minute,
+				Last:     tc.last,
+			})
+			assert.True(t, env.IsWorkflowCompleted())
+
+			if tc.done {
+				assert.NoError(t, env.GetWorkflowError())
+			} else {
+				assert.True(t, workflow.IsContinueAsNewError(env.GetWorkflowError()))
+			}
+
+			v, err := env.QueryWorkflow(CheckIPRecurringLastCycleQuery)
+			assert.NoError(t, err)
+
+			var last CheckIPRecurringCycle
+
+			assert.NoError(t, v.Get(&last))
+			assert.Equal(t, tc.cycle, last.Cycle)
+
+			if tc.cycle > 0 {
+				assert.Empty(t, last.Error)
+				assert.Equal(t, hwAddr, last.Result.IPs[ip])
+			}
+		})
+	}
+}
+
+func TestCheckIPRecurringInvalid(t *testing.T) {
+	testcases := map[string]struct {
+		in  CheckIPRecurringParam
+		err error
+	}{
+		"no interval": {
+			in:  CheckIPRecurringParam{CheckIP: CheckIPParam{IPs: []netip.Addr{netip.MustParseAddr("10.0.0.1")}}},
+			err: ErrInvalidInterval,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var suite testsuite.WorkflowTestSuite
+
+			env := suite.NewTestWorkflowEnvironment()
+
+			env.ExecuteWorkflow(CheckIPRecurring, tc.in)
+			assert.True(t, env.IsWorkflowCompleted())
+			assert.ErrorContains(t, env.GetWorkflowError(), tc.err.Error())
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package workflow
+
+import (
+	"net"
+	"net/netip"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// CheckSingleIPResult is a value returned by the CheckSingleIP workflow
+type CheckSingleIPResult struct {
+	// MAC is the hardware address that replied first, it is empty
+	// when Found is false
+	MAC   net.HardwareAddr `json:"mac,omitempty"`
+	Found bool             `json:"found"`
+}
+
+// CheckSingleIP is a Temporal workflow resolving a single address to the
+// hardware address of the host using it, with a single CheckIPActivity
+// scan and the defaults of CheckIP. An address that does not reply within
+// netmon.OperationTimeout, or that can't own a hardware address on the
+// link, like a loopback address, is not found, which is not an error.
+// Errors of the scan fail the workflow like they fail CheckIP.
+func CheckSingleIP(ctx workflow.Context, ip netip.Addr) (CheckSingleIPResult, error) {
+	param := CheckIPParam{IPs: []netip.Addr{ip}}
+
+	if err := validateCheckIPParam(param); err != nil {
+		return CheckSingleIPResult{}, err
+	}
+
+	ips, _ := skipUnscannable(normalizeIPs(param.IPs))
+	if len(ips) == 0 {
+		return CheckSingleIPResult{}, nil
+	}
+
+	var res CheckIPActivityResult
+
+	err := workflow.ExecuteLocalActivity(withCheckIPActivityOptions(ctx, param), CheckIPActivity,
+		CheckIPActivityParam{IPs: ips}).Get(ctx, &res)
+	if err != nil {
+		return CheckSingleIPResult{}, err
+	}
+
+	mac := res.IPs[ips[0]]
+
+	return CheckSingleIPResult{MAC: mac, Found: len(mac) > 0}, nil
+}
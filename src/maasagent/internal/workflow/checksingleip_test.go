@@ -0,0 +1,87 @@
+package workflow
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestCheckSingleIP(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	testcases := map[string]struct {
+		in      netip.Addr
+		replies map[netip.Addr]net.HardwareAddr
+		out     CheckSingleIPResult
+		scanned bool
+		err     bool
+	}{
+		"found": {
+			in:      netip.MustParseAddr("10.0.0.1"),
+			replies: map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): mac},
+			out:     CheckSingleIPResult{MAC: mac, Found: true},
+			scanned: true,
+		},
+		"mapped address found": {
+			in:      netip.MustParseAddr("::ffff:10.0.0.1"),
+			replies: map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): mac},
+			out:     CheckSingleIPResult{MAC: mac, Found: true},
+			scanned: true,
+		},
+		"not found": {
+			in:      netip.MustParseAddr("10.0.0.1"),
+			replies: map[netip.Addr]net.HardwareAddr{},
+			scanned: true,
+		},
+		"loopback is not scanned": {
+			in: netip.MustParseAddr("127.0.0.1"),
+		},
+		"invalid address": {
+			in:  netip.Addr{},
+			err: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var suite testsuite.WorkflowTestSuite
+
+			env := suite.NewTestWorkflowEnvironment()
+
+			var scanned bool
+
+			env.RegisterActivity(CheckIPActivity)
+			env.OnActivity(CheckIPActivity, mock.Anything, mock.Anything).Return(
+				func(context.Context, CheckIPActivityParam) (CheckIPActivityResult, error) {
+					scanned = true
+					return CheckIPActivityResult{IPs: tc.replies}, nil
+				})
+
+			env.ExecuteWorkflow(CheckSingleIP, tc.in)
+
+			assert.True(t, env.IsWorkflowCompleted())
+			assert.Equal(t, tc.scanned, scanned)
+
+			if tc.err {
+				assert.Error(t, env.GetWorkflowError())
+				return
+			}
+
+			assert.NoError(t, env.GetWorkflowError())
+
+			var res CheckSingleIPResult
+
+			assert.NoError(t, env.GetWorkflowResult(&res))
+			assert.Equal(t, tc.out, res)
+		})
+	}
+}
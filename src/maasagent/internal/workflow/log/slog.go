@@ -0,0 +1,104 @@
+package log
+
+import (
+	"context"
+
+	"go.temporal.io/sdk/log"
+	"golang.org/x/exp/slog"
+)
+
+// SlogHandler is an adapter that allows usage of a Temporal log.Logger,
+// like the logger of an activity, as the handler of a slog.Logger
+type SlogHandler struct {
+	logger log.Logger
+	level  slog.Leveler
+	// keyvals are attributes added with WithAttrs
+	keyvals []interface{}
+	// prefix is prepended to keys of attributes, it is made of groups
+	// opened with WithGroup
+	prefix string
+}
+
+// NewSlogHandler returns new slog.Handler passing records of level
+// or above to logger
+func NewSlogHandler(logger log.Logger, level slog.Leveler) *SlogHandler {
+	return &SlogHandler{logger: logger, level: level}
+}
+
+// Enabled implements slog.Handler interface
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle implements slog.Handler interface
+func (h *SlogHandler) Handle(_ context.Context, r slog.Record) error {
+	keyvals := make([]interface{}, 0, len(h.keyvals)+2*r.NumAttrs())
+	keyvals = append(keyvals, h.keyvals...)
+
+	r.Attrs(func(a slog.Attr) {
+		keyvals = appendAttr(keyvals, h.prefix, a)
+	})
+
+	switch {
+	case r.Level >= slog.LevelError:
+		h.logger.Error(r.Message, keyvals...)
+	case r.Level >= slog.LevelWarn:
+		h.logger.Warn(r.Message, keyvals...)
+	case r.Level >= slog.LevelInfo:
+		h.logger.Info(r.Message, keyvals...)
+	default:
+		h.logger.Debug(r.Message, keyvals...)
+	}
+
+	return nil
+}
+
+// WithAttrs implements slog.Handler interface
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.keyvals = make([]interface{}, 0, len(h.keyvals)+2*len(attrs))
+	c.keyvals = append(c.keyvals, h.keyvals...)
+
+	for _, a := range attrs {
+		c.keyvals = appendAttr(c.keyvals, h.prefix, a)
+	}
+
+	return &c
+}
+
+// WithGroup implements slog.Handler interface
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	c := *h
+	c.prefix = h.prefix + name + "."
+
+	return &c
+}
+
+// appendAttr appends the key and the value of a to keyvals, attributes
+// of groups are flattened into keys joined with dots
+func appendAttr(keyvals []interface{}, prefix string, a slog.Attr) []interface{} {
+	v := a.Value.Resolve()
+
+	if v.Kind() == slog.KindGroup {
+		// attributes of a group without a key belong to the parent
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+
+		for _, ga := range v.Group() {
+			keyvals = appendAttr(keyvals, prefix, ga)
+		}
+
+		return keyvals
+	}
+
+	if a.Key == "" {
+		return keyvals
+	}
+
+	return append(keyvals, prefix+a.Key, v.Any())
+}
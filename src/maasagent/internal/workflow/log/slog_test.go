@@ -0,0 +1,40 @@
+package log
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+// recorder is a Temporal log.Logger recording every call
+type recorder struct {
+	records []string
+}
+
+func (r *recorder) record(level, msg string, keyvals []interface{}) {
+	r.records = append(r.records, fmt.Sprintf("%s %s %v", level, msg, keyvals))
+}
+
+func (r *recorder) Debug(msg string, keyvals ...interface{}) { r.record("DEBUG", msg, keyvals) }
+func (r *recorder) Info(msg string, keyvals ...interface{})  { r.record("INFO", msg, keyvals) }
+func (r *recorder) Warn(msg string, keyvals ...interface{})  { r.record("WARN", msg, keyvals) }
+func (r *recorder) Error(msg string, keyvals ...interface{}) { r.record("ERROR", msg, keyvals) }
+
+func TestSlogHandler(t *testing.T) {
+	var r recorder
+
+	l := slog.New(NewSlogHandler(&r, slog.LevelInfo))
+
+	l.Debug("dropped")
+	l.Info("scan complete", "scanned", 2)
+	l.With("ip", "10.0.0.1").WithGroup("reply").Warn("got reply", slog.Group("from", slog.String("mac", "c0:ff:ee:15:c0:01")))
+	l.Error("failed", slog.Group("", slog.Int("attempts", 3)))
+
+	assert.Equal(t, []string{
+		"INFO scan complete [scanned 2]",
+		"WARN got reply [ip 10.0.0.1 reply.from.mac c0:ff:ee:15:c0:01]",
+		"ERROR failed [attempts 3]",
+	}, r.records)
+}
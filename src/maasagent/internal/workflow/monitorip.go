@@ -0,0 +1,164 @@
+package workflow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// MonitorIPEventSignal is the name of the signal carrying a MonitorIPEvent
+	// sent by MonitorIP to the workflow it notifies
+	MonitorIPEventSignal = "ip-monitor-event"
+
+	// monitorIPPollsPerRun is the number of polls after which MonitorIP
+	// continues as new, which keeps the history of a single run bounded
+	monitorIPPollsPerRun = 100
+)
+
+const (
+	// MonitorIPChanged is an event for an address that answers
+	// with another hardware address than before
+	MonitorIPChanged = "changed"
+	// MonitorIPGone is an event for an address that stopped answering
+	MonitorIPGone = "gone"
+	// MonitorIPReturned is an event for an address that answers again
+	// after it was gone
+	MonitorIPReturned = "returned"
+)
+
+var (
+	// ErrInvalidInterval is an error for when a poll interval that is not
+	// positive is passed to MonitorIP or CheckIPRecurring
+	ErrInvalidInterval = errors.New("poll interval must be positive")
+	// ErrNoNotifyWorkflow is an error for when MonitorIP is started without
+	// a parent workflow and without NotifyWorkflowID
+	ErrNoNotifyWorkflow = errors.New("no workflow to notify")
+)
+
+// MonitorIPParam is a workflow parameter for the MonitorIP workflow
+type MonitorIPParam struct {
+	IPs []netip.Addr `json:"ips"`
+	// Interval is the time between the end of a scan and the next one
+	Interval time.Duration `json:"interval"`
+	// Timeout and Interface are used for every scan like in CheckIPParam
+	Timeout   time.Duration `json:"timeout"`
+	Interface string        `json:"interface"`
+	// NotifyWorkflowID is the workflow that receives MonitorIPEventSignal,
+	// the parent workflow is notified when empty
+	NotifyWorkflowID string `json:"notify_workflow_id"`
+	// LastSeen is the hardware address of every address that answered
+	// a previous scan, or nil if it has stopped answering since.
+	// It is carried over when the workflow continues as new.
+	LastSeen map[netip.Addr]net.HardwareAddr `json:"last_seen"`
+}
+
+// MonitorIPEvent is a change of an address noticed by MonitorIP
+type MonitorIPEvent struct {
+	IP netip.Addr `json:"ip"`
+	// Kind is one of MonitorIPChanged, MonitorIPGone or MonitorIPReturned
+	Kind string `json:"kind"`
+	// PreviousMAC is the hardware address before the change, it is empty
+	// for MonitorIPReturned. MAC is empty for MonitorIPGone.
+	PreviousMAC net.HardwareAddr `json:"previous_mac"`
+	MAC         net.HardwareAddr `json:"mac"`
+}
+
+// MonitorIP is a Temporal workflow scanning addresses every Interval to notify
+// another workflow whenever an address starts answering with another hardware
+// address, for example because the machine was swapped, or stops answering.
+// The first scan of an address records its hardware address without an event.
+// It runs until it is cancelled and continues as new every
+// monitorIPPollsPerRun scans.
+func MonitorIP(ctx workflow.Context, param MonitorIPParam) error {
+	checkIPParam := CheckIPParam{
+		IPs:       param.IPs,
+		Timeout:   param.Timeout,
+		Interface: param.Interface,
+	}
+
+	if err := validateCheckIPParam(checkIPParam); err != nil {
+		return err
+	}
+
+	if param.Interval <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidInterval, param.Interval)
+	}
+
+	if param.NotifyWorkflowID == "" {
+		parent := workflow.GetInfo(ctx).ParentWorkflowExecution
+		if parent == nil {
+			return ErrNoNotifyWorkflow
+		}
+
+		// the ID is kept, so that the workflow is still notified after
+		// continuing as new
+		param.NotifyWorkflowID = parent.ID
+	}
+
+	if param.LastSeen == nil {
+		param.LastSeen = make(map[netip.Addr]net.HardwareAddr, len(param.IPs))
+	}
+
+	ips, _ := skipUnscannable(normalizeIPs(param.IPs))
+	lctx := withCheckIPActivityOptions(ctx, checkIPParam)
+
+	for i := 0; i < monitorIPPollsPerRun; i++ {
+		scanned, err := scanIPs(lctx, ips, checkIPParam, param.Interface,
+			newCheckIPTracker(&CheckIPProgress{}, nil))
+		if err != nil {
+			return err
+		}
+
+		for _, event := range monitorIPEvents(ips, param.LastSeen, scanned.IPs) {
+			err := workflow.SignalExternalWorkflow(ctx, param.NotifyWorkflowID, "",
+				MonitorIPEventSignal, event).Get(ctx, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := workflow.NewTimer(ctx, param.Interval).Get(ctx, nil); err != nil {
+			return err
+		}
+	}
+
+	return workflow.NewContinueAsNewError(ctx, MonitorIP, param)
+}
+
+// monitorIPEvents returns changes of scanned addresses since lastSeen, which
+// is updated with the scan. Events follow the order of ips, so that they are
+// the same on replay.
+func monitorIPEvents(ips []netip.Addr, lastSeen map[netip.Addr]net.HardwareAddr,
+	scanned map[netip.Addr]net.HardwareAddr) []MonitorIPEvent {
+	var res []MonitorIPEvent
+
+	for _, ip := range ips {
+		prev, seen := lastSeen[ip]
+		hwAddr := scanned[ip]
+
+		switch {
+		case !seen:
+			if len(hwAddr) > 0 {
+				lastSeen[ip] = hwAddr
+			}
+
+			continue
+		case len(prev) > 0 && len(hwAddr) == 0:
+			res = append(res, MonitorIPEvent{IP: ip, Kind: MonitorIPGone, PreviousMAC: prev})
+		case len(prev) == 0 && len(hwAddr) > 0:
+			res = append(res, MonitorIPEvent{IP: ip, Kind: MonitorIPReturned, MAC: hwAddr})
+		case len(prev) > 0 && !bytes.Equal(prev, hwAddr):
+			res = append(res, MonitorIPEvent{IP: ip, Kind: MonitorIPChanged, PreviousMAC: prev, MAC: hwAddr})
+		}
+
+		lastSeen[ip] = hwAddr
+	}
+
+	return res
+}
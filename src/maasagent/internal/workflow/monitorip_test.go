@@ -0,0 +1,72 @@
+package workflow
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitorIPEvents(t *testing.T) {
+	first := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
+	second := net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x02}
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	testcases := map[string]struct {
+		lastSeen map[netip.Addr]net.HardwareAddr
+		scanned  map[netip.Addr]net.HardwareAddr
+		events   []MonitorIPEvent
+		out      map[netip.Addr]net.HardwareAddr
+	}{
+		"first scan records the address": {
+			lastSeen: map[netip.Addr]net.HardwareAddr{},
+			scanned:  map[netip.Addr]net.HardwareAddr{ip: first},
+			out:      map[netip.Addr]net.HardwareAddr{ip: first},
+		},
+		"address never seen": {
+			lastSeen: map[netip.Addr]net.HardwareAddr{},
+			scanned:  map[netip.Addr]net.HardwareAddr{ip: nil},
+			out:      map[netip.Addr]net.HardwareAddr{},
+		},
+		"unchanged": {
+			lastSeen: map[netip.Addr]net.HardwareAddr{ip: first},
+			scanned:  map[netip.Addr]net.HardwareAddr{ip: first},
+			out:      map[netip.Addr]net.HardwareAddr{ip: first},
+		},
+		"changed": {
+			lastSeen: map[netip.Addr]net.HardwareAddr{ip: first},
+			scanned:  map[netip.Addr]net.HardwareAddr{ip: second},
+			events:   []MonitorIPEvent{{IP: ip, Kind: MonitorIPChanged, PreviousMAC: first, MAC: second}},
+			out:      map[netip.Addr]net.HardwareAddr{ip: second},
+		},
+		"gone": {
+			lastSeen: map[netip.Addr]net.HardwareAddr{ip: first},
+			scanned:  map[netip.Addr]net.HardwareAddr{ip: nil},
+			events:   []MonitorIPEvent{{IP: ip, Kind: MonitorIPGone, PreviousMAC: first}},
+			out:      map[netip.Addr]net.HardwareAddr{ip: nil},
+		},
+		"still gone": {
+			lastSeen: map[netip.Addr]net.HardwareAddr{ip: nil},
+			scanned:  map[netip.Addr]net.HardwareAddr{ip: nil},
+			out:      map[netip.Addr]net.HardwareAddr{ip: nil},
+		},
+		"returned": {
+			lastSeen: map[netip.Addr]net.HardwareAddr{ip: nil},
+			scanned:  map[netip.Addr]net.HardwareAddr{ip: second},
+			events:   []MonitorIPEvent{{IP: ip, Kind: MonitorIPReturned, MAC: second}},
+			out:      map[netip.Addr]net.HardwareAddr{ip: second},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			events := monitorIPEvents([]netip.Addr{ip}, tc.lastSeen, tc.scanned)
+			assert.Equal(t, tc.events, events)
+			assert.Equal(t, tc.out, tc.lastSeen)
+		})
+	}
+}
@@ -68,6 +68,9 @@ class ConfigureWorkerPoolWorkflow:
                     "task_queue": f"vlan-{vlan_id}",
                     "workflows": [
                         "check_ip",
+                        "check_ip_recurring",
+                        "check_single_ip",
+                        "monitor_ip",
                         "power_query",
                         "power_cycle",
                         "power_on",
@@ -75,6 +78,7 @@ class ConfigureWorkerPoolWorkflow:
                     ],
                     "activities": [
                         "power",
+                        "check_ip_heartbeat",
                     ],
                 },
             )